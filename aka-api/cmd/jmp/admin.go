@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/api"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/backup"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+	"io"
+	"os"
+	"time"
+)
+
+// initDatabase runs the schema migration and
+// creates the change notification triggers.
+func initDatabase(ctx context.Context, accessLayer *dao.AccessLayer) error {
+	log := logr.FromContextOrDiscard(ctx)
+	if err := accessLayer.Init(ctx); err != nil {
+		log.Error(err, "failed to initialise database")
+		return err
+	}
+	if err := accessLayer.InitTriggers(ctx); err != nil {
+		log.Error(err, "failed to setup table triggers")
+		return err
+	}
+	return nil
+}
+
+func migrate(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := fs.Int("to", -1, "migrate up or down to a specific version (defaults to the latest)")
+	status := fs.Bool("status", false, "print the current version and exit")
+	_ = fs.Parse(args)
+
+	accessLayer, _, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	migrator, err := accessLayer.Migrator()
+	if err != nil {
+		return err
+	}
+	if *status {
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("database is at version %d (latest is %d)\n", version, migrator.Latest())
+		return nil
+	}
+	if *to >= 0 {
+		return migrator.Migrate(ctx, *to)
+	}
+	return initDatabase(ctx, accessLayer)
+}
+
+func rbacCmd(ctx context.Context, e *environment, args []string) error {
+	if len(args) == 0 || args[0] != "reconcile" {
+		return errors.New("usage: rbac reconcile")
+	}
+	fs := flag.NewFlagSet("rbac reconcile", flag.ExitOnError)
+	_ = fs.Parse(args[1:])
+
+	_, repos, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	rbacClient, err := dialRBAC(ctx, e)
+	if err != nil {
+		return err
+	}
+	report, err := api.NewAdminService(repos, rbacClient).ReconcileRoles(ctx, e.Admin.Users)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reconciled rolebindings for %d admins, %d jumps and %d groups\n", report.Admins, report.Jumps, report.Groups)
+	return nil
+}
+
+func promoteAdmin(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("promote-admin", flag.ExitOnError)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: promote-admin <user>")
+	}
+
+	rbacClient, err := dialRBAC(ctx, e)
+	if err != nil {
+		return err
+	}
+	// the admin service only needs the database
+	// for reconciliation
+	return api.NewAdminService(nil, rbacClient).PromoteAdmin(ctx, fs.Arg(0))
+}
+
+func syncGroups(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("sync-groups", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	_, repos, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	rbacClient, err := dialRBAC(ctx, e)
+	if err != nil {
+		return err
+	}
+	report, err := api.NewGroupService(ctx, repos, rbacClient, nil).SyncAll(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("synchronised groups for %d users, added %d and removed %d memberships\n", report.Users, len(report.Added), len(report.Removed))
+	return nil
+}
+
+func purgeEvents(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("purge-events", flag.ExitOnError)
+	before := fs.String("before", "", "delete events before this time, either as an RFC3339 date or a duration relative to now (e.g. 2160h)")
+	_ = fs.Parse(args)
+
+	cutoff, err := parseBefore(*before, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, repos, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	count, err := api.NewAdminService(repos, nil).PurgeEvents(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d jump events before %s\n", count, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+func purgeAudit(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("purge-audit", flag.ExitOnError)
+	before := fs.String("before", "", "delete entries before this time, either as an RFC3339 date or a duration relative to now (e.g. 2160h)")
+	_ = fs.Parse(args)
+
+	cutoff, err := parseBefore(*before, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, repos, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	count, err := api.NewAuditService(repos).Purge(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d audit log entries before %s\n", count, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+// parseBefore converts the value of a --before flag
+// into an absolute time.
+func parseBefore(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("--before must be set")
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--before must be a date or duration: %w", err)
+	}
+	return now.Add(-d), nil
+}
+
+func doctor(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "how long each check may take")
+	_ = fs.Parse(args)
+
+	var failed bool
+	check := func(name string, f func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		if err := f(ctx); err != nil {
+			failed = true
+			_, _ = fmt.Fprintf(os.Stdout, "[FAIL] %s: %s\n", name, err)
+			return
+		}
+		_, _ = fmt.Fprintf(os.Stdout, "[ OK ] %s\n", name)
+	}
+
+	accessLayer, _, err := openDatabase(ctx, e)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stdout, "[FAIL] database: %s\n", err)
+		return errors.New("one or more checks failed")
+	}
+	check("database", accessLayer.Ping)
+	check("triggers", func(ctx context.Context) error {
+		missing, err := accessLayer.MissingTriggers(ctx)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing triggers %v, run 'migrate' to create them", missing)
+		}
+		return nil
+	})
+	check("notifier channels", accessLayer.CheckNotify)
+	check("rbac", func(ctx context.Context) error {
+		rbacClient, err := dialRBAC(ctx, e)
+		if err != nil {
+			return err
+		}
+		// we don't care about the answer, only
+		// that we got one
+		_, err = rbacClient.Can(ctx, &rbac.AccessRequest{
+			Subject:  "",
+			Resource: api.RoleSuper,
+			Action:   rbac.Verb_SUDO,
+		})
+		return err
+	})
+	if failed {
+		return errors.New("one or more checks failed")
+	}
+	return nil
+}
+
+func export(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "-", "file to write the archive to, or '-' for stdout")
+	_ = fs.Parse(args)
+
+	accessLayer, _, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	archive := backup.NewWriter(w)
+	if err := accessLayer.Export(ctx, archive); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func restore(ctx context.Context, e *environment, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "-", "file to read the archive from, or '-' for stdin")
+	skipRoles := fs.Bool("skip-roles", false, "don't restore role bindings")
+	_ = fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	archive, err := backup.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	urlPolicy, err := policy.New(e.URLPolicy)
+	if err != nil {
+		return err
+	}
+	accessLayer, repos, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	ids, err := accessLayer.Restore(ctx, archive, urlPolicy)
+	if err != nil {
+		return err
+	}
+	if *skipRoles {
+		return nil
+	}
+	rbacClient, err := dialRBAC(ctx, e)
+	if err != nil {
+		return err
+	}
+	count, err := api.NewAdminService(repos, rbacClient).RestoreRoles(ctx, archive, ids)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "restored archive created at %s with %d rolebindings\n", archive.Manifest.CreatedAt.Format(time.RFC3339), count)
+	return nil
+}
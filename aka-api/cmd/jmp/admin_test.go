@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseBefore(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	var cases = []struct {
+		in       string
+		expected time.Time
+		ok       bool
+	}{
+		{"2024-01-02T03:04:05Z", time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC), true},
+		{"2024-01-02", time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC), true},
+		{"24h", time.Date(2024, time.May, 31, 12, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := parseBefore(tt.in, now)
+			if !tt.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tt.expected.Equal(out))
+		})
+	}
+}
@@ -3,37 +3,27 @@ package main
 import (
 	"context"
 	_ "embed"
-	"github.com/99designs/gqlgen/graphql/handler"
-	"github.com/99designs/gqlgen/graphql/handler/transport"
-	"github.com/99designs/gqlgen/graphql/playground"
+	"fmt"
 	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
 	"github.com/djcass44/go-utils/logging"
 	"github.com/djcass44/go-utils/otel"
-	"github.com/djcass44/go-utils/otel/metrics"
-	sentryhttp "github.com/getsentry/sentry-go/http"
-	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
+	"github.com/go-logr/logr"
 	"github.com/kelseyhightower/envconfig"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"gitlab.com/autokubeops/serverless"
-	cap10 "gitlab.com/av1o/cap10/pkg/client"
-	"gitlab.com/av1o/cap10/pkg/verify"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
-	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph"
-	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/generated"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/api"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/errtracing"
-	"gitlab.dcas.dev/jmp/go-jmp/pkg/svc"
-	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
-	"net/http"
+	"io"
+	"maps"
 	"os"
+	"slices"
 	"time"
 )
 
@@ -45,18 +35,83 @@ type environment struct {
 	LogLevel int    `split_words:"true"`
 	DSN      string `required:"true"`
 
+	// MigrateOnStart controls whether the server
+	// migrates the database before it starts.
+	MigrateOnStart bool `split_words:"true" default:"true"`
+
 	Sentry errtracing.SentryOptions
 	Otel   traceopts.OtelOptions
 
+	// Subscriptions controls how changes are
+	// delivered to GraphQL subscribers.
+	Subscriptions api.FanoutOptions
+	// Webhooks controls how changes are
+	// delivered to Webhooks.
+	Webhooks api.WebhookOptions
+
 	RbacURL                 string `split_words:"true" required:"true"`
 	AllowedOrigin           string `split_words:"true" default:"*"`
 	AllowPublicJumpCreation bool   `split_words:"true"`
-	Admin                   struct {
+
+	// URLPolicy controls where Jumps are
+	// allowed to point to.
+	URLPolicy policy.Options `envconfig:"URL_POLICY"`
+
+	// GroupSyncInterval controls how often the membership
+	// of External groups is reconciled with the groups
+	// users had when they last logged in. Disabled if 0.
+	GroupSyncInterval time.Duration `split_words:"true"`
+
+	// SCIMToken is the bearer token that the identity
+	// provider uses to provision users and groups. The
+	// SCIM endpoints are disabled if it is empty.
+	SCIMToken string `envconfig:"SCIM_TOKEN"`
+
+	// AuditRetention controls how long entries in the
+	// audit log are kept for. They are kept forever if 0.
+	AuditRetention time.Duration `split_words:"true" default:"2160h"`
+
+	// Assertion configures how we check the identity
+	// of users that is signed by the oidc-proxy.
+	Assertion struct {
+		Key      string `split_words:"true"`
+		Audience string `split_words:"true" default:"aka-api"`
+	}
+	// TrustForwardedHeaders accepts the unsigned identity
+	// headers set by the oidc-proxy. Anyone that can reach
+	// the API directly can impersonate any user, so this
+	// must only be used if nothing else can.
+	TrustForwardedHeaders bool `split_words:"true"`
+	// OIDC lets users authenticate by sending a token from
+	// the OIDC provider in the Authorization header, for
+	// when there is no oidc-proxy in front of us.
+	OIDC identity.OIDCOptions
+
+	Admin struct {
 		Groups []string `split_words:"true"`
 		Users  []string `split_words:"true"`
 	}
 }
 
+// command is a subcommand of the jmp binary
+type command struct {
+	usage string
+	run   func(ctx context.Context, e *environment, args []string) error
+}
+
+var commands = map[string]command{
+	"serve":         {usage: "start the HTTP server (default)", run: serve},
+	"migrate":       {usage: "migrate the database and create change triggers", run: migrate},
+	"rbac":          {usage: "manage role bindings (reconcile)", run: rbacCmd},
+	"promote-admin": {usage: "grant the SUPER role to a user", run: promoteAdmin},
+	"purge-events":  {usage: "delete jump events older than a given date", run: purgeEvents},
+	"purge-audit":   {usage: "delete audit log entries older than a given date", run: purgeAudit},
+	"sync-groups":   {usage: "reconcile external group membership", run: syncGroups},
+	"doctor":        {usage: "check the health of aka and its dependencies", run: doctor},
+	"export":        {usage: "write a backup of all data to an archive", run: export},
+	"restore":       {usage: "restore data from an archive", run: restore},
+}
+
 // @title JMP
 // @version 0.1
 
@@ -71,6 +126,19 @@ type environment struct {
 // @in header
 // @name X-Auth-Source
 func main() {
+	// figure out what we're being asked to do
+	name := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		usage(os.Stderr)
+		os.Exit(2)
+		return
+	}
+
 	// setup environment
 	var e environment
 	envconfig.MustProcess("aka", &e)
@@ -99,44 +167,63 @@ func main() {
 		return
 	}
 
-	// create the db connection
-	accessLayer, err := dao.NewAccessLayer(ctx, e.DSN)
-	if err != nil {
-		log.Error(err, "failed to establish database connection")
+	if err := cmd.run(ctx, &e, args); err != nil {
+		log.Error(err, "command failed", "Command", name)
 		os.Exit(1)
 		return
 	}
-	if err = accessLayer.Init(ctx); err != nil {
-		log.Error(err, "failed to initialise database")
-		os.Exit(1)
-		return
+}
+
+// usage lists every command in alphabetical order.
+func usage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		_, _ = fmt.Fprintf(w, "  %-14s %s\n", name, commands[name].usage)
 	}
-	notifiers, err := accessLayer.InitNotify(ctx)
+}
+
+// openDatabase creates the db connection
+func openDatabase(ctx context.Context, e *environment) (*dao.AccessLayer, *dao.Repos, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	accessLayer, err := dao.NewAccessLayer(ctx, e.DSN)
 	if err != nil {
-		log.Error(err, "failed to setup table notifiers")
-		os.Exit(1)
-		return
+		log.Error(err, "failed to establish database connection")
+		return nil, nil, err
 	}
-	c := cap10.NewClient(verify.NewNoOpVerifier())
-	similarService := svc.NewSimilarService(0.7)
 
 	jumpRepo := &dao.JumpRepo{}
 	eventRepo := &dao.JumpEventRepo{}
 	userRepo := &dao.UserV2Repo{}
 	groupRepo := &dao.GroupRepo{}
+	tokenRepo := &dao.AccessTokenRepo{}
+	auditRepo := &dao.AuditRepo{}
+	webhookRepo := &dao.WebhookRepo{}
+	proposalRepo := &dao.JumpProposalRepo{}
 	accessLayer.NewRepo(&jumpRepo.Repository)
 	accessLayer.NewRepo(&eventRepo.Repository)
 	accessLayer.NewRepo(&userRepo.Repository)
 	accessLayer.NewRepo(&groupRepo.Repository)
+	accessLayer.NewRepo(&tokenRepo.Repository)
+	accessLayer.NewRepo(&auditRepo.Repository)
+	accessLayer.NewRepo(&webhookRepo.Repository)
+	accessLayer.NewRepo(&proposalRepo.Repository)
 
 	repos := &dao.Repos{
 		JumpRepo:      jumpRepo,
 		GroupRepo:     groupRepo,
 		UserRepo:      userRepo,
 		JumpEventRepo: eventRepo,
+		TokenRepo:     tokenRepo,
+		AuditRepo:     auditRepo,
+		WebhookRepo:   webhookRepo,
+		ProposalRepo:  proposalRepo,
 	}
+	return accessLayer, repos, nil
+}
 
-	// connect to the RBAC sidecar
+// dialRBAC connects to the RBAC sidecar
+func dialRBAC(ctx context.Context, e *environment) (rbac.AuthorityClient, error) {
+	log := logr.FromContextOrDiscard(ctx)
 	log.V(1).Info("establishing connection to RBAC", "Url", e.RbacURL)
 	conn, err := grpc.NewClient(e.RbacURL,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
@@ -145,56 +232,7 @@ func main() {
 	)
 	if err != nil {
 		log.Error(err, "failed to dial RBAC")
-		os.Exit(1)
-		return
-	}
-	rbacClient := rbac.NewAuthorityClient(conn)
-	for _, user := range e.Admin.Users {
-		log.Info("creating superuser rolebinding", "user", user)
-		_, err := rbacClient.AddGlobalRole(ctx, &rbac.AddGlobalRoleRequest{
-			Subject: user,
-			Role:    "SUPER",
-		})
-		if err != nil {
-			log.Error(err, "failed to create rolebinding for superuser")
-		}
+		return nil, err
 	}
-
-	// setup router and handlers
-	router := mux.NewRouter()
-
-	// setup the swagger handler
-	router.Use(sentryhttp.New(sentryhttp.Options{Repanic: true}).Handle)
-	router.Use(logging.Middleware(log), metrics.Middleware())
-	router.Use(otelmux.Middleware(traceopts.DefaultServiceName))
-	router.Handle("/metrics", promhttp.Handler())
-	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte("OK"))
-	})
-
-	// graphql
-	srv := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: graph.NewResolver(ctx, repos, similarService, rbacClient, e.AllowPublicJumpCreation, e.Admin.Groups, notifiers)}))
-	srv.AddTransport(transport.POST{})
-	srv.AddTransport(transport.Websocket{
-		KeepAlivePingInterval: 10 * time.Second,
-		Upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				log.V(1).Info("validating websocket origin", "Origin", r.Header.Get("Origin"), "AllowedOrigin", e.AllowedOrigin)
-				if e.AllowedOrigin == "*" {
-					return true
-				}
-				return r.Host == e.AllowedOrigin
-			},
-		},
-	})
-	router.Handle("/v4/query", identity.Middleware(srv))
-	router.Handle("/v4/graphql", playground.Handler("GraphQL Playground", "/v4/query"))
-
-	_ = api.NewJumpAPI(ctx, repos, c, e.AllowPublicJumpCreation, rbacClient, router)
-
-	// start the http server
-	serverless.NewBuilder(router).
-		WithPort(e.Port).
-		WithLogger(log).
-		Run()
+	return rbac.NewAuthorityClient(conn), nil
 }
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	var buf bytes.Buffer
+	usage(&buf)
+	for name, cmd := range commands {
+		assert.Contains(t, buf.String(), name)
+		assert.Contains(t, buf.String(), cmd.usage)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/djcass44/go-utils/logging"
+	"github.com/djcass44/go-utils/otel/metrics"
+	sentryhttp "github.com/getsentry/sentry-go/http"
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gitlab.com/autokubeops/serverless"
+	cap10 "gitlab.com/av1o/cap10/pkg/client"
+	"gitlab.com/av1o/cap10/pkg/verify"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/generated"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/api"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/svc"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"net/http"
+	"time"
+)
+
+func serve(ctx context.Context, e *environment, _ []string) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	// create the db connection
+	accessLayer, repos, err := openDatabase(ctx, e)
+	if err != nil {
+		return err
+	}
+	if e.MigrateOnStart {
+		if err := initDatabase(ctx, accessLayer); err != nil {
+			return err
+		}
+	}
+	feed := accessLayer.ChangeFeed()
+	defer feed.Close()
+	c := cap10.NewClient(verify.NewNoOpVerifier())
+	similarService := svc.NewSimilarService(0.7)
+
+	// connect to the RBAC sidecar
+	rbacClient, err := dialRBAC(ctx, e)
+	if err != nil {
+		return err
+	}
+	adminService := api.NewAdminService(repos, rbacClient)
+	for _, user := range e.Admin.Users {
+		// failing to create the rolebinding
+		// shouldn't stop the server
+		_ = adminService.PromoteAdmin(ctx, user)
+	}
+	if e.GroupSyncInterval > 0 {
+		go syncGroupsEvery(ctx, api.NewGroupService(ctx, repos, rbacClient, nil), e.GroupSyncInterval)
+	}
+	if e.AuditRetention > 0 {
+		go purgeAuditEvery(ctx, api.NewAuditService(repos), e.AuditRetention)
+	}
+
+	// setup router and handlers
+	router := mux.NewRouter()
+
+	// setup the swagger handler
+	router.Use(sentryhttp.New(sentryhttp.Options{Repanic: true}).Handle)
+	router.Use(logging.Middleware(log), metrics.Middleware())
+	router.Use(otelmux.Middleware(traceopts.DefaultServiceName))
+	router.Use(api.RequestIDMiddleware)
+	router.Handle("/metrics", promhttp.Handler())
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	})
+	// readyz reports whether we can serve requests
+	// and deliver live updates to subscribers
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := accessLayer.Ping(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err := feed.Health(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("OK"))
+	})
+
+	urlPolicy, err := policy.New(e.URLPolicy)
+	if err != nil {
+		log.Error(err, "failed to setup url policy")
+		return err
+	}
+
+	// graphql
+	resolver, err := graph.NewResolver(ctx, repos, similarService, rbacClient, e.AllowPublicJumpCreation, urlPolicy, e.Admin.Groups, feed, e.Subscriptions, e.Webhooks)
+	if err != nil {
+		log.Error(err, "failed to setup table notifiers")
+		return err
+	}
+	srv := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	srv.SetErrorPresenter(graph.PresentError)
+	srv.AroundRootFields(resolver.RejectDeactivated)
+	srv.AroundRootFields(graph.TokenScopes)
+	srv.AroundFields(resolver.AuditMutations)
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				log.V(1).Info("validating websocket origin", "Origin", r.Header.Get("Origin"), "AllowedOrigin", e.AllowedOrigin)
+				if e.AllowedOrigin == "*" {
+					return true
+				}
+				return r.Host == e.AllowedOrigin
+			},
+		},
+	})
+	query, err := identityMiddleware(ctx, e, srv)
+	if err != nil {
+		return err
+	}
+	verifier := identity.ChainVerifier{api.NewTokenService(repos)}
+	if e.OIDC.IssuerURI != "" {
+		log.Info("validating bearer tokens issued by the OIDC provider", "Issuer", e.OIDC.IssuerURI)
+		oidcVerifier, err := identity.NewOIDCVerifier(ctx, e.OIDC)
+		if err != nil {
+			log.Error(err, "failed to setup oidc verifier")
+			return err
+		}
+		verifier = append(verifier, oidcVerifier)
+	}
+	router.Handle("/v4/query", identity.BearerMiddleware(verifier, query))
+	router.Handle("/v4/graphql", playground.Handler("GraphQL Playground", "/v4/query"))
+
+	_ = api.NewJumpAPI(ctx, repos, c, e.AllowPublicJumpCreation, rbacClient, router)
+	if e.SCIMToken != "" {
+		_ = api.NewSCIMAPI(ctx, repos, rbacClient, e.SCIMToken, router)
+	}
+
+	// start the http server
+	serverless.NewBuilder(router).
+		WithPort(e.Port).
+		WithLogger(log).
+		Run()
+	return nil
+}
+
+// identityMiddleware works out who users are from
+// the identity forwarded by the oidc-proxy.
+func identityMiddleware(ctx context.Context, e *environment, h http.Handler) (http.Handler, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	if e.TrustForwardedHeaders {
+		log.Info("WARNING: trusting unsigned identity headers, anyone that can reach the API can impersonate any user")
+		h = identity.Middleware(h)
+	}
+	if e.Assertion.Key == "" {
+		if !e.TrustForwardedHeaders {
+			log.Info("no assertion key has been configured, only bearer tokens will be accepted")
+		}
+		return h, nil
+	}
+	verifier, err := identity.NewAssertionVerifier(e.Assertion.Key, e.Assertion.Audience)
+	if err != nil {
+		log.Error(err, "failed to setup assertion verifier")
+		return nil, err
+	}
+	return identity.AssertionMiddleware(verifier, h), nil
+}
+
+// syncGroupsEvery periodically reconciles the membership
+// of External groups until the context is cancelled.
+func syncGroupsEvery(ctx context.Context, groupService *api.GroupService, interval time.Duration) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Interval", interval)
+	log.Info("starting periodic group synchronisation")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// errors are logged by the service, and
+			// we'll try again on the next tick
+			_, _ = groupService.SyncAll(ctx)
+		}
+	}
+}
+
+// purgeAuditEvery periodically removes entries from the
+// audit log that are older than the retention period.
+func purgeAuditEvery(ctx context.Context, auditService *api.AuditService, retention time.Duration) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Retention", retention)
+	log.Info("starting periodic audit log purge")
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if _, err := auditService.Purge(ctx, time.Now().Add(-retention)); err != nil {
+			log.Error(err, "failed to purge audit log")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-logr/logr"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HeaderAssertion contains a signed assertion of the
+// users identity, created by the oidc-proxy.
+const HeaderAssertion = "X-Forwarded-Assertion"
+
+// minAssertionKeyLength is the shortest key that we'll
+// accept, the same as the size of the HS256 digest.
+const minAssertionKeyLength = 32
+
+// assertionLeeway allows for a small amount of clock
+// skew between us and the oidc-proxy.
+const assertionLeeway = 10 * time.Second
+
+var (
+	ErrInvalidAssertion = errors.New("invalid identity assertion")
+	ErrExpiredAssertion = errors.New("identity assertion has expired")
+	ErrAssertionAud     = errors.New("identity assertion is not intended for us")
+)
+
+// AssertionClaims describe the user that a
+// request was made by.
+type AssertionClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	Email     string   `json:"email"`
+	Groups    []string `json:"groups"`
+	Username  string   `json:"preferred_username"`
+}
+
+type assertionHeader struct {
+	Alg string `json:"alg"`
+}
+
+// AssertionVerifier checks the identity assertions
+// (HS256 JWTs) that are signed by the oidc-proxy.
+type AssertionVerifier struct {
+	key      []byte
+	audience string
+	now      func() time.Time
+}
+
+func NewAssertionVerifier(key, audience string) (*AssertionVerifier, error) {
+	if len(key) < minAssertionKeyLength {
+		return nil, errors.New("assertion key must be at least 32 bytes")
+	}
+	return &AssertionVerifier{
+		key:      []byte(key),
+		audience: audience,
+		now:      time.Now,
+	}, nil
+}
+
+// Verify checks the signature, audience and expiry of an
+// assertion and returns the user that it describes.
+func (v *AssertionVerifier) Verify(assertion string) (*OAuthUser, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidAssertion
+	}
+	var header assertionHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidAssertion
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidAssertion
+	}
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidAssertion
+	}
+	// the claims can be trusted from here on
+	var claims AssertionClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidAssertion
+	}
+	if claims.Audience != v.audience {
+		return nil, ErrAssertionAud
+	}
+	now := v.now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(assertionLeeway)) {
+		return nil, ErrExpiredAssertion
+	}
+	if now.Add(assertionLeeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, ErrInvalidAssertion
+	}
+	if claims.Subject == "" {
+		return nil, ErrInvalidAssertion
+	}
+	groups := make([]string, 0, len(claims.Groups))
+	for _, g := range claims.Groups {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return &OAuthUser{
+		Subject:  claims.Subject,
+		Groups:   groups,
+		Email:    claims.Email,
+		Username: claims.Username,
+	}, nil
+}
+
+func decodeSegment(s string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// AssertionMiddleware extracts the users identity from the
+// assertion signed by the oidc-proxy. Requests with an
+// assertion that can't be verified are rejected.
+func AssertionMiddleware(v *AssertionVerifier, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		assertion := r.Header.Get(HeaderAssertion)
+		if _, ok := GetContextUser(ctx); ok || assertion == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		log := logr.FromContextOrDiscard(ctx)
+		u, err := v.Verify(assertion)
+		if err != nil {
+			log.Info("rejecting invalid identity assertion", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		log.V(5).Info("injecting asserted user into context", "user", u)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(ctx, UserContextKey, u)))
+	})
+}
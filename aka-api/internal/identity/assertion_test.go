@@ -0,0 +1,169 @@
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAssertionKey = strings.Repeat("k", minAssertionKeyLength)
+
+func signAssertion(t *testing.T, key, alg string, claims AssertionClaims) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	body, err := json.Marshal(claims)
+	require.NoError(t, err)
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAssertionVerifier_Verify(t *testing.T) {
+	_, err := NewAssertionVerifier("short", "aka-api")
+	assert.Error(t, err)
+
+	v, err := NewAssertionVerifier(testAssertionKey, "aka-api")
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	v.now = func() time.Time {
+		return now
+	}
+	valid := AssertionClaims{
+		Subject:   "john",
+		Audience:  "aka-api",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(30 * time.Second).Unix(),
+		Email:     "john@example.org",
+		Groups:    []string{"devs", " ops"},
+		Username:  "John",
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		user, err := v.Verify(signAssertion(t, testAssertionKey, "HS256", valid))
+		require.NoError(t, err)
+		assert.EqualValues(t, "john", user.Subject)
+		assert.EqualValues(t, "john@example.org", user.Email)
+		assert.EqualValues(t, []string{"devs", "ops"}, user.Groups)
+		assert.EqualValues(t, "John", user.Username)
+	})
+
+	var cases = []struct {
+		name  string
+		token func(t *testing.T) string
+		err   error
+	}{
+		{
+			"wrong key",
+			func(t *testing.T) string {
+				return signAssertion(t, strings.Repeat("x", minAssertionKeyLength), "HS256", valid)
+			},
+			ErrInvalidAssertion,
+		},
+		{
+			"unsigned",
+			func(t *testing.T) string {
+				token := signAssertion(t, testAssertionKey, "none", valid)
+				return token[:strings.LastIndex(token, ".")+1]
+			},
+			ErrInvalidAssertion,
+		},
+		{
+			"tampered",
+			func(t *testing.T) string {
+				parts := strings.Split(signAssertion(t, testAssertionKey, "HS256", valid), ".")
+				claims := valid
+				claims.Subject = "admin"
+				body, _ := json.Marshal(claims)
+				parts[1] = base64.RawURLEncoding.EncodeToString(body)
+				return strings.Join(parts, ".")
+			},
+			ErrInvalidAssertion,
+		},
+		{
+			"wrong audience",
+			func(t *testing.T) string {
+				claims := valid
+				claims.Audience = "something-else"
+				return signAssertion(t, testAssertionKey, "HS256", claims)
+			},
+			ErrAssertionAud,
+		},
+		{
+			"expired",
+			func(t *testing.T) string {
+				claims := valid
+				claims.ExpiresAt = now.Add(-time.Minute).Unix()
+				return signAssertion(t, testAssertionKey, "HS256", claims)
+			},
+			ErrExpiredAssertion,
+		},
+		{
+			"issued in the future",
+			func(t *testing.T) string {
+				claims := valid
+				claims.IssuedAt = now.Add(time.Hour).Unix()
+				claims.ExpiresAt = now.Add(2 * time.Hour).Unix()
+				return signAssertion(t, testAssertionKey, "HS256", claims)
+			},
+			ErrInvalidAssertion,
+		},
+		{
+			"garbage",
+			func(*testing.T) string {
+				return "hunter2"
+			},
+			ErrInvalidAssertion,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(tt.token(t))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestAssertionMiddleware(t *testing.T) {
+	v, err := NewAssertionVerifier(testAssertionKey, "aka-api")
+	require.NoError(t, err)
+
+	var user *OAuthUser
+	h := AssertionMiddleware(v, Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = GetContextUser(r.Context())
+	})))
+
+	t.Run("the assertion is preferred", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderAssertion, signAssertion(t, testAssertionKey, "HS256", AssertionClaims{
+			Subject:   "john",
+			Audience:  "aka-api",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		}))
+		req.Header.Set(HeaderUser, "jane")
+		req.Header.Set(HeaderEmail, "jane@example.org")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		require.EqualValues(t, http.StatusOK, w.Code)
+		require.NotNil(t, user)
+		assert.EqualValues(t, "john", user.Subject)
+	})
+	t.Run("invalid assertions are rejected", func(t *testing.T) {
+		user = nil
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderAssertion, "hunter2")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+		assert.Nil(t, user)
+	})
+}
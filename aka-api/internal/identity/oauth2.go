@@ -25,6 +25,16 @@ type OAuthUser struct {
 	Groups   []string `json:"groups"`
 	Email    string   `json:"email"`
 	Username string   `json:"username"`
+	// Scope limits what the user can do when they
+	// authenticate with an access token. It is empty
+	// for interactive sessions.
+	Scope string `json:"scope,omitempty"`
+}
+
+// TokenVerifier exchanges a bearer token
+// for the identity of its user.
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (*OAuthUser, error)
 }
 
 func GetContextUser(ctx context.Context) (*OAuthUser, bool) {
@@ -35,10 +45,44 @@ func GetContextUser(ctx context.Context) (*OAuthUser, bool) {
 	return u, ok
 }
 
-// Middleware extracts the OAuth2 users identity if one is available
+// BearerMiddleware extracts the users identity from
+// an Authorization bearer token if one is given. Requests
+// with a token that can't be verified are rejected.
+//
+// Handlers further down the chain don't replace
+// the identity once it has been found.
+func BearerMiddleware(v TokenVerifier, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		u, err := v.VerifyToken(ctx, strings.TrimSpace(token))
+		if err != nil {
+			log.V(1).Info("rejecting invalid bearer token", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		log.V(5).Info("injecting token user into context", "user", u)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(ctx, UserContextKey, u)))
+	})
+}
+
+// Middleware extracts the OAuth2 users identity if one is available.
+//
+// The headers are trusted as-is, so this must only be used
+// when nothing but the oidc-proxy can reach us. Prefer
+// AssertionMiddleware.
 func Middleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		if _, ok := GetContextUser(ctx); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
 		log := logr.FromContextOrDiscard(ctx)
 		user := r.Header.Get(HeaderUser)
 		groups := r.Header.Get(HeaderGroups)
@@ -0,0 +1,167 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-logr/logr"
+	"strings"
+)
+
+// OIDCOptions configures how OIDC tokens
+// are validated and mapped to users.
+type OIDCOptions struct {
+	// IssuerURI is everything before the /.well-known/openid-configuration
+	// part of the discovery URL. Validation is disabled if it is empty.
+	IssuerURI string `split_words:"true"`
+	// Audience must be in the aud claim of every token,
+	// usually the client ID. It is required, otherwise
+	// tokens issued to any client would be accepted.
+	Audience string `split_words:"true"`
+	// Claim is where each part of the users identity is
+	// found. Nested claims are separated by dots,
+	// e.g. realm_access.roles
+	Claim struct {
+		Email    string `split_words:"true" default:"email"`
+		Username string `split_words:"true" default:"preferred_username"`
+		Groups   string `split_words:"true" default:"groups"`
+	}
+}
+
+// OIDCVerifier validates access and ID tokens that
+// were issued by an OIDC provider. The providers
+// signing keys are cached, and are fetched again
+// whenever a token is signed by a key that we
+// haven't seen before so that they can be rotated.
+type OIDCVerifier struct {
+	verifier *oidc.IDTokenVerifier
+	opts     OIDCOptions
+}
+
+var _ TokenVerifier = &OIDCVerifier{}
+
+// ErrNoAudience is returned by NewOIDCVerifier
+// if OIDCOptions.Audience isn't set.
+var ErrNoAudience = errors.New("an audience is required to validate oidc tokens")
+
+// NewOIDCVerifier discovers the configuration
+// of the OIDC provider.
+func NewOIDCVerifier(ctx context.Context, opts OIDCOptions) (*OIDCVerifier, error) {
+	if opts.Audience == "" {
+		return nil, ErrNoAudience
+	}
+	provider, err := oidc.NewProvider(ctx, opts.IssuerURI)
+	if err != nil {
+		return nil, fmt.Errorf("getting oidc provider: %w", err)
+	}
+	return &OIDCVerifier{
+		verifier: provider.Verifier(&oidc.Config{
+			ClientID: opts.Audience,
+		}),
+		opts: opts,
+	}, nil
+}
+
+// VerifyToken checks the signature, issuer, audience and
+// expiry of a token and returns the user that it describes.
+func (v *OIDCVerifier) VerifyToken(ctx context.Context, token string) (*OAuthUser, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	idToken, err := v.verifier.Verify(ctx, token)
+	if err != nil {
+		log.V(1).Info("failed to verify oidc token", "error", err.Error())
+		return nil, err
+	}
+	if idToken.Subject == "" {
+		return nil, errors.New("oidc token has no subject")
+	}
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		log.Error(err, "failed to parse oidc token claims")
+		return nil, err
+	}
+	email, _ := lookupClaim(claims, v.opts.Claim.Email).(string)
+	username, _ := lookupClaim(claims, v.opts.Claim.Username).(string)
+	return &OAuthUser{
+		Subject:  idToken.Subject,
+		Groups:   parseGroupsClaim(lookupClaim(claims, v.opts.Claim.Groups)),
+		Email:    email,
+		Username: username,
+	}, nil
+}
+
+// lookupClaim finds a claim by its dot-separated
+// path, returning nil if it doesn't exist.
+func lookupClaim(claims map[string]any, path string) any {
+	if path == "" {
+		return nil
+	}
+	var current any = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = m[key]
+	}
+	return current
+}
+
+// parseGroupsClaim reads groups from either a list
+// or a comma-separated string.
+func parseGroupsClaim(claim any) []string {
+	var groups []string
+	switch c := claim.(type) {
+	case []any:
+		for _, g := range c {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = strings.Split(c, ",")
+	}
+	results := make([]string, 0, len(groups))
+	for _, g := range groups {
+		if g = strings.TrimSpace(g); g != "" {
+			results = append(results, g)
+		}
+	}
+	return results
+}
+
+// ErrNoVerifier is returned by a ChainVerifier
+// that doesn't contain any TokenVerifiers.
+var ErrNoVerifier = errors.New("no token verifiers have been configured")
+
+// TokenRecogniser is implemented by TokenVerifiers
+// that can tell whether a token was issued by them
+// without verifying it, e.g. by its prefix.
+type TokenRecogniser interface {
+	RecognisesToken(token string) bool
+}
+
+// ChainVerifier tries each TokenVerifier in turn,
+// returning the first user that is found.
+//
+// If a TokenRecogniser recognises the token, only it
+// is tried so that its errors (e.g. the database being
+// unavailable) aren't hidden by the other verifiers.
+type ChainVerifier []TokenVerifier
+
+func (c ChainVerifier) VerifyToken(ctx context.Context, token string) (*OAuthUser, error) {
+	for _, v := range c {
+		if r, ok := v.(TokenRecogniser); ok && r.RecognisesToken(token) {
+			return v.VerifyToken(ctx, token)
+		}
+	}
+	err := ErrNoVerifier
+	for _, v := range c {
+		var user *OAuthUser
+		user, err = v.VerifyToken(ctx, token)
+		if err == nil {
+			return user, nil
+		}
+	}
+	return nil, err
+}
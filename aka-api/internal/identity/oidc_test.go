@@ -0,0 +1,262 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is a minimal OIDC provider that
+// serves its discovery document and signing keys.
+type fakeIssuer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	kid       string
+	key       *rsa.PrivateKey
+	jwksCalls atomic.Int32
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	iss := &fakeIssuer{}
+	iss.rotate(t, "1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                iss.URL,
+			"authorization_endpoint":                iss.URL + "/auth",
+			"token_endpoint":                        iss.URL + "/token",
+			"jwks_uri":                              iss.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		iss.jwksCalls.Add(1)
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": iss.kid,
+					"alg": "RS256",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(iss.key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(iss.key.E)).Bytes()),
+				},
+			},
+		})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// rotate replaces the signing key
+func (iss *fakeIssuer) rotate(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.kid = kid
+	iss.key = key
+}
+
+// sign creates an RS256 token with the given claims
+func (iss *fakeIssuer) sign(t *testing.T, claims map[string]any) string {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": iss.kid})
+	require.NoError(t, err)
+	body, err := json.Marshal(claims)
+	require.NoError(t, err)
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (iss *fakeIssuer) claims(sub string) map[string]any {
+	return map[string]any{
+		"iss":   iss.URL,
+		"sub":   sub,
+		"aud":   "aka",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"email": sub + "@example.org",
+		"name":  sub,
+		"realm_access": map[string]any{
+			"roles": []string{"devs", "ops"},
+		},
+	}
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	iss := newFakeIssuer(t)
+
+	opts := OIDCOptions{
+		IssuerURI: iss.URL,
+		Audience:  "aka",
+	}
+	opts.Claim.Email = "email"
+	opts.Claim.Username = "name"
+	opts.Claim.Groups = "realm_access.roles"
+	v, err := NewOIDCVerifier(ctx, opts)
+	require.NoError(t, err)
+
+	t.Run("claims are mapped", func(t *testing.T) {
+		user, err := v.VerifyToken(ctx, iss.sign(t, iss.claims("john")))
+		require.NoError(t, err)
+		assert.EqualValues(t, "john", user.Subject)
+		assert.EqualValues(t, "john@example.org", user.Email)
+		assert.EqualValues(t, "john", user.Username)
+		assert.EqualValues(t, []string{"devs", "ops"}, user.Groups)
+		assert.Empty(t, user.Scope)
+	})
+	t.Run("keys are cached", func(t *testing.T) {
+		calls := iss.jwksCalls.Load()
+		_, err := v.VerifyToken(ctx, iss.sign(t, iss.claims("jane")))
+		require.NoError(t, err)
+		assert.EqualValues(t, calls, iss.jwksCalls.Load())
+	})
+	t.Run("keys can be rotated", func(t *testing.T) {
+		old := iss.sign(t, iss.claims("john"))
+		iss.rotate(t, "2")
+		_, err := v.VerifyToken(ctx, iss.sign(t, iss.claims("john")))
+		require.NoError(t, err)
+
+		// tokens signed with the old key are
+		// no longer accepted
+		_, err = v.VerifyToken(ctx, old)
+		assert.Error(t, err)
+	})
+
+	var cases = []struct {
+		name   string
+		mutate func(claims map[string]any)
+	}{
+		{
+			"expired",
+			func(claims map[string]any) {
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			},
+		},
+		{
+			"wrong audience",
+			func(claims map[string]any) {
+				claims["aud"] = "something-else"
+			},
+		},
+		{
+			"wrong issuer",
+			func(claims map[string]any) {
+				claims["iss"] = "https://example.org"
+			},
+		},
+		{
+			"no subject",
+			func(claims map[string]any) {
+				delete(claims, "sub")
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := iss.claims("john")
+			tt.mutate(claims)
+			_, err := v.VerifyToken(ctx, iss.sign(t, claims))
+			assert.Error(t, err)
+		})
+	}
+	t.Run("forged tokens are rejected", func(t *testing.T) {
+		forger := newFakeIssuer(t)
+		claims := forger.claims("admin")
+		claims["iss"] = iss.URL
+		_, err := v.VerifyToken(ctx, forger.sign(t, claims))
+		assert.Error(t, err)
+	})
+	t.Run("an audience is required", func(t *testing.T) {
+		_, err := NewOIDCVerifier(ctx, OIDCOptions{IssuerURI: iss.URL})
+		assert.ErrorIs(t, err, ErrNoAudience)
+	})
+}
+
+func TestParseGroupsClaim(t *testing.T) {
+	var cases = []struct {
+		in  any
+		out []string
+	}{
+		{nil, []string{}},
+		{"devs, ops", []string{"devs", "ops"}},
+		{[]any{"devs", 1, "ops"}, []string{"devs", "ops"}},
+		{map[string]any{}, []string{}},
+	}
+	for _, tt := range cases {
+		t.Run("", func(t *testing.T) {
+			assert.EqualValues(t, tt.out, parseGroupsClaim(tt.in))
+		})
+	}
+}
+
+// fakeVerifier accepts a single token and
+// recognises those with a given prefix.
+type fakeVerifier struct {
+	token  string
+	prefix string
+	err    error
+}
+
+func (v *fakeVerifier) VerifyToken(_ context.Context, token string) (*OAuthUser, error) {
+	if token != v.token {
+		return nil, v.err
+	}
+	return &OAuthUser{Subject: token}, nil
+}
+
+func (v *fakeVerifier) RecognisesToken(token string) bool {
+	return v.prefix != "" && strings.HasPrefix(token, v.prefix)
+}
+
+func TestChainVerifier(t *testing.T) {
+	ctx := context.TODO()
+	_, err := ChainVerifier{}.VerifyToken(ctx, "hunter2")
+	assert.ErrorIs(t, err, ErrNoVerifier)
+
+	errDatabase := errors.New("database is unavailable")
+	errInvalid := errors.New("invalid token")
+	chain := ChainVerifier{
+		&fakeVerifier{token: "aka_john", prefix: "aka_", err: errDatabase},
+		&fakeVerifier{token: "jane", err: errInvalid},
+	}
+
+	user, err := chain.VerifyToken(ctx, "aka_john")
+	require.NoError(t, err)
+	assert.EqualValues(t, "aka_john", user.Subject)
+
+	user, err = chain.VerifyToken(ctx, "jane")
+	require.NoError(t, err)
+	assert.EqualValues(t, "jane", user.Subject)
+
+	// recognised tokens aren't passed on, so
+	// their errors aren't hidden
+	_, err = chain.VerifyToken(ctx, "aka_jane")
+	assert.ErrorIs(t, err, errDatabase)
+}
@@ -0,0 +1,158 @@
+package graph
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"strconv"
+)
+
+// auditResource finds the resource that a mutation changes.
+// The result is nil if the mutation hasn't been run yet,
+// so resources that are only known once the mutation
+// has finished (e.g. newly created Jumps) return "".
+type auditResource func(args map[string]any, result any) string
+
+func jumpArg(args map[string]any, _ any) string {
+	id, _ := args["id"].(int)
+	return schemas.ResourceName(schemas.ResourceJump, uint(id))
+}
+
+func groupArg(args map[string]any, _ any) string {
+	id, _ := args["id"].(int)
+	return schemas.ResourceName(schemas.ResourceGroup, uint(id))
+}
+
+var auditResources = map[string]auditResource{
+	"createJump": func(_ map[string]any, result any) string {
+		if jump, ok := result.(*model.Jump); ok && jump != nil {
+			return schemas.ResourceName(schemas.ResourceJump, jump.ID)
+		}
+		return ""
+	},
+	"patchJump": func(args map[string]any, _ any) string {
+		input, _ := args["input"].(model.EditJump)
+		return schemas.ResourceName(schemas.ResourceJump, uint(input.ID))
+	},
+	"deleteJump": jumpArg,
+	"createGroup": func(_ map[string]any, result any) string {
+		if group, ok := result.(*model.Group); ok && group != nil {
+			return schemas.ResourceName(schemas.ResourceGroup, group.ID)
+		}
+		return ""
+	},
+	"patchGroup": func(args map[string]any, _ any) string {
+		input, _ := args["input"].(model.EditGroup)
+		return schemas.ResourceName(schemas.ResourceGroup, uint(input.ID))
+	},
+	"deleteGroup": func(args map[string]any, _ any) string {
+		input, _ := args["input"].(model.DeleteGroup)
+		return schemas.ResourceName(schemas.ResourceGroup, uint(input.ID))
+	},
+	"archiveGroup":       groupArg,
+	"addGroupMember":     groupArg,
+	"setGroupMemberRole": groupArg,
+	"removeGroupMember":  groupArg,
+	"leaveGroup":         groupArg,
+	"deactivateUser": func(args map[string]any, _ any) string {
+		input, _ := args["input"].(model.DeactivateUser)
+		return "user://" + input.Subject
+	},
+	"reactivateUser": func(args map[string]any, _ any) string {
+		subject, _ := args["subject"].(string)
+		return "user://" + subject
+	},
+	"createAccessToken": func(_ map[string]any, result any) string {
+		if token, ok := result.(*model.CreatedToken); ok && token != nil && token.AccessToken != nil {
+			return "token://" + strconv.FormatUint(uint64(token.AccessToken.ID), 10)
+		}
+		return ""
+	},
+	"revokeAccessToken": func(args map[string]any, _ any) string {
+		id, _ := args["id"].(int)
+		return "token://" + strconv.Itoa(id)
+	},
+	"createWebhook": func(_ map[string]any, result any) string {
+		if hook, ok := result.(*model.CreatedWebhook); ok && hook != nil && hook.Webhook != nil {
+			return "webhook://" + strconv.FormatUint(uint64(hook.Webhook.ID), 10)
+		}
+		return ""
+	},
+	"deleteWebhook": func(args map[string]any, _ any) string {
+		id, _ := args["id"].(int)
+		return "webhook://" + strconv.Itoa(id)
+	},
+	"redeliverWebhook": func(_ map[string]any, result any) string {
+		if delivery, ok := result.(*model.WebhookDelivery); ok && delivery != nil {
+			return "webhook://" + strconv.FormatUint(uint64(delivery.WebhookID), 10)
+		}
+		return ""
+	},
+	"proposeJump": func(_ map[string]any, result any) string {
+		if p, ok := result.(*model.JumpProposal); ok && p != nil {
+			return "proposal://" + strconv.FormatUint(uint64(p.ID), 10)
+		}
+		return ""
+	},
+	"approveJump": func(_ map[string]any, result any) string {
+		if p, ok := result.(*model.JumpProposal); ok && p != nil {
+			return schemas.ResourceName(schemas.ResourceJump, p.JumpID)
+		}
+		return ""
+	},
+	"rejectJump": func(args map[string]any, _ any) string {
+		id, _ := args["id"].(int)
+		return "proposal://" + strconv.Itoa(id)
+	},
+}
+
+// AuditMutations records every mutation that succeeds
+// in the audit log, along with a snapshot of what it
+// changed before and after it was run.
+func (r *Resolver) AuditMutations(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || fc.Object != "Mutation" || !fc.IsResolver {
+		return next(ctx)
+	}
+	user, ok := identity.GetContextUser(ctx)
+	if !ok {
+		return next(ctx)
+	}
+	action := fc.Field.Name
+	lookup, known := auditResources[action]
+
+	// snapshot the resource before it's changed,
+	// if we already know what it is
+	var resource, before string
+	if known {
+		resource = lookup(fc.Args, nil)
+		if resource != "" {
+			before = r.auditService.Snapshot(ctx, resource)
+		}
+	}
+	result, err := next(ctx)
+	if err != nil {
+		return result, err
+	}
+	if known && resource == "" {
+		resource = lookup(fc.Args, result)
+	}
+	e := &model.AuditEvent{
+		Actor:    user.Subject,
+		Action:   action,
+		Resource: resource,
+		Before:   before,
+	}
+	if resource != "" {
+		e.After = r.auditService.Snapshot(ctx, resource)
+	}
+	// the change has already been made, so there's
+	// no point failing the request
+	if err := r.auditService.Record(ctx, e); err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to record audit event", "Action", action, "Resource", resource)
+	}
+	return result, nil
+}
@@ -0,0 +1,19 @@
+package graph
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/generated"
+	"testing"
+)
+
+func TestAuditResources(t *testing.T) {
+	schema := generated.NewExecutableSchema(generated.Config{Resolvers: &Resolver{}}).Schema()
+	for _, field := range schema.Mutation.Fields {
+		if field.Name == "__schema" || field.Name == "__type" {
+			continue
+		}
+		t.Run(field.Name, func(t *testing.T) {
+			assert.Contains(t, auditResources, field.Name, "mutations must say what resource they change")
+		})
+	}
+}
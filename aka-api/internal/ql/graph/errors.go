@@ -0,0 +1,29 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+)
+
+// CodeURLPolicy is the extension code given to errors
+// caused by a Jump breaking the URL policy.
+const CodeURLPolicy = "URL_POLICY_VIOLATION"
+
+// PresentError adds extensions to the errors that
+// clients need to be able to tell apart, such as
+// the rule that a Jump's destination broke.
+func PresentError(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+	var violation *policy.Violation
+	if errors.As(err, &violation) {
+		if gqlErr.Extensions == nil {
+			gqlErr.Extensions = map[string]any{}
+		}
+		gqlErr.Extensions["code"] = CodeURLPolicy
+		gqlErr.Extensions["rule"] = violation.Rule
+	}
+	return gqlErr
+}
@@ -0,0 +1,35 @@
+package model
+
+// AuditEvent records a change that was made
+// by a user.
+type AuditEvent struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+	// Actor is the user that made the change. It is
+	// "scim" for changes made by the identity provider.
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	// Resource is what was changed, e.g. jump://12. It
+	// is empty if we don't know what the change affected.
+	Resource string `json:"resource"`
+	// Before and After are JSON snapshots of the
+	// Resource. They are empty if the Resource
+	// didn't exist.
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	RequestID string `json:"requestId"`
+	Date      int64  `json:"date"`
+}
+
+func (AuditEvent) TableName() string {
+	return TableNameAuditEvents
+}
+
+// AuditFilter narrows down the AuditEvents that
+// are returned. Empty fields match everything.
+type AuditFilter struct {
+	Actor    string `json:"actor"`
+	Resource string `json:"resource"`
+	// Since and Until are unix timestamps
+	Since int64 `json:"since"`
+	Until int64 `json:"until"`
+}
@@ -18,16 +18,27 @@
 package model
 
 import (
+	"fmt"
 	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Group struct {
 	gorm.Model
-	Name     string `json:"name" ,gorm:"unique"`
-	Public   bool   `json:"public"` // visible to all
-	Owner    string `json:"owner"`
-	Users    string `json:"users"`
+	Name   string `json:"name" ,gorm:"unique"`
+	Public bool   `json:"public"` // visible to all
+	Owner  string `json:"owner"`
+	// Users is a comma-separated list of members. It is
+	// filled in by the repository when reading and is
+	// not saved, use GroupMember instead.
+	Users    string `json:"users" gorm:"-"`
 	External bool   `json:"external"`
+	// Archived groups and their Jumps are hidden
+	// from everything except their members.
+	Archived bool `json:"archived"`
 }
 
 func (*Group) IsPageable() {}
@@ -35,3 +46,105 @@ func (*Group) IsPageable() {}
 func (Group) TableName() string {
 	return TableNameGroups
 }
+
+// GroupRole is what a member is allowed
+// to do within a Group.
+type GroupRole string
+
+const (
+	// GroupRoleOwner members manage the Group's
+	// settings and members.
+	GroupRoleOwner GroupRole = "owner"
+	// GroupRoleMaintainer members manage the
+	// Group's Jumps.
+	GroupRoleMaintainer GroupRole = "maintainer"
+	// GroupRoleMember members can only use
+	// the Group's Jumps.
+	GroupRoleMember GroupRole = "member"
+)
+
+var groupRoleRanks = map[GroupRole]int{
+	GroupRoleMember:     1,
+	GroupRoleMaintainer: 2,
+	GroupRoleOwner:      3,
+}
+
+// AtLeast returns true if the role grants
+// everything that the given role does.
+func (r GroupRole) AtLeast(role GroupRole) bool {
+	rank, ok := groupRoleRanks[r]
+	return ok && rank >= groupRoleRanks[role]
+}
+
+// IsValid returns true if the role is known.
+func (r GroupRole) IsValid() bool {
+	_, ok := groupRoleRanks[r]
+	return ok
+}
+
+func (r *GroupRole) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+	*r = GroupRole(strings.ToLower(str))
+	if !r.IsValid() {
+		return fmt.Errorf("%s is not a valid GroupRole", str)
+	}
+	return nil
+}
+
+func (r GroupRole) MarshalGQL(w io.Writer) {
+	_, _ = fmt.Fprint(w, strconv.Quote(strings.ToUpper(string(r))))
+}
+
+// where a GroupMember came from
+const (
+	// GroupSourceManual members were added by a user
+	GroupSourceManual = "manual"
+	// GroupSourceIdP members were added because the
+	// identity provider said so
+	GroupSourceIdP = "idp"
+	// GroupSourceSCIM members were provisioned by
+	// the identity provider using SCIM
+	GroupSourceSCIM = "scim"
+)
+
+// GroupMember records that a user belongs to a Group.
+type GroupMember struct {
+	GroupID uint      `json:"groupId" gorm:"primaryKey;autoIncrement:false"`
+	Subject string    `json:"subject" gorm:"primaryKey"`
+	Role    GroupRole `json:"role"`
+	Source  string    `json:"source"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+func (GroupMember) TableName() string {
+	return TableNameGroupMembers
+}
+
+// what happened to a member of a Group
+const (
+	GroupMemberAdded       = "added"
+	GroupMemberRemoved     = "removed"
+	GroupMemberRoleChanged = "role_changed"
+)
+
+// GroupMemberEvent records a change to the
+// members of a Group.
+type GroupMemberEvent struct {
+	ID      uint      `json:"id" gorm:"primaryKey"`
+	GroupID uint      `json:"groupId"`
+	Subject string    `json:"subject"`
+	Action  string    `json:"action"`
+	Role    GroupRole `json:"role"`
+	Source  string    `json:"source"`
+	// Actor is the user that made the change. It is
+	// empty if the change was made by aka itself.
+	Actor string `json:"actor"`
+	Date  int64  `json:"date"`
+}
+
+func (GroupMemberEvent) TableName() string {
+	return TableNameGroupMemberEvents
+}
@@ -20,6 +20,8 @@ package model
 import (
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/datatypes"
 	"gorm.io/gorm"
+	"strconv"
+	"strings"
 )
 
 type Jump struct {
@@ -41,6 +43,20 @@ func (j *Jump) IsPublic() bool {
 	return j.Owner == ""
 }
 
+// GroupID returns the ID of the Group that
+// owns the jump, if it is owned by a Group.
+func (j *Jump) GroupID() (uint, bool) {
+	id, ok := strings.CutPrefix(j.Owner, "group://")
+	if !ok {
+		return 0, false
+	}
+	gid, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(gid), true
+}
+
 // Equals checks whether the Jump ID matches the other
 func (j *Jump) Equals(other *Jump) bool {
 	return other.ID == j.ID
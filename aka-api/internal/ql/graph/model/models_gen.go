@@ -16,6 +16,42 @@ type ApplicationSettings struct {
 	AllowPublicLinkCreation bool `json:"allowPublicLinkCreation"`
 }
 
+type ChangeEvent struct {
+	Type ChangeType `json:"type"`
+	// ID of the item that changed, empty for RESYNC
+	ID string `json:"id"`
+	// the item after it changed, only set for ADDED and UPDATED
+	Item Pageable `json:"item,omitempty"`
+	// the full page of results, only set for RESYNC
+	Page *Page `json:"page,omitempty"`
+}
+
+type CreatedToken struct {
+	// the token to send in the Authorization header. It can't be retrieved again.
+	Token       string       `json:"token"`
+	AccessToken *AccessToken `json:"accessToken"`
+}
+
+type CreatedWebhook struct {
+	// the key used to sign requests sent to the webhook. It can't be retrieved again.
+	Secret  string   `json:"secret"`
+	Webhook *Webhook `json:"webhook"`
+}
+
+type DeactivateUser struct {
+	Subject string `json:"subject"`
+	// the owner that the user's jumps are given to, e.g. user://jane or group://2. If empty, the jumps are left with the user. Groups owned by the user are only handed over to users, otherwise another member becomes the owner.
+	HandoverTo string `json:"handoverTo"`
+	// describes what would change without changing anything
+	DryRun bool `json:"dryRun"`
+}
+
+type DeleteGroup struct {
+	ID int `json:"id"`
+	// the owner that the group's jumps are given to, e.g. user://john or group://2. Users must be yourself or a member of the group. If empty, the jumps are deleted along with the group.
+	ReassignTo string `json:"reassignTo"`
+}
+
 type EditGroup struct {
 	ID     int    `json:"id"`
 	Public bool   `json:"public"`
@@ -32,6 +68,13 @@ type EditJump struct {
 type Mutation struct {
 }
 
+type NewAccessToken struct {
+	Name  string     `json:"name"`
+	Scope TokenScope `json:"scope"`
+	// how many days the token can be used for, 0 if it never expires
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
 type NewGroup struct {
 	Name   string `json:"name"`
 	Public bool   `json:"public"`
@@ -44,6 +87,19 @@ type NewJump struct {
 	Group    int      `json:"group"`
 }
 
+type NewJumpProposal struct {
+	Name     string   `json:"name"`
+	Location string   `json:"location"`
+	Alias    []string `json:"alias"`
+}
+
+type NewWebhook struct {
+	// where events are sent, must be an http or https url that isn't on a private network
+	URL string `json:"url"`
+	// the group whose changes are sent, requires the OWNER role. If 0, every change is sent and admin is required.
+	Group int `json:"group"`
+}
+
 type Page struct {
 	Results []Pageable `json:"results"`
 	Count   int        `json:"count"`
@@ -72,6 +128,64 @@ type User struct {
 
 func (User) IsPageable() {}
 
+type UserHandover struct {
+	Subject    string `json:"subject"`
+	HandoverTo string `json:"handoverTo"`
+	DryRun     bool   `json:"dryRun"`
+	// the jumps owned by the user, these are given to handoverTo if it is set
+	Jumps []*Jump `json:"jumps"`
+	// the groups that the user is removed from
+	Groups []*Group `json:"groups"`
+	// the groups owned by the user, these are given to handoverTo if it is a user, otherwise to another member or archived if there are none
+	OwnedGroups []*Group `json:"ownedGroups"`
+}
+
+type ChangeType string
+
+const (
+	// the full page of results, sent when subscribing and whenever the client should discard what it has
+	ChangeTypeResync  ChangeType = "RESYNC"
+	ChangeTypeAdded   ChangeType = "ADDED"
+	ChangeTypeUpdated ChangeType = "UPDATED"
+	ChangeTypeRemoved ChangeType = "REMOVED"
+)
+
+var AllChangeType = []ChangeType{
+	ChangeTypeResync,
+	ChangeTypeAdded,
+	ChangeTypeUpdated,
+	ChangeTypeRemoved,
+}
+
+func (e ChangeType) IsValid() bool {
+	switch e {
+	case ChangeTypeResync, ChangeTypeAdded, ChangeTypeUpdated, ChangeTypeRemoved:
+		return true
+	}
+	return false
+}
+
+func (e ChangeType) String() string {
+	return string(e)
+}
+
+func (e *ChangeType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ChangeType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ChangeType", str)
+	}
+	return nil
+}
+
+func (e ChangeType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
 type Verb string
 
 const (
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"fmt"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/datatypes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JumpProposal is a public Jump that a user has asked
+// to be created. It must be approved by an administrator
+// before the Jump is created.
+type JumpProposal struct {
+	ID       uint                `json:"id" gorm:"primaryKey"`
+	Name     string              `json:"name"`
+	Location string              `json:"location"`
+	Alias    datatypes.JSONArray `json:"alias"`
+	// Submitter is the user that proposed the Jump. It
+	// is stored as the owner so that change notifications
+	// say who the proposal belongs to.
+	Submitter string         `json:"submitter" gorm:"column:owner"`
+	Status    ProposalStatus `json:"status"`
+	// Reason explains why the proposal was rejected
+	Reason     string `json:"reason"`
+	ReviewedBy string `json:"reviewedBy"`
+	// JumpID is the Jump that was created when
+	// the proposal was approved.
+	JumpID uint `json:"jumpId"`
+	// CreatedAt and ReviewedAt are unix timestamps.
+	// ReviewedAt is 0 until the proposal is reviewed.
+	CreatedAt  int64 `json:"createdAt"`
+	ReviewedAt int64 `json:"reviewedAt"`
+}
+
+func (JumpProposal) TableName() string {
+	return TableNameJumpProposals
+}
+
+// ProposalStatus is the state of a JumpProposal.
+type ProposalStatus string
+
+const (
+	// ProposalStatusProposed proposals are
+	// waiting to be reviewed.
+	ProposalStatusProposed ProposalStatus = "proposed"
+	// ProposalStatusApproved proposals have
+	// been turned into a Jump.
+	ProposalStatusApproved ProposalStatus = "approved"
+	// ProposalStatusRejected proposals won't
+	// be turned into a Jump.
+	ProposalStatusRejected ProposalStatus = "rejected"
+)
+
+// IsValid returns true if the status is known.
+func (s ProposalStatus) IsValid() bool {
+	switch s {
+	case ProposalStatusProposed, ProposalStatusApproved, ProposalStatusRejected:
+		return true
+	}
+	return false
+}
+
+func (s *ProposalStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+	*s = ProposalStatus(strings.ToLower(str))
+	if !s.IsValid() {
+		return fmt.Errorf("%s is not a valid ProposalStatus", str)
+	}
+	return nil
+}
+
+func (s ProposalStatus) MarshalGQL(w io.Writer) {
+	_, _ = fmt.Fprint(w, strconv.Quote(strings.ToUpper(string(s))))
+}
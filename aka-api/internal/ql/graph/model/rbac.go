@@ -18,3 +18,16 @@ func (v Verb) DAO() rbac.Verb {
 		return -1
 	}
 }
+
+// Verb returns the action that a member with
+// the role is given on their Group.
+func (r GroupRole) Verb() Verb {
+	switch r {
+	case GroupRoleOwner:
+		return VerbSudo
+	case GroupRoleMaintainer:
+		return VerbUpdate
+	default:
+		return VerbRead
+	}
+}
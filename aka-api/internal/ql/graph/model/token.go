@@ -0,0 +1,76 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// AccessToken is a personal access token that lets
+// a user call the API from scripts. Only a hash of
+// the token is stored.
+type AccessToken struct {
+	ID      uint       `json:"id" gorm:"primaryKey"`
+	Subject string     `json:"subject"`
+	Name    string     `json:"name"`
+	Hash    string     `json:"-"`
+	Prefix  string     `json:"prefix"`
+	Scope   TokenScope `json:"scope"`
+	// CreatedAt, ExpiresAt and LastUsedAt are unix
+	// timestamps. ExpiresAt and LastUsedAt are 0 if
+	// the token never expires or hasn't been used.
+	CreatedAt  int64 `json:"createdAt"`
+	ExpiresAt  int64 `json:"expiresAt"`
+	LastUsedAt int64 `json:"lastUsedAt"`
+}
+
+func (AccessToken) TableName() string {
+	return TableNameAccessTokens
+}
+
+// Expired returns true if the token can no
+// longer be used at the given unix time.
+func (t *AccessToken) Expired(now int64) bool {
+	return t.ExpiresAt > 0 && now >= t.ExpiresAt
+}
+
+// TokenScope limits what an AccessToken can do.
+type TokenScope string
+
+const (
+	// TokenScopeReadOnly tokens can only run
+	// queries and subscriptions.
+	TokenScopeReadOnly TokenScope = "read_only"
+	// TokenScopeJumpsWrite tokens can also create,
+	// change and delete Jumps.
+	TokenScopeJumpsWrite TokenScope = "jumps_write"
+	// TokenScopeAdmin tokens can do anything that
+	// their user can.
+	TokenScopeAdmin TokenScope = "admin"
+)
+
+// IsValid returns true if the scope is known.
+func (s TokenScope) IsValid() bool {
+	switch s {
+	case TokenScopeReadOnly, TokenScopeJumpsWrite, TokenScopeAdmin:
+		return true
+	}
+	return false
+}
+
+func (s *TokenScope) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+	*s = TokenScope(strings.ToLower(str))
+	if !s.IsValid() {
+		return fmt.Errorf("%s is not a valid TokenScope", str)
+	}
+	return nil
+}
+
+func (s TokenScope) MarshalGQL(w io.Writer) {
+	_, _ = fmt.Fprint(w, strconv.Quote(strings.ToUpper(string(s))))
+}
@@ -18,8 +18,14 @@
 package model
 
 const (
-	TableNameGroups  = "groups"
-	TableNameUsers   = "users"
-	TableNameUsersV2 = "users_v2"
-	TableNameJumps   = "jumps"
+	TableNameGroups            = "groups"
+	TableNameUsersV2           = "users_v2"
+	TableNameJumps             = "jumps"
+	TableNameGroupMembers      = "group_members"
+	TableNameGroupMemberEvents = "group_member_events"
+	TableNameAccessTokens      = "access_tokens"
+	TableNameAuditEvents       = "audit_events"
+	TableNameWebhooks          = "webhooks"
+	TableNameWebhookDeliveries = "webhook_deliveries"
+	TableNameJumpProposals     = "jump_proposals"
 )
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Webhook sends changes to Jumps and Groups to
+// another service as they happen.
+type Webhook struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+	// GroupID is the Group whose Jumps (and itself) the
+	// Webhook is told about. If it is 0, the Webhook is
+	// told about everything and only administrators can
+	// manage it.
+	GroupID uint   `json:"groupId"`
+	URL     string `json:"url"`
+	// Secret is used to sign each request so that
+	// the receiver knows that it came from us.
+	Secret    string `json:"-"`
+	CreatedBy string `json:"createdBy"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+func (Webhook) TableName() string {
+	return TableNameWebhooks
+}
+
+// WebhookDelivery is an attempt to send a single
+// event to a Webhook. Deliveries that keep failing
+// are eventually given up on and marked as dead.
+type WebhookDelivery struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	WebhookID uint   `json:"webhookId"`
+	Event     string `json:"event"`
+	// Payload is the JSON body that is sent
+	Payload  string         `json:"payload"`
+	Status   DeliveryStatus `json:"status"`
+	Attempts int            `json:"attempts"`
+	// ResponseCode and LastError describe the most
+	// recent attempt. ResponseCode is 0 if the
+	// receiver couldn't be reached.
+	ResponseCode int    `json:"responseCode"`
+	LastError    string `json:"lastError"`
+	// CreatedAt, UpdatedAt and NextAttemptAt
+	// are unix timestamps.
+	CreatedAt     int64 `json:"createdAt"`
+	UpdatedAt     int64 `json:"updatedAt"`
+	NextAttemptAt int64 `json:"nextAttemptAt"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return TableNameWebhookDeliveries
+}
+
+// DeliveryStatus is the state of a WebhookDelivery.
+type DeliveryStatus string
+
+const (
+	// DeliveryStatusPending deliveries haven't been
+	// delivered yet, but will be tried again.
+	DeliveryStatusPending DeliveryStatus = "pending"
+	// DeliveryStatusDelivered deliveries were
+	// accepted by the receiver.
+	DeliveryStatusDelivered DeliveryStatus = "delivered"
+	// DeliveryStatusDead deliveries failed too many
+	// times and won't be tried again unless asked.
+	DeliveryStatusDead DeliveryStatus = "dead"
+)
+
+// IsValid returns true if the status is known.
+func (s DeliveryStatus) IsValid() bool {
+	switch s {
+	case DeliveryStatusPending, DeliveryStatusDelivered, DeliveryStatusDead:
+		return true
+	}
+	return false
+}
+
+func (s *DeliveryStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+	*s = DeliveryStatus(strings.ToLower(str))
+	if !s.IsValid() {
+		return fmt.Errorf("%s is not a valid DeliveryStatus", str)
+	}
+	return nil
+}
+
+func (s DeliveryStatus) MarshalGQL(w io.Writer) {
+	_, _ = fmt.Fprint(w, strconv.Quote(strings.ToUpper(string(s))))
+}
@@ -5,6 +5,7 @@ package graph
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
 	"github.com/go-logr/logr"
@@ -12,7 +13,9 @@ import (
 	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/api"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/svc"
+	"slices"
 )
 
 // This file will not be regenerated automatically.
@@ -33,19 +36,27 @@ type Resolver struct {
 	jumpService         *api.JumpService
 	jumpEventService    *api.JumpEventService
 	similarService      *api.SimilarService
+	tokenService        *api.TokenService
+	auditService        *api.AuditService
+	webhookService      *api.WebhookService
+	proposalService     *api.JumpProposalService
 	authz               rbac.AuthorityClient
 	adminGroups         []string
 	applicationSettings *model.ApplicationSettings
 }
 
-func NewResolver(ctx context.Context, repos *dao.Repos, similarSvc *svc.SimilarService, authz rbac.AuthorityClient, allowPublicJumpCreation bool, adminGroups []string, notifiers map[string]chan *dao.Message) *Resolver {
+func NewResolver(ctx context.Context, repos *dao.Repos, similarSvc *svc.SimilarService, authz rbac.AuthorityClient, allowPublicJumpCreation bool, urlPolicy *policy.Policy, adminGroups []string, feed dao.ChangeFeed, fanout api.FanoutOptions, webhooks api.WebhookOptions) (*Resolver, error) {
 	r := new(Resolver)
 	r.repos = repos
-	r.userService = api.NewUserService(ctx, repos, notifiers[model.TableNameUsers])
-	r.groupService = api.NewGroupService(ctx, repos, authz, notifiers[model.TableNameGroups])
-	r.jumpService = api.NewJumpService(ctx, repos, authz, allowPublicJumpCreation, notifiers[model.TableNameJumps])
+	r.userService = api.NewUserService(ctx, repos, authz, feed)
+	r.groupService = api.NewGroupService(ctx, repos, authz, feed)
+	r.jumpService = api.NewJumpService(ctx, repos, authz, allowPublicJumpCreation, urlPolicy, feed)
 	r.jumpEventService = api.NewJumpEventService(repos)
-	r.similarService = api.NewSimilarService(repos, similarSvc)
+	r.similarService = api.NewSimilarService(repos, similarSvc, urlPolicy)
+	r.tokenService = api.NewTokenService(repos)
+	r.auditService = api.NewAuditService(repos)
+	r.webhookService = api.NewWebhookService(repos, authz, webhooks)
+	r.proposalService = api.NewJumpProposalService(ctx, repos, authz, urlPolicy, feed)
 	r.authz = authz
 	r.adminGroups = adminGroups
 	r.applicationSettings = &model.ApplicationSettings{
@@ -53,11 +64,19 @@ func NewResolver(ctx context.Context, repos *dao.Repos, similarSvc *svc.SimilarS
 	}
 
 	// start listener threads
-	go r.groupService.Listen()
-	go r.userService.Listen()
-	go r.jumpService.Listen()
+	for _, l := range []*api.ListeningService{r.groupService.ListeningService, r.userService.ListeningService, r.jumpService.ListeningService, r.proposalService.ListeningService} {
+		if err := l.Listen(ctx, fanout); err != nil {
+			return nil, err
+		}
+	}
+
+	if webhooks.Enabled {
+		if err := r.webhookService.Run(ctx, feed); err != nil {
+			return nil, err
+		}
+	}
 
-	return r
+	return r, nil
 }
 
 // CanI checks that the requesting user is allowed
@@ -86,30 +105,201 @@ func (r *Resolver) CanI(ctx context.Context, resource string, action rbac.Verb)
 	return nil
 }
 
+// requireAdmin checks that the requesting user is
+// an administrator, either because they have the
+// SUPER role or are in one of the admin groups.
+func (r *Resolver) requireAdmin(ctx context.Context) error {
+	user, ok := identity.GetContextUser(ctx)
+	if !ok {
+		return ErrUnauthorised
+	}
+	// access tokens need the admin scope to
+	// do anything that an administrator can
+	if user.Scope != "" && user.Scope != string(model.TokenScopeAdmin) {
+		return ErrForbidden
+	}
+	if slices.ContainsFunc(user.Groups, func(s string) bool {
+		return slices.Contains(r.adminGroups, s)
+	}) {
+		return nil
+	}
+	return r.CanI(ctx, api.RoleSuper, rbac.Verb_SUDO)
+}
+
+// canManageWebhooks checks that the requesting user is
+// allowed to manage the Webhooks of a Group. Webhooks
+// that aren't part of a Group can see everything, so
+// they can only be managed by administrators.
+func (r *Resolver) canManageWebhooks(ctx context.Context, groupID uint) error {
+	if groupID == 0 {
+		return r.requireAdmin(ctx)
+	}
+	return r.webhookService.CanManage(ctx, groupID)
+}
+
+// getManagedWebhook returns a Webhook if the requesting
+// user is allowed to manage it.
+func (r *Resolver) getManagedWebhook(ctx context.Context, id uint) (*model.Webhook, error) {
+	hook, err := r.webhookService.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.canManageWebhooks(ctx, hook.GroupID); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// warnDuplicates warns the client if a Jump looks
+// like a copy of one that they can already see. The
+// Jump has already been saved, so failing to check
+// isn't worth failing the request over.
+func (r *Resolver) warnDuplicates(ctx context.Context, jump *model.Jump) {
+	dupes, err := r.similarService.GetDuplicates(ctx, jump)
+	if err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to check for duplicate jumps", "ID", jump.ID)
+		return
+	}
+	if len(dupes.Location) > 0 {
+		addWarning(ctx, &Warning{
+			Code:    WarningDuplicateLocation,
+			Message: fmt.Sprintf("%d other jump(s) already point to %s", len(dupes.Location), jump.Location),
+			Jumps:   jumpIDs(dupes.Location),
+		})
+	}
+	if len(dupes.Name) > 0 {
+		addWarning(ctx, &Warning{
+			Code:    WarningSimilarName,
+			Message: fmt.Sprintf("%d other jump(s) have a name similar to %s", len(dupes.Name), jump.Name),
+			Jumps:   jumpIDs(dupes.Name),
+		})
+	}
+}
+
+func jumpIDs(jumps []*model.Jump) []uint {
+	ids := make([]uint, len(jumps))
+	for i := range jumps {
+		ids[i] = jumps[i].ID
+	}
+	return ids
+}
+
 func (r *Resolver) streamPage(ctx context.Context, svc *api.ListeningService, f func(message *dao.Message) (*model.Page, error)) chan *model.Page {
 	events := make(chan *model.Page, 1)
-	l := make(chan *dao.Message)
 	// start listening
-	svc.AddListener(l)
+	sub := svc.Subscribe()
 	// do a first call so the client gets data straight away
 	items, err := f(nil)
 	if err == nil {
 		events <- items
 	}
 	go func() {
+		// stop listening
+		defer svc.Unsubscribe(sub)
 		for {
 			select {
 			case <-ctx.Done():
-				// stop listening
-				svc.RemoveListener(l)
 				return
-			case m := <-l:
+			case m := <-sub.C():
 				items, err := f(m)
 				if err != nil {
 					graphql.AddError(ctx, err)
 					continue
 				}
-				events <- items
+				select {
+				case events <- items:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// streamReviewed sends the subscriber each of their
+// JumpProposals as soon as it has been reviewed.
+func (r *Resolver) streamReviewed(ctx context.Context) chan *model.JumpProposal {
+	events := make(chan *model.JumpProposal, 1)
+	sub := r.proposalService.Subscribe()
+	go func() {
+		defer r.proposalService.Unsubscribe(sub)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-sub.C():
+				p, err := r.proposalService.Reviewed(ctx, m)
+				if err != nil {
+					graphql.AddError(ctx, err)
+					continue
+				}
+				if p == nil {
+					continue
+				}
+				select {
+				case events <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// streamChanges sends the subscriber the full page of results,
+// followed by an event for each visible change to the table
+// watched by svc. Changes to the table watched by resyncOn
+// (if given) cause the page to be reloaded if it has become
+// stale.
+func (r *Resolver) streamChanges(ctx context.Context, tracker *api.ChangeTracker, svc, resyncOn *api.ListeningService) chan *model.ChangeEvent {
+	events := make(chan *model.ChangeEvent, 1)
+	// start listening
+	sub := svc.Subscribe()
+	// a nil channel never receives, so there's
+	// nothing to invalidate the page
+	var invalidate <-chan *dao.Message
+	var resyncSub *api.Subscription
+	if resyncOn != nil {
+		resyncSub = resyncOn.Subscribe()
+		invalidate = resyncSub.C()
+	}
+	// do a first call so the client gets data straight away
+	evt, err := tracker.Resync(ctx)
+	if err != nil {
+		graphql.AddError(ctx, err)
+	} else {
+		events <- evt
+	}
+	go func() {
+		// stop listening
+		defer svc.Unsubscribe(sub)
+		if resyncSub != nil {
+			defer resyncOn.Unsubscribe(resyncSub)
+		}
+		for {
+			var evt *model.ChangeEvent
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-sub.C():
+				evt, err = tracker.Apply(ctx, m)
+			case <-invalidate:
+				evt, err = tracker.Invalidate(ctx)
+			}
+			if err != nil {
+				graphql.AddError(ctx, err)
+				continue
+			}
+			if evt == nil {
+				continue
+			}
+			select {
+			case events <- evt:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -18,9 +18,18 @@ import (
 	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/api"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
-	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
 )
 
+// ID is the resolver for the id field.
+func (r *accessTokenResolver) ID(ctx context.Context, obj *model.AccessToken) (int, error) {
+	return int(obj.ID), nil
+}
+
+// ID is the resolver for the id field.
+func (r *auditEventResolver) ID(ctx context.Context, obj *model.AuditEvent) (int, error) {
+	return int(obj.ID), nil
+}
+
 // ID is the resolver for the id field.
 func (r *groupResolver) ID(ctx context.Context, obj *model.Group) (string, error) {
 	return strconv.Itoa(int(obj.ID)), nil
@@ -43,6 +52,11 @@ func (r *groupResolver) Users(ctx context.Context, obj *model.Group) ([]string,
 	return users, nil
 }
 
+// Members is the resolver for the members field.
+func (r *groupResolver) Members(ctx context.Context, obj *model.Group) ([]*model.GroupMember, error) {
+	return r.repos.GroupRepo.GetMembers(ctx, obj.ID)
+}
+
 // ID is the resolver for the id field.
 func (r *jumpResolver) ID(ctx context.Context, obj *model.Jump) (string, error) {
 	return strconv.Itoa(int(obj.ID)), nil
@@ -84,23 +98,46 @@ func (r *jumpEventResolver) JumpID(ctx context.Context, obj *model.JumpEvent) (s
 	return strconv.Itoa(int(obj.JumpID)), nil
 }
 
+// ID is the resolver for the id field.
+func (r *jumpProposalResolver) ID(ctx context.Context, obj *model.JumpProposal) (int, error) {
+	return int(obj.ID), nil
+}
+
+// Alias is the resolver for the alias field.
+func (r *jumpProposalResolver) Alias(ctx context.Context, obj *model.JumpProposal) ([]string, error) {
+	if obj.Alias == nil {
+		return []string{}, nil
+	}
+	return obj.Alias, nil
+}
+
+// JumpID is the resolver for the jumpId field.
+func (r *jumpProposalResolver) JumpID(ctx context.Context, obj *model.JumpProposal) (int, error) {
+	return int(obj.JumpID), nil
+}
+
 // CreateJump is the resolver for the createJump field.
 func (r *mutationResolver) CreateJump(ctx context.Context, input model.NewJump) (*model.Jump, error) {
 	if _, ok := identity.GetContextUser(ctx); !ok {
 		return nil, ErrUnauthorised
 	}
-	return r.jumpService.Create(ctx, api.CreateJumpOpts{
+	jump, err := r.jumpService.Create(ctx, api.CreateJumpOpts{
 		GID:      input.Group,
 		Name:     input.Name,
 		Location: input.Location,
 		Alias:    input.Alias,
 	})
+	if err != nil {
+		return nil, err
+	}
+	r.warnDuplicates(ctx, jump)
+	return jump, nil
 }
 
 // PatchJump is the resolver for the patchJump field.
 func (r *mutationResolver) PatchJump(ctx context.Context, input model.EditJump) (*model.Jump, error) {
-	if err := r.CanI(ctx, schemas.ResourceName(schemas.ResourceJump, input.ID), rbac.Verb_UPDATE); err != nil {
-		return nil, err
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
 	}
 	return r.jumpService.Update(ctx, api.UpdateJumpOpts{
 		ID:       input.ID,
@@ -112,8 +149,8 @@ func (r *mutationResolver) PatchJump(ctx context.Context, input model.EditJump)
 
 // DeleteJump is the resolver for the deleteJump field.
 func (r *mutationResolver) DeleteJump(ctx context.Context, id int) (bool, error) {
-	if err := r.CanI(ctx, schemas.ResourceName(schemas.ResourceJump, id), rbac.Verb_DELETE); err != nil {
-		return false, err
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return false, ErrUnauthorised
 	}
 	return r.jumpService.Delete(ctx, id)
 }
@@ -128,12 +165,156 @@ func (r *mutationResolver) CreateGroup(ctx context.Context, input model.NewGroup
 
 // PatchGroup is the resolver for the patchGroup field.
 func (r *mutationResolver) PatchGroup(ctx context.Context, input model.EditGroup) (*model.Group, error) {
-	if err := r.CanI(ctx, schemas.ResourceName(schemas.ResourceGroup, input.ID), rbac.Verb_UPDATE); err != nil {
-		return nil, err
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
 	}
 	return r.groupService.Patch(ctx, input)
 }
 
+// DeleteGroup is the resolver for the deleteGroup field.
+func (r *mutationResolver) DeleteGroup(ctx context.Context, input model.DeleteGroup) (bool, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return false, ErrUnauthorised
+	}
+	if err := r.groupService.Delete(ctx, input.ID, input.ReassignTo); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ArchiveGroup is the resolver for the archiveGroup field.
+func (r *mutationResolver) ArchiveGroup(ctx context.Context, id int, archived bool) (*model.Group, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.groupService.Archive(ctx, id, archived)
+}
+
+// AddGroupMember is the resolver for the addGroupMember field.
+func (r *mutationResolver) AddGroupMember(ctx context.Context, id int, user string, role model.GroupRole) (*model.Group, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.groupService.AddMember(ctx, id, user, role)
+}
+
+// SetGroupMemberRole is the resolver for the setGroupMemberRole field.
+func (r *mutationResolver) SetGroupMemberRole(ctx context.Context, id int, user string, role model.GroupRole) (*model.Group, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.groupService.SetRole(ctx, id, user, role)
+}
+
+// RemoveGroupMember is the resolver for the removeGroupMember field.
+func (r *mutationResolver) RemoveGroupMember(ctx context.Context, id int, user string) (*model.Group, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.groupService.RemoveMember(ctx, id, user)
+}
+
+// LeaveGroup is the resolver for the leaveGroup field.
+func (r *mutationResolver) LeaveGroup(ctx context.Context, id int) (bool, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return false, ErrUnauthorised
+	}
+	if err := r.groupService.Leave(ctx, id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeactivateUser is the resolver for the deactivateUser field.
+func (r *mutationResolver) DeactivateUser(ctx context.Context, input model.DeactivateUser) (*model.UserHandover, error) {
+	if err := r.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return r.userService.Deactivate(ctx, input.Subject, input.HandoverTo, input.DryRun)
+}
+
+// ReactivateUser is the resolver for the reactivateUser field.
+func (r *mutationResolver) ReactivateUser(ctx context.Context, subject string) (bool, error) {
+	if err := r.requireAdmin(ctx); err != nil {
+		return false, err
+	}
+	if err := r.userService.Reactivate(ctx, subject); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateAccessToken is the resolver for the createAccessToken field.
+func (r *mutationResolver) CreateAccessToken(ctx context.Context, input model.NewAccessToken) (*model.CreatedToken, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.tokenService.Create(ctx, input.Name, input.Scope, input.ExpiresInDays)
+}
+
+// RevokeAccessToken is the resolver for the revokeAccessToken field.
+func (r *mutationResolver) RevokeAccessToken(ctx context.Context, id int) (bool, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return false, ErrUnauthorised
+	}
+	if err := r.tokenService.Revoke(ctx, uint(id)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateWebhook is the resolver for the createWebhook field.
+func (r *mutationResolver) CreateWebhook(ctx context.Context, input model.NewWebhook) (*model.CreatedWebhook, error) {
+	if err := r.canManageWebhooks(ctx, uint(input.Group)); err != nil {
+		return nil, err
+	}
+	return r.webhookService.Create(ctx, uint(input.Group), input.URL)
+}
+
+// DeleteWebhook is the resolver for the deleteWebhook field.
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, id int) (bool, error) {
+	if _, err := r.getManagedWebhook(ctx, uint(id)); err != nil {
+		return false, err
+	}
+	if err := r.webhookService.Delete(ctx, uint(id)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RedeliverWebhook is the resolver for the redeliverWebhook field.
+func (r *mutationResolver) RedeliverWebhook(ctx context.Context, id int) (*model.WebhookDelivery, error) {
+	delivery, err := r.webhookService.GetDelivery(ctx, uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.getManagedWebhook(ctx, delivery.WebhookID); err != nil {
+		return nil, err
+	}
+	return r.webhookService.Redeliver(ctx, uint(id))
+}
+
+// ProposeJump is the resolver for the proposeJump field.
+func (r *mutationResolver) ProposeJump(ctx context.Context, input model.NewJumpProposal) (*model.JumpProposal, error) {
+	return r.proposalService.Propose(ctx, input.Name, input.Location, input.Alias)
+}
+
+// ApproveJump is the resolver for the approveJump field.
+func (r *mutationResolver) ApproveJump(ctx context.Context, id int) (*model.JumpProposal, error) {
+	if err := r.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return r.proposalService.Approve(ctx, uint(id))
+}
+
+// RejectJump is the resolver for the rejectJump field.
+func (r *mutationResolver) RejectJump(ctx context.Context, id int, reason string) (*model.JumpProposal, error) {
+	if err := r.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return r.proposalService.Reject(ctx, uint(id), reason)
+}
+
 // CurrentUser is the resolver for the currentUser field.
 func (r *queryResolver) CurrentUser(ctx context.Context) (*model.User, error) {
 	log := logr.FromContextOrDiscard(ctx)
@@ -166,12 +347,13 @@ func (r *queryResolver) CurrentUser(ctx context.Context) (*model.User, error) {
 		if g == "" {
 			continue
 		}
-		// try to create the group but
-		// swallow any errors since we don't really care
-		// if it fails
-		_, _ = r.groupService.Create(ctx, g, false, true)
 		filteredGroups = append(filteredGroups, g)
 	}
+	// reconcile group membership but swallow any
+	// errors since the user can still log in
+	if _, err := r.groupService.Sync(ctx, userDao.Subject, filteredGroups); err != nil {
+		log.Error(err, "failed to synchronise user groups")
+	}
 	log.V(2).Info("loaded user groups", "groups", filteredGroups)
 	if slices.ContainsFunc(filteredGroups, func(s string) bool {
 		return slices.Contains(r.adminGroups, s)
@@ -230,6 +412,23 @@ func (r *queryResolver) GroupsForUser(ctx context.Context, username string) ([]*
 	return r.repos.GroupRepo.GetUserGroups(ctx, username)
 }
 
+// ArchivedGroups is the resolver for the archivedGroups field.
+func (r *queryResolver) ArchivedGroups(ctx context.Context) ([]*model.Group, error) {
+	user, ok := identity.GetContextUser(ctx)
+	if !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.repos.GroupRepo.GetArchived(ctx, user.Subject)
+}
+
+// GroupHistory is the resolver for the groupHistory field.
+func (r *queryResolver) GroupHistory(ctx context.Context, id int, offset int, limit int) ([]*model.GroupMemberEvent, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.groupService.History(ctx, id, offset, limit)
+}
+
 // TopPicks is the resolver for the topPicks field.
 func (r *queryResolver) TopPicks(ctx context.Context, amount int) ([]*model.Jump, error) {
 	if _, ok := identity.GetContextUser(ctx); !ok {
@@ -268,6 +467,55 @@ func (r *queryResolver) ApplicationSettings(ctx context.Context) (*model.Applica
 	return r.applicationSettings, nil
 }
 
+// AccessTokens is the resolver for the accessTokens field.
+func (r *queryResolver) AccessTokens(ctx context.Context) ([]*model.AccessToken, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.tokenService.List(ctx)
+}
+
+// AuditLog is the resolver for the auditLog field.
+func (r *queryResolver) AuditLog(ctx context.Context, filter model.AuditFilter, offset int, limit int) ([]*model.AuditEvent, error) {
+	if err := r.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return r.auditService.List(ctx, filter, offset, limit)
+}
+
+// Webhooks is the resolver for the webhooks field.
+func (r *queryResolver) Webhooks(ctx context.Context, group int) ([]*model.Webhook, error) {
+	if err := r.canManageWebhooks(ctx, uint(group)); err != nil {
+		return nil, err
+	}
+	return r.webhookService.List(ctx, uint(group))
+}
+
+// WebhookDeliveries is the resolver for the webhookDeliveries field.
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhook int, status *model.DeliveryStatus, offset int, limit int) ([]*model.WebhookDelivery, error) {
+	if _, err := r.getManagedWebhook(ctx, uint(webhook)); err != nil {
+		return nil, err
+	}
+	var s model.DeliveryStatus
+	if status != nil {
+		s = *status
+	}
+	return r.webhookService.Deliveries(ctx, uint(webhook), s, offset, limit)
+}
+
+// PendingJumps is the resolver for the pendingJumps field.
+func (r *queryResolver) PendingJumps(ctx context.Context, offset int, limit int) ([]*model.JumpProposal, error) {
+	if err := r.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return r.proposalService.Pending(ctx, offset, limit)
+}
+
+// JumpProposals is the resolver for the jumpProposals field.
+func (r *queryResolver) JumpProposals(ctx context.Context) ([]*model.JumpProposal, error) {
+	return r.proposalService.List(ctx)
+}
+
 // Jumps is the resolver for the jumps field.
 func (r *subscriptionResolver) Jumps(ctx context.Context, offset int, limit int, target string) (<-chan *model.Page, error) {
 	return r.streamPage(ctx, r.jumpService.ListeningService, func(message *dao.Message) (*model.Page, error) {
@@ -301,6 +549,66 @@ func (r *subscriptionResolver) Groups(ctx context.Context, offset int, limit int
 	}), nil
 }
 
+// JumpChanges is the resolver for the jumpChanges field.
+func (r *subscriptionResolver) JumpChanges(ctx context.Context, offset int, limit int, target string) (<-chan *model.ChangeEvent, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	// jumps become (in)visible when the user's
+	// group membership changes
+	return r.streamChanges(ctx, api.NewChangeTracker(r.jumpService.ChangeSource(offset, limit, target)), r.jumpService.ListeningService, r.groupService.ListeningService), nil
+}
+
+// UserChanges is the resolver for the userChanges field.
+func (r *subscriptionResolver) UserChanges(ctx context.Context, offset int, limit int) (<-chan *model.ChangeEvent, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.streamChanges(ctx, api.NewChangeTracker(r.userService.ChangeSource(offset, limit)), r.userService.ListeningService, nil), nil
+}
+
+// GroupChanges is the resolver for the groupChanges field.
+func (r *subscriptionResolver) GroupChanges(ctx context.Context, offset int, limit int) (<-chan *model.ChangeEvent, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.streamChanges(ctx, api.NewChangeTracker(r.groupService.ChangeSource(offset, limit)), r.groupService.ListeningService, nil), nil
+}
+
+// JumpProposalReviewed is the resolver for the jumpProposalReviewed field.
+func (r *subscriptionResolver) JumpProposalReviewed(ctx context.Context) (<-chan *model.JumpProposal, error) {
+	if _, ok := identity.GetContextUser(ctx); !ok {
+		return nil, ErrUnauthorised
+	}
+	return r.streamReviewed(ctx), nil
+}
+
+// ID is the resolver for the id field.
+func (r *webhookResolver) ID(ctx context.Context, obj *model.Webhook) (int, error) {
+	return int(obj.ID), nil
+}
+
+// GroupID is the resolver for the groupId field.
+func (r *webhookResolver) GroupID(ctx context.Context, obj *model.Webhook) (int, error) {
+	return int(obj.GroupID), nil
+}
+
+// ID is the resolver for the id field.
+func (r *webhookDeliveryResolver) ID(ctx context.Context, obj *model.WebhookDelivery) (int, error) {
+	return int(obj.ID), nil
+}
+
+// WebhookID is the resolver for the webhookId field.
+func (r *webhookDeliveryResolver) WebhookID(ctx context.Context, obj *model.WebhookDelivery) (int, error) {
+	return int(obj.WebhookID), nil
+}
+
+// AccessToken returns generated.AccessTokenResolver implementation.
+func (r *Resolver) AccessToken() generated.AccessTokenResolver { return &accessTokenResolver{r} }
+
+// AuditEvent returns generated.AuditEventResolver implementation.
+func (r *Resolver) AuditEvent() generated.AuditEventResolver { return &auditEventResolver{r} }
+
 // Group returns generated.GroupResolver implementation.
 func (r *Resolver) Group() generated.GroupResolver { return &groupResolver{r} }
 
@@ -310,6 +618,9 @@ func (r *Resolver) Jump() generated.JumpResolver { return &jumpResolver{r} }
 // JumpEvent returns generated.JumpEventResolver implementation.
 func (r *Resolver) JumpEvent() generated.JumpEventResolver { return &jumpEventResolver{r} }
 
+// JumpProposal returns generated.JumpProposalResolver implementation.
+func (r *Resolver) JumpProposal() generated.JumpProposalResolver { return &jumpProposalResolver{r} }
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
@@ -319,9 +630,22 @@ func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 // Subscription returns generated.SubscriptionResolver implementation.
 func (r *Resolver) Subscription() generated.SubscriptionResolver { return &subscriptionResolver{r} }
 
+// Webhook returns generated.WebhookResolver implementation.
+func (r *Resolver) Webhook() generated.WebhookResolver { return &webhookResolver{r} }
+
+// WebhookDelivery returns generated.WebhookDeliveryResolver implementation.
+func (r *Resolver) WebhookDelivery() generated.WebhookDeliveryResolver {
+	return &webhookDeliveryResolver{r}
+}
+
+type accessTokenResolver struct{ *Resolver }
+type auditEventResolver struct{ *Resolver }
 type groupResolver struct{ *Resolver }
 type jumpResolver struct{ *Resolver }
 type jumpEventResolver struct{ *Resolver }
+type jumpProposalResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
+type webhookResolver struct{ *Resolver }
+type webhookDeliveryResolver struct{ *Resolver }
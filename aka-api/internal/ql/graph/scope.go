@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/go-logr/logr"
+	"github.com/vektah/gqlparser/v2/ast"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+)
+
+// scopedMutations are the mutations that each TokenScope
+// allows. A nil list allows all of them.
+var scopedMutations = map[model.TokenScope][]string{
+	model.TokenScopeReadOnly:   {},
+	model.TokenScopeJumpsWrite: {"createJump", "patchJump", "deleteJump", "proposeJump"},
+	model.TokenScopeAdmin:      nil,
+}
+
+// tokenMutations can only be run in an interactive session,
+// so that a leaked token can't be used to mint more.
+var tokenMutations = []string{"createAccessToken", "revokeAccessToken"}
+
+// RejectDeactivated stops deactivated users from doing
+// anything, no matter how they authenticated.
+func (r *Resolver) RejectDeactivated(ctx context.Context, next graphql.RootResolver) graphql.Marshaler {
+	user, ok := identity.GetContextUser(ctx)
+	if !ok {
+		return next(ctx)
+	}
+	if err := r.userService.RequireActive(ctx, user.Subject); err != nil {
+		logr.FromContextOrDiscard(ctx).Info("rejecting request as the user may not be active", "Subject", user.Subject, "error", err.Error())
+		graphql.AddError(ctx, err)
+		return graphql.Null
+	}
+	return next(ctx)
+}
+
+// TokenScopes stops requests made with an access
+// token from running mutations that are outside
+// of the token's scope.
+func TokenScopes(ctx context.Context, next graphql.RootResolver) graphql.Marshaler {
+	user, ok := identity.GetContextUser(ctx)
+	if !ok || user.Scope == "" {
+		return next(ctx)
+	}
+	if graphql.GetOperationContext(ctx).Operation.Operation != ast.Mutation {
+		return next(ctx)
+	}
+	field := graphql.GetRootFieldContext(ctx).Field.Name
+	if !scopeAllows(model.TokenScope(user.Scope), field) {
+		logr.FromContextOrDiscard(ctx).Info("access token is not allowed to run mutation", "Subject", user.Subject, "Scope", user.Scope, "Field", field)
+		graphql.AddError(ctx, ErrForbidden)
+		return graphql.Null
+	}
+	return next(ctx)
+}
+
+func scopeAllows(scope model.TokenScope, field string) bool {
+	for _, f := range tokenMutations {
+		if f == field {
+			return false
+		}
+	}
+	allowed, ok := scopedMutations[scope]
+	if !ok {
+		return false
+	}
+	if allowed == nil {
+		return true
+	}
+	for _, f := range allowed {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
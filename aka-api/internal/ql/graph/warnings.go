@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// ExtensionWarnings is the response extension
+// that warnings are sent in.
+const ExtensionWarnings = "warnings"
+
+// The codes given to warnings.
+const (
+	WarningDuplicateLocation = "DUPLICATE_LOCATION"
+	WarningSimilarName       = "SIMILAR_NAME"
+)
+
+// Warning tells the client about something that
+// they might want to fix, but that didn't stop
+// the request from succeeding.
+type Warning struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Path    ast.Path `json:"path,omitempty"`
+	// Jumps are the IDs of the Jumps that
+	// the warning is about.
+	Jumps []uint `json:"jumps,omitempty"`
+}
+
+// addWarning adds a Warning to the response for the field
+// being resolved. Mutations are resolved one at a time, so
+// there's no need to worry about them racing each other.
+func addWarning(ctx context.Context, w *Warning) {
+	w.Path = graphql.GetPath(ctx)
+	if existing, ok := graphql.GetExtension(ctx, ExtensionWarnings).(*[]*Warning); ok {
+		*existing = append(*existing, w)
+		return
+	}
+	graphql.RegisterExtension(ctx, ExtensionWarnings, &[]*Warning{w})
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/backup"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+// RoleSuper is the global role granted to administrators.
+const RoleSuper = "SUPER"
+
+// AdminService contains operational tasks that
+// are run outside the normal request flow.
+type AdminService struct {
+	repos *dao.Repos
+	authz rbac.AuthorityClient
+}
+
+// ReconcileReport summarises the changes made
+// by AdminService.ReconcileRoles.
+type ReconcileReport struct {
+	Admins int
+	Jumps  int
+	Groups int
+}
+
+func NewAdminService(repos *dao.Repos, authz rbac.AuthorityClient) *AdminService {
+	return &AdminService{
+		repos: repos,
+		authz: authz,
+	}
+}
+
+// PromoteAdmin grants the SUPER role to a given user.
+func (svc *AdminService) PromoteAdmin(ctx context.Context, subject string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Subject", subject)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_admin_promoteAdmin", trace.WithAttributes(attribute.String("subject", subject)))
+	defer span.End()
+	log.Info("creating superuser rolebinding")
+	if _, err := svc.authz.AddGlobalRole(ctx, &rbac.AddGlobalRoleRequest{
+		Subject: subject,
+		Role:    RoleSuper,
+	}); err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to create rolebinding for superuser")
+		return err
+	}
+	return nil
+}
+
+// ReconcileRoles recreates the role bindings that
+// aka expects to exist. Bindings are derived from the
+// configured admins, the owners of each Jump and the
+// members of each Group.
+func (svc *AdminService) ReconcileRoles(ctx context.Context, admins []string) (*ReconcileReport, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_admin_reconcileRoles")
+	defer span.End()
+	report := &ReconcileReport{}
+	for _, user := range admins {
+		if err := svc.PromoteAdmin(ctx, user); err != nil {
+			return nil, err
+		}
+		report.Admins++
+	}
+	log.Info("reconciling jump rolebindings")
+	err := svc.repos.JumpRepo.FindInBatches(ctx, 100, func(jumps []*model.Jump) error {
+		for _, j := range jumps {
+			subject, ok := strings.CutPrefix(j.Owner, "user://")
+			if !ok || subject == "" {
+				continue
+			}
+			if err := svc.addRole(ctx, subject, schemas.ResourceName(schemas.ResourceJump, j.ID), rbac.Verb_SUDO); err != nil {
+				return err
+			}
+			report.Jumps++
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	log.Info("reconciling group rolebindings")
+	err = svc.repos.GroupRepo.FindInBatches(ctx, 100, func(groups []*model.Group) error {
+		for _, g := range groups {
+			members, err := svc.repos.GroupRepo.GetMembers(ctx, g.ID)
+			if err != nil {
+				return err
+			}
+			for _, m := range members {
+				if err := svc.addRole(ctx, m.Subject, schemas.ResourceName(schemas.ResourceGroup, g.ID), groupRoleVerbs[m.Role]); err != nil {
+					return err
+				}
+			}
+			report.Groups++
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return report, nil
+}
+
+func (svc *AdminService) addRole(ctx context.Context, subject, resource string, action rbac.Verb) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Subject", subject, "Resource", resource, "Action", action.String())
+	log.V(1).Info("creating role binding")
+	if _, err := svc.authz.AddRole(ctx, &rbac.AddRoleRequest{
+		Subject:  subject,
+		Resource: resource,
+		Action:   action,
+	}); err != nil {
+		log.Error(err, "failed to create role binding")
+		return err
+	}
+	return nil
+}
+
+// PurgeEvents removes JumpEvents older than a given time.
+func (svc *AdminService) PurgeEvents(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_admin_purgeEvents")
+	defer span.End()
+	return svc.repos.JumpEventRepo.DeleteBefore(ctx, before)
+}
+
+// RestoreRoles recreates the role bindings stored in
+// a backup, rewriting the resources they refer to.
+func (svc *AdminService) RestoreRoles(ctx context.Context, r *backup.Reader, ids *backup.IDMap) (int, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_admin_restoreRoles")
+	defer span.End()
+	var count int
+	err := r.Each(backup.TableRoleBindings, func(data json.RawMessage) error {
+		var b backup.RoleBinding
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		resource, ok := ids.Resource(b.Resource)
+		if !ok {
+			log.Info("skipping rolebinding for resource that was not restored", "Resource", b.Resource)
+			return nil
+		}
+		action := model.Verb(b.Action)
+		if !action.IsValid() {
+			log.Info("skipping rolebinding with unknown action", "Action", b.Action)
+			return nil
+		}
+		if _, err := svc.authz.AddRole(ctx, &rbac.AddRoleRequest{
+			Subject:  b.Subject,
+			Resource: resource,
+			Action:   action.DAO(),
+		}); err != nil {
+			log.Error(err, "failed to restore rolebinding", "Subject", b.Subject, "Resource", resource)
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return count, err
+	}
+	return count, nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActorSCIM is the actor of changes made
+// by the identity provider using SCIM.
+const ActorSCIM = "scim"
+
+// AuditService keeps a record of the changes
+// that are made by users.
+type AuditService struct {
+	repos *dao.Repos
+}
+
+func NewAuditService(repos *dao.Repos) *AuditService {
+	return &AuditService{
+		repos: repos,
+	}
+}
+
+// Record adds an AuditEvent to the audit log. The
+// request ID and date are filled in if they're empty.
+func (svc *AuditService) Record(ctx context.Context, e *model.AuditEvent) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_audit_record", trace.WithAttributes(
+		attribute.String("action", e.Action),
+		attribute.String("resource", e.Resource),
+	))
+	defer span.End()
+	if e.RequestID == "" {
+		e.RequestID = GetRequestID(ctx)
+	}
+	if e.Date == 0 {
+		e.Date = time.Now().Unix()
+	}
+	logr.FromContextOrDiscard(ctx).V(1).Info("recording audit event", "Actor", e.Actor, "Action", e.Action, "Resource", e.Resource, "RequestID", e.RequestID)
+	if err := svc.repos.AuditRepo.Create(ctx, e); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// Snapshot returns the current state of a resource
+// as JSON. It is empty if the resource doesn't exist
+// or isn't something that we know how to describe.
+func (svc *AuditService) Snapshot(ctx context.Context, resource string) string {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Resource", resource)
+	kind, name, ok := strings.Cut(resource, "://")
+	if !ok {
+		return ""
+	}
+	var v any
+	var err error
+	switch kind {
+	case string(schemas.ResourceJump):
+		var id uint64
+		if id, err = strconv.ParseUint(name, 10, 64); err == nil {
+			v, err = svc.repos.JumpRepo.GetByID(ctx, uint(id))
+		}
+	case string(schemas.ResourceGroup):
+		var id uint64
+		if id, err = strconv.ParseUint(name, 10, 64); err == nil {
+			v, err = svc.repos.GroupRepo.GetByID(ctx, uint(id))
+		}
+	case "user":
+		v, err = svc.repos.UserRepo.Get(ctx, name)
+	case "proposal":
+		var id uint64
+		if id, err = strconv.ParseUint(name, 10, 64); err == nil {
+			v, err = svc.repos.ProposalRepo.Get(ctx, uint(id))
+		}
+	default:
+		return ""
+	}
+	if err != nil {
+		log.V(2).Info("unable to snapshot resource", "error", err.Error())
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Error(err, "failed to marshal resource snapshot")
+		return ""
+	}
+	return string(data)
+}
+
+// List returns the AuditEvents that match a
+// filter, newest first.
+func (svc *AuditService) List(ctx context.Context, filter model.AuditFilter, offset, limit int) ([]*model.AuditEvent, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_audit_list")
+	defer span.End()
+	return svc.repos.AuditRepo.Find(ctx, filter, offset, limit)
+}
+
+// Purge removes AuditEvents older than a given time.
+func (svc *AuditService) Purge(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_audit_purge")
+	defer span.End()
+	count, err := svc.repos.AuditRepo.DeleteBefore(ctx, before)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	logr.FromContextOrDiscard(ctx).Info("purged audit events", "Before", before, "Count", count)
+	return count, nil
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuditService(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	svc := NewAuditService(repos)
+
+	jump, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: "foo", Location: "https://example.org"})
+	require.NoError(t, err)
+	resource := schemas.ResourceName(schemas.ResourceJump, jump.ID)
+
+	t.Run("snapshot", func(t *testing.T) {
+		assert.Contains(t, svc.Snapshot(ctx, resource), `"https://example.org"`)
+		// things that don't exist, or that we
+		// don't know about, are empty
+		assert.Empty(t, svc.Snapshot(ctx, schemas.ResourceName(schemas.ResourceJump, 100)))
+		assert.Empty(t, svc.Snapshot(ctx, "token://1"))
+		assert.Empty(t, svc.Snapshot(ctx, "foo"))
+	})
+	t.Run("record and list", func(t *testing.T) {
+		var id string
+		h := RequestIDMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			id = GetRequestID(r.Context())
+			require.NoError(t, svc.Record(r.Context(), &model.AuditEvent{Actor: "john", Action: "patchJump", Resource: resource}))
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx))
+		assert.NotEmpty(t, id)
+		assert.EqualValues(t, id, w.Header().Get(HeaderRequestID))
+
+		require.NoError(t, svc.Record(ctx, &model.AuditEvent{Actor: "jane", Action: "createGroup", Resource: "group://1", Date: time.Now().Add(-time.Hour).Unix()}))
+
+		events, err := svc.List(ctx, model.AuditFilter{}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.EqualValues(t, "patchJump", events[0].Action)
+		assert.EqualValues(t, id, events[0].RequestID)
+		assert.NotZero(t, events[0].Date)
+
+		events, err = svc.List(ctx, model.AuditFilter{Actor: "jane"}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.EqualValues(t, "group://1", events[0].Resource)
+
+		events, err = svc.List(ctx, model.AuditFilter{Since: time.Now().Add(-time.Minute).Unix()}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.EqualValues(t, "john", events[0].Actor)
+	})
+	t.Run("the client can set the request id", func(t *testing.T) {
+		var id string
+		h := RequestIDMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			id = GetRequestID(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(HeaderRequestID, "abc")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		assert.EqualValues(t, "abc", id)
+	})
+	t.Run("purge", func(t *testing.T) {
+		count, err := svc.Purge(ctx, time.Now().Add(-time.Minute))
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, count)
+
+		events, err := svc.List(ctx, model.AuditFilter{}, 0, 10)
+		require.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"google.golang.org/grpc"
+	"sync"
+)
+
+// fakeAuthz is an in-memory rbac.AuthorityClient and
+// RoleRevoker. Any binding grants every verb on a
+// resource, and the global SUPER role grants everything.
+// Global roles are kept apart from bindings, as they are
+// by the real authority.
+type fakeAuthz struct {
+	rbac.AuthorityClient
+	mu       sync.Mutex
+	bindings map[string]map[string]rbac.Verb
+	globals  map[string]map[string]struct{}
+}
+
+func newFakeAuthz() *fakeAuthz {
+	return &fakeAuthz{
+		bindings: map[string]map[string]rbac.Verb{},
+		globals:  map[string]map[string]struct{}{},
+	}
+}
+
+func (f *fakeAuthz) Can(_ context.Context, in *rbac.AccessRequest, _ ...grpc.CallOption) (*rbac.GenericResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.globals[in.Subject][RoleSuper]; ok {
+		return &rbac.GenericResponse{Ok: true}, nil
+	}
+	_, ok := f.bindings[in.Subject][in.Resource]
+	return &rbac.GenericResponse{Ok: ok}, nil
+}
+
+func (f *fakeAuthz) AddRole(_ context.Context, in *rbac.AddRoleRequest, _ ...grpc.CallOption) (*rbac.GenericResponse, error) {
+	f.add(in.Subject, in.Resource, in.Action)
+	return &rbac.GenericResponse{Ok: true}, nil
+}
+
+func (f *fakeAuthz) RemoveRole(_ context.Context, in *rbac.AddRoleRequest, _ ...grpc.CallOption) (*rbac.GenericResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bindings[in.Subject][in.Resource] == in.Action {
+		delete(f.bindings[in.Subject], in.Resource)
+	}
+	return &rbac.GenericResponse{Ok: true}, nil
+}
+
+func (f *fakeAuthz) AddGlobalRole(_ context.Context, in *rbac.AddGlobalRoleRequest, _ ...grpc.CallOption) (*rbac.GenericResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.globals[in.Subject] == nil {
+		f.globals[in.Subject] = map[string]struct{}{}
+	}
+	f.globals[in.Subject][in.Role] = struct{}{}
+	return &rbac.GenericResponse{Ok: true}, nil
+}
+
+func (f *fakeAuthz) RemoveGlobalRole(_ context.Context, in *rbac.AddGlobalRoleRequest, _ ...grpc.CallOption) (*rbac.GenericResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.globals[in.Subject], in.Role)
+	return &rbac.GenericResponse{Ok: true}, nil
+}
+
+func (f *fakeAuthz) add(subject, resource string, verb rbac.Verb) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bindings[subject] == nil {
+		f.bindings[subject] = map[string]rbac.Verb{}
+	}
+	f.bindings[subject][resource] = verb
+}
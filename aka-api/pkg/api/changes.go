@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"strconv"
+)
+
+// ChangeSource describes how to load the data
+// behind a subscription.
+type ChangeSource struct {
+	// Page loads the full page of results.
+	Page func(ctx context.Context) (*model.Page, error)
+	// Get loads a single row and reports whether
+	// the subscriber is allowed to see it.
+	Get func(ctx context.Context, id uint) (model.Pageable, bool, error)
+	// Relevant reports whether a message could be
+	// about a row that the subscriber can see, using
+	// only what the message carries. Rows that it
+	// rules out aren't loaded. May be nil.
+	Relevant func(msg *dao.Message) bool
+	// Stale reports whether something outside the
+	// table (e.g. group membership) has changed enough
+	// that the page must be reloaded. May be nil.
+	Stale func(ctx context.Context) (bool, error)
+	// ResyncOnChange sends the full page rather than
+	// the row whenever a visible row changes. This is
+	// used when the page can't be updated
+	// incrementally (e.g. search results).
+	ResyncOnChange bool
+}
+
+// ChangeTracker converts table changes into the
+// ChangeEvents that a single subscriber should
+// receive.
+type ChangeTracker struct {
+	src *ChangeSource
+	// known contains the IDs of every row that
+	// the subscriber has been sent
+	known map[string]struct{}
+}
+
+func NewChangeTracker(src *ChangeSource) *ChangeTracker {
+	return &ChangeTracker{
+		src:   src,
+		known: map[string]struct{}{},
+	}
+}
+
+// Resync loads the full page of results.
+func (t *ChangeTracker) Resync(ctx context.Context) (*model.ChangeEvent, error) {
+	page, err := t.src.Page(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.known = make(map[string]struct{}, len(page.Results))
+	for _, item := range page.Results {
+		t.known[pageableID(item)] = struct{}{}
+	}
+	return &model.ChangeEvent{
+		Type: model.ChangeTypeResync,
+		Page: page,
+	}, nil
+}
+
+// Apply returns the event that the subscriber should
+// receive for a given message. If the subscriber
+// shouldn't be told about the change, nil is returned.
+func (t *ChangeTracker) Apply(ctx context.Context, msg *dao.Message) (*model.ChangeEvent, error) {
+	if msg.Operation == dao.OperationResync {
+		return t.Resync(ctx)
+	}
+	id := strconv.Itoa(msg.ID)
+	_, known := t.known[id]
+	if !known && t.src.Relevant != nil && !t.src.Relevant(msg) {
+		return nil, nil
+	}
+
+	var item model.Pageable
+	var visible bool
+	if msg.Operation != "DELETE" {
+		var err error
+		item, visible, err = t.src.Get(ctx, uint(msg.ID))
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	if !visible {
+		// only tell the subscriber about things
+		// they've previously been able to see
+		if !known {
+			return nil, nil
+		}
+		if t.src.ResyncOnChange {
+			return t.Resync(ctx)
+		}
+		delete(t.known, id)
+		return &model.ChangeEvent{
+			Type: model.ChangeTypeRemoved,
+			ID:   id,
+		}, nil
+	}
+	if t.src.ResyncOnChange {
+		return t.Resync(ctx)
+	}
+	kind := model.ChangeTypeUpdated
+	if !known {
+		kind = model.ChangeTypeAdded
+		t.known[id] = struct{}{}
+	}
+	return &model.ChangeEvent{
+		Type: kind,
+		ID:   id,
+		Item: item,
+	}, nil
+}
+
+// Invalidate resyncs the subscriber if the page
+// has become stale. If it hasn't, nil is returned.
+func (t *ChangeTracker) Invalidate(ctx context.Context) (*model.ChangeEvent, error) {
+	if t.src.Stale == nil {
+		return nil, nil
+	}
+	stale, err := t.src.Stale(ctx)
+	if err != nil || !stale {
+		return nil, err
+	}
+	return t.Resync(ctx)
+}
+
+// pageableID returns the ID of an item
+// as it is presented in the API.
+func pageableID(p model.Pageable) string {
+	switch v := p.(type) {
+	case *model.Jump:
+		return strconv.FormatUint(uint64(v.ID), 10)
+	case *model.Group:
+		return strconv.FormatUint(uint64(v.ID), 10)
+	case *model.User:
+		return v.ID
+	}
+	return ""
+}
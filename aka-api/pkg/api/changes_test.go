@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"testing"
+)
+
+func TestChangeTracker(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewJumpService(ctx, repos, authz, false, nil, nil)
+	groups := NewGroupService(ctx, repos, authz, nil)
+
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+
+	tracker := NewChangeTracker(svc.ChangeSource(0, 10, ""))
+
+	existing, err := svc.Create(john, CreateJumpOpts{Name: "existing", Location: "https://example.org/existing"})
+	require.NoError(t, err)
+
+	evt, err := tracker.Resync(john)
+	require.NoError(t, err)
+	assert.EqualValues(t, model.ChangeTypeResync, evt.Type)
+	assert.EqualValues(t, 1, evt.Page.Count)
+
+	t.Run("new jumps are added", func(t *testing.T) {
+		jump, err := svc.Create(john, CreateJumpOpts{Name: "added", Location: "https://example.org/added"})
+		require.NoError(t, err)
+
+		evt, err := tracker.Apply(john, &dao.Message{Operation: "INSERT", ID: int(jump.ID)})
+		require.NoError(t, err)
+		require.NotNil(t, evt)
+		assert.EqualValues(t, model.ChangeTypeAdded, evt.Type)
+		assert.EqualValues(t, "added", evt.Item.(*model.Jump).Name)
+	})
+	t.Run("known jumps are updated", func(t *testing.T) {
+		_, err := svc.Update(john, UpdateJumpOpts{ID: int(existing.ID), Name: "renamed", Location: existing.Location})
+		require.NoError(t, err)
+
+		evt, err := tracker.Apply(john, &dao.Message{Operation: "UPDATE", ID: int(existing.ID)})
+		require.NoError(t, err)
+		require.NotNil(t, evt)
+		assert.EqualValues(t, model.ChangeTypeUpdated, evt.Type)
+		assert.EqualValues(t, "renamed", evt.Item.(*model.Jump).Name)
+	})
+	t.Run("invisible jumps are ignored", func(t *testing.T) {
+		jump, err := svc.Create(jane, CreateJumpOpts{Name: "private", Location: "https://example.org/private"})
+		require.NoError(t, err)
+
+		evt, err := tracker.Apply(john, &dao.Message{Operation: "INSERT", ID: int(jump.ID)})
+		require.NoError(t, err)
+		assert.Nil(t, evt)
+	})
+	t.Run("messages about other owners are skipped", func(t *testing.T) {
+		jump, err := svc.Create(john, CreateJumpOpts{Name: "skipped", Location: "https://example.org/skipped"})
+		require.NoError(t, err)
+
+		// the owner in the message is trusted
+		// without loading the jump
+		evt, err := tracker.Apply(john, &dao.Message{Operation: "INSERT", ID: int(jump.ID), Owner: "user://jane"})
+		require.NoError(t, err)
+		assert.Nil(t, evt)
+	})
+	t.Run("deleted jumps are removed", func(t *testing.T) {
+		_, err := svc.Delete(john, int(existing.ID))
+		require.NoError(t, err)
+
+		// soft-deletes arrive as updates
+		evt, err := tracker.Apply(john, &dao.Message{Operation: "UPDATE", ID: int(existing.ID)})
+		require.NoError(t, err)
+		require.NotNil(t, evt)
+		assert.EqualValues(t, model.ChangeTypeRemoved, evt.Type)
+		assert.Nil(t, evt.Item)
+
+		// we shouldn't be told twice
+		evt, err = tracker.Apply(john, &dao.Message{Operation: "DELETE", ID: int(existing.ID)})
+		require.NoError(t, err)
+		assert.Nil(t, evt)
+	})
+	t.Run("joining a group resyncs", func(t *testing.T) {
+		evt, err := tracker.Invalidate(john)
+		require.NoError(t, err)
+		assert.Nil(t, evt)
+
+		_, err = groups.Create(john, "team", false, false)
+		require.NoError(t, err)
+
+		evt, err = tracker.Invalidate(john)
+		require.NoError(t, err)
+		require.NotNil(t, evt)
+		assert.EqualValues(t, model.ChangeTypeResync, evt.Type)
+	})
+	t.Run("search results are resynced", func(t *testing.T) {
+		search := NewChangeTracker(svc.ChangeSource(0, 10, "add"))
+		_, err := search.Resync(john)
+		require.NoError(t, err)
+
+		jump, err := svc.Create(john, CreateJumpOpts{Name: "address", Location: "https://example.org/address"})
+		require.NoError(t, err)
+
+		evt, err := search.Apply(john, &dao.Message{Operation: "INSERT", ID: int(jump.ID)})
+		require.NoError(t, err)
+		require.NotNil(t, evt)
+		assert.EqualValues(t, model.ChangeTypeResync, evt.Type)
+		assert.EqualValues(t, 2, evt.Page.Count)
+	})
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what happens to a change
+// when a subscriber isn't keeping up.
+type OverflowPolicy string
+
+const (
+	// PolicyDrop discards changes that don't fit in
+	// the subscriber's buffer.
+	PolicyDrop OverflowPolicy = "drop"
+	// PolicyCoalesce holds changes back while the
+	// subscriber's buffer is full and replaces them with
+	// a single dao.OperationResync message if it doesn't
+	// catch up.
+	PolicyCoalesce OverflowPolicy = "coalesce"
+)
+
+const defaultBufferSize = 16
+
+// FanoutOptions configures how changes are
+// delivered to each subscriber.
+type FanoutOptions struct {
+	// BufferSize is the number of changes that can be
+	// waiting for a subscriber.
+	BufferSize int `split_words:"true" default:"16"`
+	// Policy decides what happens when a subscriber's
+	// buffer is full.
+	Policy OverflowPolicy `default:"coalesce"`
+	// Debounce is how long PolicyCoalesce waits for a
+	// subscriber to catch up before resyncing it.
+	Debounce time.Duration `default:"100ms"`
+}
+
+// Subscription receives the changes forwarded by
+// a ListeningService.
+type Subscription struct {
+	out   chan *dao.Message
+	opts  FanoutOptions
+	table string
+
+	// mu guards the fields below
+	mu      sync.Mutex
+	pending []*dao.Message
+	timer   *time.Timer
+	closed  bool
+}
+
+func newSubscription(table string, opts FanoutOptions) *Subscription {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	return &Subscription{
+		out:   make(chan *dao.Message, opts.BufferSize),
+		opts:  opts,
+		table: table,
+	}
+}
+
+// C returns the channel that changes are delivered on.
+func (s *Subscription) C() <-chan *dao.Message {
+	return s.out
+}
+
+// send delivers a message without blocking the caller.
+// Changes go straight to the subscriber while it keeps
+// up. Once its buffer is full they are either dropped
+// or, when coalescing, held back until the debounce
+// elapses to give the subscriber a chance to catch up.
+func (s *Subscription) send(msg *dao.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	// anything pending must be delivered
+	// first so that changes stay in order
+	if len(s.pending) == 0 {
+		select {
+		case s.out <- msg:
+			return
+		default:
+		}
+	}
+	if s.opts.Policy != PolicyCoalesce {
+		s.dropped(1, PolicyDrop)
+		return
+	}
+	s.pending = append(s.pending, msg)
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.opts.Debounce, s.flush)
+	}
+}
+
+// flush delivers the changes that were held back while
+// the subscriber was behind. If it still hasn't caught
+// up, everything it hasn't read is replaced by a
+// single resync.
+func (s *Subscription) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending
+	s.pending = nil
+	s.timer = nil
+	if s.closed {
+		return
+	}
+	for i, msg := range pending {
+		select {
+		case s.out <- msg:
+			continue
+		default:
+		}
+		dropped := int64(len(pending) - i)
+	drain:
+		for {
+			select {
+			case <-s.out:
+				dropped++
+			default:
+				break drain
+			}
+		}
+		s.dropped(dropped, PolicyCoalesce)
+		select {
+		case s.out <- &dao.Message{Operation: dao.OperationResync, Table: msg.Table}:
+		default:
+		}
+		return
+	}
+}
+
+func (s *Subscription) dropped(n int64, reason OverflowPolicy) {
+	if n == 0 {
+		return
+	}
+	metricDropped.Add(context.Background(), n, metric.WithAttributes(attribute.String("table", s.table), attribute.String("reason", string(reason))))
+}
+
+// close stops any further changes from being delivered.
+func (s *Subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.pending = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"sync"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, s *Subscription) *dao.Message {
+	select {
+	case msg := <-s.C():
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	t.Run("drop never blocks", func(t *testing.T) {
+		s := newSubscription(model.TableNameJumps, FanoutOptions{BufferSize: 2, Policy: PolicyDrop})
+		for i := 1; i <= 5; i++ {
+			s.send(&dao.Message{Operation: "INSERT", ID: i})
+		}
+		assert.EqualValues(t, 1, receive(t, s).ID)
+		assert.EqualValues(t, 2, receive(t, s).ID)
+		assert.Len(t, s.C(), 0)
+	})
+	t.Run("single changes are delivered", func(t *testing.T) {
+		s := newSubscription(model.TableNameJumps, FanoutOptions{BufferSize: 2, Policy: PolicyCoalesce, Debounce: time.Millisecond})
+		s.send(&dao.Message{Operation: "INSERT", ID: 1})
+		assert.EqualValues(t, &dao.Message{Operation: "INSERT", ID: 1}, receive(t, s))
+	})
+	t.Run("subscribers that keep up receive every change", func(t *testing.T) {
+		s := newSubscription(model.TableNameJumps, FanoutOptions{BufferSize: 5, Policy: PolicyCoalesce, Debounce: 50 * time.Millisecond})
+		for i := 1; i <= 5; i++ {
+			s.send(&dao.Message{Operation: "INSERT", ID: i, Table: model.TableNameJumps})
+		}
+		for i := 1; i <= 5; i++ {
+			assert.EqualValues(t, i, receive(t, s).ID)
+		}
+		time.Sleep(100 * time.Millisecond)
+		assert.Len(t, s.C(), 0)
+	})
+	t.Run("bursts are coalesced when the buffer is full", func(t *testing.T) {
+		s := newSubscription(model.TableNameJumps, FanoutOptions{BufferSize: 2, Policy: PolicyCoalesce, Debounce: 50 * time.Millisecond})
+		for i := 1; i <= 5; i++ {
+			s.send(&dao.Message{Operation: "INSERT", ID: i, Table: model.TableNameJumps})
+		}
+		time.Sleep(100 * time.Millisecond)
+		assert.EqualValues(t, &dao.Message{Operation: dao.OperationResync, Table: model.TableNameJumps}, receive(t, s))
+		assert.Len(t, s.C(), 0)
+	})
+	t.Run("held back changes are delivered in order once the subscriber catches up", func(t *testing.T) {
+		s := newSubscription(model.TableNameJumps, FanoutOptions{BufferSize: 2, Policy: PolicyCoalesce, Debounce: time.Hour})
+		defer s.close()
+		for i := 1; i <= 3; i++ {
+			s.send(&dao.Message{Operation: "INSERT", ID: i})
+		}
+		assert.EqualValues(t, 1, receive(t, s).ID)
+		assert.EqualValues(t, 2, receive(t, s).ID)
+		// a change must not overtake the ones held back
+		s.send(&dao.Message{Operation: "INSERT", ID: 4})
+		s.flush()
+		assert.EqualValues(t, 3, receive(t, s).ID)
+		assert.EqualValues(t, 4, receive(t, s).ID)
+		assert.Len(t, s.C(), 0)
+	})
+	t.Run("slow subscribers are resynced", func(t *testing.T) {
+		// flush by hand rather than waiting for the timer
+		s := newSubscription(model.TableNameJumps, FanoutOptions{BufferSize: 1, Policy: PolicyCoalesce, Debounce: time.Hour})
+		defer s.close()
+		s.send(&dao.Message{Operation: "INSERT", ID: 1})
+		s.flush()
+		require.Len(t, s.C(), 1)
+		s.send(&dao.Message{Operation: "INSERT", ID: 2})
+		s.flush()
+		assert.EqualValues(t, dao.OperationResync, receive(t, s).Operation)
+		assert.Len(t, s.C(), 0)
+	})
+	t.Run("closed subscriptions receive nothing", func(t *testing.T) {
+		s := newSubscription(model.TableNameJumps, FanoutOptions{BufferSize: 1, Policy: PolicyCoalesce, Debounce: 50 * time.Millisecond})
+		s.send(&dao.Message{Operation: "INSERT", ID: 1})
+		s.send(&dao.Message{Operation: "INSERT", ID: 2})
+		s.close()
+		s.send(&dao.Message{Operation: "INSERT", ID: 3})
+		time.Sleep(100 * time.Millisecond)
+		// only what was delivered before closing
+		assert.EqualValues(t, 1, receive(t, s).ID)
+		assert.Len(t, s.C(), 0)
+	})
+}
+
+func TestListeningService_SlowSubscriber(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed := dao.NewLocalFeed(ctx)
+	svc := NewListeningService(ctx, feed, model.TableNameJumps)
+	require.NoError(t, svc.Listen(ctx, FanoutOptions{BufferSize: 1, Policy: PolicyDrop}))
+
+	// slow never reads anything
+	slow := svc.Subscribe()
+	defer svc.Unsubscribe(slow)
+	fast := svc.Subscribe()
+	defer svc.Unsubscribe(fast)
+
+	for i := 1; i <= 3; i++ {
+		feed.Publish(&dao.Message{Operation: "INSERT", ID: i, Table: model.TableNameJumps})
+		assert.EqualValues(t, i, receive(t, fast).ID)
+	}
+}
+
+func TestListeningService_Subscribe(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 0}))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed := dao.NewLocalFeed(ctx)
+	svc := NewListeningService(ctx, feed, model.TableNameJumps)
+	require.NoError(t, svc.Listen(ctx, FanoutOptions{Policy: PolicyDrop}))
+
+	// subscribers come and go while
+	// changes are being sent
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				svc.Unsubscribe(svc.Subscribe())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				feed.Publish(&dao.Message{Operation: "INSERT", ID: j, Table: model.TableNameJumps})
+			}
+		}()
+	}
+	wg.Wait()
+
+	svc.lisLock.RLock()
+	defer svc.lisLock.RUnlock()
+	assert.Len(t, svc.listeners, 0)
+}
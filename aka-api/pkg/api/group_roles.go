@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+)
+
+// RoleRevoker is implemented by rbac.AuthorityClients
+// that are able to remove role bindings. Members can't
+// be demoted or removed if the client can't, as they
+// would keep their previous bindings.
+type RoleRevoker interface {
+	RemoveRole(ctx context.Context, in *rbac.AddRoleRequest, opts ...grpc.CallOption) (*rbac.GenericResponse, error)
+	// RemoveGlobalRole undoes AddGlobalRole
+	RemoveGlobalRole(ctx context.Context, in *rbac.AddGlobalRoleRequest, opts ...grpc.CallOption) (*rbac.GenericResponse, error)
+}
+
+// groupRoleVerbs maps each GroupRole to the
+// role binding that it is given on the Group.
+var groupRoleVerbs = map[model.GroupRole]rbac.Verb{
+	model.GroupRoleOwner:      rbac.Verb_SUDO,
+	model.GroupRoleMaintainer: rbac.Verb_UPDATE,
+	model.GroupRoleMember:     rbac.Verb_READ,
+}
+
+// groupRole returns the role that a user has within
+// a Group, or an empty role if they aren't a member.
+func groupRole(ctx context.Context, repos *dao.Repos, id uint, subject string) (model.GroupRole, error) {
+	member, err := repos.GroupRepo.GetMember(ctx, id, subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// requireGroupRole checks that the current user has at
+// least the given role within a Group. Administrators
+// are allowed to do anything.
+func requireGroupRole(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, id uint, role model.GroupRole) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "Role", role)
+	username := GetUsernameCtx(ctx)
+	current, err := groupRole(ctx, repos, id, username)
+	if err != nil {
+		log.Error(err, "failed to check group role")
+		return err
+	}
+	if current.AtLeast(role) {
+		return nil
+	}
+	resp, err := authz.Can(ctx, &rbac.AccessRequest{
+		Subject:  username,
+		Resource: RoleSuper,
+		Action:   rbac.Verb_SUDO,
+	})
+	if err != nil {
+		log.Error(err, "failed to check privilege")
+		return err
+	}
+	if !resp.GetOk() {
+		log.V(1).Info("user does not have the required group role", "Current", current)
+		return ErrForbidden
+	}
+	return nil
+}
+
+// checkRevoke returns ErrCannotRevoke if changing the role
+// of a member from before to after takes access away, but
+// the authority isn't a RoleRevoker.
+func checkRevoke(authz rbac.AuthorityClient, before, after model.GroupRole) error {
+	if before == "" || (after != "" && after.AtLeast(before)) {
+		return nil
+	}
+	if _, ok := authz.(RoleRevoker); !ok {
+		return ErrCannotRevoke
+	}
+	return nil
+}
+
+// syncGroupRole updates the role bindings of a member
+// after their role changes. An empty role means that
+// they are no longer a member.
+func syncGroupRole(ctx context.Context, authz rbac.AuthorityClient, id uint, subject string, before, after model.GroupRole) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "Subject", subject, "Before", before, "After", after)
+	if before == after {
+		return nil
+	}
+	resource := schemas.ResourceName(schemas.ResourceGroup, id)
+	if before != "" {
+		if err := checkRevoke(authz, before, after); err != nil {
+			log.Error(err, "refusing to change group role")
+			return err
+		}
+		if revoker, ok := authz.(RoleRevoker); ok {
+			log.V(1).Info("removing group role binding")
+			if _, err := revoker.RemoveRole(ctx, &rbac.AddRoleRequest{
+				Subject:  subject,
+				Resource: resource,
+				Action:   groupRoleVerbs[before],
+			}); err != nil {
+				log.Error(err, "failed to remove group role binding")
+				return err
+			}
+		} else {
+			// the previous binding is lower than the new one
+			log.V(1).Info("authority can't remove role bindings, the previous binding will remain")
+		}
+	}
+	if after == "" {
+		return nil
+	}
+	log.V(1).Info("creating group role binding")
+	if _, err := authz.AddRole(ctx, &rbac.AddRoleRequest{
+		Subject:  subject,
+		Resource: resource,
+		Action:   groupRoleVerbs[after],
+	}); err != nil {
+		log.Error(err, "failed to create group role binding")
+		return err
+	}
+	return nil
+}
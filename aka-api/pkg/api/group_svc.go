@@ -31,7 +31,9 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type GroupService struct {
@@ -40,14 +42,17 @@ type GroupService struct {
 	authz rbac.AuthorityClient
 }
 
-func NewGroupService(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, listener chan *dao.Message) *GroupService {
+func NewGroupService(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, feed dao.ChangeFeed) *GroupService {
 	return &GroupService{
 		repos:            repos,
 		authz:            authz,
-		ListeningService: NewListeningService(ctx, listener),
+		ListeningService: NewListeningService(ctx, feed, model.TableNameGroups),
 	}
 }
 
+// Create makes a new Group owned by the current user.
+// Names must be unique, so that nobody can get into an
+// existing Group by creating one with the same name.
 func (svc *GroupService) Create(ctx context.Context, name string, public, external bool) (*model.Group, error) {
 	log := logr.FromContextOrDiscard(ctx).WithValues("Name", name, "Public", public, "external", external)
 	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_create", trace.WithAttributes(attribute.String("name", name)))
@@ -55,38 +60,91 @@ func (svc *GroupService) Create(ctx context.Context, name string, public, extern
 	log.V(1).Info("creating group")
 	username := GetUsernameCtx(ctx)
 	// check to make sure that the group doesn't already exist
+	if _, err := svc.repos.GroupRepo.FindByName(ctx, name); err == nil {
+		log.Info("refusing to create group as the name is already taken")
+		return nil, ErrAlreadyExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	group, err := svc.repos.GroupRepo.Save(&model.Group{
+		Name:     name,
+		Public:   public,
+		Owner:    username,
+		External: external,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	group, _, err = svc.addMember(ctx, group, username, username)
+	return group, err
+}
+
+// join adds a user to the Group with a given name,
+// creating it if it doesn't exist yet. It must only be
+// used for the groups claimed by the identity provider.
+func (svc *GroupService) join(ctx context.Context, username, name string, public, external bool, actor string) (*model.Group, bool, error) {
 	group, err := svc.repos.GroupRepo.FindByName(ctx, name)
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, err
+		return nil, false, err
 	}
+	// whether a group is External is decided when it is
+	// created, so that joining can't change it
 	if group == nil {
-		group = &model.Group{
+		group, err = svc.repos.GroupRepo.Save(&model.Group{
 			Name:     name,
 			Public:   public,
 			Owner:    username,
 			External: external,
+		})
+		if err != nil {
+			return nil, false, err
 		}
 	}
-	users := strings.Split(group.Users, ",")
-	if !slices.Contains(users, username) {
-		users = append(users, username)
-	}
-	group.External = external
-	group.Users = strings.Join(users, ",")
-	group, err = svc.repos.GroupRepo.Save(group)
+	return svc.addMember(ctx, group, username, actor)
+}
+
+// addMember makes a user a member of a Group if they aren't
+// one already, returning whether they were added. The owner
+// is given the owner role.
+func (svc *GroupService) addMember(ctx context.Context, group *model.Group, username, actor string) (*model.Group, bool, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	role, err := groupRole(ctx, svc.repos, group.ID, username)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	added := role == ""
+	if added {
+		source := model.GroupSourceManual
+		if group.External {
+			source = model.GroupSourceIdP
+		}
+		role = model.GroupRoleMember
+		if group.Owner == username {
+			role = model.GroupRoleOwner
+		}
+		if err := svc.repos.GroupRepo.AddMember(ctx, &model.GroupMember{
+			GroupID: group.ID,
+			Subject: username,
+			Role:    role,
+			Source:  source,
+		}); err != nil {
+			log.Error(err, "failed to add group member")
+			return nil, false, err
+		}
+		if err := svc.recordEvent(ctx, group.ID, username, model.GroupMemberAdded, role, source, actor); err != nil {
+			return nil, false, err
+		}
+		group, err = svc.repos.GroupRepo.GetByID(ctx, group.ID)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 	// create role bindings
-	if _, err := svc.authz.AddRole(ctx, &rbac.AddRoleRequest{
-		Subject:  username,
-		Resource: schemas.ResourceName(schemas.ResourceGroup, group.ID),
-		Action:   rbac.Verb_SUDO,
-	}); err != nil {
-		log.Error(err, "failed to create owner role binding")
-		return nil, err
+	if err := syncGroupRole(ctx, svc.authz, group.ID, username, "", role); err != nil {
+		return nil, false, err
 	}
-	return group, nil
+	return group, added, nil
 }
 
 func (svc *GroupService) Patch(ctx context.Context, opt model.EditGroup) (*model.Group, error) {
@@ -95,27 +153,618 @@ func (svc *GroupService) Patch(ctx context.Context, opt model.EditGroup) (*model
 	defer span.End()
 	log.V(1).Info("patching group")
 
-	resp, err := svc.authz.Can(ctx, &rbac.AccessRequest{
-		Subject:  GetUsernameCtx(ctx),
-		Resource: schemas.ResourceName(schemas.ResourceGroup, opt.ID),
-		Action:   rbac.Verb_UPDATE,
+	if err := requireGroupRole(ctx, svc.repos, svc.authz, uint(opt.ID), model.GroupRoleOwner); err != nil {
+		return nil, err
+	}
+	group, err := svc.repos.GroupRepo.GetByID(ctx, uint(opt.ID))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	group.Public = opt.Public
+	if opt.Owner != "" && opt.Owner != group.Owner {
+		// ownership can only be given to
+		// someone that is already a member
+		role, err := groupRole(ctx, svc.repos, group.ID, opt.Owner)
+		if err != nil {
+			return nil, err
+		}
+		if role == "" {
+			log.Info("refusing to transfer ownership to a non-member", "Owner", opt.Owner)
+			return nil, ErrNotMember
+		}
+		if err := svc.transferOwnership(ctx, group, opt.Owner); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := svc.repos.GroupRepo.Save(group); err != nil {
+		return nil, err
+	}
+	return svc.repos.GroupRepo.GetByID(ctx, group.ID)
+}
+
+// transferOwnership makes a user the owner of a Group,
+// demoting the previous owner to a member. The caller
+// is responsible for saving the Group.
+func (svc *GroupService) transferOwnership(ctx context.Context, group *model.Group, owner string) error {
+	log := logr.FromContextOrDiscard(ctx)
+	log.Info("updating group owner", "Before", group.Owner, "After", owner)
+	// the new owner becomes a member if
+	// they aren't already
+	if _, err := svc.setRole(ctx, group, owner, model.GroupRoleOwner); err != nil {
+		return err
+	}
+	previous := group.Owner
+	group.Owner = owner
+	if previous != "" && slices.Contains(groupMembers(group), previous) {
+		if _, err := svc.setRole(ctx, group, previous, model.GroupRoleMember); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Archive hides a Group and its Jumps from everyone
+// without deleting them. Only owners can archive a Group.
+func (svc *GroupService) Archive(ctx context.Context, id int, archived bool) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "Archived", archived)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_archive", trace.WithAttributes(attribute.Int("id", id)))
+	defer span.End()
+	log.Info("archiving group")
+
+	if err := requireGroupRole(ctx, svc.repos, svc.authz, uint(id), model.GroupRoleOwner); err != nil {
+		return nil, err
+	}
+	group, err := svc.repos.GroupRepo.GetByID(ctx, uint(id))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	group.Archived = archived
+	return svc.repos.GroupRepo.Save(group)
+}
+
+// Delete soft-deletes a Group. Its Jumps are given to
+// the reassignTo owner (e.g. user://john or group://2), or
+// deleted along with the Group if there isn't one. Only
+// owners can delete a Group.
+func (svc *GroupService) Delete(ctx context.Context, id int, reassignTo string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "ReassignTo", reassignTo)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_delete", trace.WithAttributes(attribute.Int("id", id)))
+	defer span.End()
+	log.Info("deleting group")
+
+	if err := requireGroupRole(ctx, svc.repos, svc.authz, uint(id), model.GroupRoleOwner); err != nil {
+		return err
+	}
+	group, err := svc.repos.GroupRepo.GetByID(ctx, uint(id))
+	if err != nil {
+		return ErrNotFound
+	}
+	return svc.delete(ctx, group, reassignTo)
+}
+
+// delete removes a Group along with its members' role
+// bindings. Its Jumps are reassigned or deleted.
+func (svc *GroupService) delete(ctx context.Context, group *model.Group, reassignTo string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", group.ID, "ReassignTo", reassignTo)
+	if reassignTo != "" {
+		if err := svc.checkNewOwner(ctx, group, reassignTo); err != nil {
+			return err
+		}
+	}
+	members, err := svc.repos.GroupRepo.GetMembers(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err := checkRevoke(svc.authz, m.Role, ""); err != nil {
+			return err
+		}
+	}
+	ids, err := svc.repos.GroupRepo.Delete(ctx, group.ID, reassignTo)
+	if err != nil {
+		log.Error(err, "failed to delete group")
+		return err
+	}
+	log.Info("deleted group", "Reassigned", len(ids))
+	// role bindings can't be changed in the same
+	// transaction, so they're updated afterwards.
+	// Users need to be able to manage the jumps
+	// that they've been given
+	if subject, ok := strings.CutPrefix(reassignTo, "user://"); ok {
+		for _, jid := range ids {
+			if _, err := svc.authz.AddRole(ctx, &rbac.AddRoleRequest{
+				Subject:  subject,
+				Resource: schemas.ResourceName(schemas.ResourceJump, jid),
+				Action:   rbac.Verb_SUDO,
+			}); err != nil {
+				log.Error(err, "failed to create owner role binding")
+				return err
+			}
+		}
+	}
+	for _, m := range members {
+		if err := syncGroupRole(ctx, svc.authz, group.ID, m.Subject, m.Role, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNewOwner makes sure that the Jumps of a Group
+// can be given to a new owner.
+func (svc *GroupService) checkNewOwner(ctx context.Context, group *model.Group, owner string) error {
+	kind, name, _ := strings.Cut(owner, "://")
+	switch kind {
+	case "user":
+		if _, err := svc.repos.UserRepo.Get(ctx, name); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+		// jumps can only be given to ourselves or
+		// to someone that could already manage them
+		if name == GetUsernameCtx(ctx) {
+			return nil
+		}
+		role, err := groupRole(ctx, svc.repos, group.ID, name)
+		if err != nil {
+			return err
+		}
+		if role == "" {
+			return ErrNotMember
+		}
+		return nil
+	case "group":
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil || uint(id) == group.ID {
+			return ErrInvalidOwner
+		}
+		target, err := svc.repos.GroupRepo.GetByID(ctx, uint(id))
+		if err != nil {
+			return ErrNotFound
+		}
+		if target.Archived {
+			return ErrGroupArchived
+		}
+		// we need to be able to add jumps
+		// to the other group
+		return requireGroupRole(ctx, svc.repos, svc.authz, target.ID, model.GroupRoleMaintainer)
+	}
+	return ErrInvalidOwner
+}
+
+// AddMember adds a user to a Group with a given role. Members
+// of external groups are managed by the identity provider,
+// so they can't be changed here.
+func (svc *GroupService) AddMember(ctx context.Context, id int, subject string, role model.GroupRole) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "Subject", subject, "Role", role)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_addMember", trace.WithAttributes(attribute.Int("id", id)))
+	defer span.End()
+	log.V(1).Info("adding group member")
+
+	group, err := svc.getEditable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// make sure that the user exists
+	if _, err := svc.repos.UserRepo.Get(ctx, subject); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if slices.Contains(groupMembers(group), subject) {
+		return group, nil
+	}
+	return svc.setRole(ctx, group, subject, role)
+}
+
+// SetRole changes the role of a member of a Group.
+// Every Group must be left with at least one owner.
+func (svc *GroupService) SetRole(ctx context.Context, id int, subject string, role model.GroupRole) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "Subject", subject, "Role", role)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_setRole", trace.WithAttributes(attribute.Int("id", id)))
+	defer span.End()
+	log.V(1).Info("changing group member role")
+
+	if err := requireGroupRole(ctx, svc.repos, svc.authz, uint(id), model.GroupRoleOwner); err != nil {
+		return nil, err
+	}
+	group, err := svc.repos.GroupRepo.GetByID(ctx, uint(id))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if !slices.Contains(groupMembers(group), subject) {
+		return nil, ErrNotFound
+	}
+	if role != model.GroupRoleOwner {
+		if err := svc.requireOtherOwner(ctx, group.ID, subject); err != nil {
+			log.Info("refusing to demote the last owner of a group")
+			return nil, err
+		}
+	}
+	if _, err := svc.setRole(ctx, group, subject, role); err != nil {
+		return nil, err
+	}
+	return svc.replaceOwner(ctx, group, subject)
+}
+
+// requireOtherOwner returns ErrLastOwner if a user is
+// the only member of a Group with the owner role.
+func (svc *GroupService) requireOtherOwner(ctx context.Context, id uint, subject string) error {
+	members, err := svc.repos.GroupRepo.GetMembers(ctx, id)
+	if err != nil {
+		return err
+	}
+	var owners int
+	var isOwner bool
+	for _, m := range members {
+		if m.Role != model.GroupRoleOwner {
+			continue
+		}
+		owners++
+		if m.Subject == subject {
+			isOwner = true
+		}
+	}
+	if isOwner && owners == 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// replaceOwner picks another owner to show as the owner
+// of a Group once the previous one stops being an owner.
+func (svc *GroupService) replaceOwner(ctx context.Context, group *model.Group, previous string) (*model.Group, error) {
+	if group.Owner != previous {
+		return svc.repos.GroupRepo.GetByID(ctx, group.ID)
+	}
+	members, err := svc.repos.GroupRepo.GetMembers(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if m.Role == model.GroupRoleOwner && m.Subject != previous {
+			logr.FromContextOrDiscard(ctx).Info("updating group owner", "Before", previous, "After", m.Subject)
+			group.Owner = m.Subject
+			if _, err := svc.repos.GroupRepo.Save(group); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return svc.repos.GroupRepo.GetByID(ctx, group.ID)
+}
+
+// setRole adds or updates a member of a Group and
+// their role bindings.
+func (svc *GroupService) setRole(ctx context.Context, group *model.Group, subject string, role model.GroupRole) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	if !role.IsValid() {
+		return nil, ErrInvalidRole
+	}
+	before, err := groupRole(ctx, svc.repos, group.ID, subject)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRevoke(svc.authz, before, role); err != nil {
+		return nil, err
+	}
+	source := model.GroupSourceManual
+	if group.External {
+		source = model.GroupSourceIdP
+	}
+	if err := svc.repos.GroupRepo.AddMember(ctx, &model.GroupMember{
+		GroupID: group.ID,
+		Subject: subject,
+		Role:    role,
+		Source:  source,
+	}); err != nil {
+		log.Error(err, "failed to add group member")
+		return nil, err
+	}
+	if before != role {
+		action := model.GroupMemberRoleChanged
+		if before == "" {
+			action = model.GroupMemberAdded
+		}
+		if err := svc.recordEvent(ctx, group.ID, subject, action, role, source, GetUsernameCtx(ctx)); err != nil {
+			return nil, err
+		}
+	}
+	if err := syncGroupRole(ctx, svc.authz, group.ID, subject, before, role); err != nil {
+		return nil, err
+	}
+	return svc.repos.GroupRepo.GetByID(ctx, group.ID)
+}
+
+// keepOwner makes sure that a Group still has an owner
+// once a user is removed without handing it over first.
+// Another owner takes over if there is one, otherwise the
+// longest-standing member with the highest role is
+// promoted. A Group with no other members is archived.
+func (svc *GroupService) keepOwner(ctx context.Context, group *model.Group, subject string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", group.ID, "Subject", subject)
+	members, err := svc.repos.GroupRepo.GetMembers(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	var owner bool
+	var next *model.GroupMember
+	for _, m := range members {
+		if m.Subject == subject {
+			owner = m.Role == model.GroupRoleOwner
+			continue
+		}
+		// members are in the order that they were added,
+		// so the first with the highest role wins
+		if next == nil || !next.Role.AtLeast(m.Role) {
+			next = m
+		}
+	}
+	if !owner && group.Owner != subject {
+		return nil
+	}
+	switch {
+	case next == nil:
+		log.Info("archiving group as its owner is leaving and nobody else is a member")
+		group.Archived = true
+	case next.Role == model.GroupRoleOwner:
+		group.Owner = next.Subject
+	default:
+		log.Info("promoting member as the only owner is leaving", "Owner", next.Subject)
+		if _, err := svc.setRole(ctx, group, next.Subject, model.GroupRoleOwner); err != nil {
+			return err
+		}
+		group.Owner = next.Subject
+	}
+	if group.Owner == subject {
+		group.Owner = ""
+	}
+	_, err = svc.repos.GroupRepo.Save(group)
+	return err
+}
+
+// RemoveMember removes a user from a Group. The
+// last owner can't be removed.
+func (svc *GroupService) RemoveMember(ctx context.Context, id int, subject string) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "Subject", subject)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_removeMember", trace.WithAttributes(attribute.Int("id", id)))
+	defer span.End()
+	log.V(1).Info("removing group member")
+
+	group, err := svc.getEditable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return svc.removeMember(ctx, group, subject)
+}
+
+// Leave removes the current user from a Group. The
+// last owner can't leave.
+func (svc *GroupService) Leave(ctx context.Context, id int) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_leave", trace.WithAttributes(attribute.Int("id", id)))
+	defer span.End()
+	log.V(1).Info("leaving group")
+
+	// check that the group exists AND that
+	// we're a member
+	group, err := svc.repos.GroupRepo.FindByID(ctx, id)
+	if err != nil {
+		return ErrNotFound
+	}
+	if group.External {
+		return ErrExternalGroup
+	}
+	_, err = svc.removeMember(ctx, group, GetUsernameCtx(ctx))
+	return err
+}
+
+func (svc *GroupService) removeMember(ctx context.Context, group *model.Group, subject string) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	// a group without an owner can't be
+	// managed by anyone
+	if err := svc.requireOtherOwner(ctx, group.ID, subject); err != nil {
+		log.Info("refusing to remove the last owner of a group")
+		return nil, err
+	}
+	if _, err := svc.evict(ctx, group.ID, subject, GetUsernameCtx(ctx)); err != nil {
+		return nil, err
+	}
+	return svc.replaceOwner(ctx, group, subject)
+}
+
+// evict removes a user from a Group along with their
+// role bindings, returning false if they weren't a member.
+// The role bindings are removed first so that they aren't
+// left behind if removing the member fails.
+func (svc *GroupService) evict(ctx context.Context, id uint, subject, actor string) (bool, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	member, err := svc.repos.GroupRepo.GetMember(ctx, id, subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := syncGroupRole(ctx, svc.authz, id, subject, member.Role, ""); err != nil {
+		return false, err
+	}
+	if err := svc.repos.GroupRepo.RemoveMember(ctx, id, subject); err != nil {
+		log.Error(err, "failed to remove group member")
+		return false, err
+	}
+	if err := svc.recordEvent(ctx, id, subject, model.GroupMemberRemoved, member.Role, member.Source, actor); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recordEvent adds a change to the history of a Group.
+func (svc *GroupService) recordEvent(ctx context.Context, id uint, subject, action string, role model.GroupRole, source, actor string) error {
+	return svc.repos.GroupRepo.AddMemberEvent(ctx, &model.GroupMemberEvent{
+		GroupID: id,
+		Subject: subject,
+		Action:  action,
+		Role:    role,
+		Source:  source,
+		Actor:   actor,
+		Date:    time.Now().Unix(),
+	})
+}
+
+// History returns the changes made to the members of
+// a Group, newest first. Only owners can see it.
+func (svc *GroupService) History(ctx context.Context, id, offset, limit int) ([]*model.GroupMemberEvent, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_history", trace.WithAttributes(attribute.Int("id", id)))
+	defer span.End()
+	if err := requireGroupRole(ctx, svc.repos, svc.authz, uint(id), model.GroupRoleOwner); err != nil {
+		return nil, err
+	}
+	return svc.repos.GroupRepo.GetMemberEvents(ctx, uint(id), offset, limit)
+}
+
+// GroupSyncReport summarises the changes made when
+// synchronising group membership with the identity provider.
+type GroupSyncReport struct {
+	Users   int
+	Added   []string
+	Removed []string
+}
+
+// Sync reconciles a user's membership of External Groups
+// with the groups claimed by the identity provider. Users
+// are added to every claimed Group and removed from any
+// External Group that they were given by the identity
+// provider but no longer claim. Members that were added
+// manually are left alone. Groups that lose their owner
+// are kept by another member (see keepOwner).
+func (svc *GroupService) Sync(ctx context.Context, subject string, claims []string) (*GroupSyncReport, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Subject", subject)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_sync", trace.WithAttributes(attribute.String("subject", subject)))
+	defer span.End()
+	report := &GroupSyncReport{Users: 1}
+	claimed := map[string]struct{}{}
+	for _, name := range claims {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		claimed[name] = struct{}{}
+		_, added, err := svc.join(ctx, subject, name, false, true, "")
+		if err != nil {
+			span.RecordError(err)
+			log.Error(err, "failed to join group", "Name", name)
+			return nil, err
+		}
+		if added {
+			report.Added = append(report.Added, name)
+		}
+	}
+	memberships, err := svc.repos.GroupRepo.GetMemberships(ctx, subject)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	for _, m := range memberships {
+		if m.Source != model.GroupSourceIdP {
+			continue
+		}
+		group, err := svc.repos.GroupRepo.GetByID(ctx, m.GroupID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			span.RecordError(err)
+			return nil, err
+		}
+		if !group.External {
+			continue
+		}
+		if _, ok := claimed[group.Name]; ok {
+			continue
+		}
+		log.Info("removing user from group that is no longer claimed by the identity provider", "Name", group.Name, "Role", m.Role)
+		if err := svc.keepOwner(ctx, group, subject); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		if _, err := svc.evict(ctx, group.ID, subject, ""); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		report.Removed = append(report.Removed, group.Name)
+	}
+	return report, nil
+}
+
+// SyncAll runs Sync for every known user using the
+// groups that were claimed when they last logged in.
+func (svc *GroupService) SyncAll(ctx context.Context) (*GroupSyncReport, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_group_syncAll")
+	defer span.End()
+	report := &GroupSyncReport{}
+	err := svc.repos.UserRepo.FindInBatches(ctx, 100, func(users []*dao.UserV2) error {
+		for _, u := range users {
+			r, err := svc.Sync(ctx, u.Subject, strings.Split(u.Groups, ","))
+			if err != nil {
+				return err
+			}
+			report.Users++
+			report.Added = append(report.Added, r.Added...)
+			report.Removed = append(report.Removed, r.Removed...)
+		}
+		return nil
 	})
 	if err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to synchronise group membership")
 		return nil, err
 	}
-	if !resp.Ok {
-		return nil, ErrForbidden
+	log.Info("synchronised group membership", "Users", report.Users, "Added", len(report.Added), "Removed", len(report.Removed))
+	return report, nil
+}
+
+// getEditable returns a Group if the current
+// user is allowed to change its members.
+func (svc *GroupService) getEditable(ctx context.Context, id int) (*model.Group, error) {
+	if err := requireGroupRole(ctx, svc.repos, svc.authz, uint(id), model.GroupRoleOwner); err != nil {
+		return nil, err
 	}
-	// check that the group exists AND that we're
-	// allowed to see it
-	group, err := svc.repos.GroupRepo.FindByID(ctx, opt.ID)
+	group, err := svc.repos.GroupRepo.GetByID(ctx, uint(id))
 	if err != nil {
 		return nil, ErrNotFound
 	}
-	group.Public = opt.Public
-	if opt.Owner != "" {
-		log.Info("updating group owner", "Before", group.Owner, "After", opt.Owner)
-		group.Owner = opt.Owner
+	if group.External {
+		return nil, ErrExternalGroup
+	}
+	return group, nil
+}
+
+// groupMembers returns the subjects of every
+// member of a Group.
+func groupMembers(group *model.Group) []string {
+	return slices.DeleteFunc(strings.Split(group.Users, ","), func(s string) bool {
+		return s == ""
+	})
+}
+
+// ChangeSource returns the data behind a subscriber's
+// live view of the Groups that they can see.
+func (svc *GroupService) ChangeSource(offset, limit int) *ChangeSource {
+	return &ChangeSource{
+		Page: func(ctx context.Context) (*model.Page, error) {
+			return svc.repos.GroupRepo.GetGroups(ctx, GetUsernameCtx(ctx), offset, limit)
+		},
+		Get: func(ctx context.Context, id uint) (model.Pageable, bool, error) {
+			group, err := svc.repos.GroupRepo.GetByID(ctx, id)
+			if err != nil {
+				return nil, false, err
+			}
+			return group, group.Public || slices.Contains(groupMembers(group), GetUsernameCtx(ctx)), nil
+		},
 	}
-	return svc.repos.GroupRepo.Save(group)
 }
@@ -0,0 +1,439 @@
+package api
+
+import (
+	"context"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"strconv"
+	"testing"
+)
+
+func TestGroupService_Members(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewGroupService(ctx, repos, authz, nil)
+
+	for _, sub := range []string{"john", "jane", "bob"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+
+	group, err := svc.Create(john, "team", false, false)
+	require.NoError(t, err)
+	id := int(group.ID)
+	assert.EqualValues(t, "john", group.Users)
+
+	t.Run("names can't be reused to join a group", func(t *testing.T) {
+		_, err := svc.Create(jane, "team", false, false)
+		assert.ErrorIs(t, err, ErrAlreadyExists)
+		role, err := groupRole(ctx, repos, group.ID, "jane")
+		require.NoError(t, err)
+		assert.Empty(t, role)
+	})
+	t.Run("members can only be added by those with access", func(t *testing.T) {
+		_, err := svc.AddMember(jane, id, "jane", model.GroupRoleMember)
+		assert.ErrorIs(t, err, ErrForbidden)
+	})
+	t.Run("unknown users can't be added", func(t *testing.T) {
+		_, err := svc.AddMember(john, id, "alice", model.GroupRoleMember)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("add", func(t *testing.T) {
+		group, err := svc.AddMember(john, id, "jane", model.GroupRoleMember)
+		require.NoError(t, err)
+		assert.EqualValues(t, "john,jane", group.Users)
+
+		// adding someone twice does nothing
+		group, err = svc.AddMember(john, id, "jane", model.GroupRoleMember)
+		require.NoError(t, err)
+		assert.EqualValues(t, "john,jane", group.Users)
+
+		groups, err := repos.GroupRepo.GetUserGroups(jane, "jane")
+		require.NoError(t, err)
+		assert.Len(t, groups, 1)
+	})
+	t.Run("the owner can't be removed", func(t *testing.T) {
+		_, err := svc.RemoveMember(john, id, "john")
+		assert.ErrorIs(t, err, ErrLastOwner)
+		assert.ErrorIs(t, svc.Leave(john, id), ErrLastOwner)
+	})
+	t.Run("remove", func(t *testing.T) {
+		_, err := svc.AddMember(john, id, "bob", model.GroupRoleMember)
+		require.NoError(t, err)
+		group, err := svc.RemoveMember(john, id, "bob")
+		require.NoError(t, err)
+		assert.EqualValues(t, "john,jane", group.Users)
+	})
+	t.Run("leave", func(t *testing.T) {
+		require.NoError(t, svc.Leave(jane, id))
+		group, err := repos.GroupRepo.GetByID(ctx, uint(id))
+		require.NoError(t, err)
+		assert.EqualValues(t, "john", group.Users)
+
+		// we can't leave a group we're not in
+		assert.ErrorIs(t, svc.Leave(jane, id), ErrNotFound)
+	})
+	t.Run("external groups can't be changed", func(t *testing.T) {
+		group, err := svc.Create(john, "external", false, true)
+		require.NoError(t, err)
+		_, err = svc.AddMember(john, int(group.ID), "jane", model.GroupRoleMember)
+		assert.ErrorIs(t, err, ErrExternalGroup)
+	})
+}
+
+func TestGroupService_Roles(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewGroupService(ctx, repos, authz, nil)
+
+	for _, sub := range []string{"john", "jane", "bob"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+
+	group, err := svc.Create(john, "team", false, false)
+	require.NoError(t, err)
+	id := int(group.ID)
+	resource := schemas.ResourceName(schemas.ResourceGroup, id)
+	assert.EqualValues(t, rbac.Verb_SUDO, authz.bindings["john"][resource])
+
+	_, err = svc.AddMember(john, id, "jane", model.GroupRoleMaintainer)
+	require.NoError(t, err)
+	assert.EqualValues(t, rbac.Verb_UPDATE, authz.bindings["jane"][resource])
+
+	t.Run("maintainers can't manage members", func(t *testing.T) {
+		_, err := svc.AddMember(jane, id, "bob", model.GroupRoleMember)
+		assert.ErrorIs(t, err, ErrForbidden)
+		_, err = svc.SetRole(jane, id, "jane", model.GroupRoleOwner)
+		assert.ErrorIs(t, err, ErrForbidden)
+	})
+	t.Run("owners can change roles", func(t *testing.T) {
+		_, err := svc.SetRole(john, id, "jane", model.GroupRoleOwner)
+		require.NoError(t, err)
+		member, err := repos.GroupRepo.GetMember(ctx, uint(id), "jane")
+		require.NoError(t, err)
+		assert.EqualValues(t, model.GroupRoleOwner, member.Role)
+		assert.EqualValues(t, rbac.Verb_SUDO, authz.bindings["jane"][resource])
+
+		// and now jane can manage members
+		_, err = svc.AddMember(jane, id, "bob", model.GroupRoleMember)
+		assert.NoError(t, err)
+	})
+	t.Run("owners can be demoted while another owner remains", func(t *testing.T) {
+		group, err := svc.SetRole(jane, id, "john", model.GroupRoleMember)
+		require.NoError(t, err)
+		assert.EqualValues(t, "jane", group.Owner)
+
+		_, err = svc.SetRole(jane, id, "jane", model.GroupRoleMember)
+		assert.ErrorIs(t, err, ErrLastOwner)
+		assert.ErrorIs(t, svc.Leave(jane, id), ErrLastOwner)
+
+		_, err = svc.SetRole(jane, id, "john", model.GroupRoleOwner)
+		require.NoError(t, err)
+	})
+	t.Run("only members have roles", func(t *testing.T) {
+		_, err := svc.SetRole(john, id, "alice", model.GroupRoleMember)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("bindings are removed with the member", func(t *testing.T) {
+		_, err := svc.RemoveMember(john, id, "bob")
+		require.NoError(t, err)
+		assert.NotContains(t, authz.bindings["bob"], resource)
+	})
+	t.Run("transferring ownership", func(t *testing.T) {
+		group, err := svc.Patch(jane, model.EditGroup{ID: id, Owner: "john"})
+		require.NoError(t, err)
+		assert.EqualValues(t, "john", group.Owner)
+		member, err := repos.GroupRepo.GetMember(ctx, uint(id), "jane")
+		require.NoError(t, err)
+		assert.EqualValues(t, model.GroupRoleMember, member.Role)
+		assert.EqualValues(t, rbac.Verb_READ, authz.bindings["jane"][resource])
+	})
+	t.Run("ownership can only be given to members", func(t *testing.T) {
+		_, err := svc.Patch(john, model.EditGroup{ID: id, Owner: "bob"})
+		assert.ErrorIs(t, err, ErrNotMember)
+		_, err = svc.Patch(john, model.EditGroup{ID: id, Owner: "alice"})
+		assert.ErrorIs(t, err, ErrNotMember)
+	})
+}
+
+func TestGroupService_RolesWithoutRevoke(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	svc := NewGroupService(ctx, repos, grantOnly{newFakeAuthz()}, nil)
+
+	for _, sub := range []string{"john", "jane"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+
+	group, err := svc.Create(john, "team", false, false)
+	require.NoError(t, err)
+	id := int(group.ID)
+	_, err = svc.AddMember(john, id, "jane", model.GroupRoleMaintainer)
+	require.NoError(t, err)
+
+	// promotions only add bindings
+	_, err = svc.SetRole(john, id, "jane", model.GroupRoleOwner)
+	require.NoError(t, err)
+
+	// but anything that takes access away would
+	// leave the previous binding behind
+	_, err = svc.SetRole(john, id, "jane", model.GroupRoleMember)
+	assert.ErrorIs(t, err, ErrCannotRevoke)
+	_, err = svc.RemoveMember(john, id, "jane")
+	assert.ErrorIs(t, err, ErrCannotRevoke)
+	assert.ErrorIs(t, svc.Delete(john, id, ""), ErrCannotRevoke)
+
+	// and nothing was changed
+	member, err := repos.GroupRepo.GetMember(ctx, group.ID, "jane")
+	require.NoError(t, err)
+	assert.EqualValues(t, model.GroupRoleOwner, member.Role)
+	_, err = repos.GroupRepo.GetByID(ctx, group.ID)
+	assert.NoError(t, err)
+}
+
+func TestGroupService_Delete(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewGroupService(ctx, repos, authz, nil)
+	jumps := NewJumpService(ctx, repos, authz, false, nil, nil)
+
+	for _, sub := range []string{"john", "jane"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+
+	newGroup := func(t *testing.T, name string) (*model.Group, *model.Jump) {
+		group, err := svc.Create(john, name, false, false)
+		require.NoError(t, err)
+		jump, err := jumps.Create(john, CreateJumpOpts{GID: int(group.ID), Name: name, Location: "https://example.org/" + name})
+		require.NoError(t, err)
+		return group, jump
+	}
+
+	t.Run("archived groups are hidden", func(t *testing.T) {
+		group, jump := newGroup(t, "archived")
+
+		_, err := svc.Archive(jane, int(group.ID), true)
+		assert.ErrorIs(t, err, ErrForbidden)
+		_, err = svc.Archive(john, int(group.ID), true)
+		require.NoError(t, err)
+
+		page, err := jumps.List(john, 0, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 0, page.Count)
+		archived, err := repos.GroupRepo.GetArchived(john, "john")
+		require.NoError(t, err)
+		assert.Len(t, archived, 1)
+		_, err = jumps.Create(john, CreateJumpOpts{GID: int(group.ID), Name: "more", Location: "https://example.org/more"})
+		assert.ErrorIs(t, err, ErrGroupArchived)
+
+		// nothing was deleted
+		assert.True(t, repos.JumpRepo.ExistsByID(ctx, jump.ID))
+		_, err = svc.Archive(john, int(group.ID), false)
+		require.NoError(t, err)
+		page, err = jumps.List(john, 0, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, page.Count)
+	})
+	t.Run("delete requires access", func(t *testing.T) {
+		group, _ := newGroup(t, "protected")
+		assert.ErrorIs(t, svc.Delete(jane, int(group.ID), ""), ErrForbidden)
+
+		// a role binding isn't enough, they need to be an owner
+		_, err := authz.AddRole(ctx, &rbac.AddRoleRequest{
+			Subject:  "jane",
+			Resource: schemas.ResourceName(schemas.ResourceGroup, int(group.ID)),
+			Action:   rbac.Verb_DELETE,
+		})
+		require.NoError(t, err)
+		assert.ErrorIs(t, svc.Delete(jane, int(group.ID), ""), ErrForbidden)
+		_, err = svc.AddMember(john, int(group.ID), "jane", model.GroupRoleMaintainer)
+		require.NoError(t, err)
+		assert.ErrorIs(t, svc.Delete(jane, int(group.ID), ""), ErrForbidden)
+
+		// administrators can delete any group
+		_, err = authz.AddGlobalRole(ctx, &rbac.AddGlobalRoleRequest{Subject: "alice", Role: RoleSuper})
+		require.NoError(t, err)
+		require.NoError(t, svc.Delete(withUser(ctx, "alice"), int(group.ID), ""))
+	})
+	t.Run("cascade", func(t *testing.T) {
+		group, jump := newGroup(t, "cascade")
+		require.NoError(t, svc.Delete(john, int(group.ID), ""))
+
+		assert.False(t, repos.JumpRepo.ExistsByID(ctx, jump.ID))
+		_, err := repos.GroupRepo.GetByID(ctx, group.ID)
+		assert.Error(t, err)
+		assert.NotContains(t, authz.bindings["john"], schemas.ResourceName(schemas.ResourceGroup, group.ID))
+	})
+	t.Run("reassign to a user", func(t *testing.T) {
+		group, jump := newGroup(t, "to-user")
+		// only members can be given the group's jumps
+		assert.ErrorIs(t, svc.Delete(john, int(group.ID), "user://jane"), ErrNotMember)
+		assert.True(t, repos.JumpRepo.ExistsByID(ctx, jump.ID))
+
+		_, err := svc.AddMember(john, int(group.ID), "jane", model.GroupRoleMember)
+		require.NoError(t, err)
+		require.NoError(t, svc.Delete(john, int(group.ID), "user://jane"))
+
+		jump, err = repos.JumpRepo.GetByID(ctx, jump.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "user://jane", jump.Owner)
+		assert.Contains(t, authz.bindings["jane"], schemas.ResourceName(schemas.ResourceJump, jump.ID))
+	})
+	t.Run("reassign to ourselves", func(t *testing.T) {
+		group, jump := newGroup(t, "to-self")
+		require.NoError(t, svc.Delete(john, int(group.ID), "user://john"))
+
+		jump, err := repos.JumpRepo.GetByID(ctx, jump.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "user://john", jump.Owner)
+	})
+	t.Run("reassign to a group", func(t *testing.T) {
+		group, jump := newGroup(t, "from-group")
+		target, _ := newGroup(t, "to-group")
+
+		assert.ErrorIs(t, svc.Delete(john, int(group.ID), "group://"+strconv.Itoa(int(group.ID))), ErrInvalidOwner)
+		assert.ErrorIs(t, svc.Delete(john, int(group.ID), "https://example.org"), ErrInvalidOwner)
+		assert.ErrorIs(t, svc.Delete(john, int(group.ID), "user://alice"), ErrNotFound)
+
+		require.NoError(t, svc.Delete(john, int(group.ID), "group://"+strconv.Itoa(int(target.ID))))
+		jump, err := repos.JumpRepo.GetByID(ctx, jump.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, schemas.ResourceName(schemas.ResourceGroup, target.ID), jump.Owner)
+	})
+}
+
+func TestGroupService_Sync(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewGroupService(ctx, repos, authz, nil)
+
+	for _, sub := range []string{"john", "jane"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+
+	report, err := svc.Sync(ctx, "john", []string{"devs", " ops", ""})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"devs", "ops"}, report.Added)
+	assert.Empty(t, report.Removed)
+
+	team, err := svc.Create(john, "team", false, false)
+	require.NoError(t, err)
+
+	t.Run("unclaimed groups are removed", func(t *testing.T) {
+		report, err := svc.Sync(ctx, "john", []string{"devs"})
+		require.NoError(t, err)
+		assert.Empty(t, report.Added)
+		assert.EqualValues(t, []string{"ops"}, report.Removed)
+
+		groups, err := repos.GroupRepo.GetUserGroups(john, "john")
+		require.NoError(t, err)
+		names := make([]string, len(groups))
+		for i := range groups {
+			names[i] = groups[i].Name
+		}
+		// manual groups are left alone
+		assert.ElementsMatch(t, []string{"devs", "team"}, names)
+	})
+	t.Run("bindings are removed", func(t *testing.T) {
+		ops, err := repos.GroupRepo.FindByName(ctx, "ops")
+		require.NoError(t, err)
+		resp, err := authz.Can(ctx, &rbac.AccessRequest{
+			Subject:  "john",
+			Resource: schemas.ResourceName(schemas.ResourceGroup, ops.ID),
+			Action:   rbac.Verb_READ,
+		})
+		require.NoError(t, err)
+		assert.False(t, resp.GetOk())
+	})
+	t.Run("changes are recorded", func(t *testing.T) {
+		ops, err := repos.GroupRepo.FindByName(ctx, "ops")
+		require.NoError(t, err)
+		events, err := repos.GroupRepo.GetMemberEvents(ctx, ops.ID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.EqualValues(t, model.GroupMemberRemoved, events[0].Action)
+		assert.EqualValues(t, model.GroupMemberAdded, events[1].Action)
+		assert.EqualValues(t, model.GroupSourceIdP, events[0].Source)
+		assert.Empty(t, events[0].Actor)
+	})
+	t.Run("history is only visible to owners", func(t *testing.T) {
+		_, err := svc.AddMember(john, int(team.ID), "jane", model.GroupRoleMember)
+		require.NoError(t, err)
+
+		_, err = svc.History(withUser(ctx, "jane"), int(team.ID), 0, 10)
+		assert.ErrorIs(t, err, ErrForbidden)
+
+		events, err := svc.History(john, int(team.ID), 0, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.EqualValues(t, "jane", events[0].Subject)
+		assert.EqualValues(t, "john", events[0].Actor)
+	})
+	t.Run("users can't make external groups manual", func(t *testing.T) {
+		_, err := svc.Create(withUser(ctx, "jane"), "devs", false, false)
+		assert.ErrorIs(t, err, ErrAlreadyExists)
+		devs, err := repos.GroupRepo.FindByName(ctx, "devs")
+		require.NoError(t, err)
+		assert.True(t, devs.External)
+	})
+	t.Run("sync all", func(t *testing.T) {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: "bob", Groups: "ops"})
+		require.NoError(t, err)
+
+		report, err := svc.SyncAll(ctx)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, report.Users)
+		// john has no groups stored, so loses devs
+		assert.ElementsMatch(t, []string{"devs"}, report.Removed)
+		assert.ElementsMatch(t, []string{"ops"}, report.Added)
+	})
+	t.Run("unclaimed groups keep an owner", func(t *testing.T) {
+		_, err := svc.Sync(ctx, "john", []string{"infra"})
+		require.NoError(t, err)
+		_, err = svc.Sync(ctx, "jane", []string{"infra"})
+		require.NoError(t, err)
+		infra, err := repos.GroupRepo.FindByName(ctx, "infra")
+		require.NoError(t, err)
+		require.EqualValues(t, "john", infra.Owner)
+
+		// the next member is promoted
+		_, err = svc.Sync(ctx, "john", nil)
+		require.NoError(t, err)
+		infra, err = repos.GroupRepo.GetByID(ctx, infra.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "jane", infra.Owner)
+		member, err := repos.GroupRepo.GetMember(ctx, infra.ID, "jane")
+		require.NoError(t, err)
+		assert.EqualValues(t, model.GroupRoleOwner, member.Role)
+
+		// and the group is archived once nobody is left
+		_, err = svc.Sync(ctx, "jane", nil)
+		require.NoError(t, err)
+		infra, err = repos.GroupRepo.GetByID(ctx, infra.ID)
+		require.NoError(t, err)
+		assert.True(t, infra.Archived)
+	})
+}
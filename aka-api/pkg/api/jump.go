@@ -38,7 +38,7 @@ type JumpAPI struct {
 
 func NewJumpAPI(ctx context.Context, repos *dao.Repos, auth *client.Client, allowPublicJumpCreation bool, authz rbac.AuthorityClient, router *mux.Router) *JumpAPI {
 	api := new(JumpAPI)
-	api.svc = NewJumpService(ctx, repos, authz, allowPublicJumpCreation, nil)
+	api.svc = NewJumpService(ctx, repos, authz, allowPublicJumpCreation, nil, nil)
 
 	router.HandleFunc("/v3/jump", auth.WithOptionalUserFunc(func(w http.ResponseWriter, r *http.Request) {
 		withPagedData(w, r, api.List)
@@ -25,10 +25,12 @@ import (
 	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"maps"
 	"strings"
 	"time"
 )
@@ -38,6 +40,7 @@ type JumpService struct {
 	repos                   *dao.Repos
 	authz                   rbac.AuthorityClient
 	allowPublicJumpCreation bool
+	urlPolicy               *policy.Policy
 }
 
 type CreateJumpOpts struct {
@@ -54,12 +57,13 @@ type UpdateJumpOpts struct {
 	Alias    []string
 }
 
-func NewJumpService(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, allowPublicJumpCreation bool, listener chan *dao.Message) *JumpService {
+func NewJumpService(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, allowPublicJumpCreation bool, urlPolicy *policy.Policy, feed dao.ChangeFeed) *JumpService {
 	return &JumpService{
 		repos:                   repos,
 		authz:                   authz,
 		allowPublicJumpCreation: allowPublicJumpCreation,
-		ListeningService:        NewListeningService(ctx, listener),
+		urlPolicy:               urlPolicy,
+		ListeningService:        NewListeningService(ctx, feed, model.TableNameJumps),
 	}
 }
 
@@ -200,6 +204,17 @@ func (svc *JumpService) Create(ctx context.Context, opts CreateJumpOpts) (*model
 	username := GetUsernameCtx(ctx)
 	owner := fmt.Sprintf("user://%s", username)
 	if opts.GID > 0 {
+		// only maintainers can add jumps to a group
+		if err := requireGroupRole(ctx, svc.repos, svc.authz, uint(opts.GID), model.GroupRoleMaintainer); err != nil {
+			return nil, err
+		}
+		group, err := svc.repos.GroupRepo.GetByID(ctx, uint(opts.GID))
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		if group.Archived {
+			return nil, ErrGroupArchived
+		}
 		owner = fmt.Sprintf("group://%d", opts.GID)
 	} else if opts.GID == -1 {
 		// check if normal users are allowed to create public
@@ -215,12 +230,25 @@ func (svc *JumpService) Create(ctx context.Context, opts CreateJumpOpts) (*model
 				return nil, err
 			}
 			if !resp.Ok {
-				return nil, ErrForbidden
+				return nil, ErrProposalRequired
 			}
 		}
 		owner = ""
 	}
+	return svc.create(ctx, owner, username, opts)
+}
+
+// create saves a new Jump and gives the subject
+// that created it full control over it, unless it
+// is owned by a Group.
+func (svc *JumpService) create(ctx context.Context, owner, subject string, opts CreateJumpOpts) (*model.Jump, error) {
+	log := logr.FromContextOrDiscard(ctx)
 	log.V(1).Info("created jump has owner", "Owner", owner)
+	opts.Location = svc.urlPolicy.Normalise(opts.Location)
+	if err := svc.urlPolicy.Check(owner, opts.Location); err != nil {
+		log.Info("rejecting jump destination", "Location", opts.Location, "Reason", err.Error())
+		return nil, err
+	}
 	log.Info("creating new jump")
 	// create the jump
 	jump, err := svc.repos.JumpRepo.Save(ctx, &model.Jump{
@@ -234,9 +262,15 @@ func (svc *JumpService) Create(ctx context.Context, opts CreateJumpOpts) (*model
 	if err != nil {
 		return nil, err
 	}
+	// jumps owned by a group are managed through
+	// the group, so the creator isn't given access
+	// that outlives their membership
+	if _, ok := jump.GroupID(); ok {
+		return jump, nil
+	}
 	// create role bindings
 	if _, err := svc.authz.AddRole(ctx, &rbac.AddRoleRequest{
-		Subject:  username,
+		Subject:  subject,
 		Resource: schemas.ResourceName(schemas.ResourceJump, jump.ID),
 		Action:   rbac.Verb_SUDO,
 	}); err != nil {
@@ -252,25 +286,18 @@ func (svc *JumpService) Update(ctx context.Context, opts UpdateJumpOpts) (*model
 	defer span.End()
 	log.V(1).Info("patching jump")
 
-	resp, err := svc.authz.Can(ctx, &rbac.AccessRequest{
-		Subject:  GetUsernameCtx(ctx),
-		Resource: schemas.ResourceName(schemas.ResourceJump, opts.ID),
-		Action:   rbac.Verb_UPDATE,
-	})
-	if err != nil {
-		return nil, err
-	}
-	if !resp.Ok {
-		return nil, ErrForbidden
-	}
-
 	// get the matching jump
-	existing, err := svc.repos.JumpRepo.GetByID(ctx, uint(opts.ID))
+	existing, err := svc.getEditable(ctx, opts.ID, rbac.Verb_UPDATE)
 	if err != nil {
 		log.Error(err, "cannot update jump")
 		return nil, err
 	}
 	log.V(1).Info("updating jump", "ID", existing.ID)
+	opts.Location = svc.urlPolicy.Normalise(opts.Location)
+	if err := svc.urlPolicy.Check(existing.Owner, opts.Location); err != nil {
+		log.Info("rejecting jump destination", "ID", existing.ID, "Location", opts.Location, "Reason", err.Error())
+		return nil, err
+	}
 	// update mutable fields
 	existing.Name = opts.Name
 	existing.Location = opts.Location
@@ -290,27 +317,103 @@ func (svc *JumpService) Delete(ctx context.Context, id int) (bool, error) {
 	defer span.End()
 	log.Info("deleting jump")
 
+	jump, err := svc.getEditable(ctx, id, rbac.Verb_DELETE)
+	if err != nil {
+		log.Error(err, "cannot delete jump")
+		return false, err
+	}
+	// delete the jump
+	if err = svc.repos.JumpRepo.DeleteByID(ctx, jump.ID); err != nil {
+		log.Error(err, "failed to delete jump")
+		return false, err
+	}
+	return true, nil
+}
+
+// getEditable returns a Jump if the current user is
+// allowed to change it. Jumps owned by a Group can be
+// changed by its maintainers, everything else depends
+// on the user's role bindings.
+func (svc *JumpService) getEditable(ctx context.Context, id int, action rbac.Verb) (*model.Jump, error) {
+	jump, err := svc.repos.JumpRepo.GetByID(ctx, uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if gid, ok := jump.GroupID(); ok {
+		if err := requireGroupRole(ctx, svc.repos, svc.authz, gid, model.GroupRoleMaintainer); err != nil {
+			return nil, err
+		}
+		return jump, nil
+	}
 	resp, err := svc.authz.Can(ctx, &rbac.AccessRequest{
 		Subject:  GetUsernameCtx(ctx),
 		Resource: schemas.ResourceName(schemas.ResourceJump, id),
-		Action:   rbac.Verb_DELETE,
+		Action:   action,
 	})
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if !resp.Ok {
-		return false, ErrForbidden
+		return nil, ErrForbidden
 	}
+	return jump, nil
+}
 
-	jump, err := svc.repos.JumpRepo.GetByID(ctx, uint(id))
+// ChangeSource returns the data behind a subscriber's
+// live view of their Jumps. If a target is given,
+// the view is a search.
+func (svc *JumpService) ChangeSource(offset, limit int, target string) *ChangeSource {
+	var owners map[string]struct{}
+	return &ChangeSource{
+		Page: func(ctx context.Context) (*model.Page, error) {
+			owners = svc.visibleOwners(ctx)
+			if target != "" {
+				return svc.Search(ctx, offset, limit, -1, target)
+			}
+			return svc.List(ctx, offset, limit)
+		},
+		Get: func(ctx context.Context, id uint) (model.Pageable, bool, error) {
+			jump, err := svc.repos.JumpRepo.GetByID(ctx, id)
+			if err != nil {
+				return nil, false, err
+			}
+			_, ok := owners[jump.Owner]
+			return jump, jump.IsPublic() || ok, nil
+		},
+		Relevant: func(msg *dao.Message) bool {
+			// truncated messages don't say who owns
+			// the jump, and public jumps don't have
+			// an owner
+			if msg.Truncated || msg.Owner == "" {
+				return true
+			}
+			_, ok := owners[msg.Owner]
+			return ok
+		},
+		Stale: func(ctx context.Context) (bool, error) {
+			// the visible jumps change when the
+			// user joins or leaves a group
+			return !maps.Equal(owners, svc.visibleOwners(ctx)), nil
+		},
+		ResyncOnChange: target != "",
+	}
+}
+
+// visibleOwners returns the owners whose
+// Jumps the current user can see.
+func (svc *JumpService) visibleOwners(ctx context.Context) map[string]struct{} {
+	log := logr.FromContextOrDiscard(ctx)
+	username := GetUsernameCtx(ctx)
+	owners := map[string]struct{}{
+		fmt.Sprintf("user://%s", username): {},
+	}
+	groups, err := svc.repos.GroupRepo.GetUserGroups(ctx, username)
 	if err != nil {
-		log.Error(err, "cannot determine existence of jump")
-		return false, err
+		log.Error(err, "failed to get user groups, response may be limited")
+		return owners
 	}
-	// delete the jump
-	if err = svc.repos.JumpRepo.DeleteByID(ctx, jump.ID); err != nil {
-		log.Error(err, "failed to delete jump")
-		return false, err
+	for _, g := range groups {
+		owners[fmt.Sprintf("group://%d", g.ID)] = struct{}{}
 	}
-	return true, nil
+	return owners
 }
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"testing"
+)
+
+func withUser(ctx context.Context, sub string) context.Context {
+	return context.WithValue(ctx, identity.UserContextKey, &identity.OAuthUser{Subject: sub})
+}
+
+func TestJumpService(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewJumpService(ctx, repos, authz, false, nil, nil)
+	groups := NewGroupService(ctx, repos, authz, nil)
+
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+
+	team, err := groups.Create(john, "team", false, false)
+	require.NoError(t, err)
+
+	personal, err := svc.Create(john, CreateJumpOpts{Name: "personal", Location: "https://example.org/personal"})
+	require.NoError(t, err)
+	shared, err := svc.Create(john, CreateJumpOpts{GID: int(team.ID), Name: "shared", Location: "https://example.org/shared"})
+	require.NoError(t, err)
+
+	t.Run("public jumps require admin", func(t *testing.T) {
+		_, err := svc.Create(jane, CreateJumpOpts{GID: -1, Name: "public", Location: "https://example.org"})
+		assert.ErrorIs(t, err, ErrForbidden)
+		assert.ErrorIs(t, err, ErrProposalRequired)
+	})
+
+	t.Run("owner sees their jumps", func(t *testing.T) {
+		page, err := svc.List(john, 0, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, page.Count)
+	})
+	t.Run("other users don't", func(t *testing.T) {
+		page, err := svc.List(jane, 0, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 0, page.Count)
+	})
+	t.Run("search", func(t *testing.T) {
+		page, err := svc.Search(john, 0, 10, -1, "sha")
+		require.NoError(t, err)
+		require.Len(t, page.Results, 1)
+		assert.EqualValues(t, shared.ID, page.Results[0].(*model.Jump).ID)
+	})
+	t.Run("only the owner can update", func(t *testing.T) {
+		_, err := svc.Update(jane, UpdateJumpOpts{ID: int(personal.ID), Name: "stolen"})
+		assert.ErrorIs(t, err, ErrForbidden)
+
+		jump, err := svc.Update(john, UpdateJumpOpts{ID: int(personal.ID), Name: "renamed", Location: personal.Location})
+		require.NoError(t, err)
+		assert.EqualValues(t, "renamed", jump.Name)
+	})
+	t.Run("jumping records usage", func(t *testing.T) {
+		jump, err := svc.JumpTo(john, int(personal.ID))
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, jump.Usage)
+
+		freq, err := repos.JumpEventRepo.GetFrequencyByUserID(ctx, "john", 10)
+		require.NoError(t, err)
+		require.Len(t, freq, 1)
+		assert.EqualValues(t, personal.ID, freq[0].JumpID)
+	})
+	t.Run("group roles", func(t *testing.T) {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: "jane"})
+		require.NoError(t, err)
+		_, err = groups.AddMember(john, int(team.ID), "jane", model.GroupRoleMember)
+		require.NoError(t, err)
+
+		// members can only use the group's jumps
+		_, err = svc.Create(jane, CreateJumpOpts{GID: int(team.ID), Name: "mine", Location: "https://example.org/mine"})
+		assert.ErrorIs(t, err, ErrForbidden)
+		_, err = svc.Update(jane, UpdateJumpOpts{ID: int(shared.ID), Name: "stolen", Location: shared.Location})
+		assert.ErrorIs(t, err, ErrForbidden)
+		_, err = svc.Delete(jane, int(shared.ID))
+		assert.ErrorIs(t, err, ErrForbidden)
+
+		// maintainers can change them, even
+		// if they didn't create them
+		_, err = groups.SetRole(john, int(team.ID), "jane", model.GroupRoleMaintainer)
+		require.NoError(t, err)
+		jump, err := svc.Update(jane, UpdateJumpOpts{ID: int(shared.ID), Name: "renamed-shared", Location: shared.Location})
+		require.NoError(t, err)
+		assert.EqualValues(t, "renamed-shared", jump.Name)
+		mine, err := svc.Create(jane, CreateJumpOpts{GID: int(team.ID), Name: "mine", Location: "https://example.org/mine"})
+		require.NoError(t, err)
+		// but don't keep access once they leave
+		assert.NotContains(t, authz.bindings["jane"], schemas.ResourceName(schemas.ResourceJump, mine.ID))
+
+		// people outside the group can't
+		_, err = svc.Create(withUser(ctx, "bob"), CreateJumpOpts{GID: int(team.ID), Name: "bob", Location: "https://example.org/bob"})
+		assert.ErrorIs(t, err, ErrForbidden)
+	})
+	t.Run("delete", func(t *testing.T) {
+		ok, err := svc.Delete(john, int(personal.ID))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.False(t, repos.JumpRepo.ExistsByID(ctx, personal.ID))
+	})
+}
+
+func TestJumpService_URLPolicy(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	urlPolicy, err := policy.New(policy.Options{
+		Schemes: []string{"https"},
+		Rules: policy.Rules{
+			{Name: "personal-docs", Scope: "user://", Pattern: `^https://docs\.example\.org/`},
+		},
+	})
+	require.NoError(t, err)
+	svc := NewJumpService(ctx, repos, authz, false, urlPolicy, nil)
+	john := withUser(ctx, "john")
+
+	var violation *policy.Violation
+	_, err = svc.Create(john, CreateJumpOpts{Name: "xss", Location: "javascript:alert(1)"})
+	require.ErrorAs(t, err, &violation)
+	assert.Equal(t, policy.RuleScheme, violation.Rule)
+
+	_, err = svc.Create(john, CreateJumpOpts{Name: "other", Location: "https://example.org"})
+	require.ErrorAs(t, err, &violation)
+	assert.Equal(t, "personal-docs", violation.Rule)
+
+	jump, err := svc.Create(john, CreateJumpOpts{Name: "docs", Location: "https://docs.example.org/"})
+	require.NoError(t, err)
+
+	_, err = svc.Update(john, UpdateJumpOpts{ID: int(jump.ID), Name: "docs", Location: "http://docs.example.org/"})
+	require.ErrorAs(t, err, &violation)
+	assert.Equal(t, policy.RuleScheme, violation.Rule)
+
+	// the change must not have been saved
+	existing, err := repos.JumpRepo.GetByID(ctx, jump.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://docs.example.org/", existing.Location)
+}
@@ -21,39 +21,86 @@ import (
 	"context"
 	"github.com/go-logr/logr"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
-	"sync"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
-func NewListeningService(ctx context.Context, listener chan *dao.Message) *ListeningService {
+func NewListeningService(ctx context.Context, feed dao.ChangeFeed, table string) *ListeningService {
 	log := logr.FromContextOrDiscard(ctx)
 	return &ListeningService{
-		log:       log,
-		listener:  listener,
-		listeners: map[chan *dao.Message]struct{}{},
-		lisLock:   sync.Mutex{},
+		log:       log.WithValues("Table", table),
+		feed:      feed,
+		table:     table,
+		listeners: map[*Subscription]struct{}{},
 	}
 }
 
-func (svc *ListeningService) AddListener(l chan *dao.Message) {
+// Subscribe registers a new subscriber that receives
+// changes until Unsubscribe is called.
+func (svc *ListeningService) Subscribe() *Subscription {
 	svc.log.V(2).Info("adding new listener")
 	svc.lisLock.Lock()
-	svc.listeners[l] = struct{}{}
+	s := newSubscription(svc.table, svc.opts)
+	svc.listeners[s] = struct{}{}
 	svc.lisLock.Unlock()
+	metricSubscribers.Add(context.Background(), 1, metric.WithAttributes(attribute.String("table", svc.table)))
+	return s
 }
 
-func (svc *ListeningService) RemoveListener(l chan *dao.Message) {
+func (svc *ListeningService) Unsubscribe(s *Subscription) {
 	svc.log.V(2).Info("removing listener")
 	svc.lisLock.Lock()
-	delete(svc.listeners, l)
+	_, ok := svc.listeners[s]
+	delete(svc.listeners, s)
 	svc.lisLock.Unlock()
+	s.close()
+	if ok {
+		metricSubscribers.Add(context.Background(), -1, metric.WithAttributes(attribute.String("table", svc.table)))
+	}
 }
 
-func (svc *ListeningService) Listen() {
-	for {
-		msg := <-svc.listener
-		svc.log.V(2).Info("sending event to listeners", "Count", len(svc.listeners))
-		for k := range svc.listeners {
-			k <- msg
-		}
+// Listen subscribes to the change feed and forwards
+// changes to every subscriber in the background until
+// the feed is closed or the context is cancelled.
+//
+// Changes are never sent while holding the lock and
+// never block, so a slow subscriber can't hold up
+// the others.
+func (svc *ListeningService) Listen(ctx context.Context, opts FanoutOptions) error {
+	svc.lisLock.Lock()
+	svc.opts = opts
+	svc.lisLock.Unlock()
+	if svc.feed == nil {
+		svc.log.Info("no change feed has been configured, listeners will not receive updates")
+		return nil
+	}
+	messages, err := svc.feed.Subscribe(ctx, svc.table)
+	if err != nil {
+		svc.log.Error(err, "failed to subscribe to change feed")
+		return err
 	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					svc.log.V(1).Info("change feed has been closed")
+					return
+				}
+				svc.lisLock.RLock()
+				listeners := make([]*Subscription, 0, len(svc.listeners))
+				for k := range svc.listeners {
+					listeners = append(listeners, k)
+				}
+				svc.lisLock.RUnlock()
+				svc.log.V(2).Info("sending event to listeners", "Count", len(listeners))
+				for _, k := range listeners {
+					k.send(msg)
+				}
+			}
+		}
+	}()
+	return nil
 }
@@ -22,11 +22,40 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
 	"testing"
+	"time"
 )
 
 func TestNewListeningService(t *testing.T) {
 	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
-	svc := NewListeningService(ctx, nil)
+	svc := NewListeningService(ctx, nil, "")
 	assert.NotNil(t, svc)
 }
+
+func TestListeningService_Listen(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed := dao.NewLocalFeed(ctx)
+	repos := memory.NewRepos(feed)
+	svc := NewListeningService(ctx, feed, model.TableNameJumps)
+	require.NoError(t, svc.Listen(ctx, FanoutOptions{}))
+
+	sub := svc.Subscribe()
+	defer svc.Unsubscribe(sub)
+
+	jump, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: "foo"})
+	require.NoError(t, err)
+
+	select {
+	case msg := <-sub.C():
+		assert.EqualValues(t, jump.ID, msg.ID)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	metric2 "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+var (
+	meter                = metric.NewMeterProvider().Meter("aka")
+	metricSubscribers, _ = meter.Int64UpDownCounter(
+		"aka.api.subscription.subscribers",
+		metric2.WithDescription("Measures the number of active subscribers."),
+	)
+	metricDropped, _ = meter.Int64Counter(
+		"aka.api.subscription.dropped.total",
+		metric2.WithDescription("Measures the number of changes that were dropped or coalesced before reaching a subscriber."),
+	)
+	metricWebhookAttempts, _ = meter.Int64Counter(
+		"aka.api.webhook.attempts.total",
+		metric2.WithDescription("Measures the number of attempts to deliver an event to a webhook, by the status of the delivery afterwards."),
+	)
+)
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// JumpProposalService lets users ask for public Jumps
+// to be created when they aren't allowed to create
+// them themselves.
+type JumpProposalService struct {
+	*ListeningService
+	repos *dao.Repos
+	jumps *JumpService
+}
+
+func NewJumpProposalService(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, urlPolicy *policy.Policy, feed dao.ChangeFeed) *JumpProposalService {
+	return &JumpProposalService{
+		repos:            repos,
+		jumps:            NewJumpService(ctx, repos, authz, false, urlPolicy, nil),
+		ListeningService: NewListeningService(ctx, feed, model.TableNameJumpProposals),
+	}
+}
+
+// Propose asks for a public Jump to be created. It
+// is created once an administrator approves it.
+func (svc *JumpProposalService) Propose(ctx context.Context, name, location string, alias []string) (*model.JumpProposal, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_proposal_propose")
+	defer span.End()
+	username := GetUsernameCtx(ctx)
+	if username == "" {
+		return nil, ErrForbidden
+	}
+	// there's no point asking an administrator about
+	// something that they won't be able to approve
+	location = svc.jumps.urlPolicy.Normalise(location)
+	if err := svc.jumps.urlPolicy.Check("", location); err != nil {
+		return nil, err
+	}
+	p := &model.JumpProposal{
+		Name:      name,
+		Location:  location,
+		Alias:     alias,
+		Submitter: username,
+		Status:    model.ProposalStatusProposed,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := svc.repos.ProposalRepo.Create(ctx, p); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	logr.FromContextOrDiscard(ctx).Info("proposed public jump", "ID", p.ID, "Name", name, "Submitter", username)
+	return p, nil
+}
+
+// Pending returns the JumpProposals that are
+// waiting to be reviewed, oldest first.
+func (svc *JumpProposalService) Pending(ctx context.Context, offset, limit int) ([]*model.JumpProposal, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_proposal_pending")
+	defer span.End()
+	return svc.repos.ProposalRepo.GetByStatus(ctx, model.ProposalStatusProposed, offset, limit)
+}
+
+// List returns the JumpProposals made by
+// the current user, newest first.
+func (svc *JumpProposalService) List(ctx context.Context) ([]*model.JumpProposal, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_proposal_list")
+	defer span.End()
+	username := GetUsernameCtx(ctx)
+	if username == "" {
+		return nil, ErrForbidden
+	}
+	return svc.repos.ProposalRepo.GetBySubmitter(ctx, username)
+}
+
+// Approve creates the public Jump that was proposed.
+// Nobody is given a role binding on it, so only
+// administrators can change it afterwards.
+func (svc *JumpProposalService) Approve(ctx context.Context, id uint) (*model.JumpProposal, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_proposal_approve", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	p, err := svc.getPending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("approving jump proposal", "Submitter", p.Submitter)
+	// the policy may have changed since
+	// the proposal was made
+	location := svc.jumps.urlPolicy.Normalise(p.Location)
+	if err := svc.jumps.urlPolicy.Check("", location); err != nil {
+		log.Info("rejecting jump destination", "Location", location, "Reason", err.Error())
+		return nil, err
+	}
+	jump := &model.Jump{
+		Name:     p.Name,
+		Location: location,
+		Alias:    p.Alias,
+	}
+	svc.review(ctx, p, model.ProposalStatusApproved, "")
+	if err := svc.repos.ProposalRepo.Approve(ctx, p, jump); err != nil {
+		span.RecordError(err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAlreadyReviewed
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reject turns down a JumpProposal. The reason
+// is shown to the submitter.
+func (svc *JumpProposalService) Reject(ctx context.Context, id uint, reason string) (*model.JumpProposal, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_proposal_reject", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrReasonRequired
+	}
+	p, err := svc.getPending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	logr.FromContextOrDiscard(ctx).Info("rejecting jump proposal", "ID", id, "Submitter", p.Submitter)
+	svc.review(ctx, p, model.ProposalStatusRejected, reason)
+	if err := svc.repos.ProposalRepo.Save(ctx, p); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reviewed returns the JumpProposal that a message is
+// about if it belongs to the current user and has just
+// been reviewed. Otherwise, it returns nil.
+func (svc *JumpProposalService) Reviewed(ctx context.Context, msg *dao.Message) (*model.JumpProposal, error) {
+	if msg.Operation != "UPDATE" {
+		return nil, nil
+	}
+	username := GetUsernameCtx(ctx)
+	if !msg.Truncated && msg.Owner != username {
+		return nil, nil
+	}
+	p, err := svc.repos.ProposalRepo.Get(ctx, uint(msg.ID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if p.Submitter != username || p.Status == model.ProposalStatusProposed {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// getPending returns a JumpProposal that
+// hasn't been reviewed yet.
+func (svc *JumpProposalService) getPending(ctx context.Context, id uint) (*model.JumpProposal, error) {
+	p, err := svc.repos.ProposalRepo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if p.Status != model.ProposalStatusProposed {
+		return nil, ErrAlreadyReviewed
+	}
+	return p, nil
+}
+
+// review records the outcome of reviewing
+// a JumpProposal, but doesn't save it.
+func (svc *JumpProposalService) review(ctx context.Context, p *model.JumpProposal, status model.ProposalStatus, reason string) {
+	p.Status = status
+	p.Reason = reason
+	p.ReviewedBy = GetUsernameCtx(ctx)
+	p.ReviewedAt = time.Now().Unix()
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJumpProposalService(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed := dao.NewLocalFeed(ctx)
+	repos := memory.NewRepos(feed)
+	authz := newFakeAuthz()
+	svc := NewJumpProposalService(ctx, repos, authz, nil, feed)
+	require.NoError(t, svc.Listen(ctx, FanoutOptions{}))
+
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+	admin := withUser(ctx, "admin")
+
+	sub := svc.Subscribe()
+	defer svc.Unsubscribe(sub)
+
+	// reviewed waits for the subscriber to be
+	// told that one of john's proposals was reviewed
+	reviewed := func(t *testing.T) *model.JumpProposal {
+		for {
+			select {
+			case msg := <-sub.C():
+				p, err := svc.Reviewed(john, msg)
+				require.NoError(t, err)
+				if p != nil {
+					return p
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for review")
+				return nil
+			}
+		}
+	}
+
+	first, err := svc.Propose(john, "docs", "https://example.org/docs", []string{"d"})
+	require.NoError(t, err)
+	second, err := svc.Propose(john, "spam", "https://example.org/spam", nil)
+	require.NoError(t, err)
+
+	t.Run("anonymous users can't propose", func(t *testing.T) {
+		_, err := svc.Propose(ctx, "anon", "https://example.org", nil)
+		assert.ErrorIs(t, err, ErrForbidden)
+	})
+	t.Run("pending proposals are oldest first", func(t *testing.T) {
+		pending, err := svc.Pending(admin, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, pending, 2)
+		assert.EqualValues(t, first.ID, pending[0].ID)
+		assert.EqualValues(t, second.ID, pending[1].ID)
+	})
+	t.Run("users only see their own proposals", func(t *testing.T) {
+		mine, err := svc.List(jane)
+		require.NoError(t, err)
+		assert.Empty(t, mine)
+
+		mine, err = svc.List(john)
+		require.NoError(t, err)
+		assert.Len(t, mine, 2)
+	})
+	t.Run("approving creates the jump", func(t *testing.T) {
+		p, err := svc.Approve(admin, first.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.ProposalStatusApproved, p.Status)
+		assert.Equal(t, "admin", p.ReviewedBy)
+		require.NotZero(t, p.JumpID)
+
+		jump, err := repos.JumpRepo.GetByID(ctx, p.JumpID)
+		require.NoError(t, err)
+		assert.Equal(t, "docs", jump.Name)
+		assert.Empty(t, jump.Owner)
+
+		// the submitter can't change it without a review
+		ok, err := authz.Can(ctx, &rbac.AccessRequest{Subject: "john", Resource: schemas.ResourceName(schemas.ResourceJump, jump.ID), Action: rbac.Verb_UPDATE})
+		require.NoError(t, err)
+		assert.False(t, ok.GetOk())
+
+		got := reviewed(t)
+		assert.EqualValues(t, first.ID, got.ID)
+	})
+	t.Run("proposals can only be reviewed once", func(t *testing.T) {
+		_, err := svc.Approve(admin, first.ID)
+		assert.ErrorIs(t, err, ErrAlreadyReviewed)
+		_, err = svc.Reject(admin, first.ID, "too late")
+		assert.ErrorIs(t, err, ErrAlreadyReviewed)
+	})
+	t.Run("rejecting requires a reason", func(t *testing.T) {
+		_, err := svc.Reject(admin, second.ID, " ")
+		assert.ErrorIs(t, err, ErrReasonRequired)
+	})
+	t.Run("rejecting notifies the submitter", func(t *testing.T) {
+		p, err := svc.Reject(admin, second.ID, "not useful")
+		require.NoError(t, err)
+		assert.Equal(t, model.ProposalStatusRejected, p.Status)
+
+		got := reviewed(t)
+		assert.EqualValues(t, second.ID, got.ID)
+		assert.Equal(t, "not useful", got.Reason)
+
+		pending, err := svc.Pending(admin, 0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+	t.Run("other users aren't notified", func(t *testing.T) {
+		msg := &dao.Message{Operation: "UPDATE", ID: int(second.ID), Owner: "john"}
+		p, err := svc.Reviewed(jane, msg)
+		require.NoError(t, err)
+		assert.Nil(t, p)
+	})
+	t.Run("unknown proposals", func(t *testing.T) {
+		_, err := svc.Approve(admin, 999)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("concurrent approvals create one jump", func(t *testing.T) {
+		p, err := svc.Propose(john, "wiki", "https://example.org/wiki", nil)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		var approved atomic.Int32
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := svc.Approve(admin, p.ID); err == nil {
+					approved.Add(1)
+				} else {
+					assert.ErrorIs(t, err, ErrAlreadyReviewed)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, approved.Load())
+
+		jumps, err := repos.JumpRepo.GetByOwner(ctx, "")
+		require.NoError(t, err)
+		var n int
+		for _, j := range jumps {
+			if j.Name == "wiki" {
+				n++
+			}
+		}
+		assert.Equal(t, 1, n)
+	})
+}
@@ -0,0 +1,894 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc7644
+
+const (
+	SCIMSchemaUser            = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup           = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp         = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError           = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMSchemaServiceProvider = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	scimContentType = "application/scim+json"
+	scimMaxResults  = 100
+)
+
+var (
+	// scimFilter matches the only kind of filter that
+	// we support, e.g. userName eq "john"
+	scimFilter = regexp.MustCompile(`(?i)^\s*([a-z.]+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+	// scimMemberPath matches a path that selects a
+	// single member, e.g. members[value eq "1"]
+	scimMemberPath = regexp.MustCompile(`(?i)^members\[value\s+eq\s+"([^"]*)"]$`)
+	// scimEmailPath matches a path that selects the
+	// value of an email, e.g. emails[type eq "work"].value
+	scimEmailPath = regexp.MustCompile(`(?i)^emails\[[^]]*]\.value$`)
+)
+
+// SCIMError is returned to the identity provider
+// when a request fails.
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMUser is a User as seen by the identity
+// provider. The userName is the subject that
+// the user logs in with.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	UserName    string      `json:"userName"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []SCIMEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Meta        *SCIMMeta   `json:"meta,omitempty"`
+}
+
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+type SCIMPatchOp struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// SCIMAPI lets the identity provider provision Users
+// and Groups using SCIM 2.0. Every request must
+// present the shared bearer token.
+type SCIMAPI struct {
+	svc   *SCIMService
+	audit *AuditService
+	token string
+}
+
+func NewSCIMAPI(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, token string, router *mux.Router) *SCIMAPI {
+	api := &SCIMAPI{
+		svc:   NewSCIMService(ctx, repos, authz),
+		audit: NewAuditService(repos),
+		token: token,
+	}
+
+	sr := router.PathPrefix("/scim/v2").Subrouter()
+	sr.Use(api.authenticate, api.recordChanges)
+	sr.HandleFunc("/ServiceProviderConfig", api.ServiceProviderConfig).Methods(http.MethodGet)
+	sr.HandleFunc("/Users", api.ListUsers).Methods(http.MethodGet)
+	sr.HandleFunc("/Users", api.CreateUser).Methods(http.MethodPost)
+	sr.HandleFunc("/Users/{id}", api.GetUser).Methods(http.MethodGet)
+	sr.HandleFunc("/Users/{id}", api.ReplaceUser).Methods(http.MethodPut)
+	sr.HandleFunc("/Users/{id}", api.PatchUser).Methods(http.MethodPatch)
+	sr.HandleFunc("/Users/{id}", api.DeleteUser).Methods(http.MethodDelete)
+	sr.HandleFunc("/Groups", api.ListGroups).Methods(http.MethodGet)
+	sr.HandleFunc("/Groups", api.CreateGroup).Methods(http.MethodPost)
+	sr.HandleFunc("/Groups/{id}", api.GetGroup).Methods(http.MethodGet)
+	sr.HandleFunc("/Groups/{id}", api.ReplaceGroup).Methods(http.MethodPut)
+	sr.HandleFunc("/Groups/{id}", api.PatchGroup).Methods(http.MethodPatch)
+	sr.HandleFunc("/Groups/{id}", api.DeleteGroup).Methods(http.MethodDelete)
+
+	return api
+}
+
+// authenticate rejects requests that don't
+// have the correct bearer token.
+func (api *SCIMAPI) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+			logr.FromContextOrDiscard(r.Context()).Info("rejecting SCIM request with missing or incorrect token")
+			scimError(w, http.StatusUnauthorized, "", "a valid bearer token is required")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// scimActions names the AuditEvent that is
+// recorded for each kind of write.
+var scimActions = map[string]string{
+	http.MethodPost:   "create",
+	http.MethodPut:    "replace",
+	http.MethodPatch:  "patch",
+	http.MethodDelete: "delete",
+}
+
+// recordChanges adds successful writes to the audit log.
+func (api *SCIMAPI) recordChanges(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verb, ok := scimActions[r.Method]
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		kind := "Group"
+		if strings.Contains(r.URL.Path, "/Users") {
+			kind = "User"
+		}
+		resource := api.resource(r, kind)
+		var before string
+		if resource != "" {
+			before = api.audit.Snapshot(ctx, resource)
+		}
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		if rec.status >= http.StatusBadRequest {
+			return
+		}
+		// we only find out what was created
+		// once it has been
+		if resource == "" {
+			var created struct {
+				ID       string `json:"id"`
+				UserName string `json:"userName"`
+			}
+			if err := json.Unmarshal(rec.body.Bytes(), &created); err == nil {
+				if kind == "User" {
+					resource = "user://" + created.UserName
+				} else {
+					resource = schemas.ResourceName(schemas.ResourceGroup, created.ID)
+				}
+			}
+		}
+		if err := api.audit.Record(ctx, &model.AuditEvent{
+			Actor:    ActorSCIM,
+			Action:   "scim." + verb + kind,
+			Resource: resource,
+			Before:   before,
+			After:    api.audit.Snapshot(ctx, resource),
+		}); err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "failed to record audit event")
+		}
+	})
+}
+
+// resource returns the name of the User or Group that
+// a request is about, or an empty string if it isn't
+// about one in particular.
+func (api *SCIMAPI) resource(r *http.Request, kind string) string {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return ""
+	}
+	if kind == "Group" {
+		return schemas.ResourceName(schemas.ResourceGroup, id)
+	}
+	user, err := api.svc.GetUser(r.Context(), uint(id))
+	if err != nil {
+		return ""
+	}
+	return "user://" + user.Subject
+}
+
+// recordingWriter keeps a copy of the response
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// ServiceProviderConfig tells the identity provider
+// which parts of SCIM we support.
+func (api *SCIMAPI) ServiceProviderConfig(w http.ResponseWriter, _ *http.Request) {
+	supported := func(ok bool) map[string]bool {
+		return map[string]bool{"supported": ok}
+	}
+	scimJSON(w, http.StatusOK, map[string]any{
+		"schemas":        []string{SCIMSchemaServiceProvider},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]any{
+			{"type": "oauthbearertoken", "name": "Bearer Token", "description": "Authentication using a shared bearer token"},
+		},
+	})
+}
+
+func (api *SCIMAPI) ListUsers(w http.ResponseWriter, r *http.Request) {
+	startIndex, count, ok := scimPage(w, r)
+	if !ok {
+		return
+	}
+	subject, ok := scimFilterValue(w, r, "userName")
+	if !ok {
+		return
+	}
+	users, total, err := api.svc.ListUsers(r.Context(), subject, startIndex-1, count)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	resources := make([]any, len(users))
+	for i := range users {
+		resources[i] = toSCIMUser(users[i])
+	}
+	scimJSON(w, http.StatusOK, scimList(resources, total, startIndex))
+}
+
+func (api *SCIMAPI) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	user, err := api.svc.GetUser(r.Context(), id)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	scimJSON(w, http.StatusOK, toSCIMUser(user))
+}
+
+func (api *SCIMAPI) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req SCIMUser
+	if !scimDecode(w, r, &req) {
+		return
+	}
+	if req.UserName == "" {
+		scimError(w, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+	user := &dao.UserV2{Subject: req.UserName}
+	applySCIMUser(user, &req)
+	if req.Active != nil {
+		user.Deactivated = !*req.Active
+	}
+	user, err := api.svc.CreateUser(r.Context(), user)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	scimJSON(w, http.StatusCreated, toSCIMUser(user))
+}
+
+func (api *SCIMAPI) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	var req SCIMUser
+	if !scimDecode(w, r, &req) {
+		return
+	}
+	user, err := api.svc.GetUser(r.Context(), id)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	if req.UserName != "" {
+		user.Subject = req.UserName
+	}
+	user.Username = ""
+	user.Email = ""
+	applySCIMUser(user, &req)
+	if _, err := api.svc.UpdateUser(r.Context(), user); err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	api.writeUser(w, r, id, req.Active)
+}
+
+func (api *SCIMAPI) PatchUser(w http.ResponseWriter, r *http.Request) {
+	log := logr.FromContextOrDiscard(r.Context())
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	var req SCIMPatchOp
+	if !scimDecode(w, r, &req) {
+		return
+	}
+	user, err := api.svc.GetUser(r.Context(), id)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	var active *bool
+	for _, op := range req.Operations {
+		var patch SCIMUser
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			// a path means that the value is a single
+			// attribute rather than part of a User
+			value := op.Value
+			if scimEmailPath.MatchString(op.Path) {
+				var email string
+				if err := json.Unmarshal(op.Value, &email); err != nil {
+					scimError(w, http.StatusBadRequest, "invalidValue", err.Error())
+					return
+				}
+				user.Email = email
+				continue
+			}
+			if op.Path != "" {
+				value, err = json.Marshal(map[string]json.RawMessage{scimAttribute(op.Path): op.Value})
+				if err != nil {
+					scimError(w, http.StatusBadRequest, "invalidValue", err.Error())
+					return
+				}
+			}
+			if err := json.Unmarshal(value, &patch); err != nil {
+				scimError(w, http.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+			if patch.UserName != "" {
+				user.Subject = patch.UserName
+			}
+			if patch.Active != nil {
+				active = patch.Active
+			}
+			applySCIMUser(user, &patch)
+		case "remove":
+			switch scimAttribute(op.Path) {
+			case "displayName":
+				user.Username = ""
+			case "emails":
+				user.Email = ""
+			default:
+				log.V(1).Info("ignoring removal of unsupported attribute", "Path", op.Path)
+			}
+		default:
+			scimError(w, http.StatusBadRequest, "invalidSyntax", fmt.Sprintf("unsupported operation: %s", op.Op))
+			return
+		}
+	}
+	if _, err := api.svc.UpdateUser(r.Context(), user); err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	api.writeUser(w, r, id, active)
+}
+
+// writeUser applies any change to whether a User is
+// active and then returns them. This happens after
+// their attributes have been saved so that it isn't
+// overwritten.
+func (api *SCIMAPI) writeUser(w http.ResponseWriter, r *http.Request, id uint, active *bool) {
+	var user *dao.UserV2
+	var err error
+	if active != nil {
+		user, err = api.svc.SetActive(r.Context(), id, *active)
+	} else {
+		user, err = api.svc.GetUser(r.Context(), id)
+	}
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	scimJSON(w, http.StatusOK, toSCIMUser(user))
+}
+
+func (api *SCIMAPI) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	if err := api.svc.DeleteUser(r.Context(), id); err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *SCIMAPI) ListGroups(w http.ResponseWriter, r *http.Request) {
+	startIndex, count, ok := scimPage(w, r)
+	if !ok {
+		return
+	}
+	name, ok := scimFilterValue(w, r, "displayName")
+	if !ok {
+		return
+	}
+	groups, total, err := api.svc.ListGroups(r.Context(), name, startIndex-1, count)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	// identity providers usually ask us not to
+	// send the members when listing
+	withMembers := !strings.Contains(r.URL.Query().Get("excludedAttributes"), "members")
+	resources := make([]any, len(groups))
+	for i := range groups {
+		group, err := api.toSCIMGroup(r.Context(), groups[i], withMembers)
+		if err != nil {
+			scimServiceError(w, err)
+			return
+		}
+		resources[i] = group
+	}
+	scimJSON(w, http.StatusOK, scimList(resources, total, startIndex))
+}
+
+func (api *SCIMAPI) GetGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	group, err := api.svc.GetGroup(r.Context(), id)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	api.writeGroup(w, r, http.StatusOK, group)
+}
+
+func (api *SCIMAPI) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req SCIMGroup
+	if !scimDecode(w, r, &req) {
+		return
+	}
+	if req.DisplayName == "" {
+		scimError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+	members, ok := scimMemberIDs(w, req.Members)
+	if !ok {
+		return
+	}
+	group, err := api.svc.CreateGroup(r.Context(), req.DisplayName, members)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	api.writeGroup(w, r, http.StatusCreated, group)
+}
+
+func (api *SCIMAPI) ReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	var req SCIMGroup
+	if !scimDecode(w, r, &req) {
+		return
+	}
+	members, ok := scimMemberIDs(w, req.Members)
+	if !ok {
+		return
+	}
+	if req.DisplayName != "" {
+		if _, err := api.svc.RenameGroup(r.Context(), id, req.DisplayName); err != nil {
+			scimServiceError(w, err)
+			return
+		}
+	}
+	if err := api.svc.ReplaceGroupMembers(r.Context(), id, members); err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	api.GetGroup(w, r)
+}
+
+func (api *SCIMAPI) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	log := logr.FromContextOrDiscard(r.Context())
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	var req SCIMPatchOp
+	if !scimDecode(w, r, &req) {
+		return
+	}
+	if _, err := api.svc.GetGroup(r.Context(), id); err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	for _, op := range req.Operations {
+		if err := api.patchGroup(r.Context(), id, op); err != nil {
+			log.Error(err, "failed to apply patch operation", "Op", op.Op, "Path", op.Path)
+			scimServiceError(w, err)
+			return
+		}
+	}
+	if len(req.Operations) == 0 {
+		log.V(1).Info("received patch without any operations")
+	}
+	api.GetGroup(w, r)
+}
+
+// patchGroup applies a single patch operation
+// to a Group.
+func (api *SCIMAPI) patchGroup(ctx context.Context, id uint, op SCIMPatchOperation) error {
+	path := scimAttribute(op.Path)
+	// a remove that selects a single member,
+	// e.g. members[value eq "1"]
+	if m := scimMemberPath.FindStringSubmatch(op.Path); m != nil {
+		if !strings.EqualFold(op.Op, "remove") {
+			return errSCIMInvalidPath
+		}
+		uid, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return ErrNotFound
+		}
+		return api.svc.RemoveGroupMembers(ctx, id, []uint{uint(uid)})
+	}
+	// without a path, the value is part of a Group
+	if path == "" {
+		var patch SCIMGroup
+		if err := json.Unmarshal(op.Value, &patch); err != nil {
+			return errSCIMInvalidValue
+		}
+		if patch.DisplayName != "" {
+			if _, err := api.svc.RenameGroup(ctx, id, patch.DisplayName); err != nil {
+				return err
+			}
+		}
+		if patch.Members == nil {
+			return nil
+		}
+		raw, _ := json.Marshal(patch.Members)
+		op.Value = raw
+		path = "members"
+	}
+	switch path {
+	case "displayName":
+		var name string
+		if err := json.Unmarshal(op.Value, &name); err != nil || name == "" {
+			return errSCIMInvalidValue
+		}
+		_, err := api.svc.RenameGroup(ctx, id, name)
+		return err
+	case "members":
+		var members []SCIMMember
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &members); err != nil {
+				return errSCIMInvalidValue
+			}
+		}
+		ids, err := parseSCIMMembers(members)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(op.Op) {
+		case "add":
+			return api.svc.AddGroupMembers(ctx, id, ids)
+		case "replace":
+			return api.svc.ReplaceGroupMembers(ctx, id, ids)
+		case "remove":
+			// removing the attribute without
+			// a value removes everybody
+			if len(op.Value) == 0 {
+				return api.svc.ReplaceGroupMembers(ctx, id, nil)
+			}
+			return api.svc.RemoveGroupMembers(ctx, id, ids)
+		}
+	}
+	return errSCIMInvalidPath
+}
+
+func (api *SCIMAPI) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+	if err := api.svc.DeleteGroup(r.Context(), id); err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *SCIMAPI) writeGroup(w http.ResponseWriter, r *http.Request, code int, group *model.Group) {
+	resp, err := api.toSCIMGroup(r.Context(), group, true)
+	if err != nil {
+		scimServiceError(w, err)
+		return
+	}
+	scimJSON(w, code, resp)
+}
+
+func (api *SCIMAPI) toSCIMGroup(ctx context.Context, group *model.Group, withMembers bool) (*SCIMGroup, error) {
+	id := strconv.FormatUint(uint64(group.ID), 10)
+	resp := &SCIMGroup{
+		Schemas:     []string{SCIMSchemaGroup},
+		ID:          id,
+		DisplayName: group.Name,
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     "/scim/v2/Groups/" + id,
+		},
+	}
+	if !withMembers {
+		return resp, nil
+	}
+	users, err := api.svc.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		uid := strconv.FormatUint(uint64(u.ID), 10)
+		resp.Members = append(resp.Members, SCIMMember{
+			Value:   uid,
+			Display: u.Subject,
+			Ref:     "/scim/v2/Users/" + uid,
+		})
+	}
+	return resp, nil
+}
+
+func toSCIMUser(user *dao.UserV2) *SCIMUser {
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	active := !user.Deactivated
+	resp := &SCIMUser{
+		Schemas:     []string{SCIMSchemaUser},
+		ID:          id,
+		UserName:    user.Subject,
+		DisplayName: user.Username,
+		Active:      &active,
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     "/scim/v2/Users/" + id,
+		},
+	}
+	if user.Email != "" {
+		resp.Emails = []SCIMEmail{{Value: user.Email, Primary: true}}
+	}
+	return resp
+}
+
+// applySCIMUser copies the attributes that we
+// store from a SCIMUser.
+func applySCIMUser(user *dao.UserV2, req *SCIMUser) {
+	if req.DisplayName != "" {
+		user.Username = req.DisplayName
+	}
+	for i, e := range req.Emails {
+		if e.Primary || i == 0 {
+			user.Email = e.Value
+		}
+		if e.Primary {
+			break
+		}
+	}
+}
+
+var (
+	errSCIMInvalidPath  = &scimErr{status: http.StatusBadRequest, scimType: "invalidPath", detail: "unsupported path"}
+	errSCIMInvalidValue = &scimErr{status: http.StatusBadRequest, scimType: "invalidValue", detail: "invalid value"}
+)
+
+// scimErr is an error that maps directly
+// to a SCIM error response.
+type scimErr struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimErr) Error() string {
+	return e.detail
+}
+
+// scimServiceError converts an error from the
+// SCIMService into a SCIM error response.
+func scimServiceError(w http.ResponseWriter, err error) {
+	var se *scimErr
+	switch {
+	case errors.As(err, &se):
+		scimError(w, se.status, se.scimType, se.detail)
+	case errors.Is(err, ErrNotFound):
+		scimError(w, http.StatusNotFound, "", err.Error())
+	case errors.Is(err, ErrAlreadyExists):
+		scimError(w, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, ErrImmutable):
+		scimError(w, http.StatusBadRequest, "mutability", err.Error())
+	case errors.Is(err, ErrLastOwner):
+		scimError(w, http.StatusBadRequest, "invalidValue", err.Error())
+	default:
+		scimError(w, http.StatusInternalServerError, "", err.Error())
+	}
+}
+
+func scimError(w http.ResponseWriter, code int, scimType, detail string) {
+	scimJSON(w, code, &SCIMError{
+		Schemas:  []string{SCIMSchemaError},
+		Status:   strconv.Itoa(code),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+func scimJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func scimDecode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return false
+	}
+	return true
+}
+
+func scimList(resources []any, total, startIndex int) *SCIMListResponse {
+	return &SCIMListResponse{
+		Schemas:      []string{SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func scimID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", ErrNotFound.Error())
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// scimPage reads the 1-based startIndex and count
+// query parameters.
+func scimPage(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	startIndex, count := 1, scimMaxResults
+	if v := r.URL.Query().Get("startIndex"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			scimError(w, http.StatusBadRequest, "invalidValue", "startIndex must be a number")
+			return 0, 0, false
+		}
+		startIndex = max(i, 1)
+	}
+	if v := r.URL.Query().Get("count"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			scimError(w, http.StatusBadRequest, "invalidValue", "count must be a number")
+			return 0, 0, false
+		}
+		count = min(max(i, 0), scimMaxResults)
+	}
+	return startIndex, count, true
+}
+
+// scimFilterValue returns the value of an equality
+// filter on the given attribute, or an empty string
+// if there isn't a filter.
+func scimFilterValue(w http.ResponseWriter, r *http.Request, attribute string) (string, bool) {
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		return "", true
+	}
+	m := scimFilter.FindStringSubmatch(filter)
+	if m == nil || !strings.EqualFold(m[1], attribute) {
+		scimError(w, http.StatusBadRequest, "invalidFilter", fmt.Sprintf("only '%s eq \"value\"' filters are supported", attribute))
+		return "", false
+	}
+	value, err := strconv.Unquote(`"` + m[2] + `"`)
+	if err != nil {
+		scimError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return "", false
+	}
+	return value, true
+}
+
+func scimMemberIDs(w http.ResponseWriter, members []SCIMMember) ([]uint, bool) {
+	ids, err := parseSCIMMembers(members)
+	if err != nil {
+		scimServiceError(w, err)
+		return nil, false
+	}
+	return ids, true
+}
+
+// parseSCIMMembers returns the User IDs of
+// the given members.
+func parseSCIMMembers(members []SCIMMember) ([]uint, error) {
+	ids := make([]uint, len(members))
+	for i, m := range members {
+		id, err := strconv.ParseUint(m.Value, 10, 64)
+		if err != nil {
+			return nil, errSCIMInvalidValue
+		}
+		ids[i] = uint(id)
+	}
+	return ids, nil
+}
+
+// scimAttribute strips the schema from a fully
+// qualified attribute path.
+func scimAttribute(path string) string {
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		path = path[i+1:]
+	}
+	return path
+}
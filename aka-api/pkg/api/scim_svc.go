@@ -0,0 +1,427 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"slices"
+)
+
+// SCIMService provisions Users and Groups on behalf
+// of the identity provider. There is no user in the
+// context, so nothing here checks permissions.
+type SCIMService struct {
+	repos  *dao.Repos
+	users  *UserService
+	groups *GroupService
+}
+
+func NewSCIMService(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient) *SCIMService {
+	return &SCIMService{
+		repos:  repos,
+		users:  NewUserService(ctx, repos, authz, nil),
+		groups: NewGroupService(ctx, repos, authz, nil),
+	}
+}
+
+// ListUsers returns a page of Users, or the User with
+// the given subject if it isn't empty.
+func (svc *SCIMService) ListUsers(ctx context.Context, subject string, offset, limit int) ([]*dao.UserV2, int, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_scim_listUsers")
+	defer span.End()
+	if subject != "" {
+		user, err := svc.repos.UserRepo.Get(ctx, subject)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, 0, nil
+			}
+			return nil, 0, err
+		}
+		return []*dao.UserV2{user}, 1, nil
+	}
+	var users []*dao.UserV2
+	var count int
+	err := svc.repos.UserRepo.FindInBatches(ctx, 100, func(batch []*dao.UserV2) error {
+		for _, u := range batch {
+			if count >= offset && (limit < 0 || len(users) < limit) {
+				users = append(users, u)
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, 0, err
+	}
+	return users, count, nil
+}
+
+// GetUser returns a User by their primaryKey (ID)
+func (svc *SCIMService) GetUser(ctx context.Context, id uint) (*dao.UserV2, error) {
+	user, err := svc.repos.UserRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser provisions a new User. Their groups are
+// filled in when they log in. Deactivated Users (e.g.
+// those that were deleted) are provisioned again.
+func (svc *SCIMService) CreateUser(ctx context.Context, user *dao.UserV2) (*dao.UserV2, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Subject", user.Subject)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_scim_createUser", trace.WithAttributes(attribute.String("subject", user.Subject)))
+	defer span.End()
+	existing, err := svc.repos.UserRepo.Get(ctx, user.Subject)
+	switch {
+	case err == nil && !existing.Deactivated:
+		return nil, ErrAlreadyExists
+	case err == nil:
+		log.Info("provisioning deactivated user again", "ID", existing.ID)
+		user.Model = existing.Model
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	default:
+		log.Info("provisioning user")
+	}
+	return svc.repos.UserRepo.Save(ctx, user)
+}
+
+// UpdateUser saves changes to a User. The subject of
+// a User can't be changed since it is used to refer
+// to them everywhere else.
+func (svc *SCIMService) UpdateUser(ctx context.Context, user *dao.UserV2) (*dao.UserV2, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_scim_updateUser", trace.WithAttributes(attribute.Int("id", int(user.ID))))
+	defer span.End()
+	existing, err := svc.GetUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Subject != user.Subject {
+		return nil, ErrImmutable
+	}
+	return svc.repos.UserRepo.Save(ctx, user)
+}
+
+// SetActive deactivates or reactivates a User. Their
+// Jumps are left alone when they are deactivated.
+func (svc *SCIMService) SetActive(ctx context.Context, id uint, active bool) (*dao.UserV2, error) {
+	user, err := svc.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case active && user.Deactivated:
+		err = svc.users.Reactivate(ctx, user.Subject)
+	case !active && !user.Deactivated:
+		_, err = svc.users.Deactivate(ctx, user.Subject, "", false)
+	default:
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return svc.GetUser(ctx, id)
+}
+
+// DeleteUser deprovisions a User. They are deactivated
+// rather than deleted (see UserService.Deactivate), so that
+// a session that they already have stops working and they
+// aren't created again when they next log in. Their Jumps
+// are left alone, and Groups that they own are given to
+// another member, or archived if there isn't one.
+func (svc *SCIMService) DeleteUser(ctx context.Context, id uint) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_scim_deleteUser", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	user, err := svc.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	logr.FromContextOrDiscard(ctx).Info("deprovisioning user", "ID", id, "Subject", user.Subject)
+	if _, err := svc.users.Deactivate(ctx, user.Subject, "", false); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// ListGroups returns a page of Groups, or the Group
+// with the given name if it isn't empty. Archived Groups
+// have been deprovisioned, so they are skipped.
+func (svc *SCIMService) ListGroups(ctx context.Context, name string, offset, limit int) ([]*model.Group, int, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_scim_listGroups")
+	defer span.End()
+	if name != "" {
+		group, err := svc.repos.GroupRepo.FindByName(ctx, name)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, 0, nil
+			}
+			return nil, 0, err
+		}
+		if group.Archived {
+			return nil, 0, nil
+		}
+		return []*model.Group{group}, 1, nil
+	}
+	var groups []*model.Group
+	var count int
+	err := svc.repos.GroupRepo.FindInBatches(ctx, 100, func(batch []*model.Group) error {
+		for _, g := range batch {
+			if g.Archived {
+				continue
+			}
+			if count >= offset && (limit < 0 || len(groups) < limit) {
+				groups = append(groups, g)
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, 0, err
+	}
+	return groups, count, nil
+}
+
+// GetGroup returns a Group by its primaryKey (ID). Archived
+// Groups have been deprovisioned, so they aren't found.
+func (svc *SCIMService) GetGroup(ctx context.Context, id uint) (*model.Group, error) {
+	group, err := svc.repos.GroupRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if group.Archived {
+		return nil, ErrNotFound
+	}
+	return group, nil
+}
+
+// GetGroupMembers returns the Users in a Group. Members
+// that haven't been provisioned or logged in yet are
+// skipped.
+func (svc *SCIMService) GetGroupMembers(ctx context.Context, id uint) ([]*dao.UserV2, error) {
+	members, err := svc.repos.GroupRepo.GetMembers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*dao.UserV2, 0, len(members))
+	for _, m := range members {
+		user, err := svc.repos.UserRepo.Get(ctx, m.Subject)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// CreateGroup provisions a new External Group with
+// the given members. It has no owner, so only
+// administrators can change its settings. A Group
+// that was deprovisioned is restored instead.
+func (svc *SCIMService) CreateGroup(ctx context.Context, name string, members []uint) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Name", name)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_scim_createGroup", trace.WithAttributes(attribute.String("name", name)))
+	defer span.End()
+	existing, err := svc.repos.GroupRepo.FindByName(ctx, name)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if existing != nil && !existing.Archived {
+		return nil, ErrAlreadyExists
+	}
+	subjects, err := svc.subjects(ctx, members)
+	if err != nil {
+		return nil, err
+	}
+	group := existing
+	if group != nil {
+		log.Info("restoring deprovisioned group", "ID", group.ID)
+		group.Archived = false
+	} else {
+		log.Info("provisioning group")
+		group = &model.Group{
+			Name:     name,
+			External: true,
+		}
+	}
+	group, err = svc.repos.GroupRepo.Save(group)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	for _, s := range subjects {
+		if err := svc.addMember(ctx, group.ID, s); err != nil {
+			return nil, err
+		}
+	}
+	return svc.GetGroup(ctx, group.ID)
+}
+
+// RenameGroup changes the name of a Group
+func (svc *SCIMService) RenameGroup(ctx context.Context, id uint, name string) (*model.Group, error) {
+	group, err := svc.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if group.Name == name {
+		return group, nil
+	}
+	if _, err := svc.repos.GroupRepo.FindByName(ctx, name); err == nil {
+		return nil, ErrAlreadyExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	group.Name = name
+	return svc.repos.GroupRepo.Save(group)
+}
+
+// AddGroupMembers adds Users to a Group
+func (svc *SCIMService) AddGroupMembers(ctx context.Context, id uint, members []uint) error {
+	subjects, err := svc.subjects(ctx, members)
+	if err != nil {
+		return err
+	}
+	for _, s := range subjects {
+		if err := svc.addMember(ctx, id, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveGroupMembers removes Users from a Group. The
+// last owner of a Group can't be removed.
+func (svc *SCIMService) RemoveGroupMembers(ctx context.Context, id uint, members []uint) error {
+	group, err := svc.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	subjects, err := svc.subjects(ctx, members)
+	if err != nil {
+		return err
+	}
+	for _, s := range subjects {
+		if err := svc.groups.requireOtherOwner(ctx, id, s); err != nil {
+			return err
+		}
+		if _, err := svc.groups.evict(ctx, id, s, ""); err != nil {
+			return err
+		}
+		if group, err = svc.groups.replaceOwner(ctx, group, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceGroupMembers sets the members of a Group that
+// are managed by the identity provider. Members that
+// were added manually and the owner are kept.
+func (svc *SCIMService) ReplaceGroupMembers(ctx context.Context, id uint, members []uint) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id)
+	group, err := svc.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	subjects, err := svc.subjects(ctx, members)
+	if err != nil {
+		return err
+	}
+	current, err := svc.repos.GroupRepo.GetMembers(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, m := range current {
+		if m.Source == model.GroupSourceManual || m.Subject == group.Owner || slices.Contains(subjects, m.Subject) {
+			continue
+		}
+		log.V(1).Info("removing member that is no longer provisioned", "Subject", m.Subject)
+		if _, err := svc.groups.evict(ctx, id, m.Subject, ""); err != nil {
+			return err
+		}
+	}
+	for _, s := range subjects {
+		if err := svc.addMember(ctx, id, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteGroup deprovisions a Group by archiving it, so
+// that its Jumps are hidden rather than destroyed. The
+// Group is restored if it is provisioned again.
+func (svc *SCIMService) DeleteGroup(ctx context.Context, id uint) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_scim_deleteGroup", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	group, err := svc.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	logr.FromContextOrDiscard(ctx).Info("deprovisioning group", "ID", id, "Name", group.Name)
+	group.Archived = true
+	if _, err := svc.repos.GroupRepo.Save(group); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// addMember adds a User to a Group unless they
+// are already a member.
+func (svc *SCIMService) addMember(ctx context.Context, id uint, subject string) error {
+	role, err := groupRole(ctx, svc.repos, id, subject)
+	if err != nil {
+		return err
+	}
+	if role != "" {
+		return nil
+	}
+	if err := svc.repos.GroupRepo.AddMember(ctx, &model.GroupMember{
+		GroupID: id,
+		Subject: subject,
+		Role:    model.GroupRoleMember,
+		Source:  model.GroupSourceSCIM,
+	}); err != nil {
+		return err
+	}
+	if err := svc.groups.recordEvent(ctx, id, subject, model.GroupMemberAdded, model.GroupRoleMember, model.GroupSourceSCIM, ""); err != nil {
+		return err
+	}
+	return syncGroupRole(ctx, svc.groups.authz, id, subject, "", model.GroupRoleMember)
+}
+
+// subjects converts User IDs into subjects, failing
+// if any of them don't exist.
+func (svc *SCIMService) subjects(ctx context.Context, ids []uint) ([]string, error) {
+	subjects := make([]string, len(ids))
+	for i, id := range ids {
+		user, err := svc.GetUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		subjects[i] = user.Subject
+	}
+	return subjects, nil
+}
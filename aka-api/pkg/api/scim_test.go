@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSCIMAPI(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	router := mux.NewRouter()
+	_ = NewSCIMAPI(ctx, repos, authz, "hunter2", router)
+
+	do := func(t *testing.T, method, target, body string, v any) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer hunter2")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.EqualValues(t, scimContentType, w.Header().Get("Content-Type"))
+		if v != nil {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(v))
+		}
+		return w.Code
+	}
+
+	t.Run("a token is required", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+		req.Header.Set("Authorization", "Bearer hunter3")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+	})
+
+	var john, jane SCIMUser
+	t.Run("create users", func(t *testing.T) {
+		code := do(t, http.MethodPost, "/scim/v2/Users", `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"john","displayName":"John","emails":[{"value":"john@example.org","primary":true}]}`, &john)
+		require.EqualValues(t, http.StatusCreated, code)
+		assert.EqualValues(t, "john", john.UserName)
+		assert.EqualValues(t, "/scim/v2/Users/"+john.ID, john.Meta.Location)
+
+		code = do(t, http.MethodPost, "/scim/v2/Users", `{"userName":"jane"}`, &jane)
+		require.EqualValues(t, http.StatusCreated, code)
+
+		user, err := repos.UserRepo.Get(ctx, "john")
+		require.NoError(t, err)
+		assert.EqualValues(t, "John", user.Username)
+		assert.EqualValues(t, "john@example.org", user.Email)
+
+		var scimErr SCIMError
+		code = do(t, http.MethodPost, "/scim/v2/Users", `{"userName":"john"}`, &scimErr)
+		assert.EqualValues(t, http.StatusConflict, code)
+		assert.EqualValues(t, "uniqueness", scimErr.SCIMType)
+	})
+	t.Run("list and filter users", func(t *testing.T) {
+		var list SCIMListResponse
+		code := do(t, http.MethodGet, "/scim/v2/Users?startIndex=2&count=1", "", &list)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.EqualValues(t, 2, list.TotalResults)
+		assert.EqualValues(t, 2, list.StartIndex)
+		assert.Len(t, list.Resources, 1)
+
+		code = do(t, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "jane"`), "", &list)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.EqualValues(t, 1, list.TotalResults)
+
+		code = do(t, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "alice"`), "", &list)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.EqualValues(t, 0, list.TotalResults)
+
+		code = do(t, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`emails co "example"`), "", nil)
+		assert.EqualValues(t, http.StatusBadRequest, code)
+	})
+	t.Run("patch users", func(t *testing.T) {
+		var user SCIMUser
+		code := do(t, http.MethodPatch, "/scim/v2/Users/"+jane.ID, `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"displayName","value":"Jane"},{"op":"add","path":"emails[type eq \"work\"].value","value":"jane@example.org"}]}`, &user)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.EqualValues(t, "Jane", user.DisplayName)
+		require.Len(t, user.Emails, 1)
+		assert.EqualValues(t, "jane@example.org", user.Emails[0].Value)
+
+		// the subject can't be changed
+		code = do(t, http.MethodPatch, "/scim/v2/Users/"+jane.ID, `{"Operations":[{"op":"replace","value":{"userName":"janet"}}]}`, nil)
+		assert.EqualValues(t, http.StatusBadRequest, code)
+	})
+
+	var group SCIMGroup
+	t.Run("create groups", func(t *testing.T) {
+		code := do(t, http.MethodPost, "/scim/v2/Groups", `{"displayName":"devs","members":[{"value":"`+john.ID+`"}]}`, &group)
+		require.EqualValues(t, http.StatusCreated, code)
+		assert.EqualValues(t, "devs", group.DisplayName)
+		require.Len(t, group.Members, 1)
+		assert.EqualValues(t, john.ID, group.Members[0].Value)
+
+		g, err := repos.GroupRepo.FindByName(ctx, "devs")
+		require.NoError(t, err)
+		assert.True(t, g.External)
+		member, err := repos.GroupRepo.GetMember(ctx, g.ID, "john")
+		require.NoError(t, err)
+		assert.EqualValues(t, model.GroupSourceSCIM, member.Source)
+
+		// unknown members are rejected
+		code = do(t, http.MethodPost, "/scim/v2/Groups", `{"displayName":"ops","members":[{"value":"100"}]}`, nil)
+		assert.EqualValues(t, http.StatusNotFound, code)
+	})
+	t.Run("patch group members", func(t *testing.T) {
+		code := do(t, http.MethodPatch, "/scim/v2/Groups/"+group.ID, `{"Operations":[{"op":"add","path":"members","value":[{"value":"`+jane.ID+`"}]}]}`, &group)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.Len(t, group.Members, 2)
+
+		code = do(t, http.MethodPatch, "/scim/v2/Groups/"+group.ID, `{"Operations":[{"op":"remove","path":"members[value eq \"`+john.ID+`\"]"}]}`, &group)
+		require.EqualValues(t, http.StatusOK, code)
+		require.Len(t, group.Members, 1)
+		assert.EqualValues(t, jane.ID, group.Members[0].Value)
+
+		resp, err := authz.Can(ctx, &rbac.AccessRequest{
+			Subject:  "john",
+			Resource: schemas.ResourceName(schemas.ResourceGroup, group.ID),
+			Action:   rbac.Verb_READ,
+		})
+		require.NoError(t, err)
+		assert.False(t, resp.GetOk())
+
+		code = do(t, http.MethodPatch, "/scim/v2/Groups/"+group.ID, `{"Operations":[{"op":"replace","value":{"displayName":"developers","members":[{"value":"`+john.ID+`"}]}}]}`, &group)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.EqualValues(t, "developers", group.DisplayName)
+		require.Len(t, group.Members, 1)
+		assert.EqualValues(t, john.ID, group.Members[0].Value)
+	})
+	t.Run("manual members are kept", func(t *testing.T) {
+		require.NoError(t, repos.GroupRepo.AddMember(ctx, &model.GroupMember{
+			GroupID: parseID(t, group.ID),
+			Subject: "jane",
+			Role:    model.GroupRoleMaintainer,
+			Source:  model.GroupSourceManual,
+		}))
+		code := do(t, http.MethodPut, "/scim/v2/Groups/"+group.ID, `{"displayName":"developers","members":[]}`, &group)
+		require.EqualValues(t, http.StatusOK, code)
+		require.Len(t, group.Members, 1)
+		assert.EqualValues(t, jane.ID, group.Members[0].Value)
+	})
+	t.Run("list and filter groups", func(t *testing.T) {
+		var list SCIMListResponse
+		code := do(t, http.MethodGet, "/scim/v2/Groups?excludedAttributes=members&filter="+url.QueryEscape(`displayName eq "developers"`), "", &list)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.EqualValues(t, 1, list.TotalResults)
+	})
+	t.Run("deactivate users", func(t *testing.T) {
+		var user SCIMUser
+		code := do(t, http.MethodPatch, "/scim/v2/Users/"+john.ID, `{"Operations":[{"op":"replace","path":"active","value":false}]}`, &user)
+		require.EqualValues(t, http.StatusOK, code)
+		require.NotNil(t, user.Active)
+		assert.False(t, *user.Active)
+
+		// they're removed from their groups
+		memberships, err := repos.GroupRepo.GetMemberships(ctx, "john")
+		require.NoError(t, err)
+		assert.Empty(t, memberships)
+
+		code = do(t, http.MethodPatch, "/scim/v2/Users/"+john.ID, `{"Operations":[{"op":"replace","value":{"active":true}}]}`, &user)
+		require.EqualValues(t, http.StatusOK, code)
+		assert.True(t, *user.Active)
+	})
+	t.Run("delete users", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/scim/v2/Users/"+jane.ID, nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer hunter2")
+		router.ServeHTTP(w, req)
+		require.EqualValues(t, http.StatusNoContent, w.Code)
+
+		// they are deactivated rather than deleted
+		user, err := repos.UserRepo.Get(ctx, "jane")
+		require.NoError(t, err)
+		assert.True(t, user.Deactivated)
+		memberships, err := repos.GroupRepo.GetMemberships(ctx, "jane")
+		require.NoError(t, err)
+		assert.Empty(t, memberships)
+
+		// so their next request is rejected, and they
+		// aren't created again when they log in
+		users := NewUserService(ctx, repos, authz, nil)
+		assert.ErrorIs(t, users.RequireActive(ctx, "jane"), ErrUserDeactivated)
+		_, err = users.CreateOrUpdateUser(ctx, &identity.OAuthUser{Subject: "jane"})
+		assert.ErrorIs(t, err, ErrUserDeactivated)
+
+		// they can be provisioned again
+		var restored SCIMUser
+		code := do(t, http.MethodPost, "/scim/v2/Users", `{"userName":"jane"}`, &restored)
+		require.EqualValues(t, http.StatusCreated, code)
+		assert.EqualValues(t, jane.ID, restored.ID)
+		assert.NoError(t, users.RequireActive(ctx, "jane"))
+	})
+	t.Run("delete groups", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/scim/v2/Groups/"+group.ID, nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer hunter2")
+		router.ServeHTTP(w, req)
+		require.EqualValues(t, http.StatusNoContent, w.Code)
+
+		code := do(t, http.MethodGet, "/scim/v2/Groups/"+group.ID, "", nil)
+		assert.EqualValues(t, http.StatusNotFound, code)
+
+		// the group is archived rather than deleted
+		g, err := repos.GroupRepo.GetByID(ctx, parseID(t, group.ID))
+		require.NoError(t, err)
+		assert.True(t, g.Archived)
+
+		// and comes back if it is provisioned again
+		var restored SCIMGroup
+		code = do(t, http.MethodPost, "/scim/v2/Groups", `{"displayName":"developers"}`, &restored)
+		require.EqualValues(t, http.StatusCreated, code)
+		assert.EqualValues(t, group.ID, restored.ID)
+	})
+	t.Run("writes are audited", func(t *testing.T) {
+		events, err := repos.AuditRepo.Find(ctx, model.AuditFilter{Resource: "user://jane"}, 0, -1)
+		require.NoError(t, err)
+		require.NotEmpty(t, events)
+
+		// the newest event is jane being provisioned again
+		assert.EqualValues(t, ActorSCIM, events[0].Actor)
+		assert.EqualValues(t, "scim.createUser", events[0].Action)
+		assert.Empty(t, events[0].Before)
+		assert.Contains(t, events[0].After, `"jane"`)
+
+		assert.EqualValues(t, "scim.deleteUser", events[1].Action)
+		assert.Contains(t, events[1].Before, `"jane"`)
+		assert.Contains(t, events[1].After, `"deactivated":true`)
+
+		// reads aren't
+		events, err = repos.AuditRepo.Find(ctx, model.AuditFilter{Actor: ActorSCIM}, 0, -1)
+		require.NoError(t, err)
+		for _, e := range events {
+			assert.NotContains(t, e.Action, "get")
+			assert.NotContains(t, e.Action, "list")
+		}
+	})
+}
+
+func parseID(t *testing.T, s string) uint {
+	id, err := strconv.ParseUint(s, 10, 64)
+	require.NoError(t, err)
+	return uint(id)
+}
+
+func TestSCIMService_DeleteUser(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewSCIMService(ctx, repos, authz)
+	groups := NewGroupService(ctx, repos, authz, nil)
+
+	ids := map[string]uint{}
+	for _, sub := range []string{"john", "jane", "bob"} {
+		user, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+		ids[sub] = user.ID
+	}
+	john := withUser(ctx, "john")
+	team, err := groups.Create(john, "team", false, false)
+	require.NoError(t, err)
+	_, err = groups.AddMember(john, int(team.ID), "jane", model.GroupRoleMaintainer)
+	require.NoError(t, err)
+	_, err = groups.AddMember(john, int(team.ID), "bob", model.GroupRoleMember)
+	require.NoError(t, err)
+	solo, err := groups.Create(withUser(ctx, "jane"), "solo", false, false)
+	require.NoError(t, err)
+
+	t.Run("owned groups are given to the next member", func(t *testing.T) {
+		require.NoError(t, svc.DeleteUser(ctx, ids["john"]))
+
+		g, err := repos.GroupRepo.GetByID(ctx, team.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "jane", g.Owner)
+		member, err := repos.GroupRepo.GetMember(ctx, team.ID, "jane")
+		require.NoError(t, err)
+		assert.EqualValues(t, model.GroupRoleOwner, member.Role)
+	})
+	t.Run("groups without other members are archived", func(t *testing.T) {
+		require.NoError(t, svc.DeleteUser(ctx, ids["jane"]))
+
+		g, err := repos.GroupRepo.GetByID(ctx, solo.ID)
+		require.NoError(t, err)
+		assert.True(t, g.Archived)
+
+		g, err = repos.GroupRepo.GetByID(ctx, team.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "bob", g.Owner)
+	})
+}
@@ -23,21 +23,42 @@ import (
 	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/svc"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"net/url"
+	"strings"
+	"unicode"
 )
 
+// duplicateCandidates is the most Jumps that we
+// compare names against when looking for duplicates.
+const duplicateCandidates = 100
+
 type SimilarService struct {
-	repos *dao.Repos
-	svc   *svc.SimilarService
+	repos     *dao.Repos
+	svc       *svc.SimilarService
+	urlPolicy *policy.Policy
+}
+
+// Duplicates are the Jumps that another
+// Jump might be a copy of.
+type Duplicates struct {
+	// Location are the Jumps that point
+	// to the same place.
+	Location []*model.Jump
+	// Name are the Jumps with a
+	// near-identical name.
+	Name []*model.Jump
 }
 
-func NewSimilarService(repos *dao.Repos, svc *svc.SimilarService) *SimilarService {
+func NewSimilarService(repos *dao.Repos, svc *svc.SimilarService, urlPolicy *policy.Policy) *SimilarService {
 	return &SimilarService{
-		repos: repos,
-		svc:   svc,
+		repos:     repos,
+		svc:       svc,
+		urlPolicy: urlPolicy,
 	}
 }
 
@@ -61,21 +82,80 @@ func (svc *SimilarService) GetSimilarSuggest(ctx context.Context, query string)
 	return svc.svc.ForSuggesting(ctx, items, query), nil
 }
 
-func (svc *SimilarService) getSimilar(ctx context.Context) ([]*model.Jump, error) {
-	log := logr.FromContextOrDiscard(ctx)
-	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_similar_getSimilar")
+// GetDuplicates returns the other Jumps visible to the current
+// user that point to the same place as a Jump once normalised,
+// or that have a near-identical name.
+func (svc *SimilarService) GetDuplicates(ctx context.Context, jump *model.Jump) (*Duplicates, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_similar_getDuplicates", trace.WithAttributes(attribute.Int("id", int(jump.ID))))
 	defer span.End()
 	username := GetUsernameCtx(ctx)
-	// get the users groups
+	groupIDs := svc.getGroupIDs(ctx, username)
+	notSelf := func(j *model.Jump) bool {
+		return j.ID != jump.ID
+	}
+
+	dupes := &Duplicates{}
+	if uri, err := url.Parse(jump.Location); err == nil && uri.Hostname() != "" {
+		items, err := svc.repos.JumpRepo.GetByHost(ctx, username, uri.Hostname(), groupIDs)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		dupes.Location = dao.FilterJumps(items, func(j *model.Jump) bool {
+			return notSelf(j) && svc.urlPolicy.Equivalent(j.Location, jump.Location)
+		})
+	}
+	// near-identical names almost always start the
+	// same way, so use that to narrow down the search
+	if prefix := namePrefix(jump.Name); prefix != "" {
+		results, err := svc.repos.JumpRepo.SearchForTerm(ctx, username, prefix, 0, duplicateCandidates, groupIDs)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		items := make([]*model.Jump, 0, len(results.Results))
+		for i := range results.Results {
+			if j := results.Results[i].(*model.Jump); notSelf(j) {
+				items = append(items, j)
+			}
+		}
+		dupes.Name = svc.svc.ForNaming(ctx, items, jump.Name)
+	}
+	return dupes, nil
+}
+
+// namePrefix returns the first few letters of the first
+// word of a name, which is safe to use as a search term.
+func namePrefix(name string) string {
+	prefix := make([]rune, 0, 3)
+	for _, r := range strings.ToLower(name) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) || len(prefix) == cap(prefix) {
+			break
+		}
+		prefix = append(prefix, r)
+	}
+	return string(prefix)
+}
+
+func (svc *SimilarService) getGroupIDs(ctx context.Context, username string) []uint {
 	groups, err := svc.repos.GroupRepo.GetUserGroups(ctx, username)
 	if err != nil {
-		log.Error(err, "failed to get user groups, response may be limited")
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to get user groups, response may be limited")
 		groups = nil
 	}
 	groupIDs := make([]uint, len(groups))
 	for i := range groups {
 		groupIDs[i] = groups[i].ID
 	}
+	return groupIDs
+}
+
+func (svc *SimilarService) getSimilar(ctx context.Context) ([]*model.Jump, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_similar_getSimilar")
+	defer span.End()
+	username := GetUsernameCtx(ctx)
+	// get the users groups
+	groupIDs := svc.getGroupIDs(ctx, username)
 	results, err := svc.repos.JumpRepo.GetAll(ctx, username, 0, 20, groupIDs)
 	if err != nil {
 		return nil, err
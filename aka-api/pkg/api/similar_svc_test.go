@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/svc"
+	"testing"
+)
+
+func TestSimilarService_GetDuplicates(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	urlPolicy, err := policy.New(policy.Options{StripParams: []string{"utm_*"}})
+	require.NoError(t, err)
+	jumps := NewJumpService(ctx, repos, authz, false, urlPolicy, nil)
+	similar := NewSimilarService(repos, svc.NewSimilarService(0.7), urlPolicy)
+
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+
+	// saved before normalisation was added
+	legacy, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: "wiki", Location: "HTTPS://Docs.Example.org:443/?utm_source=mail", Owner: "user://john"})
+	require.NoError(t, err)
+	docs, err := jumps.Create(john, CreateJumpOpts{Name: "documentation", Location: "https://docs.example.org/guide"})
+	require.NoError(t, err)
+	_, err = jumps.Create(jane, CreateJumpOpts{Name: "docs", Location: "https://docs.example.org"})
+	require.NoError(t, err)
+
+	t.Run("same location", func(t *testing.T) {
+		jump, err := jumps.Create(john, CreateJumpOpts{Name: "home", Location: "https://DOCS.example.org/?utm_medium=chat"})
+		require.NoError(t, err)
+		assert.Equal(t, "https://docs.example.org/", jump.Location)
+
+		dupes, err := similar.GetDuplicates(john, jump)
+		require.NoError(t, err)
+		// jane's jump isn't visible to john
+		require.Len(t, dupes.Location, 1)
+		assert.EqualValues(t, legacy.ID, dupes.Location[0].ID)
+		assert.Empty(t, dupes.Name)
+	})
+	t.Run("similar name", func(t *testing.T) {
+		jump, err := jumps.Create(john, CreateJumpOpts{Name: "Documentations", Location: "https://example.org/docs"})
+		require.NoError(t, err)
+
+		dupes, err := similar.GetDuplicates(john, jump)
+		require.NoError(t, err)
+		assert.Empty(t, dupes.Location)
+		require.Len(t, dupes.Name, 1)
+		assert.EqualValues(t, docs.ID, dupes.Name[0].ID)
+	})
+	t.Run("nothing similar", func(t *testing.T) {
+		jump, err := jumps.Create(john, CreateJumpOpts{Name: "calendar", Location: "https://calendar.example.org"})
+		require.NoError(t, err)
+
+		dupes, err := similar.GetDuplicates(john, jump)
+		require.NoError(t, err)
+		assert.Empty(t, dupes.Location)
+		assert.Empty(t, dupes.Name)
+	})
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	// TokenPrefix is at the start of every AccessToken
+	// so that they're easy to spot if they're leaked.
+	TokenPrefix = "aka_"
+	// tokenDisplayLength is how much of an AccessToken
+	// we keep so that users can tell them apart.
+	tokenDisplayLength = len(TokenPrefix) + 6
+	// tokenTouchInterval is how often we record
+	// that an AccessToken has been used.
+	tokenTouchInterval = int64(60)
+)
+
+var (
+	// ErrInvalidToken is returned when an AccessToken
+	// doesn't exist, has expired or belongs to a user
+	// that can't log in.
+	ErrInvalidToken = errors.New("invalid access token")
+	// ErrInvalidScope is returned when a TokenScope
+	// isn't one that we know about.
+	ErrInvalidScope = errors.New("unknown token scope")
+)
+
+type TokenService struct {
+	repos *dao.Repos
+}
+
+var _ identity.TokenVerifier = &TokenService{}
+var _ identity.TokenRecogniser = &TokenService{}
+
+func NewTokenService(repos *dao.Repos) *TokenService {
+	return &TokenService{
+		repos: repos,
+	}
+}
+
+// Create mints a new AccessToken for the current user. The
+// token is only ever returned here, we just keep its hash.
+// Tokens that expire after 0 days never expire.
+func (svc *TokenService) Create(ctx context.Context, name string, scope model.TokenScope, expiresInDays int) (*model.CreatedToken, error) {
+	subject := GetUsernameCtx(ctx)
+	log := logr.FromContextOrDiscard(ctx).WithValues("Subject", subject, "Name", name, "Scope", scope)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_token_create", trace.WithAttributes(
+		attribute.String("subject", subject),
+		attribute.String("scope", string(scope)),
+	))
+	defer span.End()
+	if subject == "" {
+		return nil, ErrForbidden
+	}
+	if !scope.IsValid() {
+		return nil, ErrInvalidScope
+	}
+	if expiresInDays < 0 {
+		return nil, errors.New("tokens can't expire in the past")
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		log.Error(err, "failed to generate token")
+		return nil, err
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now()
+	t := &model.AccessToken{
+		Subject:   subject,
+		Name:      strings.TrimSpace(name),
+		Hash:      hashToken(token),
+		Prefix:    token[:tokenDisplayLength],
+		Scope:     scope,
+		CreatedAt: now.Unix(),
+	}
+	if expiresInDays > 0 {
+		t.ExpiresAt = now.AddDate(0, 0, expiresInDays).Unix()
+	}
+	if err := svc.repos.TokenRepo.Create(ctx, t); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	log.Info("created access token", "ID", t.ID)
+	return &model.CreatedToken{
+		Token:       token,
+		AccessToken: t,
+	}, nil
+}
+
+// List returns the current user's AccessTokens
+func (svc *TokenService) List(ctx context.Context) ([]*model.AccessToken, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_token_list")
+	defer span.End()
+	subject := GetUsernameCtx(ctx)
+	if subject == "" {
+		return nil, ErrForbidden
+	}
+	return svc.repos.TokenRepo.GetBySubject(ctx, subject)
+}
+
+// Revoke deletes one of the current user's AccessTokens
+func (svc *TokenService) Revoke(ctx context.Context, id uint) error {
+	subject := GetUsernameCtx(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_token_revoke", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	if subject == "" {
+		return ErrForbidden
+	}
+	ok, err := svc.repos.TokenRepo.Delete(ctx, subject, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	logr.FromContextOrDiscard(ctx).Info("revoked access token", "ID", id, "Subject", subject)
+	return nil
+}
+
+// RecognisesToken checks whether a token
+// looks like one of our AccessTokens.
+func (svc *TokenService) RecognisesToken(token string) bool {
+	return strings.HasPrefix(token, TokenPrefix)
+}
+
+// VerifyToken returns the identity of the user
+// that an AccessToken belongs to.
+func (svc *TokenService) VerifyToken(ctx context.Context, token string) (*identity.OAuthUser, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_token_verify")
+	defer span.End()
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	t, err := svc.repos.TokenRepo.GetByHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		span.RecordError(err)
+		return nil, err
+	}
+	log = log.WithValues("ID", t.ID, "Subject", t.Subject)
+	now := time.Now().Unix()
+	if t.Expired(now) {
+		log.V(1).Info("rejecting expired access token")
+		return nil, ErrInvalidToken
+	}
+	user, err := svc.repos.UserRepo.Get(ctx, t.Subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		span.RecordError(err)
+		return nil, err
+	}
+	if user.Deactivated {
+		log.Info("rejecting access token of deactivated user")
+		return nil, ErrInvalidToken
+	}
+	// don't write to the database on every
+	// request made by busy scripts
+	if now-t.LastUsedAt >= tokenTouchInterval {
+		if err := svc.repos.TokenRepo.SetLastUsed(ctx, t.ID, now); err != nil {
+			log.Error(err, "failed to record access token usage")
+		}
+	}
+	var groups []string
+	if user.Groups != "" {
+		groups = strings.Split(user.Groups, ",")
+	}
+	return &identity.OAuthUser{
+		Subject:  user.Subject,
+		Groups:   groups,
+		Email:    user.Email,
+		Username: user.Username,
+		Scope:    string(t.Scope),
+	}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
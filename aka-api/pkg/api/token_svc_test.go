@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenService(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	svc := NewTokenService(repos)
+
+	_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: "john", Email: "john@example.org", Groups: "devs,ops"})
+	require.NoError(t, err)
+	john := withUser(ctx, "john")
+
+	var created *model.CreatedToken
+	t.Run("create", func(t *testing.T) {
+		_, err := svc.Create(ctx, "ci", model.TokenScopeReadOnly, 30)
+		assert.ErrorIs(t, err, ErrForbidden)
+		_, err = svc.Create(john, "ci", "root", 30)
+		assert.ErrorIs(t, err, ErrInvalidScope)
+
+		created, err = svc.Create(john, "ci", model.TokenScopeJumpsWrite, 30)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(created.Token, TokenPrefix))
+		assert.True(t, strings.HasPrefix(created.Token, created.AccessToken.Prefix))
+		assert.NotContains(t, created.AccessToken.Hash, created.Token)
+		assert.Greater(t, created.AccessToken.ExpiresAt, time.Now().Unix())
+
+		// only the hash is stored
+		tokens, err := svc.List(john)
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+		assert.NotEqualValues(t, created.Token, tokens[0].Hash)
+	})
+	t.Run("verify", func(t *testing.T) {
+		user, err := svc.VerifyToken(ctx, created.Token)
+		require.NoError(t, err)
+		assert.EqualValues(t, "john", user.Subject)
+		assert.EqualValues(t, "john@example.org", user.Email)
+		assert.EqualValues(t, []string{"devs", "ops"}, user.Groups)
+		assert.EqualValues(t, model.TokenScopeJumpsWrite, user.Scope)
+
+		// usage is recorded
+		tokens, err := svc.List(john)
+		require.NoError(t, err)
+		assert.NotZero(t, tokens[0].LastUsedAt)
+
+		_, err = svc.VerifyToken(ctx, created.Token+"x")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		_, err = svc.VerifyToken(ctx, "hunter2")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+	t.Run("expired tokens are rejected", func(t *testing.T) {
+		require.NoError(t, repos.TokenRepo.Create(ctx, &model.AccessToken{
+			Subject:   "john",
+			Hash:      hashToken(TokenPrefix + "old"),
+			Scope:     model.TokenScopeReadOnly,
+			ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		}))
+		_, err := svc.VerifyToken(ctx, TokenPrefix+"old")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+	t.Run("deactivated users are rejected", func(t *testing.T) {
+		user, err := repos.UserRepo.Get(ctx, "john")
+		require.NoError(t, err)
+		user.Deactivated = true
+		_, err = repos.UserRepo.Save(ctx, user)
+		require.NoError(t, err)
+
+		_, err = svc.VerifyToken(ctx, created.Token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		user.Deactivated = false
+		_, err = repos.UserRepo.Save(ctx, user)
+		require.NoError(t, err)
+	})
+	t.Run("revoke", func(t *testing.T) {
+		err := svc.Revoke(withUser(ctx, "jane"), created.AccessToken.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, svc.Revoke(john, created.AccessToken.ID))
+		_, err = svc.VerifyToken(ctx, created.Token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
@@ -19,6 +19,7 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"github.com/go-logr/logr"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
 	"sync"
@@ -27,11 +28,53 @@ import (
 var (
 	ErrNotFound  = errors.New("not found")
 	ErrForbidden = errors.New("forbidden")
+	// ErrLastOwner is returned when removing a user
+	// would leave a Group without an owner.
+	ErrLastOwner = errors.New("the last owner can't be removed from a group, make someone else an owner first")
+	// ErrNotMember is returned when giving a Group
+	// to a user that isn't one of its members.
+	ErrNotMember = errors.New("the user isn't a member of the group")
+	// ErrInvalidRole is returned when a GroupRole
+	// isn't one that we know about.
+	ErrInvalidRole = errors.New("unknown group role")
+	// ErrInvalidOwner is returned when something is given
+	// to an owner that isn't a user:// or group://.
+	ErrInvalidOwner = errors.New("owners must be a user:// or group://")
+	// ErrGroupArchived is returned when adding
+	// Jumps to an archived Group.
+	ErrGroupArchived = errors.New("the group has been archived")
+	// ErrExternalGroup is returned when changing the
+	// members of a Group that is managed by the
+	// identity provider.
+	ErrExternalGroup = errors.New("the members of external groups are managed by the identity provider")
+	// ErrProposalRequired is returned when a user isn't
+	// allowed to create public Jumps themselves.
+	ErrProposalRequired = fmt.Errorf("%w: public jumps must be proposed and approved by an administrator", ErrForbidden)
+	// ErrAlreadyReviewed is returned when reviewing a
+	// JumpProposal that has already been reviewed.
+	ErrAlreadyReviewed = errors.New("the proposal has already been reviewed")
+	// ErrReasonRequired is returned when a JumpProposal
+	// is rejected without saying why.
+	ErrReasonRequired = errors.New("a reason must be given when rejecting a proposal")
+	// ErrUserDeactivated is returned when a
+	// deactivated user tries to log in.
+	ErrUserDeactivated = errors.New("the user has been deactivated")
+	// ErrCannotRevoke is returned when access needs to be
+	// taken away but the authority can't remove role bindings.
+	ErrCannotRevoke = errors.New("the authority can't remove role bindings")
+	// ErrAlreadyExists is returned when creating
+	// something with a name that is already taken.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrImmutable is returned when changing
+	// something that can't be changed.
+	ErrImmutable = errors.New("attribute can't be changed")
 )
 
 type ListeningService struct {
 	log       logr.Logger
-	listener  chan *dao.Message
-	listeners map[chan *dao.Message]struct{}
-	lisLock   sync.Mutex
+	feed      dao.ChangeFeed
+	table     string
+	opts      FanoutOptions
+	listeners map[*Subscription]struct{}
+	lisLock   sync.RWMutex
 }
@@ -20,25 +20,35 @@ package api
 import (
 	"context"
 	"errors"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
 	"github.com/go-logr/logr"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 type UserService struct {
 	*ListeningService
-	repos *dao.Repos
+	repos  *dao.Repos
+	authz  rbac.AuthorityClient
+	groups *GroupService
 }
 
-func NewUserService(ctx context.Context, repos *dao.Repos, listener chan *dao.Message) *UserService {
+func NewUserService(ctx context.Context, repos *dao.Repos, authz rbac.AuthorityClient, feed dao.ChangeFeed) *UserService {
 	return &UserService{
 		repos:            repos,
-		ListeningService: NewListeningService(ctx, listener),
+		authz:            authz,
+		groups:           NewGroupService(ctx, repos, authz, nil),
+		ListeningService: NewListeningService(ctx, feed, model.TableNameUsersV2),
 	}
 }
 
@@ -53,6 +63,10 @@ func (svc *UserService) CreateOrUpdateUser(ctx context.Context, user *identity.O
 	}
 	// the user exists, and we looked them up, or the user
 	// does not exist
+	if daoUser != nil && daoUser.Deactivated {
+		log.Info("refusing to log in deactivated user", "Subject", user.Subject)
+		return nil, ErrUserDeactivated
+	}
 	if daoUser == nil {
 		log.Info("creating DAO for new user")
 		daoUser = &dao.UserV2{
@@ -81,3 +95,280 @@ func (svc *UserService) CreateOrUpdateUser(ctx context.Context, user *identity.O
 	}
 	return daoUser, nil
 }
+
+// RequireActive checks that a User hasn't been deactivated.
+// Users that haven't logged in yet are allowed.
+func (svc *UserService) RequireActive(ctx context.Context, subject string) error {
+	user, err := svc.repos.UserRepo.Get(ctx, subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to retrieve user information", "Subject", subject)
+		return err
+	}
+	if user.Deactivated {
+		return ErrUserDeactivated
+	}
+	return nil
+}
+
+// Deactivate hides a User and revokes their access. They
+// are removed from every Group and lose the role bindings
+// that aka knows about. Their Jumps, and any Groups that
+// they own, can be handed over to someone else. Groups that
+// aren't handed over to a user are kept by another owner,
+// or the next member is promoted (see GroupService.keepOwner).
+// Nothing is changed if dryRun is set.
+//
+// Deactivation fails with ErrCannotRevoke if the authority
+// isn't a RoleRevoker, rather than leaving the User with
+// role bindings that they shouldn't have.
+//
+// The User is deactivated before anything else is changed,
+// and every step can be repeated, so a Deactivate that fails
+// part of the way through can be run again to finish it.
+func (svc *UserService) Deactivate(ctx context.Context, subject, handoverTo string, dryRun bool) (*model.UserHandover, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Subject", subject, "HandoverTo", handoverTo, "DryRun", dryRun)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_user_deactivate", trace.WithAttributes(
+		attribute.String("subject", subject),
+		attribute.Bool("dryRun", dryRun),
+	))
+	defer span.End()
+
+	user, err := svc.repos.UserRepo.Get(ctx, subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if err := svc.checkHandover(ctx, subject, handoverTo); err != nil {
+		return nil, err
+	}
+	owner := "user://" + subject
+	report := &model.UserHandover{
+		Subject:     subject,
+		HandoverTo:  handoverTo,
+		DryRun:      dryRun,
+		Groups:      []*model.Group{},
+		OwnedGroups: []*model.Group{},
+	}
+	report.Jumps, err = svc.repos.JumpRepo.GetByOwner(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := svc.repos.GroupRepo.GetMemberships(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[uint]*model.Group, len(memberships))
+	for _, m := range memberships {
+		group, err := svc.repos.GroupRepo.GetByID(ctx, m.GroupID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		report.Groups = append(report.Groups, group)
+		groups[group.ID] = group
+		if group.Owner == subject {
+			report.OwnedGroups = append(report.OwnedGroups, group)
+		}
+	}
+	if dryRun {
+		log.V(1).Info("not deactivating user as this is a dry run", "Jumps", len(report.Jumps), "Groups", len(report.Groups))
+		return report, nil
+	}
+	// bail out before changing anything if we won't
+	// be able to take the user's access away
+	if _, ok := svc.authz.(RoleRevoker); !ok {
+		log.Info("refusing to deactivate user as the authority can't remove role bindings")
+		return nil, ErrCannotRevoke
+	}
+	log.Info("deactivating user", "Jumps", len(report.Jumps), "Groups", len(report.Groups))
+
+	// the user loses access first, so that nothing
+	// they do can get in the way of the rest
+	if !user.Deactivated {
+		user.Deactivated = true
+		if _, err := svc.repos.UserRepo.Save(ctx, user); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+	if _, err := svc.repos.TokenRepo.DeleteBySubject(ctx, subject); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	// the user's jumps are revoked before they are
+	// handed over, otherwise we won't be able to find
+	// them if we're run again
+	if err := svc.revokeAccess(ctx, subject, report.Jumps); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	// hand over the user's groups. Those that
+	// aren't handed over still need an owner
+	newOwner, toUser := strings.CutPrefix(handoverTo, "user://")
+	if toUser {
+		for _, group := range report.OwnedGroups {
+			if err := svc.groups.transferOwnership(ctx, group, newOwner); err != nil {
+				return nil, err
+			}
+			if _, err := svc.repos.GroupRepo.Save(group); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, m := range memberships {
+		if group, ok := groups[m.GroupID]; ok {
+			if err := svc.groups.keepOwner(ctx, group, subject); err != nil {
+				span.RecordError(err)
+				return nil, err
+			}
+		}
+		if _, err := svc.groups.evict(ctx, m.GroupID, subject, GetUsernameCtx(ctx)); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	// hand over the user's jumps. The new owner is
+	// given access before the jumps are moved, for
+	// the same reason that they are revoked first
+	if handoverTo == "" {
+		return report, nil
+	}
+	if toUser {
+		for _, j := range report.Jumps {
+			if _, err := svc.authz.AddRole(ctx, &rbac.AddRoleRequest{
+				Subject:  newOwner,
+				Resource: schemas.ResourceName(schemas.ResourceJump, j.ID),
+				Action:   rbac.Verb_SUDO,
+			}); err != nil {
+				log.Error(err, "failed to create owner role binding")
+				return nil, err
+			}
+		}
+	}
+	if _, err := svc.repos.JumpRepo.SetOwner(ctx, owner, handoverTo); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return report, nil
+}
+
+// Reactivate lets a deactivated User log in again. They
+// rejoin their External Groups when they next log in.
+func (svc *UserService) Reactivate(ctx context.Context, subject string) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_user_reactivate", trace.WithAttributes(attribute.String("subject", subject)))
+	defer span.End()
+	user, err := svc.repos.UserRepo.Get(ctx, subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if !user.Deactivated {
+		return nil
+	}
+	logr.FromContextOrDiscard(ctx).Info("reactivating user", "Subject", subject)
+	user.Deactivated = false
+	_, err = svc.repos.UserRepo.Save(ctx, user)
+	return err
+}
+
+// checkHandover makes sure that a deactivated
+// User's things can be given to a new owner.
+func (svc *UserService) checkHandover(ctx context.Context, subject, owner string) error {
+	if owner == "" {
+		return nil
+	}
+	kind, name, _ := strings.Cut(owner, "://")
+	switch kind {
+	case "user":
+		if name == subject {
+			return ErrInvalidOwner
+		}
+		target, err := svc.repos.UserRepo.Get(ctx, name)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if target.Deactivated {
+			return ErrUserDeactivated
+		}
+		return nil
+	case "group":
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			return ErrInvalidOwner
+		}
+		target, err := svc.repos.GroupRepo.GetByID(ctx, uint(id))
+		if err != nil {
+			return ErrNotFound
+		}
+		if target.Archived {
+			return ErrGroupArchived
+		}
+		return nil
+	}
+	return ErrInvalidOwner
+}
+
+// revokeAccess removes the role bindings that a User
+// was given on their Jumps, and as an administrator.
+func (svc *UserService) revokeAccess(ctx context.Context, subject string, jumps []*model.Jump) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Subject", subject)
+	revoker, ok := svc.authz.(RoleRevoker)
+	if !ok {
+		return ErrCannotRevoke
+	}
+	// administrators are given a global role
+	// rather than a binding on a resource
+	if _, err := revoker.RemoveGlobalRole(ctx, &rbac.AddGlobalRoleRequest{
+		Subject: subject,
+		Role:    RoleSuper,
+	}); err != nil {
+		log.Error(err, "failed to remove global role", "Role", RoleSuper)
+		return err
+	}
+	requests := make([]*rbac.AddRoleRequest, 0, len(jumps))
+	for _, j := range jumps {
+		requests = append(requests, &rbac.AddRoleRequest{
+			Subject:  subject,
+			Resource: schemas.ResourceName(schemas.ResourceJump, j.ID),
+			Action:   rbac.Verb_SUDO,
+		})
+	}
+	for _, r := range requests {
+		if _, err := revoker.RemoveRole(ctx, r); err != nil {
+			log.Error(err, "failed to remove role binding", "Resource", r.Resource)
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangeSource returns the data behind a subscriber's
+// live view of all Users.
+func (svc *UserService) ChangeSource(offset, limit int) *ChangeSource {
+	return &ChangeSource{
+		Page: func(ctx context.Context) (*model.Page, error) {
+			return svc.repos.UserRepo.GetUsers(ctx, offset, limit)
+		},
+		Get: func(ctx context.Context, id uint) (model.Pageable, bool, error) {
+			user, err := svc.repos.UserRepo.GetByID(ctx, id)
+			if err != nil {
+				return nil, false, err
+			}
+			return user.ToUser(), !user.Deactivated, nil
+		},
+	}
+}
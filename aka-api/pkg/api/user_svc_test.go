@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"google.golang.org/grpc"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUserService_Deactivate(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewUserService(ctx, repos, authz, nil)
+	groups := NewGroupService(ctx, repos, authz, nil)
+	jumps := NewJumpService(ctx, repos, authz, false, nil, nil)
+
+	for _, sub := range []string{"john", "jane"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+	admin := withUser(ctx, "admin")
+
+	team, err := groups.Create(john, "team", false, false)
+	require.NoError(t, err)
+	jump, err := jumps.Create(john, CreateJumpOpts{Name: "personal", Location: "https://example.org/personal"})
+	require.NoError(t, err)
+	require.NoError(t, NewAdminService(repos, authz).PromoteAdmin(ctx, "john"))
+
+	t.Run("unknown users", func(t *testing.T) {
+		_, err := svc.Deactivate(admin, "alice", "", true)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("invalid handover", func(t *testing.T) {
+		_, err := svc.Deactivate(admin, "john", "user://john", true)
+		assert.ErrorIs(t, err, ErrInvalidOwner)
+		_, err = svc.Deactivate(admin, "john", "group://100", true)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = svc.Deactivate(admin, "john", "jane", true)
+		assert.ErrorIs(t, err, ErrInvalidOwner)
+	})
+	t.Run("dry run", func(t *testing.T) {
+		report, err := svc.Deactivate(admin, "john", "user://jane", true)
+		require.NoError(t, err)
+		assert.True(t, report.DryRun)
+		require.Len(t, report.Jumps, 1)
+		assert.EqualValues(t, jump.ID, report.Jumps[0].ID)
+		require.Len(t, report.Groups, 1)
+		require.Len(t, report.OwnedGroups, 1)
+
+		// nothing changed
+		j, err := repos.JumpRepo.GetByID(ctx, jump.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "user://john", j.Owner)
+		user, err := repos.UserRepo.Get(ctx, "john")
+		require.NoError(t, err)
+		assert.False(t, user.Deactivated)
+	})
+	t.Run("deactivate", func(t *testing.T) {
+		_, err := svc.Deactivate(admin, "john", "user://jane", false)
+		require.NoError(t, err)
+
+		j, err := repos.JumpRepo.GetByID(ctx, jump.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "user://jane", j.Owner)
+
+		g, err := repos.GroupRepo.GetByID(ctx, team.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, "jane", g.Owner)
+		assert.EqualValues(t, "jane", g.Users)
+
+		for subject, ok := range map[string]bool{"john": false, "jane": true} {
+			resp, err := authz.Can(ctx, &rbac.AccessRequest{
+				Subject:  subject,
+				Resource: schemas.ResourceName(schemas.ResourceJump, jump.ID),
+				Action:   rbac.Verb_SUDO,
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, ok, resp.GetOk(), subject)
+		}
+
+		// and are no longer an administrator
+		resp, err := authz.Can(ctx, &rbac.AccessRequest{Subject: "john", Resource: RoleSuper, Action: rbac.Verb_SUDO})
+		require.NoError(t, err)
+		assert.False(t, resp.GetOk())
+
+		// they're hidden from the list of users
+		page, err := repos.UserRepo.GetUsers(ctx, 0, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, page.Count)
+
+		// and can't log in
+		_, err = svc.CreateOrUpdateUser(ctx, &identity.OAuthUser{Subject: "john"})
+		assert.ErrorIs(t, err, ErrUserDeactivated)
+	})
+	t.Run("deactivated users can't be given things", func(t *testing.T) {
+		_, err := svc.Deactivate(admin, "jane", "user://john", true)
+		assert.ErrorIs(t, err, ErrUserDeactivated)
+	})
+	t.Run("reactivate", func(t *testing.T) {
+		require.NoError(t, svc.Reactivate(admin, "john"))
+		_, err := svc.CreateOrUpdateUser(ctx, &identity.OAuthUser{Subject: "john"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestUserService_RequireActive(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	svc := NewUserService(ctx, repos, newFakeAuthz(), nil)
+
+	_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: "john"})
+	require.NoError(t, err)
+	_, err = repos.UserRepo.Save(ctx, &dao.UserV2{Subject: "jane", Deactivated: true})
+	require.NoError(t, err)
+
+	assert.NoError(t, svc.RequireActive(ctx, "john"))
+	assert.ErrorIs(t, svc.RequireActive(ctx, "jane"), ErrUserDeactivated)
+	// users that haven't logged in yet
+	assert.NoError(t, svc.RequireActive(ctx, "alice"))
+}
+
+func TestUserService_DeactivateKeepsOwner(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := newFakeAuthz()
+	svc := NewUserService(ctx, repos, authz, nil)
+	groups := NewGroupService(ctx, repos, authz, nil)
+
+	for _, sub := range []string{"john", "jane"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+	team, err := groups.Create(john, "team", false, false)
+	require.NoError(t, err)
+	_, err = groups.AddMember(john, int(team.ID), "jane", model.GroupRoleMember)
+	require.NoError(t, err)
+	solo, err := groups.Create(john, "solo", false, false)
+	require.NoError(t, err)
+
+	// the jumps are handed to a group, so the
+	// groups can't be
+	_, err = svc.Deactivate(withUser(ctx, "admin"), "john", "group://"+strconv.FormatUint(uint64(team.ID), 10), false)
+	require.NoError(t, err)
+
+	g, err := repos.GroupRepo.GetByID(ctx, team.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, "jane", g.Owner)
+	member, err := repos.GroupRepo.GetMember(ctx, team.ID, "jane")
+	require.NoError(t, err)
+	assert.EqualValues(t, model.GroupRoleOwner, member.Role)
+
+	g, err = repos.GroupRepo.GetByID(ctx, solo.ID)
+	require.NoError(t, err)
+	assert.True(t, g.Archived)
+}
+
+// flakyAuthz is an authority that fails to create
+// role bindings while broken is set.
+type flakyAuthz struct {
+	*fakeAuthz
+	broken atomic.Bool
+}
+
+func (f *flakyAuthz) AddRole(ctx context.Context, in *rbac.AddRoleRequest, opts ...grpc.CallOption) (*rbac.GenericResponse, error) {
+	if f.broken.Load() {
+		return nil, errors.New("authority is unavailable")
+	}
+	return f.fakeAuthz.AddRole(ctx, in, opts...)
+}
+
+func TestUserService_DeactivateAgain(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	authz := &flakyAuthz{fakeAuthz: newFakeAuthz()}
+	svc := NewUserService(ctx, repos, authz, nil)
+	jumps := NewJumpService(ctx, repos, authz, false, nil, nil)
+
+	for _, sub := range []string{"john", "jane"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	jump, err := jumps.Create(withUser(ctx, "john"), CreateJumpOpts{Name: "personal", Location: "https://example.org/personal"})
+	require.NoError(t, err)
+	resource := schemas.ResourceName(schemas.ResourceJump, jump.ID)
+
+	// handing over the jumps fails
+	authz.broken.Store(true)
+	_, err = svc.Deactivate(withUser(ctx, "admin"), "john", "user://jane", false)
+	require.Error(t, err)
+
+	// but the user has already lost access
+	assert.ErrorIs(t, svc.RequireActive(ctx, "john"), ErrUserDeactivated)
+	assert.NotContains(t, authz.bindings["john"], resource)
+
+	// and running it again finishes the job
+	authz.broken.Store(false)
+	_, err = svc.Deactivate(withUser(ctx, "admin"), "john", "user://jane", false)
+	require.NoError(t, err)
+	j, err := repos.JumpRepo.GetByID(ctx, jump.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, "user://jane", j.Owner)
+	assert.Contains(t, authz.bindings["jane"], resource)
+}
+
+// grantOnly is an authority that can't remove role bindings.
+type grantOnly struct {
+	rbac.AuthorityClient
+}
+
+func TestUserService_DeactivateWithoutRevoke(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(nil)
+	svc := NewUserService(ctx, repos, grantOnly{newFakeAuthz()}, nil)
+
+	_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: "john"})
+	require.NoError(t, err)
+
+	// a dry run doesn't need to remove anything
+	_, err = svc.Deactivate(withUser(ctx, "admin"), "john", "", true)
+	assert.NoError(t, err)
+
+	_, err = svc.Deactivate(withUser(ctx, "admin"), "john", "", false)
+	assert.ErrorIs(t, err, ErrCannotRevoke)
+	user, err := repos.UserRepo.Get(ctx, "john")
+	require.NoError(t, err)
+	assert.False(t, user.Deactivated)
+}
@@ -0,0 +1,614 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Snakdy/go-rbac-proxy/pkg/rbac"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// HeaderWebhookEvent contains the type of event, e.g. jump.updated
+	HeaderWebhookEvent = "X-Aka-Event"
+	// HeaderWebhookDelivery contains the ID of the
+	// WebhookDelivery. It is the same for every attempt.
+	HeaderWebhookDelivery = "X-Aka-Delivery"
+	// HeaderWebhookTimestamp contains the unix time
+	// at which the request was signed.
+	HeaderWebhookTimestamp = "X-Aka-Timestamp"
+	// HeaderWebhookSignature contains the signature
+	// of the request, see SignWebhook.
+	HeaderWebhookSignature = "X-Aka-Signature"
+)
+
+// webhookBatchSize is the number of deliveries
+// that are attempted at a time.
+const webhookBatchSize = 50
+
+// ErrInvalidWebhookURL is returned when a Webhook
+// isn't given an absolute http(s) URL.
+var ErrInvalidWebhookURL = errors.New("webhooks must have an absolute http or https url")
+
+// ErrPrivateWebhookURL is returned when a Webhook points
+// to a loopback, private or link-local address and
+// WebhookOptions.AllowPrivateNetworks isn't set.
+var ErrPrivateWebhookURL = errors.New("webhooks can't send events to private networks")
+
+// WebhookOptions controls how events are
+// delivered to Webhooks.
+type WebhookOptions struct {
+	// Enabled controls whether this instance delivers
+	// events. If more than one instance is running,
+	// it should only be enabled on one of them or events
+	// will be sent more than once.
+	Enabled bool `default:"true"`
+	// MaxAttempts is the number of times that a delivery
+	// is attempted before it is given up on.
+	MaxAttempts int `split_words:"true" default:"8"`
+	// Backoff is how long we wait after the first failed
+	// attempt. It doubles after each attempt, up to
+	// MaxBackoff.
+	Backoff    time.Duration `default:"30s"`
+	MaxBackoff time.Duration `split_words:"true" default:"1h"`
+	// Timeout is how long a receiver has to respond.
+	Timeout time.Duration `default:"10s"`
+	// Interval is how often we check for
+	// deliveries that need to be retried.
+	Interval time.Duration `default:"5s"`
+	// AllowPrivateNetworks lets Webhooks send events to
+	// loopback, private and link-local addresses. Since
+	// any group owner can create a Webhook, this should
+	// only be enabled if they are all trusted.
+	AllowPrivateNetworks bool `split_words:"true"`
+}
+
+// WebhookEvent is the body of each
+// request sent to a Webhook.
+type WebhookEvent struct {
+	ID string `json:"id"`
+	// Type is the kind of change, e.g. jump.created
+	Type string `json:"type"`
+	// Resource is what was changed, e.g. jump://12.
+	// It is empty for resync events.
+	Resource string `json:"resource"`
+	Date     int64  `json:"date"`
+	// Data is the Jump or Group after the change.
+	// It is omitted if it was deleted.
+	Data any `json:"data,omitempty"`
+}
+
+// WebhookService sends changes to Jumps and
+// Groups to the Webhooks that want them.
+type WebhookService struct {
+	repos  *dao.Repos
+	authz  rbac.AuthorityClient
+	opts   WebhookOptions
+	client *http.Client
+	// wake is signalled when there are new
+	// deliveries to be attempted
+	wake chan struct{}
+}
+
+func NewWebhookService(repos *dao.Repos, authz rbac.AuthorityClient, opts WebhookOptions) *WebhookService {
+	return &WebhookService{
+		repos: repos,
+		authz: authz,
+		opts:  opts,
+		client: newWebhookClient(opts),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// newWebhookClient returns an http.Client that doesn't
+// follow redirects. Unless private networks are allowed,
+// it also refuses to connect to private addresses, which
+// is checked after the host has been resolved so that
+// DNS can't be used to get around it.
+func newWebhookClient(opts WebhookOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !opts.AllowPrivateNetworks {
+		dialer := &net.Dialer{
+			Timeout: opts.Timeout,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || policy.IsPrivateIP(ip) {
+					return ErrPrivateWebhookURL
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+		// a proxy would be dialled instead of
+		// the receiver, so don't use one
+		transport.Proxy = nil
+	}
+	return &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// CanManage checks that the current user is allowed
+// to manage the Webhooks of a Group.
+func (svc *WebhookService) CanManage(ctx context.Context, groupID uint) error {
+	return requireGroupRole(ctx, svc.repos, svc.authz, groupID, model.GroupRoleOwner)
+}
+
+// Create adds a Webhook that is told about changes to
+// the Jumps of a Group and the Group itself. If the
+// groupID is 0, it is told about every change. The
+// secret used to sign requests is only returned here.
+func (svc *WebhookService) Create(ctx context.Context, groupID uint, target string) (*model.CreatedWebhook, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_webhook_create", trace.WithAttributes(attribute.Int("groupId", int(groupID))))
+	defer span.End()
+	uri, err := url.Parse(target)
+	if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Host == "" {
+		return nil, ErrInvalidWebhookURL
+	}
+	if !svc.opts.AllowPrivateNetworks && policy.IsPrivate(strings.TrimSuffix(strings.ToLower(uri.Hostname()), ".")) {
+		return nil, ErrPrivateWebhookURL
+	}
+	if groupID != 0 {
+		if _, err := svc.repos.GroupRepo.GetByID(ctx, groupID); err != nil {
+			return nil, ErrNotFound
+		}
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	hook := &model.Webhook{
+		GroupID:   groupID,
+		URL:       uri.String(),
+		Secret:    hex.EncodeToString(raw),
+		CreatedBy: GetUsernameCtx(ctx),
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := svc.repos.WebhookRepo.Create(ctx, hook); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	logr.FromContextOrDiscard(ctx).Info("created webhook", "ID", hook.ID, "GroupID", groupID, "URL", hook.URL)
+	return &model.CreatedWebhook{
+		Secret:  hook.Secret,
+		Webhook: hook,
+	}, nil
+}
+
+// Get returns a Webhook by its ID
+func (svc *WebhookService) Get(ctx context.Context, id uint) (*model.Webhook, error) {
+	hook, err := svc.repos.WebhookRepo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return hook, nil
+}
+
+// List returns the Webhooks that belong to a Group, or
+// those that are told about everything if groupID is 0.
+func (svc *WebhookService) List(ctx context.Context, groupID uint) ([]*model.Webhook, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_webhook_list", trace.WithAttributes(attribute.Int("groupId", int(groupID))))
+	defer span.End()
+	return svc.repos.WebhookRepo.GetByGroup(ctx, groupID)
+}
+
+// Delete removes a Webhook. Anything that
+// hasn't been delivered yet is dropped.
+func (svc *WebhookService) Delete(ctx context.Context, id uint) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_webhook_delete", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	logr.FromContextOrDiscard(ctx).Info("deleting webhook", "ID", id)
+	return svc.repos.WebhookRepo.Delete(ctx, id)
+}
+
+// GetDelivery returns a WebhookDelivery by its ID
+func (svc *WebhookService) GetDelivery(ctx context.Context, id uint) (*model.WebhookDelivery, error) {
+	d, err := svc.repos.WebhookRepo.GetDelivery(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// Deliveries returns the deliveries made to a Webhook,
+// newest first. Deliveries that have been given up
+// on can be found using model.DeliveryStatusDead.
+func (svc *WebhookService) Deliveries(ctx context.Context, webhookID uint, status model.DeliveryStatus, offset, limit int) ([]*model.WebhookDelivery, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_webhook_deliveries", trace.WithAttributes(attribute.Int("webhookId", int(webhookID))))
+	defer span.End()
+	return svc.repos.WebhookRepo.GetDeliveries(ctx, webhookID, status, offset, limit)
+}
+
+// Redeliver tries to deliver an event again, usually
+// one that has been given up on. It is attempted as
+// soon as possible with a fresh set of attempts.
+func (svc *WebhookService) Redeliver(ctx context.Context, id uint) (*model.WebhookDelivery, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_webhook_redeliver", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	d, err := svc.GetDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	logr.FromContextOrDiscard(ctx).Info("redelivering webhook event", "ID", id, "WebhookID", d.WebhookID, "Status", d.Status)
+	now := time.Now().Unix()
+	d.Status = model.DeliveryStatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = now
+	d.UpdatedAt = now
+	if err := svc.repos.WebhookRepo.SaveDelivery(ctx, d); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	svc.notify()
+	return d, nil
+}
+
+// Run delivers changes to Jumps and Groups from the
+// feed in the background until the context is
+// cancelled. Failed deliveries are retried.
+//
+// Changes are queued and delivered separately, so
+// that slow receivers don't hold up the feed.
+func (svc *WebhookService) Run(ctx context.Context, feed dao.ChangeFeed) error {
+	log := logr.FromContextOrDiscard(ctx)
+	var jumps, groups <-chan *dao.Message
+	if feed == nil {
+		log.Info("no change feed has been configured, webhooks will not receive events")
+	} else {
+		var err error
+		if jumps, err = feed.Subscribe(ctx, model.TableNameJumps); err != nil {
+			log.Error(err, "failed to subscribe to change feed")
+			return err
+		}
+		if groups, err = feed.Subscribe(ctx, model.TableNameGroups); err != nil {
+			log.Error(err, "failed to subscribe to change feed")
+			return err
+		}
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-jumps:
+				if !ok {
+					jumps = nil
+					continue
+				}
+				_ = svc.Enqueue(ctx, msg)
+			case msg, ok := <-groups:
+				if !ok {
+					groups = nil
+					continue
+				}
+				_ = svc.Enqueue(ctx, msg)
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(svc.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-svc.wake:
+			case <-ticker.C:
+			}
+			_ = svc.Dispatch(ctx, time.Now())
+		}
+	}()
+	return nil
+}
+
+// Enqueue creates a delivery of the change described by
+// a message for each Webhook that wants to know about it.
+//
+// If changes may have been missed (i.e. the message is a
+// dao.OperationResync) every Webhook is sent a resync
+// event, such as jump.resync, so that receivers know
+// to check everything again.
+func (svc *WebhookService) Enqueue(ctx context.Context, msg *dao.Message) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Table", msg.Table, "ID", msg.ID, "Operation", msg.Operation)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_webhook_enqueue", trace.WithAttributes(
+		attribute.String("table", msg.Table),
+		attribute.Int("id", msg.ID),
+	))
+	defer span.End()
+	var evt *WebhookEvent
+	var hooks []*model.Webhook
+	if msg.Operation == dao.OperationResync {
+		evt = svc.describeResync(msg)
+		if evt == nil {
+			return nil
+		}
+		log.Info("changes may have been missed, telling webhooks to resync")
+		var err error
+		if hooks, err = svc.repos.WebhookRepo.GetAll(ctx); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	} else {
+		var groupID uint
+		var err error
+		evt, groupID, err = svc.describe(ctx, msg)
+		if err != nil {
+			span.RecordError(err)
+			log.Error(err, "failed to describe change")
+			return err
+		}
+		if evt == nil {
+			return nil
+		}
+		// webhooks with no group want to
+		// know about everything
+		groupIDs := []uint{0}
+		if groupID != 0 {
+			groupIDs = append(groupIDs, groupID)
+		}
+		if hooks, err = svc.repos.WebhookRepo.GetByGroup(ctx, groupIDs...); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to marshal webhook event")
+		return err
+	}
+	log.V(1).Info("queueing webhook deliveries", "Event", evt.Type, "Count", len(hooks))
+	for _, hook := range hooks {
+		if err := svc.repos.WebhookRepo.CreateDelivery(ctx, &model.WebhookDelivery{
+			WebhookID:     hook.ID,
+			Event:         evt.Type,
+			Payload:       string(payload),
+			Status:        model.DeliveryStatusPending,
+			CreatedAt:     evt.Date,
+			UpdatedAt:     evt.Date,
+			NextAttemptAt: evt.Date,
+		}); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+	svc.notify()
+	return nil
+}
+
+// describe converts a message into a WebhookEvent, along
+// with the ID of the Group that it concerns (if any). If
+// the message isn't about a Jump or Group, the event is nil.
+func (svc *WebhookService) describe(ctx context.Context, msg *dao.Message) (*WebhookEvent, uint, error) {
+	var kind schemas.Name
+	var data any
+	var groupID uint
+	switch msg.Table {
+	case model.TableNameJumps:
+		kind = schemas.ResourceJump
+		// deleted Jumps only have the owner
+		// that was copied into the message
+		jump := &model.Jump{Owner: msg.Owner}
+		if msg.Operation != "DELETE" {
+			found, err := svc.repos.JumpRepo.GetByID(ctx, uint(msg.ID))
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, 0, err
+			}
+			if found != nil {
+				jump, data = found, found
+			}
+		}
+		groupID, _ = jump.GroupID()
+	case model.TableNameGroups:
+		kind = schemas.ResourceGroup
+		groupID = uint(msg.ID)
+		if msg.Operation != "DELETE" {
+			// soft-deleted Groups can't be found
+			found, err := svc.repos.GroupRepo.GetByID(ctx, uint(msg.ID))
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, 0, err
+			}
+			if found != nil {
+				data = found
+			}
+		}
+	default:
+		return nil, 0, nil
+	}
+	action := "deleted"
+	if data != nil {
+		action = "updated"
+		if msg.Operation == "INSERT" {
+			action = "created"
+		}
+	}
+	raw := make([]byte, 16)
+	_, _ = rand.Read(raw)
+	return &WebhookEvent{
+		ID:       hex.EncodeToString(raw),
+		Type:     string(kind) + "." + action,
+		Resource: schemas.ResourceName(kind, msg.ID),
+		Date:     time.Now().Unix(),
+		Data:     data,
+	}, groupID, nil
+}
+
+// describeResync converts a dao.OperationResync message
+// into a WebhookEvent. It has no Resource or Data since
+// anything in the table may have changed. If the message
+// isn't about Jumps or Groups, the event is nil.
+func (svc *WebhookService) describeResync(msg *dao.Message) *WebhookEvent {
+	var kind schemas.Name
+	switch msg.Table {
+	case model.TableNameJumps:
+		kind = schemas.ResourceJump
+	case model.TableNameGroups:
+		kind = schemas.ResourceGroup
+	default:
+		return nil
+	}
+	raw := make([]byte, 16)
+	_, _ = rand.Read(raw)
+	return &WebhookEvent{
+		ID:   hex.EncodeToString(raw),
+		Type: string(kind) + ".resync",
+		Date: time.Now().Unix(),
+	}
+}
+
+// Dispatch attempts every delivery that is due.
+func (svc *WebhookService) Dispatch(ctx context.Context, now time.Time) error {
+	for {
+		due, err := svc.repos.WebhookRepo.GetDue(ctx, now.Unix(), webhookBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, d := range due {
+			if err := svc.attempt(ctx, d, now); err != nil {
+				return err
+			}
+		}
+		// failed deliveries are rescheduled, so
+		// they won't be returned again
+		if len(due) < webhookBatchSize {
+			return nil
+		}
+	}
+}
+
+// attempt sends a delivery to its Webhook. If it fails, it
+// is retried later unless we've run out of attempts.
+func (svc *WebhookService) attempt(ctx context.Context, d *model.WebhookDelivery, now time.Time) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", d.ID, "WebhookID", d.WebhookID, "Event", d.Event)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_webhook_attempt", trace.WithAttributes(
+		attribute.Int("id", int(d.ID)),
+		attribute.Int("webhookId", int(d.WebhookID)),
+	))
+	defer span.End()
+	hook, err := svc.repos.WebhookRepo.Get(ctx, d.WebhookID)
+	if err != nil {
+		// the Webhook was deleted while we were
+		// working, which takes its deliveries too
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		span.RecordError(err)
+		return err
+	}
+	d.Attempts++
+	d.UpdatedAt = now.Unix()
+	d.ResponseCode, err = svc.send(ctx, hook, d, now)
+	if err == nil {
+		log.V(1).Info("delivered webhook event", "Attempts", d.Attempts)
+		d.Status = model.DeliveryStatusDelivered
+		d.LastError = ""
+	} else {
+		span.RecordError(err)
+		d.LastError = err.Error()
+		if d.Attempts >= svc.opts.MaxAttempts {
+			log.Info("giving up on webhook delivery", "Attempts", d.Attempts, "error", d.LastError)
+			d.Status = model.DeliveryStatusDead
+		} else {
+			d.NextAttemptAt = now.Add(svc.backoff(d.Attempts)).Unix()
+			log.V(1).Info("failed to deliver webhook event, it will be retried", "Attempts", d.Attempts, "NextAttemptAt", d.NextAttemptAt, "error", d.LastError)
+		}
+	}
+	metricWebhookAttempts.Add(ctx, 1, metric.WithAttributes(attribute.String("status", string(d.Status))))
+	return svc.repos.WebhookRepo.SaveDelivery(ctx, d)
+}
+
+// send makes the request to a Webhook and returns
+// the status code. Anything other than a 2xx
+// response is an error, including redirects.
+func (svc *WebhookService) send(ctx context.Context, hook *model.Webhook, d *model.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookEvent, d.Event)
+	req.Header.Set(HeaderWebhookDelivery, strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set(HeaderWebhookTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderWebhookSignature, SignWebhook(hook.Secret, now.Unix(), body))
+	resp, err := svc.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns how long to wait before the next
+// attempt, after a given number of failed attempts.
+func (svc *WebhookService) backoff(attempts int) time.Duration {
+	d := svc.opts.Backoff
+	for i := 1; i < attempts && d < svc.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, svc.opts.MaxBackoff)
+}
+
+// notify wakes up the dispatcher without blocking
+func (svc *WebhookService) notify() {
+	select {
+	case svc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SignWebhook returns the signature of a request to a
+// Webhook. It is the hex-encoded HMAC-SHA256 of the
+// timestamp and body joined by a '.', using the
+// Webhook's secret as the key.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%d.", timestamp)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
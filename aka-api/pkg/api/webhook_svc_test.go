@@ -0,0 +1,323 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/memory"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver is a local webhook receiver that
+// records the events that it is sent.
+type receiver struct {
+	t       *testing.T
+	secrets map[string]string
+	status  atomic.Int32
+
+	mu     sync.Mutex
+	events []WebhookEvent
+	// hooks contains the path that
+	// each event was sent to
+	hooks []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(rc.t, err)
+	ts, err := strconv.ParseInt(r.Header.Get(HeaderWebhookTimestamp), 10, 64)
+	require.NoError(rc.t, err)
+	assert.EqualValues(rc.t, SignWebhook(rc.secrets[r.URL.Path], ts, body), r.Header.Get(HeaderWebhookSignature))
+	assert.NotEmpty(rc.t, r.Header.Get(HeaderWebhookDelivery))
+
+	var evt WebhookEvent
+	require.NoError(rc.t, json.Unmarshal(body, &evt))
+	assert.EqualValues(rc.t, evt.Type, r.Header.Get(HeaderWebhookEvent))
+
+	status := int(rc.status.Load())
+	if status == http.StatusOK {
+		rc.mu.Lock()
+		rc.events = append(rc.events, evt)
+		rc.hooks = append(rc.hooks, r.URL.Path)
+		rc.mu.Unlock()
+	}
+	w.WriteHeader(status)
+}
+
+// received returns the events received since
+// the last call, along with their paths.
+func (rc *receiver) received() ([]WebhookEvent, []string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	events, hooks := rc.events, rc.hooks
+	rc.events, rc.hooks = nil, nil
+	return events, hooks
+}
+
+func TestWebhookService(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	feed := dao.NewLocalFeed(ctx)
+	repos := memory.NewRepos(feed)
+	authz := newFakeAuthz()
+	groups := NewGroupService(ctx, repos, authz, nil)
+	svc := NewWebhookService(repos, authz, WebhookOptions{
+		MaxAttempts: 3,
+		Backoff:     time.Minute,
+		MaxBackoff:  time.Hour,
+		Timeout:     time.Second,
+		Interval:    10 * time.Millisecond,
+		// the receiver is on localhost
+		AllowPrivateNetworks: true,
+	})
+
+	for _, sub := range []string{"john", "jane"} {
+		_, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: sub})
+		require.NoError(t, err)
+	}
+	john := withUser(ctx, "john")
+	jane := withUser(ctx, "jane")
+	group, err := groups.Create(john, "team", false, false)
+	require.NoError(t, err)
+
+	rc := &receiver{t: t, secrets: map[string]string{}}
+	rc.status.Store(http.StatusOK)
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	var global, team *model.Webhook
+	t.Run("only group owners can manage webhooks", func(t *testing.T) {
+		assert.ErrorIs(t, svc.CanManage(jane, group.ID), ErrForbidden)
+		assert.NoError(t, svc.CanManage(john, group.ID))
+	})
+	t.Run("create", func(t *testing.T) {
+		_, err := svc.Create(john, group.ID, "ftp://example.org")
+		assert.ErrorIs(t, err, ErrInvalidWebhookURL)
+		_, err = svc.Create(john, group.ID, "/hooks")
+		assert.ErrorIs(t, err, ErrInvalidWebhookURL)
+		_, err = svc.Create(john, 100, srv.URL)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		created, err := svc.Create(john, 0, srv.URL+"/global")
+		require.NoError(t, err)
+		assert.NotEmpty(t, created.Secret)
+		global = created.Webhook
+		rc.secrets["/global"] = created.Secret
+
+		created, err = svc.Create(john, group.ID, srv.URL+"/team")
+		require.NoError(t, err)
+		team = created.Webhook
+		rc.secrets["/team"] = created.Secret
+
+		hooks, err := svc.List(ctx, group.ID)
+		require.NoError(t, err)
+		assert.Len(t, hooks, 1)
+	})
+	// drop the changes made so far
+	_, _ = rc.received()
+
+	teamJump, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: "docs", Location: "https://example.org", Owner: "group://" + strconv.FormatUint(uint64(group.ID), 10)})
+	require.NoError(t, err)
+	userJump, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: "mine", Location: "https://example.com", Owner: "user://jane"})
+	require.NoError(t, err)
+
+	t.Run("events are sent to the webhooks that want them", func(t *testing.T) {
+		require.NoError(t, svc.Enqueue(ctx, &dao.Message{Operation: "INSERT", ID: int(teamJump.ID), Table: model.TableNameJumps}))
+		require.NoError(t, svc.Dispatch(ctx, time.Now()))
+		events, hooks := rc.received()
+		require.Len(t, events, 2)
+		assert.ElementsMatch(t, []string{"/global", "/team"}, hooks)
+		assert.EqualValues(t, "jump.created", events[0].Type)
+		assert.EqualValues(t, "jump://"+strconv.FormatUint(uint64(teamJump.ID), 10), events[0].Resource)
+		assert.EqualValues(t, "docs", events[0].Data.(map[string]any)["name"])
+
+		// the group doesn't own this one
+		require.NoError(t, svc.Enqueue(ctx, &dao.Message{Operation: "UPDATE", ID: int(userJump.ID), Table: model.TableNameJumps}))
+		require.NoError(t, svc.Dispatch(ctx, time.Now()))
+		events, hooks = rc.received()
+		require.Len(t, events, 1)
+		assert.EqualValues(t, []string{"/global"}, hooks)
+		assert.EqualValues(t, "jump.updated", events[0].Type)
+
+		// deleted jumps are described by the message
+		require.NoError(t, svc.Enqueue(ctx, &dao.Message{Operation: "DELETE", ID: 100, Table: model.TableNameJumps, Owner: teamJump.Owner}))
+		require.NoError(t, svc.Dispatch(ctx, time.Now()))
+		events, _ = rc.received()
+		require.Len(t, events, 2)
+		assert.EqualValues(t, "jump.deleted", events[0].Type)
+		assert.Nil(t, events[0].Data)
+
+		// changes to the group itself
+		require.NoError(t, svc.Enqueue(ctx, &dao.Message{Operation: "UPDATE", ID: int(group.ID), Table: model.TableNameGroups}))
+		require.NoError(t, svc.Dispatch(ctx, time.Now()))
+		events, _ = rc.received()
+		require.Len(t, events, 2)
+		assert.EqualValues(t, "group.updated", events[0].Type)
+	})
+	t.Run("every webhook is told about missed changes", func(t *testing.T) {
+		require.NoError(t, svc.Enqueue(ctx, &dao.Message{Operation: dao.OperationResync, Table: model.TableNameJumps}))
+		require.NoError(t, svc.Dispatch(ctx, time.Now()))
+		events, hooks := rc.received()
+		require.Len(t, events, 2)
+		assert.ElementsMatch(t, []string{"/global", "/team"}, hooks)
+		assert.EqualValues(t, "jump.resync", events[0].Type)
+		assert.Empty(t, events[0].Resource)
+		assert.Nil(t, events[0].Data)
+	})
+	t.Run("failed deliveries are retried and eventually given up on", func(t *testing.T) {
+		rc.status.Store(http.StatusInternalServerError)
+		now := time.Now()
+		require.NoError(t, svc.Enqueue(ctx, &dao.Message{Operation: "UPDATE", ID: int(teamJump.ID), Table: model.TableNameJumps}))
+		require.NoError(t, svc.Dispatch(ctx, now))
+
+		deliveries, err := svc.Deliveries(ctx, team.ID, model.DeliveryStatusPending, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		d := deliveries[0]
+		assert.EqualValues(t, 1, d.Attempts)
+		assert.EqualValues(t, http.StatusInternalServerError, d.ResponseCode)
+		assert.NotEmpty(t, d.LastError)
+		assert.EqualValues(t, now.Add(time.Minute).Unix(), d.NextAttemptAt)
+
+		// nothing happens until the back-off has passed
+		require.NoError(t, svc.Dispatch(ctx, now.Add(30*time.Second)))
+		d, err = svc.GetDelivery(ctx, d.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, d.Attempts)
+
+		// and it doubles each time
+		require.NoError(t, svc.Dispatch(ctx, now.Add(time.Minute)))
+		d, err = svc.GetDelivery(ctx, d.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, d.Attempts)
+		assert.EqualValues(t, now.Add(3*time.Minute).Unix(), d.NextAttemptAt)
+
+		require.NoError(t, svc.Dispatch(ctx, now.Add(3*time.Minute)))
+		dead, err := svc.Deliveries(ctx, team.ID, model.DeliveryStatusDead, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+		assert.EqualValues(t, d.ID, dead[0].ID)
+		assert.EqualValues(t, 3, dead[0].Attempts)
+	})
+	t.Run("dead deliveries can be redelivered", func(t *testing.T) {
+		rc.status.Store(http.StatusOK)
+		dead, err := svc.Deliveries(ctx, team.ID, model.DeliveryStatusDead, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+
+		d, err := svc.Redeliver(ctx, dead[0].ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, model.DeliveryStatusPending, d.Status)
+		assert.Zero(t, d.Attempts)
+		_, _ = rc.received()
+
+		require.NoError(t, svc.Dispatch(ctx, time.Now()))
+		d, err = svc.GetDelivery(ctx, d.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, model.DeliveryStatusDelivered, d.Status)
+		_, hooks := rc.received()
+		assert.EqualValues(t, []string{"/team"}, hooks)
+	})
+	t.Run("changes are delivered from the feed", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		require.NoError(t, svc.Run(ctx, feed))
+
+		jump, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: "live", Location: "https://example.org/live", Owner: teamJump.Owner})
+		require.NoError(t, err)
+		var events []WebhookEvent
+		assert.Eventually(t, func() bool {
+			evts, _ := rc.received()
+			events = append(events, evts...)
+			return len(events) >= 2
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Len(t, events, 2)
+		assert.EqualValues(t, "jump.created", events[0].Type)
+		assert.EqualValues(t, "jump://"+strconv.FormatUint(uint64(jump.ID), 10), events[0].Resource)
+	})
+	t.Run("deleting a webhook removes its deliveries", func(t *testing.T) {
+		require.NoError(t, svc.Delete(ctx, global.ID))
+		_, err := svc.Get(ctx, global.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		deliveries, err := svc.Deliveries(ctx, global.ID, "", 0, -1)
+		require.NoError(t, err)
+		assert.Empty(t, deliveries)
+	})
+}
+
+func TestWebhookService_privateNetworks(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	repos := memory.NewRepos(dao.NewLocalFeed(ctx))
+	svc := NewWebhookService(repos, newFakeAuthz(), WebhookOptions{Timeout: time.Second})
+
+	var called atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}))
+	defer srv.Close()
+
+	t.Run("private urls can't be used", func(t *testing.T) {
+		for _, target := range []string{
+			srv.URL,
+			"http://localhost:8080/hook",
+			"http://169.254.169.254/latest/meta-data",
+			"https://10.0.0.1/hook",
+			"http://0x7f.1/hook",
+		} {
+			_, err := svc.Create(withUser(ctx, "admin"), 0, target)
+			assert.ErrorIs(t, err, ErrPrivateWebhookURL, target)
+		}
+	})
+	t.Run("resolved addresses are checked", func(t *testing.T) {
+		hook := &model.Webhook{URL: srv.URL, Secret: "secret"}
+		_, err := svc.send(ctx, hook, &model.WebhookDelivery{Payload: "{}"}, time.Now())
+		assert.ErrorIs(t, err, ErrPrivateWebhookURL)
+		assert.False(t, called.Load())
+	})
+}
+
+func TestWebhookService_redirects(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	svc := NewWebhookService(nil, nil, WebhookOptions{Timeout: time.Second, AllowPrivateNetworks: true})
+
+	var followed atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		followed.Store(true)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	hook := &model.Webhook{URL: srv.URL + "/hook", Secret: "secret"}
+	code, err := svc.send(ctx, hook, &model.WebhookDelivery{Payload: "{}"}, time.Now())
+	assert.Error(t, err)
+	assert.EqualValues(t, http.StatusTemporaryRedirect, code)
+	assert.False(t, followed.Load())
+}
+
+func TestWebhookService_backoff(t *testing.T) {
+	svc := NewWebhookService(nil, nil, WebhookOptions{Backoff: time.Second, MaxBackoff: 5 * time.Second})
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  5 * time.Second,
+		20: 5 * time.Second,
+	} {
+		assert.EqualValues(t, want, svc.backoff(attempts), "attempts: %d", attempts)
+	}
+}
@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the version of the archive
+// layout written by this package.
+const FormatVersion = 1
+
+const manifestName = "manifest.json"
+
+// chunkSize is roughly how many bytes of a table are
+// held in memory before they're written to the archive.
+const chunkSize = 1 << 20
+
+// Table names used within the archive
+const (
+	TableGroups       = "groups"
+	TableGroupMembers = "group_members"
+	TableUsers        = "users"
+	TableJumps        = "jumps"
+	TableJumpEvents   = "jump_events"
+	TableRoleBindings = "role_bindings"
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported archive version")
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Tables    map[string]int `json:"tables"`
+}
+
+// Writer streams a gzipped tarball containing each
+// table as a series of JSON lines files, followed by a
+// manifest. Only the rows that haven't been written to
+// the archive yet are held in memory.
+type Writer struct {
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	tables map[string]*bytes.Buffer
+	counts map[string]int
+	chunks map[string]int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	gz := gzip.NewWriter(w)
+	return &Writer{
+		gz:     gz,
+		tw:     tar.NewWriter(gz),
+		tables: map[string]*bytes.Buffer{},
+		counts: map[string]int{},
+		chunks: map[string]int{},
+	}
+}
+
+// Add appends a row to the given table. Rows are
+// written to the archive once enough of them have
+// been added.
+func (w *Writer) Add(table string, v any) error {
+	buf, ok := w.tables[table]
+	if !ok {
+		buf = new(bytes.Buffer)
+		w.tables[table] = buf
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s row: %w", table, err)
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+	w.counts[table]++
+	if buf.Len() >= chunkSize {
+		return w.flush(table)
+	}
+	return nil
+}
+
+// flush writes the rows of a table that are
+// held in memory to the archive as a new file
+// (e.g. jumps/000001.jsonl).
+func (w *Writer) flush(table string) error {
+	buf := w.tables[table]
+	if buf.Len() == 0 {
+		return nil
+	}
+	w.chunks[table]++
+	if err := writeFile(w.tw, fmt.Sprintf("%s/%06d.jsonl", table, w.chunks[table]), buf.Bytes()); err != nil {
+		return err
+	}
+	buf.Reset()
+	return nil
+}
+
+// Close writes any remaining rows and the manifest,
+// and finishes the archive. The manifest comes last
+// so that an archive that wasn't closed can't be read.
+func (w *Writer) Close() error {
+	// sort the tables so that the archive
+	// is reproducible
+	names := make([]string, 0, len(w.tables))
+	for k := range w.tables {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := w.flush(name); err != nil {
+			return err
+		}
+	}
+	manifest, err := json.Marshal(&Manifest{
+		Version:   FormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Tables:    w.counts,
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFile(w.tw, manifestName, manifest); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return fmt.Errorf("writing header for %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// Reader provides access to the contents of an
+// archive created by Writer.
+type Reader struct {
+	Manifest Manifest
+	tables   map[string][]byte
+}
+
+// NewReader reads an entire archive into memory
+// and validates its manifest.
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("opening archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	br := &Reader{tables: map[string][]byte{}}
+	var foundManifest bool
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", hdr.Name, err)
+		}
+		if hdr.Name == manifestName {
+			if err := json.Unmarshal(data, &br.Manifest); err != nil {
+				return nil, fmt.Errorf("parsing manifest: %w", err)
+			}
+			foundManifest = true
+			continue
+		}
+		// tables are split across files, which
+		// are in the order they were written
+		if table, _, ok := strings.Cut(hdr.Name, "/"); ok && path.Ext(hdr.Name) == ".jsonl" {
+			br.tables[table] = append(br.tables[table], data...)
+		}
+	}
+	if !foundManifest {
+		return nil, errors.New("archive is missing a manifest")
+	}
+	if br.Manifest.Version < 1 || br.Manifest.Version > FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, br.Manifest.Version)
+	}
+	return br, nil
+}
+
+// Each calls f with every row in the given table.
+func (r *Reader) Each(table string, f func(data json.RawMessage) error) error {
+	s := bufio.NewScanner(bytes.NewReader(r.tables[table]))
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		if err := f(s.Bytes()); err != nil {
+			return fmt.Errorf("reading %s row: %w", table, err)
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	require.NoError(t, w.Add(TableJumps, &model.Jump{Model: gorm.Model{ID: 1}, Name: "foo", Owner: "group://2"}))
+	require.NoError(t, w.Add(TableJumps, &model.Jump{Model: gorm.Model{ID: 3}, Name: "bar"}))
+	require.NoError(t, w.Add(TableGroups, &model.Group{Model: gorm.Model{ID: 2}, Name: "team"}))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(buf)
+	require.NoError(t, err)
+	assert.EqualValues(t, FormatVersion, r.Manifest.Version)
+	assert.EqualValues(t, map[string]int{TableJumps: 2, TableGroups: 1}, r.Manifest.Tables)
+
+	var names []string
+	err = r.Each(TableJumps, func(data json.RawMessage) error {
+		var j model.Jump
+		if err := json.Unmarshal(data, &j); err != nil {
+			return err
+		}
+		names = append(names, j.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"foo", "bar"}, names)
+
+	// tables that weren't written are empty
+	assert.NoError(t, r.Each(TableUsers, func(json.RawMessage) error {
+		t.Fatal("users table should be empty")
+		return nil
+	}))
+}
+
+func TestArchiveStreams(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	// add a few chunks worth of rows
+	count := 3 * chunkSize / 100
+	for i := range count {
+		require.NoError(t, w.Add(TableJumpEvents, &model.JumpEvent{JumpID: uint(i), UserID: strings.Repeat(strconv.Itoa(i), 10)}))
+	}
+	// and they're written before the archive is closed
+	assert.NotZero(t, buf.Len())
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(buf)
+	require.NoError(t, err)
+	assert.EqualValues(t, count, r.Manifest.Tables[TableJumpEvents])
+
+	// and are read back in order across files
+	var ids []uint
+	require.NoError(t, r.Each(TableJumpEvents, func(data json.RawMessage) error {
+		var e model.JumpEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		ids = append(ids, e.JumpID)
+		return nil
+	}))
+	require.Len(t, ids, count)
+	for i, id := range ids {
+		assert.EqualValues(t, i, id)
+	}
+}
+
+func TestNewReaderVersion(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("not an archive")))
+	assert.Error(t, err)
+}
+
+func TestIDMap(t *testing.T) {
+	m := NewIDMap()
+	m.Set(schemas.ResourceGroup, 2, 20)
+	m.Set(schemas.ResourceJump, 1, 10)
+
+	var cases = []struct {
+		in       string
+		expected string
+		ok       bool
+	}{
+		{"group://2", "group://20", true},
+		{"jump://1", "jump://10", true},
+		{"jump://5", "jump://5", false},
+		{"SUPER", "SUPER", true},
+		{"other://1", "other://1", true},
+		{"group://3", "group://3", false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			out, ok := m.Resource(tt.in)
+			assert.EqualValues(t, tt.ok, ok)
+			assert.EqualValues(t, tt.expected, out)
+		})
+	}
+
+	owner, ok := m.Owner("user://jane")
+	assert.True(t, ok)
+	assert.EqualValues(t, "user://jane", owner)
+	owner, ok = m.Owner("group://2")
+	assert.True(t, ok)
+	assert.EqualValues(t, "group://20", owner)
+}
+
+func TestOwnerBindings(t *testing.T) {
+	bindings := OwnerBindings([]*model.Jump{
+		{Model: gorm.Model{ID: 1}, Owner: "user://jane"},
+		{Model: gorm.Model{ID: 2}, Owner: "group://1"},
+		{Model: gorm.Model{ID: 3}, Owner: ""},
+	})
+	assert.EqualValues(t, []RoleBinding{
+		{Subject: "jane", Resource: "jump://1", Action: "SUDO"},
+	}, bindings)
+}
+
+func TestMemberBindings(t *testing.T) {
+	bindings := MemberBindings([]*model.GroupMember{
+		{GroupID: 1, Subject: "john", Role: model.GroupRoleOwner},
+		{GroupID: 1, Subject: "jane", Role: model.GroupRoleMaintainer},
+		{GroupID: 2, Subject: "bob", Role: model.GroupRoleMember},
+	})
+	assert.EqualValues(t, []RoleBinding{
+		{Subject: "john", Resource: "group://1", Action: "SUDO"},
+		{Subject: "jane", Resource: "group://1", Action: "UPDATE"},
+		{Subject: "bob", Resource: "group://2", Action: "READ"},
+	}, bindings)
+}
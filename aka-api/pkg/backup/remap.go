@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"strconv"
+	"strings"
+)
+
+// RoleBinding is an RBAC role binding that aka
+// creates for a resource.
+type RoleBinding struct {
+	Subject  string `json:"subject"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+// OwnerBindings returns the role bindings that
+// aka creates for the users that own Jumps.
+func OwnerBindings(jumps []*model.Jump) []RoleBinding {
+	var bindings []RoleBinding
+	for _, j := range jumps {
+		subject, ok := strings.CutPrefix(j.Owner, "user://")
+		if !ok || subject == "" {
+			continue
+		}
+		bindings = append(bindings, RoleBinding{
+			Subject:  subject,
+			Resource: schemas.ResourceName(schemas.ResourceJump, j.ID),
+			Action:   string(model.VerbSudo),
+		})
+	}
+	return bindings
+}
+
+// MemberBindings returns the role bindings that aka
+// creates for the members of a Group, based on
+// their GroupRole.
+func MemberBindings(members []*model.GroupMember) []RoleBinding {
+	bindings := make([]RoleBinding, 0, len(members))
+	for _, m := range members {
+		bindings = append(bindings, RoleBinding{
+			Subject:  m.Subject,
+			Resource: schemas.ResourceName(schemas.ResourceGroup, m.GroupID),
+			Action:   string(m.Role.Verb()),
+		})
+	}
+	return bindings
+}
+
+// IDMap records how the primary keys of restored
+// rows map onto the primary keys they were given.
+type IDMap struct {
+	ids map[schemas.Name]map[uint]uint
+}
+
+func NewIDMap() *IDMap {
+	return &IDMap{ids: map[schemas.Name]map[uint]uint{}}
+}
+
+// Set records that the resource previously known
+// as old is now known as updated.
+func (m *IDMap) Set(resource schemas.Name, old, updated uint) {
+	if _, ok := m.ids[resource]; !ok {
+		m.ids[resource] = map[uint]uint{}
+	}
+	m.ids[resource][old] = updated
+}
+
+// Get returns the new ID of a resource.
+func (m *IDMap) Get(resource schemas.Name, old uint) (uint, bool) {
+	id, ok := m.ids[resource][old]
+	return id, ok
+}
+
+// Resource rewrites a resource name (e.g. jump://1)
+// to point to the new ID. Resources that aren't Jumps
+// or Groups are returned as-is.
+func (m *IDMap) Resource(name string) (string, bool) {
+	kind, id, ok := strings.Cut(name, "://")
+	if !ok {
+		return name, true
+	}
+	resource := schemas.Name(kind)
+	if resource != schemas.ResourceJump && resource != schemas.ResourceGroup {
+		return name, true
+	}
+	old, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return name, false
+	}
+	updated, ok := m.Get(resource, uint(old))
+	if !ok {
+		return name, false
+	}
+	return schemas.ResourceName(resource, updated), true
+}
+
+// Owner rewrites the owner of a Jump. User owners
+// are left alone since they are keyed by subject
+// rather than ID.
+func (m *IDMap) Owner(owner string) (string, bool) {
+	if !strings.HasPrefix(owner, string(schemas.ResourceGroup)+"://") {
+		return owner, true
+	}
+	return m.Resource(owner)
+}
@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+type AuditRepo struct {
+	Repository
+}
+
+// Create stores a new AuditEvent
+func (r *AuditRepo) Create(ctx context.Context, e *model.AuditEvent) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_audit_create", trace.WithAttributes(
+		attribute.String("action", e.Action),
+		attribute.String("resource", e.Resource),
+	))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Create(e).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to create audit event")
+		return err
+	}
+	return nil
+}
+
+// Find returns the AuditEvents that match a
+// filter, newest first.
+func (r *AuditRepo) Find(ctx context.Context, filter model.AuditFilter, offset, limit int) ([]*model.AuditEvent, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_audit_find")
+	defer span.End()
+	tx := r.db.WithContext(ctx)
+	if filter.Actor != "" {
+		tx = tx.Where("actor = ?", filter.Actor)
+	}
+	if filter.Resource != "" {
+		tx = tx.Where("resource = ?", filter.Resource)
+	}
+	if filter.Since > 0 {
+		tx = tx.Where("date >= ?", filter.Since)
+	}
+	if filter.Until > 0 {
+		tx = tx.Where("date < ?", filter.Until)
+	}
+	var results []*model.AuditEvent
+	if err := tx.Order("date desc").Order("id desc").Offset(offset).Limit(limit).Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to retrieve audit events")
+		return nil, err
+	}
+	return results, nil
+}
+
+// DeleteBefore permanently removes all AuditEvents
+// that occurred before a given time.
+func (r *AuditRepo) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Before", before)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_audit_deleteBefore", trace.WithAttributes(
+		attribute.Int64("before", before.Unix()),
+	))
+	defer span.End()
+	tx := r.db.WithContext(ctx).Where("date < ?", before.Unix()).Delete(&model.AuditEvent{})
+	if err := tx.Error; err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to delete AuditEvents")
+		return 0, err
+	}
+	return tx.RowsAffected, nil
+}
@@ -0,0 +1,266 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/backup"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/policy"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+)
+
+const backupBatchSize = 500
+
+// ErrGroupExists is returned when an archive contains
+// a Group whose name is already taken.
+var ErrGroupExists = errors.New("group already exists")
+
+// Export writes every Group, GroupMember, User, Jump and JumpEvent
+// to the archive, along with the role bindings that aka created
+// for them. Bindings are given to the members of each Group
+// and the users that own Jumps.
+func (al *AccessLayer) Export(ctx context.Context, w *backup.Writer) error {
+	log := logr.FromContextOrDiscard(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "dao_export")
+	defer span.End()
+
+	log.Info("exporting groups")
+	var groups []*model.Group
+	if err := al.db.WithContext(ctx).Order("id asc").FindInBatches(&groups, backupBatchSize, func(_ *gorm.DB, _ int) error {
+		return exportRows(w, backup.TableGroups, groups, nil)
+	}).Error; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	log.Info("exporting group members")
+	var members []*model.GroupMember
+	if err := al.db.WithContext(ctx).Order("group_id asc, added_at asc").FindInBatches(&members, backupBatchSize, func(_ *gorm.DB, _ int) error {
+		return exportRows(w, backup.TableGroupMembers, members, backup.MemberBindings(members))
+	}).Error; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	log.Info("exporting users")
+	var users []*UserV2
+	if err := al.db.WithContext(ctx).Order("id asc").FindInBatches(&users, backupBatchSize, func(_ *gorm.DB, _ int) error {
+		return exportRows(w, backup.TableUsers, users, nil)
+	}).Error; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	log.Info("exporting jumps")
+	var jumps []*model.Jump
+	if err := al.db.WithContext(ctx).Order("id asc").FindInBatches(&jumps, backupBatchSize, func(_ *gorm.DB, _ int) error {
+		return exportRows(w, backup.TableJumps, jumps, backup.OwnerBindings(jumps))
+	}).Error; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	log.Info("exporting jump events")
+	var events []*model.JumpEvent
+	if err := al.db.WithContext(ctx).Order("id asc").FindInBatches(&events, backupBatchSize, func(_ *gorm.DB, _ int) error {
+		return exportRows(w, backup.TableJumpEvents, events, nil)
+	}).Error; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+func exportRows[T any](w *backup.Writer, table string, rows []T, bindings []backup.RoleBinding) error {
+	for _, row := range rows {
+		if err := w.Add(table, row); err != nil {
+			return err
+		}
+	}
+	for _, b := range bindings {
+		if err := w.Add(backup.TableRoleBindings, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore imports the contents of an archive in a single
+// transaction. Every row is given a new primary key, and
+// references between rows are rewritten to match. Users
+// that already exist (by subject) are reused rather than
+// duplicated. Groups are always created, and ErrGroupExists
+// is returned if one of them has the same name as an
+// existing Group, since restoring the archived role
+// bindings would give its members access to it.
+//
+// Destinations are normalised, and Jumps whose destination
+// breaks the URL policy are skipped.
+//
+// The returned IDMap can be used to rewrite anything else
+// that refers to the archived rows (e.g. role bindings).
+func (al *AccessLayer) Restore(ctx context.Context, r *backup.Reader, urlPolicy *policy.Policy) (*backup.IDMap, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "dao_restore")
+	defer span.End()
+	ids := backup.NewIDMap()
+	err := al.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		log.Info("restoring groups")
+		// archives written before group members had
+		// their own table list them on the group
+		var legacyMembers []*model.GroupMember
+		if err := r.Each(backup.TableGroups, func(data json.RawMessage) error {
+			var g model.Group
+			if err := json.Unmarshal(data, &g); err != nil {
+				return err
+			}
+			oldID := g.ID
+			for _, subject := range strings.Split(g.Users, ",") {
+				if subject == "" {
+					continue
+				}
+				role := model.GroupRoleMember
+				if subject == g.Owner {
+					role = model.GroupRoleOwner
+				}
+				source := model.GroupSourceManual
+				if g.External {
+					source = model.GroupSourceIdP
+				}
+				legacyMembers = append(legacyMembers, &model.GroupMember{
+					GroupID: oldID,
+					Subject: subject,
+					Role:    role,
+					Source:  source,
+					AddedAt: g.CreatedAt,
+				})
+			}
+			var count int64
+			if err := tx.Model(&model.Group{}).Where("name = ?", g.Name).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: %s", ErrGroupExists, g.Name)
+			}
+			g.Model = gorm.Model{CreatedAt: g.CreatedAt, UpdatedAt: g.UpdatedAt}
+			if err := tx.Create(&g).Error; err != nil {
+				return err
+			}
+			ids.Set(schemas.ResourceGroup, oldID, g.ID)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		log.Info("restoring group members")
+		restoreMember := func(m *model.GroupMember) error {
+			groupID, ok := ids.Get(schemas.ResourceGroup, m.GroupID)
+			if !ok {
+				return nil
+			}
+			m.GroupID = groupID
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(m).Error
+		}
+		if r.Manifest.Tables[backup.TableGroupMembers] == 0 {
+			for _, m := range legacyMembers {
+				if err := restoreMember(m); err != nil {
+					return err
+				}
+			}
+		} else if err := r.Each(backup.TableGroupMembers, func(data json.RawMessage) error {
+			var m model.GroupMember
+			if err := json.Unmarshal(data, &m); err != nil {
+				return err
+			}
+			return restoreMember(&m)
+		}); err != nil {
+			return err
+		}
+
+		log.Info("restoring users")
+		if err := r.Each(backup.TableUsers, func(data json.RawMessage) error {
+			var u UserV2
+			if err := json.Unmarshal(data, &u); err != nil {
+				return err
+			}
+			var count int64
+			if err := tx.Model(&UserV2{}).Where("subject = ?", u.Subject).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				log.V(1).Info("skipping existing user", "Subject", u.Subject)
+				return nil
+			}
+			u.Model = gorm.Model{CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt}
+			return tx.Create(&u).Error
+		}); err != nil {
+			return err
+		}
+
+		log.Info("restoring jumps")
+		if err := r.Each(backup.TableJumps, func(data json.RawMessage) error {
+			var j model.Jump
+			if err := json.Unmarshal(data, &j); err != nil {
+				return err
+			}
+			oldID := j.ID
+			owner, ok := ids.Owner(j.Owner)
+			if !ok {
+				log.Info("skipping jump with unknown owner", "ID", oldID, "Owner", j.Owner)
+				return nil
+			}
+			j.Owner = owner
+			j.Location = urlPolicy.Normalise(j.Location)
+			if err := urlPolicy.Check(j.Owner, j.Location); err != nil {
+				log.Info("skipping jump that breaks the url policy", "ID", oldID, "Location", j.Location, "Reason", err.Error())
+				return nil
+			}
+			j.Model = gorm.Model{CreatedAt: j.CreatedAt, UpdatedAt: j.UpdatedAt}
+			if err := tx.Create(&j).Error; err != nil {
+				return err
+			}
+			ids.Set(schemas.ResourceJump, oldID, j.ID)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		log.Info("restoring jump events")
+		events := make([]*model.JumpEvent, 0, backupBatchSize)
+		if err := r.Each(backup.TableJumpEvents, func(data json.RawMessage) error {
+			var e model.JumpEvent
+			if err := json.Unmarshal(data, &e); err != nil {
+				return err
+			}
+			jumpID, ok := ids.Get(schemas.ResourceJump, e.JumpID)
+			if !ok {
+				return nil
+			}
+			e.JumpID = jumpID
+			e.Model = gorm.Model{CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt}
+			events = append(events, &e)
+			if len(events) < backupBatchSize {
+				return nil
+			}
+			err := tx.Create(&events).Error
+			events = events[:0]
+			return err
+		}); err != nil {
+			return err
+		}
+		if len(events) > 0 {
+			return tx.Create(&events).Error
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to restore archive")
+		return nil, err
+	}
+	return ids, nil
+}
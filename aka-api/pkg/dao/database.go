@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"github.com/djcass44/go-utils/orm"
 	"github.com/getsentry/sentry-go"
+	"github.com/glebarez/sqlite"
 	"github.com/go-logr/logr"
 	otelgorm "github.com/kostyay/gorm-opentelemetry"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -23,11 +25,25 @@ var tableNames = []string{
 	model.TableNameGroups,
 	model.TableNameUsersV2,
 	model.TableNameJumps,
+	model.TableNameJumpProposals,
 }
 
+// names of the supported gorm dialects
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// sqlitePrefix marks a DSN as pointing to a SQLite
+// database, e.g. sqlite:///var/lib/aka/aka.db
+const sqlitePrefix = "sqlite://"
+
 type AccessLayer struct {
 	db  *gorm.DB
 	dsn string
+	// local publishes changes when the database
+	// doesn't support LISTEN/NOTIFY
+	local *LocalFeed
 }
 
 type Repository struct {
@@ -38,7 +54,7 @@ type Repository struct {
 func NewAccessLayer(ctx context.Context, dsn string) (*AccessLayer, error) {
 	log := logr.FromContextOrDiscard(ctx).WithName("database")
 	al := new(AccessLayer)
-	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+	database, err := gorm.Open(openDialector(dsn), &gorm.Config{
 		Logger: orm.NewGormLogger(log, time.Millisecond*200),
 	})
 	if err != nil {
@@ -51,84 +67,108 @@ func NewAccessLayer(ctx context.Context, dsn string) (*AccessLayer, error) {
 		sentry.CaptureException(err)
 		log.Error(err, "failed to enable SQL OpenTelemetry plugin")
 	}
-	log.Info("established database connection")
+	if database.Dialector.Name() == DialectSQLite {
+		log.V(1).Info("enabling in-process change notifications")
+		al.local = NewLocalFeed(ctx)
+		if err := al.local.Register(database); err != nil {
+			log.Error(err, "failed to register change notification callbacks")
+			return nil, err
+		}
+	}
+	log.Info("established database connection", "Dialect", database.Dialector.Name())
 
 	al.db = database
 	al.dsn = dsn
 	return al, nil
 }
 
+// openDialector picks the database driver based
+// on the format of the DSN.
+func openDialector(dsn string) gorm.Dialector {
+	if !strings.HasPrefix(dsn, sqlitePrefix) {
+		return postgres.Open(dsn)
+	}
+	path := strings.TrimPrefix(dsn, sqlitePrefix)
+	// wait for locks rather than failing immediately and
+	// allow readers to continue while something is writing
+	if !strings.Contains(path, "_pragma=") {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+	return sqlite.Open(path)
+}
+
 // Init runs on-start operations such as schema migration
 func (al *AccessLayer) Init(ctx context.Context) error {
 	log := logr.FromContextOrDiscard(ctx)
-	log.Info("running auto-migration of database")
-	err := al.db.AutoMigrate(
-		&model.Jump{},
-		&model.JumpEvent{},
-		&UserV2{},
-		&model.Group{},
-	)
+	log.Info("running migration of database")
+	migrator, err := al.Migrator()
 	if err != nil {
 		return err
 	}
-	if err := al.ftIndex(ctx, "alias", "alias"); err != nil {
-		return err
-	}
-	if err := al.ftIndex(ctx, "name", "name"); err != nil {
-		return err
-	}
-	if err := al.ftIndex(ctx, "location", "location"); err != nil {
-		return err
-	}
-	return nil
+	return migrator.Up(ctx)
 }
 
-func (al *AccessLayer) ftIndex(ctx context.Context, name, field string) error {
-	log := logr.FromContextOrDiscard(ctx).WithValues("Name", name, "Field", field)
-	log.V(1).Info("creating full-text index")
-	if err := al.db.Raw(fmt.Sprintf("CREATE INDEX IF NOT EXISTS jumps_%s_idx ON jumps USING GIN (to_tsvector('simple', %s))", name, field)).Error; err != nil {
-		log.Error(err, "failed to create index")
-		return err
-	}
-	return nil
+// Migrator returns a Migrator for the database
+func (al *AccessLayer) Migrator() (*Migrator, error) {
+	return NewMigrator(al.db)
 }
 
-func (al *AccessLayer) InitNotify(ctx context.Context) (map[string]chan *Message, error) {
+// InitTriggers creates (or replaces) the triggers used to
+// publish table changes via LISTEN/NOTIFY
+func (al *AccessLayer) InitTriggers(ctx context.Context) error {
 	log := logr.FromContextOrDiscard(ctx)
+	if al.local != nil {
+		log.V(1).Info("skipping triggers as changes are published in-process")
+		return nil
+	}
 	// create the template
 	tpl, err := template.New("trigger.sql").Parse(triggerTpl)
 	if err != nil {
 		log.Error(err, "failed to create template")
-		return nil, err
+		return err
 	}
-	listeners := map[string]chan *Message{}
-	log.V(1).Info("initialising notifiers for tables", "Count", len(tableNames))
+	log.V(1).Info("initialising triggers for tables", "Count", len(tableNames))
 	for _, t := range tableNames {
-		log = log.WithValues("Table", t)
+		log := log.WithValues("Table", t)
 		log.V(1).Info("creating trigger")
 		data := new(bytes.Buffer)
 		// execute the template
 		if err := tpl.Execute(data, struct {
 			Table string
+			Limit int
 		}{
 			Table: t,
+			Limit: notifyPayloadLimit,
 		}); err != nil {
-			log.Error(err, "failed to template trigger.sql for table: %s", t)
-			return nil, err
+			log.Error(err, "failed to template trigger.sql")
+			return err
 		}
 		// create the trigger
-		if err := al.db.Exec(data.String()).Error; err != nil {
+		if err := al.db.WithContext(ctx).Exec(data.String()).Error; err != nil {
 			log.Error(err, "failed to setup trigger")
-			return nil, err
+			return err
 		}
-		// create the listener
-		_, h, err := NewNotifier(ctx, al.dsn, fmt.Sprintf("%s_update", t))
-		if err != nil {
-			return nil, err
-		}
-		listeners[t] = h
 	}
-	return listeners, nil
+	return nil
+}
+
+// ChangeFeed returns the feed that publishes
+// changes made to the database.
+func (al *AccessLayer) ChangeFeed() ChangeFeed {
+	if al.local != nil {
+		return al.local
+	}
+	return NewPostgresFeed(al.dsn)
+}
+
+// NotifyChannel returns the name of the channel
+// that changes to a given table are published to.
+func NotifyChannel(table string) string {
+	return fmt.Sprintf("%s_update", table)
 }
 
 // NewRepo attaches our current database connection to a repository struct
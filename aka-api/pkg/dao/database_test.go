@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/feedtest"
 	"gitlab.dcas.dev/jmp/go-jmp/pkg/testconstructs"
 	"testing"
 )
@@ -20,9 +21,49 @@ func newDB(ctx context.Context, t *testing.T) *dao.AccessLayer {
 	return db
 }
 
+// newRepos creates a set of repositories that
+// use the given database.
+func newRepos(db *dao.AccessLayer) *dao.Repos {
+	jumpRepo := &dao.JumpRepo{}
+	eventRepo := &dao.JumpEventRepo{}
+	userRepo := &dao.UserV2Repo{}
+	groupRepo := &dao.GroupRepo{}
+	tokenRepo := &dao.AccessTokenRepo{}
+	auditRepo := &dao.AuditRepo{}
+	webhookRepo := &dao.WebhookRepo{}
+	proposalRepo := &dao.JumpProposalRepo{}
+	db.NewRepo(&jumpRepo.Repository)
+	db.NewRepo(&eventRepo.Repository)
+	db.NewRepo(&userRepo.Repository)
+	db.NewRepo(&groupRepo.Repository)
+	db.NewRepo(&tokenRepo.Repository)
+	db.NewRepo(&auditRepo.Repository)
+	db.NewRepo(&webhookRepo.Repository)
+	db.NewRepo(&proposalRepo.Repository)
+	return &dao.Repos{
+		JumpRepo:      jumpRepo,
+		GroupRepo:     groupRepo,
+		UserRepo:      userRepo,
+		JumpEventRepo: eventRepo,
+		TokenRepo:     tokenRepo,
+		AuditRepo:     auditRepo,
+		WebhookRepo:   webhookRepo,
+		ProposalRepo:  proposalRepo,
+	}
+}
+
 func TestNewAccessLayer(t *testing.T) {
 	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
 
 	db := newDB(ctx, t)
 	assert.NotNil(t, db)
 }
+
+func TestPostgresChangeFeed(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	feedtest.Run(ctx, t, func(t *testing.T) (dao.ChangeFeed, *dao.Repos) {
+		db := newDB(ctx, t)
+		require.NoError(t, db.InitTriggers(ctx))
+		return db.ChangeFeed(), newRepos(db)
+	})
+}
@@ -0,0 +1,24 @@
+package dao
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrFeedClosed is returned when subscribing to
+// a ChangeFeed that has been closed.
+var ErrFeedClosed = errors.New("change feed is closed")
+
+// ChangeFeed delivers a Message for each row that
+// is created, updated or deleted.
+type ChangeFeed interface {
+	// Subscribe returns a channel that receives the
+	// changes made to a table from now on.
+	Subscribe(ctx context.Context, table string) (<-chan *Message, error)
+	// Health returns nil if the feed is able
+	// to deliver changes.
+	Health() error
+	// Close stops delivering changes and releases
+	// any resources held by the feed.
+	Close() error
+}
@@ -0,0 +1,130 @@
+// Package feedtest contains the contract that
+// every dao.ChangeFeed must satisfy.
+package feedtest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeout is how long we wait for a message to
+// be delivered before failing the test.
+const timeout = 5 * time.Second
+
+// Factory creates a ChangeFeed along with a set of
+// repositories whose writes are published to it.
+type Factory func(t *testing.T) (dao.ChangeFeed, *dao.Repos)
+
+// Run checks that the ChangeFeed created by newFeed
+// delivers messages the way that subscribers
+// expect.
+func Run(ctx context.Context, t *testing.T, newFeed Factory) {
+	feed, repos := newFeed(t)
+
+	t.Run("jump lifecycle", func(t *testing.T) {
+		messages, err := feed.Subscribe(ctx, model.TableNameJumps)
+		require.NoError(t, err)
+
+		jump, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: "foo", Location: "https://example.org", Owner: "user://john"})
+		require.NoError(t, err)
+		msg := expect(t, messages, &dao.Message{Operation: "INSERT", ID: int(jump.ID), Table: model.TableNameJumps})
+		assert.EqualValues(t, "foo", msg.Name)
+		assert.EqualValues(t, "user://john", msg.Owner)
+		assert.Empty(t, msg.Changed)
+
+		jump.Name = "bar"
+		_, err = repos.JumpRepo.Save(ctx, jump)
+		require.NoError(t, err)
+		msg = expect(t, messages, &dao.Message{Operation: "UPDATE", ID: int(jump.ID), Table: model.TableNameJumps})
+		assert.EqualValues(t, "bar", msg.Name)
+		assert.Contains(t, msg.Changed, "name")
+		assert.NotContains(t, msg.Changed, "location")
+
+		// jumps are soft-deleted, so this is
+		// an update
+		require.NoError(t, repos.JumpRepo.DeleteByID(ctx, jump.ID))
+		msg = expect(t, messages, &dao.Message{Operation: "UPDATE", ID: int(jump.ID), Table: model.TableNameJumps})
+		assert.EqualValues(t, []string{"deleted_at"}, msg.Changed)
+		assert.EqualValues(t, "user://john", msg.Owner)
+	})
+
+	t.Run("large rows only send the ID", func(t *testing.T) {
+		messages, err := feed.Subscribe(ctx, model.TableNameJumps)
+		require.NoError(t, err)
+
+		jump, err := repos.JumpRepo.Save(ctx, &model.Jump{Name: strings.Repeat("a", 10_000), Location: "https://example.org"})
+		require.NoError(t, err)
+		msg := expect(t, messages, &dao.Message{Operation: "INSERT", ID: int(jump.ID), Table: model.TableNameJumps})
+		assert.True(t, msg.Truncated)
+		assert.Empty(t, msg.Name)
+	})
+
+	t.Run("tables are isolated", func(t *testing.T) {
+		messages, err := feed.Subscribe(ctx, model.TableNameGroups)
+		require.NoError(t, err)
+
+		_, err = repos.JumpRepo.Save(ctx, &model.Jump{Name: "foo", Location: "https://example.org"})
+		require.NoError(t, err)
+
+		group, err := repos.GroupRepo.Save(&model.Group{Name: "team"})
+		require.NoError(t, err)
+		// the jump must not have been sent to us
+		expect(t, messages, &dao.Message{Operation: "INSERT", ID: int(group.ID), Table: model.TableNameGroups})
+	})
+
+	t.Run("every subscriber receives messages", func(t *testing.T) {
+		first, err := feed.Subscribe(ctx, model.TableNameUsersV2)
+		require.NoError(t, err)
+		second, err := feed.Subscribe(ctx, model.TableNameUsersV2)
+		require.NoError(t, err)
+
+		user, err := repos.UserRepo.Save(ctx, &dao.UserV2{Subject: "john"})
+		require.NoError(t, err)
+		msg := &dao.Message{Operation: "INSERT", ID: int(user.ID), Table: model.TableNameUsersV2}
+		expect(t, first, msg)
+		expect(t, second, msg)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		messages, err := feed.Subscribe(ctx, model.TableNameJumps)
+		require.NoError(t, err)
+		assert.NoError(t, feed.Health())
+
+		assert.NoError(t, feed.Close())
+		assert.ErrorIs(t, feed.Health(), dao.ErrFeedClosed)
+		_, err = feed.Subscribe(ctx, model.TableNameJumps)
+		assert.ErrorIs(t, err, dao.ErrFeedClosed)
+
+		// subscribers must be told that
+		// nothing else is coming
+		select {
+		case _, ok := <-messages:
+			assert.False(t, ok)
+		case <-time.After(timeout):
+			t.Fatal("timed out waiting for channel to close")
+		}
+	})
+}
+
+// expect waits for a message that describes the same
+// change as expected and returns it, so that the row
+// details can be checked.
+func expect(t *testing.T, messages <-chan *dao.Message, expected *dao.Message) *dao.Message {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		assert.EqualValues(t, expected.Operation, msg.Operation)
+		assert.EqualValues(t, expected.ID, msg.ID)
+		assert.EqualValues(t, expected.Table, msg.Table)
+		return msg
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for %s of %s %d", expected.Operation, expected.Table, expected.ID)
+		return nil
+	}
+}
@@ -24,16 +24,28 @@ import (
 	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
 )
 
 type GroupRepo struct {
 	Repository
 }
 
-// Save creates or updates a given Group
+// isMember matches the Groups that a user is a member of
+const isMember = "id IN (SELECT group_id FROM group_members WHERE subject = ?)"
+
+// isActive matches the Groups that haven't been archived
+const isActive = "archived = ?"
+
+// Save creates or updates a given Group. Members
+// are not saved, use AddMember instead.
 func (r *GroupRepo) Save(e *model.Group) (*model.Group, error) {
 	return e, r.db.Save(e).Error
 }
@@ -46,11 +58,36 @@ func (r *GroupRepo) FindByID(ctx context.Context, id int) (*model.Group, error)
 	defer span.End()
 	user, _ := identity.GetContextUser(ctx)
 	var result model.Group
-	if err := r.db.Where("id = ?", id).Where("position(? in users) > 0", user.Subject).First(&result).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("id = ?", id).Where(isMember, user.Subject).First(&result).Error; err != nil {
 		span.RecordError(err)
 		log.Error(err, "failed to find Group")
 		return nil, err
 	}
+	if err := r.withMembers(ctx, &result); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetByID returns a Group by its primaryKey (ID),
+// regardless of whether the current user can see it.
+func (r *GroupRepo) GetByID(ctx context.Context, id uint) (*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_getByID", trace.WithAttributes(
+		attribute.Int("id", int(id)),
+	))
+	defer span.End()
+	var result model.Group
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&result).Error; err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to get Group")
+		return nil, err
+	}
+	if err := r.withMembers(ctx, &result); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 	return &result, nil
 }
 
@@ -65,6 +102,10 @@ func (r *GroupRepo) FindByName(ctx context.Context, name string) (*model.Group,
 		log.Error(err, "failed to find Group")
 		return nil, err
 	}
+	if err := r.withMembers(ctx, &result); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 	return &result, nil
 }
 
@@ -82,16 +123,20 @@ func (r *GroupRepo) GetUserGroups(ctx context.Context, username string) ([]*mode
 		return nil, errors.New("unauthorised")
 	}
 
-	query := r.db.Where("position(? in users) > 0", username)
+	query := r.db.Where(isMember, username).Where(isActive, false)
 	if user.Subject != username {
 		// intersect with the current user
-		query = query.Where("position(? in users) > 0", user.Subject)
+		query = query.Where(isMember, user.Subject)
 	}
 	if err := query.WithContext(ctx).Order("name asc").Find(&results).Error; err != nil {
 		span.RecordError(err)
 		log.Error(err, "failed to get groups for user")
 		return nil, err
 	}
+	if err := r.withMembers(ctx, results...); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 	return results, nil
 }
 
@@ -106,12 +151,17 @@ func (r *GroupRepo) GetGroups(ctx context.Context, user string, offset, limit in
 	defer span.End()
 	var result []*model.Group
 	var count int64
-	r.db.WithContext(ctx).Model(&model.Group{}).Where("position(? in users) > 0 OR public = true", user).Count(&count)
-	if err := r.db.Where("position(? in users) > 0 OR public = true", user).Order("name asc").Limit(limit).Offset(offset).Find(&result).Error; err != nil {
+	query := "(" + isMember + " OR public = true) AND " + isActive
+	r.db.WithContext(ctx).Model(&model.Group{}).Where(query, user, false).Count(&count)
+	if err := r.db.WithContext(ctx).Where(query, user, false).Order("name asc").Limit(limit).Offset(offset).Find(&result).Error; err != nil {
 		span.RecordError(err)
 		log.Error(err, "failed to read groups")
 		return nil, err
 	}
+	if err := r.withMembers(ctx, result...); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 	pageable := make([]model.Pageable, len(result))
 	for i := range result {
 		pageable[i] = result[i]
@@ -122,3 +172,212 @@ func (r *GroupRepo) GetGroups(ctx context.Context, user string, offset, limit in
 		More:    count-int64((offset+1)*limit) > 0,
 	}, nil
 }
+
+// GetArchived gets the archived groups
+// that contain a given user.
+func (r *GroupRepo) GetArchived(ctx context.Context, username string) ([]*model.Group, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_getArchived", trace.WithAttributes(attribute.String("user", username)))
+	defer span.End()
+	var results []*model.Group
+	if err := r.db.WithContext(ctx).Where(isMember, username).Where(isActive, true).Order("name asc").Find(&results).Error; err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to get archived groups for user")
+		return nil, err
+	}
+	if err := r.withMembers(ctx, results...); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// Delete soft-deletes a Group by a given primaryKey (ID). Its
+// Jumps are soft-deleted in the same transaction, unless reassignTo
+// is set, in which case they are given to that owner instead and
+// their IDs are returned. Its members are kept so that it can be
+// restored.
+func (r *GroupRepo) Delete(ctx context.Context, id uint, reassignTo string) ([]uint, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_delete", trace.WithAttributes(attribute.Int("id", int(id)), attribute.String("reassignTo", reassignTo)))
+	defer span.End()
+	owner := schemas.ResourceName(schemas.ResourceGroup, id)
+	var ids []uint
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if reassignTo == "" {
+			if err := tx.Where("owner = ?", owner).Delete(&model.Jump{}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Model(&model.Jump{}).Where("owner = ?", owner).Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			if len(ids) > 0 {
+				if err := tx.Model(&model.Jump{}).Where("id IN ?", ids).Update("owner", reassignTo).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Delete(&model.Group{}, id).Error
+	})
+	if err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to delete group", "ID", id)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetMembers returns the members of a Group
+// in the order that they were added.
+func (r *GroupRepo) GetMembers(ctx context.Context, id uint) ([]*model.GroupMember, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_getMembers", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	var results []*model.GroupMember
+	if err := r.db.WithContext(ctx).Where("group_id = ?", id).Order("added_at asc, subject asc").Find(&results).Error; err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to get group members")
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetMember returns a single member of a Group.
+func (r *GroupRepo) GetMember(ctx context.Context, id uint, subject string) (*model.GroupMember, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_getMember", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	var result model.GroupMember
+	if err := r.db.WithContext(ctx).Where("group_id = ? AND subject = ?", id, subject).First(&result).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AddMember adds a user to a Group. If they are
+// already a member, their role is updated.
+func (r *GroupRepo) AddMember(ctx context.Context, m *model.GroupMember) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", m.GroupID, "Subject", m.Subject, "Role", m.Role)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_addMember", trace.WithAttributes(attribute.Int("id", int(m.GroupID))))
+	defer span.End()
+	if m.AddedAt.IsZero() {
+		m.AddedAt = time.Now()
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "group_id"}, {Name: "subject"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).Create(m).Error; err != nil {
+			return err
+		}
+		return touchGroup(tx, m.GroupID)
+	})
+	if err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to add group member")
+		return err
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a Group.
+func (r *GroupRepo) RemoveMember(ctx context.Context, id uint, subject string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id, "Subject", subject)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_removeMember", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("group_id = ? AND subject = ?", id, subject).Delete(&model.GroupMember{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return touchGroup(tx, id)
+	})
+	if err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to remove group member")
+		return err
+	}
+	return nil
+}
+
+// GetMemberships returns every Group that a user is
+// a member of, including those that are archived.
+func (r *GroupRepo) GetMemberships(ctx context.Context, subject string) ([]*model.GroupMember, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_getMemberships", trace.WithAttributes(attribute.String("subject", subject)))
+	defer span.End()
+	var results []*model.GroupMember
+	if err := r.db.WithContext(ctx).Where("subject = ?", subject).Order("group_id asc").Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to get group memberships", "Subject", subject)
+		return nil, err
+	}
+	return results, nil
+}
+
+// AddMemberEvent records a change to the members of a Group.
+func (r *GroupRepo) AddMemberEvent(ctx context.Context, e *model.GroupMemberEvent) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_addMemberEvent", trace.WithAttributes(attribute.Int("id", int(e.GroupID))))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Create(e).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to record group member event", "ID", e.GroupID)
+		return err
+	}
+	return nil
+}
+
+// GetMemberEvents returns the changes to the
+// members of a Group, newest first.
+func (r *GroupRepo) GetMemberEvents(ctx context.Context, id uint, offset, limit int) ([]*model.GroupMemberEvent, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_group_getMemberEvents", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	var results []*model.GroupMemberEvent
+	if err := r.db.WithContext(ctx).Where("group_id = ?", id).Order("date desc, id desc").Limit(limit).Offset(offset).Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to get group member events", "ID", id)
+		return nil, err
+	}
+	return results, nil
+}
+
+// touchGroup marks a Group as updated so that a change
+// notification is sent when its members change.
+func touchGroup(tx *gorm.DB, id uint) error {
+	return tx.Model(&model.Group{}).Where("id = ?", id).Update("updated_at", time.Now()).Error
+}
+
+// withMembers fills in the Users of each Group.
+func (r *GroupRepo) withMembers(ctx context.Context, groups ...*model.Group) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(groups))
+	for i, g := range groups {
+		ids[i] = g.ID
+	}
+	var members []*model.GroupMember
+	if err := r.db.WithContext(ctx).Where("group_id IN ?", ids).Order("added_at asc, subject asc").Find(&members).Error; err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to get group members")
+		return err
+	}
+	users := map[uint][]string{}
+	for _, m := range members {
+		users[m.GroupID] = append(users[m.GroupID], m.Subject)
+	}
+	for _, g := range groups {
+		g.Users = strings.Join(users[g.ID], ",")
+	}
+	return nil
+}
+
+// FindInBatches iterates over every Group, regardless of
+// who can see it.
+func (r *GroupRepo) FindInBatches(ctx context.Context, size int, f func(groups []*model.Group) error) error {
+	var results []*model.Group
+	return r.db.WithContext(ctx).Order("id asc").FindInBatches(&results, size, func(_ *gorm.DB, _ int) error {
+		if err := r.withMembers(ctx, results...); err != nil {
+			return err
+		}
+		return f(results)
+	}).Error
+}
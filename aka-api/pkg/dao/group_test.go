@@ -24,12 +24,14 @@ func TestGroupRepo(t *testing.T) {
 		Name:     "my-group",
 		Public:   false,
 		Owner:    "user://john",
-		Users:    "john,jane",
 		External: false,
 	})
 	assert.NoError(t, err)
 	assert.NotNil(t, firstGroup)
 	assert.EqualValues(t, "my-group", firstGroup.Name)
+	for _, subject := range []string{"john", "jane"} {
+		assert.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: firstGroup.ID, Subject: subject, Role: model.GroupRoleMember}))
+	}
 
 	t.Run("find by name", func(t *testing.T) {
 		group, err := repo.FindByName(ctx, "my-group")
@@ -40,5 +42,6 @@ func TestGroupRepo(t *testing.T) {
 		group, err := repo.FindByID(ctx, int(firstGroup.ID))
 		assert.NoError(t, err)
 		assert.NotNil(t, group)
+		assert.EqualValues(t, "john,jane", group.Users)
 	})
 }
@@ -0,0 +1,68 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Ping verifies that the database connection
+// is still alive.
+func (al *AccessLayer) Ping(ctx context.Context) error {
+	sqlDB, err := al.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// MissingTriggers returns the names of any change
+// notification triggers that have not been created.
+// Databases using in-process notifications don't
+// need any triggers.
+func (al *AccessLayer) MissingTriggers(ctx context.Context) ([]string, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	if al.local != nil {
+		return nil, nil
+	}
+	var missing []string
+	for _, t := range tableNames {
+		name := fmt.Sprintf("trigger_%s_update", t)
+		var count int64
+		if err := al.db.WithContext(ctx).Raw("SELECT count(*) FROM pg_trigger WHERE tgname = ?", name).Scan(&count).Error; err != nil {
+			log.Error(err, "failed to check trigger", "Trigger", name)
+			return nil, err
+		}
+		if count == 0 {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// CheckNotify opens a short-lived listener on each
+// notification channel to ensure that they can be
+// subscribed to. In-process notifications are
+// always available.
+func (al *AccessLayer) CheckNotify(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+	if al.local != nil {
+		return nil
+	}
+	for _, t := range tableNames {
+		channel := NotifyChannel(t)
+		log.V(1).Info("checking notification channel", "Channel", channel)
+		listener := pq.NewListener(al.dsn, time.Second, time.Second, nil)
+		err := listener.Listen(channel)
+		if err == nil {
+			err = listener.Ping()
+		}
+		_ = listener.Close()
+		if err != nil {
+			return fmt.Errorf("listening on channel %s: %w", channel, err)
+		}
+	}
+	return nil
+}
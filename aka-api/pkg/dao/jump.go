@@ -27,14 +27,14 @@ func (jr *JumpRepo) GetAll(ctx context.Context, user string, offset, limit int,
 		attribute.Int("limit", limit),
 	))
 	defer span.End()
-	groupIDs := jr.getGroupQuery(user, groups)
+	owners := jr.getOwners(user, groups)
 	var result []*model.Jump
 	var count int64
 
-	query := "owner = '' OR owner = ANY(?::text[])"
-	jr.db.Model(&model.Jump{}).Where(query, groupIDs).Count(&count)
+	query := "owner = '' OR owner IN ?"
+	jr.db.Model(&model.Jump{}).Where(query, owners).Count(&count)
 
-	if err := jr.db.Limit(limit).Offset(offset).Where(query, groupIDs).Find(&result).Error; err != nil {
+	if err := jr.db.Limit(limit).Offset(offset).Where(query, owners).Find(&result).Error; err != nil {
 		span.RecordError(err)
 		log.Error(err, "failed to read jumps")
 		return nil, err
@@ -76,18 +76,31 @@ func (jr *JumpRepo) GetByID(ctx context.Context, id uint) (*model.Jump, error) {
 	return &result, nil
 }
 
-func (jr *JumpRepo) getGroupQuery(user string, groups []uint) string {
-	var groupIDs strings.Builder
-	groupIDs.WriteString(`{"user://`)
-	groupIDs.WriteString(user)
-	groupIDs.WriteString(`"`)
+// getOwners returns the owners whose Jumps
+// are visible to a user.
+func (jr *JumpRepo) getOwners(user string, groups []uint) []string {
+	owners := make([]string, 0, len(groups)+1)
+	owners = append(owners, "user://"+user)
 	for _, g := range groups {
-		groupIDs.WriteString(`,"group://`)
-		groupIDs.WriteString(strconv.FormatUint(uint64(g), 10))
-		groupIDs.WriteString(`"`)
+		owners = append(owners, "group://"+strconv.FormatUint(uint64(g), 10))
 	}
-	groupIDs.WriteString("}")
-	return groupIDs.String()
+	return owners
+}
+
+// searchQuery returns a condition that matches Jumps
+// with a name, location or alias starting with the
+// given term.
+func (jr *JumpRepo) searchQuery(term string) (string, []any) {
+	if jr.db.Dialector.Name() == DialectSQLite {
+		match := fmt.Sprintf(`"%s"*`, strings.ReplaceAll(term, `"`, `""`))
+		return "id IN (SELECT rowid FROM jumps_fts WHERE jumps_fts MATCH ?)", []any{match}
+	}
+	tsQuery := fmt.Sprintf("%s:*", term)
+	return `(
+		to_tsvector('simple', name) @@ ?::tsquery OR 
+		to_tsvector('simple', location) @@ ?::tsquery OR 
+		to_tsvector('simple', alias) @@ ?::tsquery
+	)`, []any{tsQuery, tsQuery, tsQuery}
 }
 
 func (jr *JumpRepo) SearchForTerm(ctx context.Context, user, term string, offset, limit int, groups []uint) (*model.Page, error) {
@@ -98,22 +111,19 @@ func (jr *JumpRepo) SearchForTerm(ctx context.Context, user, term string, offset
 		attribute.String("term", term),
 	))
 	defer span.End()
-	groupIDs := jr.getGroupQuery(user, groups)
+	owners := jr.getOwners(user, groups)
 	var result []*model.Jump
-	tsQuery := fmt.Sprintf("%s:*", term)
 	var count int64
-	query := `(
-		to_tsvector('simple', name) @@ ?::tsquery OR 
-		to_tsvector('simple', location) @@ ?::tsquery OR 
-		to_tsvector('simple', alias) @@ ?::tsquery
-	) AND (owner = '' OR owner = ANY(?::text[]))`
+	query, args := jr.searchQuery(term)
+	query += " AND (owner = '' OR owner IN ?)"
+	args = append(args, owners)
 	// get the count for paging
-	jr.db.WithContext(ctx).Model(&model.Jump{}).Where(query, tsQuery, tsQuery, tsQuery, groupIDs).Count(&count)
+	jr.db.WithContext(ctx).Model(&model.Jump{}).Where(query, args...).Count(&count)
 	// actually run the request
 	if err := jr.db.WithContext(ctx).
 		Limit(limit).
 		Offset(offset).
-		Where(query, tsQuery, tsQuery, tsQuery, groupIDs).
+		Where(query, args...).
 		Find(&result).Error; err != nil {
 		span.RecordError(err)
 		log.Error(err, "failed to search jumps")
@@ -143,6 +153,66 @@ func (jr *JumpRepo) DeleteByID(ctx context.Context, id uint) error {
 	return tx.Error
 }
 
+// GetByHost returns the Jumps available to a user
+// whose location mentions the host, regardless of case.
+func (jr *JumpRepo) GetByHost(ctx context.Context, user, host string, groups []uint) ([]*model.Jump, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_jump_getByHost", trace.WithAttributes(attribute.String("host", host)))
+	defer span.End()
+	owners := jr.getOwners(user, groups)
+	var result []*model.Jump
+	if err := jr.db.WithContext(ctx).Where("LOWER(location) LIKE ? AND (owner = '' OR owner IN ?)", "%"+strings.ToLower(host)+"%", owners).Order("id asc").Find(&result).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetByOwner returns every Jump owned by a given owner
+func (jr *JumpRepo) GetByOwner(ctx context.Context, owner string) ([]*model.Jump, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_jump_getByOwner", trace.WithAttributes(attribute.String("owner", owner)))
+	defer span.End()
+	var result []*model.Jump
+	if err := jr.db.WithContext(ctx).Where("owner = ?", owner).Order("id asc").Find(&result).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteByOwner soft-deletes every Jump owned by a given owner
+func (jr *JumpRepo) DeleteByOwner(ctx context.Context, owner string) (int64, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_jump_deleteByOwner", trace.WithAttributes(attribute.String("owner", owner)))
+	defer span.End()
+	tx := jr.db.WithContext(ctx).Where("owner = ?", owner).Delete(&model.Jump{})
+	if tx.Error != nil {
+		span.RecordError(tx.Error)
+		return 0, tx.Error
+	}
+	return tx.RowsAffected, nil
+}
+
+// SetOwner moves every Jump owned by one owner to
+// another, returning the IDs of the Jumps that moved.
+func (jr *JumpRepo) SetOwner(ctx context.Context, from, to string) ([]uint, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_jump_setOwner", trace.WithAttributes(attribute.String("from", from), attribute.String("to", to)))
+	defer span.End()
+	var ids []uint
+	err := jr.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Jump{}).Where("owner = ?", from).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Model(&model.Jump{}).Where("id IN ?", ids).Update("owner", to).Error
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return ids, nil
+}
+
 func FilterJumps(jumps []*model.Jump, f func(j *model.Jump) bool) []*model.Jump {
 	vsf := make([]*model.Jump, 0)
 	for _, v := range jumps {
@@ -152,3 +222,12 @@ func FilterJumps(jumps []*model.Jump, f func(j *model.Jump) bool) []*model.Jump
 	}
 	return vsf
 }
+
+// FindInBatches iterates over every Jump, regardless of
+// who can see it.
+func (jr *JumpRepo) FindInBatches(ctx context.Context, size int, f func(jumps []*model.Jump) error) error {
+	var results []*model.Jump
+	return jr.db.WithContext(ctx).Order("id asc").FindInBatches(&results, size, func(_ *gorm.DB, _ int) error {
+		return f(results)
+	}).Error
+}
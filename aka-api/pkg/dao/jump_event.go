@@ -25,6 +25,7 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"time"
 )
 
 type JumpEventFrequency struct {
@@ -71,3 +72,20 @@ func (r *JumpEventRepo) GetFrequencyByUserID(ctx context.Context, userID string,
 	}
 	return results, nil
 }
+
+// DeleteBefore permanently removes all JumpEvents that
+// occurred before a given time.
+func (r *JumpEventRepo) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Before", before)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_jump_event_deleteBefore", trace.WithAttributes(
+		attribute.Int64("before", before.Unix()),
+	))
+	defer span.End()
+	tx := r.db.WithContext(ctx).Unscoped().Where("date < ?", before.Unix()).Delete(&model.JumpEvent{})
+	if err := tx.Error; err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to delete JumpEvents")
+		return 0, err
+	}
+	return tx.RowsAffected, nil
+}
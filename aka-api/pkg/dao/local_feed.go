@@ -0,0 +1,273 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// localFeedBuffer is the number of messages that
+// a subscriber can fall behind by before messages
+// are dropped.
+const localFeedBuffer = 64
+
+const (
+	localNotifyIDsKey  = "aka:notify_ids"
+	localNotifyRowsKey = "aka:notify_rows"
+)
+
+// LocalFeed is a ChangeFeed that publishes table changes
+// to subscribers in the same process. It stands in for the
+// LISTEN/NOTIFY triggers when using a database that doesn't
+// support them, or repositories that aren't backed by a
+// database at all.
+//
+// When registered with gorm, messages are published as soon
+// as the statement completes, even if it is part of a
+// transaction that is later rolled back.
+type LocalFeed struct {
+	log         logr.Logger
+	mu          sync.RWMutex
+	subscribers map[string][]chan *Message
+	closed      bool
+}
+
+var _ ChangeFeed = &LocalFeed{}
+
+func NewLocalFeed(ctx context.Context) *LocalFeed {
+	return &LocalFeed{
+		log:         logr.FromContextOrDiscard(ctx).WithName("notifier"),
+		subscribers: map[string][]chan *Message{},
+	}
+}
+
+// Subscribe returns a channel that receives
+// changes to the given table.
+func (n *LocalFeed) Subscribe(_ context.Context, table string) (<-chan *Message, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil, ErrFeedClosed
+	}
+	ch := make(chan *Message, localFeedBuffer)
+	n.subscribers[table] = append(n.subscribers[table], ch)
+	return ch, nil
+}
+
+// Health returns ErrFeedClosed once the feed has
+// been closed. Until then, it is always healthy.
+func (n *LocalFeed) Health() error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return ErrFeedClosed
+	}
+	return nil
+}
+
+// Close closes the channels of every subscriber.
+func (n *LocalFeed) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil
+	}
+	n.closed = true
+	for _, subscribers := range n.subscribers {
+		for _, ch := range subscribers {
+			close(ch)
+		}
+	}
+	n.subscribers = nil
+	return nil
+}
+
+// Publish sends a message to everything subscribed
+// to its table. It never blocks, so a subscriber that
+// isn't keeping up will miss messages.
+func (n *LocalFeed) Publish(msg *Message) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, ch := range n.subscribers[msg.Table] {
+		select {
+		case ch <- msg:
+		default:
+			n.log.Info("dropping message as subscriber is full", "Table", msg.Table, "ID", msg.ID)
+		}
+	}
+}
+
+// Register adds gorm callbacks that publish a message
+// for each row that is created, updated or deleted.
+func (n *LocalFeed) Register(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:create").Register("aka:notify_create", n.afterCreate); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("aka:notify_before_update", n.collectRows); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("aka:notify_update", n.afterUpdate); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").Register("aka:notify_before_delete", n.collectRows); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("aka:notify_delete", n.afterDelete)
+}
+
+func (n *LocalFeed) afterCreate(db *gorm.DB) {
+	if !n.shouldPublish(db) {
+		return
+	}
+	ids := primaryKeys(db)
+	n.publishAll(db.Statement.Table, "INSERT", ids, nil, n.loadRows(db, ids))
+}
+
+func (n *LocalFeed) afterUpdate(db *gorm.DB) {
+	if !n.shouldPublish(db) {
+		return
+	}
+	ids, before := n.collectedRows(db)
+	n.publishAll(db.Statement.Table, "UPDATE", ids, before, n.loadRows(db, ids))
+}
+
+func (n *LocalFeed) afterDelete(db *gorm.DB) {
+	if !n.shouldPublish(db) {
+		return
+	}
+	ids, before := n.collectedRows(db)
+	// soft-deletes are updates as far as the database
+	// is concerned, so match what the postgres
+	// triggers would send
+	if !db.Statement.Unscoped && db.Statement.Schema.LookUpField("DeletedAt") != nil {
+		n.publishAll(db.Statement.Table, "UPDATE", ids, before, n.loadRows(db, ids))
+		return
+	}
+	n.publishAll(db.Statement.Table, "DELETE", ids, before, nil)
+}
+
+func (n *LocalFeed) shouldPublish(db *gorm.DB) bool {
+	return db.Error == nil && db.RowsAffected > 0 && db.Statement.Schema != nil && slices.Contains(tableNames, db.Statement.Table)
+}
+
+func (n *LocalFeed) publishAll(table, op string, ids []uint, before, after map[uint]map[string]any) {
+	for _, id := range ids {
+		n.Publish(NewRowMessage(table, op, id, before[id], after[id]))
+	}
+}
+
+// collectRows records the rows that an update or
+// delete is going to affect, since they can't be
+// determined once the statement has run.
+func (n *LocalFeed) collectRows(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || !slices.Contains(tableNames, db.Statement.Table) {
+		return
+	}
+	ids := primaryKeys(db)
+	if len(ids) == 0 {
+		where, ok := db.Statement.Clauses["WHERE"]
+		if !ok {
+			return
+		}
+		if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+			Model(db.Statement.Model).
+			Clauses(where.Expression).
+			Pluck(db.Statement.Schema.PrioritizedPrimaryField.DBName, &ids).Error; err != nil {
+			n.log.Error(err, "failed to determine affected rows", "Table", db.Statement.Table)
+			return
+		}
+	}
+	db.InstanceSet(localNotifyIDsKey, ids)
+	db.InstanceSet(localNotifyRowsKey, n.loadRows(db, ids))
+}
+
+func (n *LocalFeed) collectedRows(db *gorm.DB) ([]uint, map[uint]map[string]any) {
+	ids, ok := db.InstanceGet(localNotifyIDsKey)
+	if !ok {
+		return nil, nil
+	}
+	rows, _ := db.InstanceGet(localNotifyRowsKey)
+	before, _ := rows.(map[uint]map[string]any)
+	return ids.([]uint), before
+}
+
+// loadRows returns the column values of the rows with
+// the given IDs, including any that are soft-deleted.
+// If they can't be loaded, messages will only
+// contain the ID.
+func (n *LocalFeed) loadRows(db *gorm.DB, ids []uint) map[uint]map[string]any {
+	if len(ids) == 0 {
+		return nil
+	}
+	pk := db.Statement.Schema.PrioritizedPrimaryField.DBName
+	var rows []map[string]any
+	if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+		Unscoped().
+		Table(db.Statement.Table).
+		Where(pk+" IN ?", ids).
+		Find(&rows).Error; err != nil {
+		n.log.Error(err, "failed to load affected rows", "Table", db.Statement.Table)
+		return nil
+	}
+	results := make(map[uint]map[string]any, len(rows))
+	for _, row := range rows {
+		switch id := row[pk].(type) {
+		case int64:
+			results[uint(id)] = row
+		case uint:
+			results[id] = row
+		}
+	}
+	return results
+}
+
+var rowSchemas = &sync.Map{}
+
+// RowValues returns the column values of a model in
+// the form expected by NewRowMessage. It is intended
+// for repositories that aren't backed by a database.
+func RowValues(row any) map[string]any {
+	s, err := schema.Parse(row, rowSchemas, schema.NamingStrategy{})
+	if err != nil {
+		return nil
+	}
+	rv := reflect.Indirect(reflect.ValueOf(row))
+	values := make(map[string]any, len(s.DBNames))
+	for _, name := range s.DBNames {
+		values[name], _ = s.FieldsByDBName[name].ValueOf(context.Background(), rv)
+	}
+	return values
+}
+
+// primaryKeys returns the non-zero primary keys
+// of the values in a statement.
+func primaryKeys(db *gorm.DB) []uint {
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return nil
+	}
+	var ids []uint
+	add := func(rv reflect.Value) {
+		v, zero := field.ValueOf(db.Statement.Context, reflect.Indirect(rv))
+		if zero {
+			return
+		}
+		if id, ok := v.(uint); ok {
+			ids = append(ids, id)
+		}
+	}
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			add(rv.Index(i))
+		}
+	case reflect.Struct:
+		add(rv)
+	}
+	return ids
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AuditRepo is an in-memory dao.AuditRepository
+type AuditRepo struct {
+	mu     sync.RWMutex
+	events []*model.AuditEvent
+	nextID uint
+}
+
+var _ dao.AuditRepository = &AuditRepo{}
+
+func NewAuditRepo() *AuditRepo {
+	return &AuditRepo{
+		nextID: 1,
+	}
+}
+
+// Create stores a new AuditEvent
+func (r *AuditRepo) Create(_ context.Context, e *model.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.ID = r.nextID
+	r.nextID++
+	cp := *e
+	r.events = append(r.events, &cp)
+	return nil
+}
+
+// Find returns the AuditEvents that match a
+// filter, newest first.
+func (r *AuditRepo) Find(_ context.Context, filter model.AuditFilter, offset, limit int) ([]*model.AuditEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.AuditEvent, 0)
+	for _, e := range r.events {
+		if filter.Actor != "" && e.Actor != filter.Actor {
+			continue
+		}
+		if filter.Resource != "" && e.Resource != filter.Resource {
+			continue
+		}
+		if filter.Since > 0 && e.Date < filter.Since {
+			continue
+		}
+		if filter.Until > 0 && e.Date >= filter.Until {
+			continue
+		}
+		cp := *e
+		results = append(results, &cp)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Date != results[j].Date {
+			return results[i].Date > results[j].Date
+		}
+		return results[i].ID > results[j].ID
+	})
+	start := min(max(offset, 0), len(results))
+	end := len(results)
+	if limit >= 0 {
+		end = min(start+limit, end)
+	}
+	return results[start:end], nil
+}
+
+// DeleteBefore permanently removes all AuditEvents
+// that occurred before a given time.
+func (r *AuditRepo) DeleteBefore(_ context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	events := make([]*model.AuditEvent, 0, len(r.events))
+	for _, e := range r.events {
+		if e.Date < before.Unix() {
+			count++
+			continue
+		}
+		events = append(events, e)
+	}
+	r.events = events
+	return count, nil
+}
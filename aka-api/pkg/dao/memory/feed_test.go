@@ -0,0 +1,18 @@
+package memory
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/feedtest"
+	"testing"
+)
+
+func TestChangeFeed(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	feedtest.Run(ctx, t, func(t *testing.T) (dao.ChangeFeed, *dao.Repos) {
+		feed := dao.NewLocalFeed(ctx)
+		return feed, NewRepos(feed)
+	})
+}
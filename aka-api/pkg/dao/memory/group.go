@@ -0,0 +1,303 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GroupRepo is an in-memory dao.GroupRepository
+type GroupRepo struct {
+	groups *table[model.Group]
+
+	mu sync.RWMutex
+	// members contains the members of each
+	// Group, indexed by subject
+	members map[uint]map[string]*model.GroupMember
+	events  []*model.GroupMemberEvent
+	// jumps holds the Jumps that are
+	// deleted along with a Group
+	jumps *JumpRepo
+}
+
+var _ dao.GroupRepository = &GroupRepo{}
+
+func NewGroupRepo(feed *dao.LocalFeed, jumps *JumpRepo) *GroupRepo {
+	return &GroupRepo{
+		jumps: jumps,
+		groups: newTable(model.TableNameGroups, feed, func(g *model.Group) *gorm.Model {
+			return &g.Model
+		}),
+		members: map[uint]map[string]*model.GroupMember{},
+	}
+}
+
+// Save creates or updates a given Group. Members
+// are not saved, use AddMember instead.
+func (r *GroupRepo) Save(e *model.Group) (*model.Group, error) {
+	r.groups.save(e)
+	return e, nil
+}
+
+// FindByID returns a Group by its primaryKey (ID)
+// if the current user is a member.
+func (r *GroupRepo) FindByID(ctx context.Context, id int) (*model.Group, error) {
+	user, _ := identity.GetContextUser(ctx)
+	group, err := r.groups.get(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !r.isMember(group, user.Subject) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	r.withMembers(group)
+	return group, nil
+}
+
+// GetByID returns a Group by its primaryKey (ID),
+// regardless of whether the current user can see it.
+func (r *GroupRepo) GetByID(_ context.Context, id uint) (*model.Group, error) {
+	group, err := r.groups.get(id)
+	if err != nil {
+		return nil, err
+	}
+	r.withMembers(group)
+	return group, nil
+}
+
+// FindByName returns a Group by a given name
+func (r *GroupRepo) FindByName(_ context.Context, name string) (*model.Group, error) {
+	results := r.groups.find(func(g *model.Group) bool {
+		return g.Name == name
+	})
+	if len(results) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	r.withMembers(results[0])
+	return results[0], nil
+}
+
+// GetUserGroups gets all groups that contain a given user.
+// If the given user is NOT the current user, the groups
+// returned will be the intersection of groups shared between both
+// users.
+func (r *GroupRepo) GetUserGroups(ctx context.Context, username string) ([]*model.Group, error) {
+	user, ok := identity.GetContextUser(ctx)
+	if !ok {
+		return nil, errors.New("unauthorised")
+	}
+	results := r.groups.find(func(g *model.Group) bool {
+		return !g.Archived && r.isMember(g, username) && r.isMember(g, user.Subject)
+	})
+	sortByName(results)
+	r.withMembers(results...)
+	return results, nil
+}
+
+// GetGroups gets all groups visible to the
+// requesting user.
+func (r *GroupRepo) GetGroups(_ context.Context, user string, offset, limit int) (*model.Page, error) {
+	results := r.groups.find(func(g *model.Group) bool {
+		return !g.Archived && (g.Public || r.isMember(g, user))
+	})
+	sortByName(results)
+	r.withMembers(results...)
+	return page(results, offset, limit), nil
+}
+
+// GetArchived gets the archived groups
+// that contain a given user.
+func (r *GroupRepo) GetArchived(_ context.Context, username string) ([]*model.Group, error) {
+	results := r.groups.find(func(g *model.Group) bool {
+		return g.Archived && r.isMember(g, username)
+	})
+	sortByName(results)
+	r.withMembers(results...)
+	return results, nil
+}
+
+// Delete soft-deletes a Group by a given primaryKey (ID)
+// along with its Jumps, or gives its Jumps to reassignTo.
+// Its members are kept so that it can be restored.
+func (r *GroupRepo) Delete(ctx context.Context, id uint, reassignTo string) ([]uint, error) {
+	owner := schemas.ResourceName(schemas.ResourceGroup, id)
+	var ids []uint
+	if r.jumps != nil {
+		var err error
+		if reassignTo == "" {
+			_, err = r.jumps.DeleteByOwner(ctx, owner)
+		} else {
+			ids, err = r.jumps.SetOwner(ctx, owner, reassignTo)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.groups.softDelete(id)
+	return ids, nil
+}
+
+// GetMembers returns the members of a Group
+// in the order that they were added.
+func (r *GroupRepo) GetMembers(_ context.Context, id uint) ([]*model.GroupMember, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.GroupMember, 0, len(r.members[id]))
+	for _, m := range r.members[id] {
+		cp := *m
+		results = append(results, &cp)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].AddedAt.Equal(results[j].AddedAt) {
+			return results[i].Subject < results[j].Subject
+		}
+		return results[i].AddedAt.Before(results[j].AddedAt)
+	})
+	return results, nil
+}
+
+// GetMember returns a single member of a Group.
+func (r *GroupRepo) GetMember(_ context.Context, id uint, subject string) (*model.GroupMember, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.members[id][subject]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	cp := *m
+	return &cp, nil
+}
+
+// AddMember adds a user to a Group. If they are
+// already a member, their role is updated.
+func (r *GroupRepo) AddMember(_ context.Context, m *model.GroupMember) error {
+	if m.AddedAt.IsZero() {
+		m.AddedAt = time.Now()
+	}
+	r.mu.Lock()
+	members, ok := r.members[m.GroupID]
+	if !ok {
+		members = map[string]*model.GroupMember{}
+		r.members[m.GroupID] = members
+	}
+	if existing, ok := members[m.Subject]; ok {
+		existing.Role = m.Role
+	} else {
+		cp := *m
+		members[m.Subject] = &cp
+	}
+	r.mu.Unlock()
+	r.touch(m.GroupID)
+	return nil
+}
+
+// RemoveMember removes a user from a Group.
+func (r *GroupRepo) RemoveMember(_ context.Context, id uint, subject string) error {
+	r.mu.Lock()
+	_, ok := r.members[id][subject]
+	delete(r.members[id], subject)
+	r.mu.Unlock()
+	if ok {
+		r.touch(id)
+	}
+	return nil
+}
+
+// GetMemberships returns every Group that a user is
+// a member of, including those that are archived.
+func (r *GroupRepo) GetMemberships(_ context.Context, subject string) ([]*model.GroupMember, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.GroupMember, 0)
+	for _, members := range r.members {
+		if m, ok := members[subject]; ok {
+			cp := *m
+			results = append(results, &cp)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].GroupID < results[j].GroupID
+	})
+	return results, nil
+}
+
+// AddMemberEvent records a change to the members of a Group.
+func (r *GroupRepo) AddMemberEvent(_ context.Context, e *model.GroupMemberEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.ID = uint(len(r.events) + 1)
+	cp := *e
+	r.events = append(r.events, &cp)
+	return nil
+}
+
+// GetMemberEvents returns the changes to the
+// members of a Group, newest first.
+func (r *GroupRepo) GetMemberEvents(_ context.Context, id uint, offset, limit int) ([]*model.GroupMemberEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.GroupMemberEvent, 0)
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].GroupID == id {
+			cp := *r.events[i]
+			results = append(results, &cp)
+		}
+	}
+	start := min(max(offset, 0), len(results))
+	end := len(results)
+	if limit >= 0 {
+		end = min(start+limit, end)
+	}
+	return results[start:end], nil
+}
+
+// touch marks a Group as updated so that a change
+// notification is sent when its members change.
+func (r *GroupRepo) touch(id uint) {
+	group, err := r.groups.get(id)
+	if err != nil {
+		return
+	}
+	r.groups.save(group)
+}
+
+// FindInBatches iterates over every Group, regardless of
+// who can see it.
+func (r *GroupRepo) FindInBatches(_ context.Context, size int, f func(groups []*model.Group) error) error {
+	results := r.groups.find(all)
+	r.withMembers(results...)
+	return batches(results, size, f)
+}
+
+func (r *GroupRepo) isMember(g *model.Group, user string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.members[g.ID][user]
+	return ok
+}
+
+// withMembers fills in the Users of each Group.
+func (r *GroupRepo) withMembers(groups ...*model.Group) {
+	for _, g := range groups {
+		members, _ := r.GetMembers(context.Background(), g.ID)
+		users := make([]string, len(members))
+		for i, m := range members {
+			users[i] = m.Subject
+		}
+		g.Users = strings.Join(users, ",")
+	}
+}
+
+func sortByName(groups []*model.Group) {
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+}
@@ -0,0 +1,158 @@
+package memory
+
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// JumpRepo is an in-memory dao.JumpRepository
+type JumpRepo struct {
+	jumps *table[model.Jump]
+}
+
+var _ dao.JumpRepository = &JumpRepo{}
+
+func NewJumpRepo(feed *dao.LocalFeed) *JumpRepo {
+	return &JumpRepo{
+		jumps: newTable(model.TableNameJumps, feed, func(j *model.Jump) *gorm.Model {
+			return &j.Model
+		}),
+	}
+}
+
+// GetAll returns all Jumps available to a user
+func (jr *JumpRepo) GetAll(_ context.Context, user string, offset, limit int, groups []uint) (*model.Page, error) {
+	visible := canSee(user, groups)
+	return page(jr.jumps.find(visible), offset, limit), nil
+}
+
+// ExistsByID returns whether a Jump exists by a given primaryKey (ID)
+func (jr *JumpRepo) ExistsByID(_ context.Context, id uint) bool {
+	_, err := jr.jumps.get(id)
+	return err == nil
+}
+
+// GetByID returns a Jump by its primaryKey (ID)
+func (jr *JumpRepo) GetByID(_ context.Context, id uint) (*model.Jump, error) {
+	return jr.jumps.get(id)
+}
+
+// SearchForTerm returns the Jumps available to a user
+// that have a word in their name, location or aliases
+// starting with the term.
+func (jr *JumpRepo) SearchForTerm(_ context.Context, user, term string, offset, limit int, groups []uint) (*model.Page, error) {
+	visible := canSee(user, groups)
+	term = strings.ToLower(term)
+	return page(jr.jumps.find(func(j *model.Jump) bool {
+		if !visible(j) {
+			return false
+		}
+		fields := append([]string{j.Name, j.Location}, j.Alias...)
+		for _, f := range fields {
+			if matchesPrefix(f, term) {
+				return true
+			}
+		}
+		return false
+	}), offset, limit), nil
+}
+
+// Save creates or updates a Jump
+func (jr *JumpRepo) Save(_ context.Context, j *model.Jump) (*model.Jump, error) {
+	jr.jumps.save(j)
+	return j, nil
+}
+
+// DeleteByID soft-deletes a Jump by a given primaryKey (ID)
+func (jr *JumpRepo) DeleteByID(_ context.Context, id uint) error {
+	jr.jumps.softDelete(id)
+	return nil
+}
+
+// GetByHost returns the Jumps available to a user
+// whose location mentions the host, regardless of case.
+func (jr *JumpRepo) GetByHost(_ context.Context, user, host string, groups []uint) ([]*model.Jump, error) {
+	visible := canSee(user, groups)
+	host = strings.ToLower(host)
+	return jr.jumps.find(func(j *model.Jump) bool {
+		return visible(j) && strings.Contains(strings.ToLower(j.Location), host)
+	}), nil
+}
+
+// GetByOwner returns every Jump owned by a given owner
+func (jr *JumpRepo) GetByOwner(_ context.Context, owner string) ([]*model.Jump, error) {
+	return jr.jumps.find(func(j *model.Jump) bool {
+		return j.Owner == owner
+	}), nil
+}
+
+// DeleteByOwner soft-deletes every Jump owned by a given owner
+func (jr *JumpRepo) DeleteByOwner(_ context.Context, owner string) (int64, error) {
+	jumps := jr.jumps.find(func(j *model.Jump) bool {
+		return j.Owner == owner
+	})
+	for _, j := range jumps {
+		jr.jumps.softDelete(j.ID)
+	}
+	return int64(len(jumps)), nil
+}
+
+// SetOwner moves every Jump owned by one owner to
+// another, returning the IDs of the Jumps that moved.
+func (jr *JumpRepo) SetOwner(_ context.Context, from, to string) ([]uint, error) {
+	jumps := jr.jumps.find(func(j *model.Jump) bool {
+		return j.Owner == from
+	})
+	ids := make([]uint, len(jumps))
+	for i, j := range jumps {
+		j.Owner = to
+		jr.jumps.save(j)
+		ids[i] = j.ID
+	}
+	return ids, nil
+}
+
+// FindInBatches iterates over every Jump, regardless of
+// who can see it.
+func (jr *JumpRepo) FindInBatches(_ context.Context, size int, f func(jumps []*model.Jump) error) error {
+	return batches(jr.jumps.find(all), size, f)
+}
+
+// canSee mirrors the owner check used by the
+// database: a Jump is visible if it is public, or
+// owned by the user or one of their groups.
+func canSee(user string, groups []uint) func(j *model.Jump) bool {
+	owners := map[string]struct{}{
+		"user://" + user: {},
+	}
+	for _, g := range groups {
+		owners["group://"+strconv.FormatUint(uint64(g), 10)] = struct{}{}
+	}
+	return func(j *model.Jump) bool {
+		if j.IsPublic() {
+			return true
+		}
+		_, ok := owners[j.Owner]
+		return ok
+	}
+}
+
+// matchesPrefix approximates a Postgres 'simple'
+// prefix query by checking whether any word in s
+// starts with term.
+func matchesPrefix(s, term string) bool {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if strings.HasPrefix(w, term) {
+			return true
+		}
+	}
+	return false
+}
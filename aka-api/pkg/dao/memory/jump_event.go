@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// JumpEventRepo is an in-memory dao.JumpEventRepository
+type JumpEventRepo struct {
+	events *table[model.JumpEvent]
+}
+
+var _ dao.JumpEventRepository = &JumpEventRepo{}
+
+func NewJumpEventRepo(feed *dao.LocalFeed) *JumpEventRepo {
+	return &JumpEventRepo{
+		events: newTable("jump_events", feed, func(e *model.JumpEvent) *gorm.Model {
+			return &e.Model
+		}),
+	}
+}
+
+// Save creates or updates a given JumpEvent
+func (r *JumpEventRepo) Save(_ context.Context, e *model.JumpEvent) (*model.JumpEvent, error) {
+	r.events.save(e)
+	return e, nil
+}
+
+// GetFrequencyByUserID returns the Jumps most used by a given user
+func (r *JumpEventRepo) GetFrequencyByUserID(_ context.Context, userID string, limit int) ([]dao.JumpEventFrequency, error) {
+	counts := map[uint]int64{}
+	for _, e := range r.events.find(func(e *model.JumpEvent) bool {
+		return e.UserID == userID
+	}) {
+		counts[e.JumpID]++
+	}
+	results := make([]dao.JumpEventFrequency, 0, len(counts))
+	for id, count := range counts {
+		results = append(results, dao.JumpEventFrequency{JumpID: id, Count: count})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Count == results[j].Count {
+			return results[i].JumpID < results[j].JumpID
+		}
+		return results[i].Count > results[j].Count
+	})
+	if limit >= 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// DeleteBefore permanently removes all JumpEvents that
+// occurred before a given time.
+func (r *JumpEventRepo) DeleteBefore(_ context.Context, before time.Time) (int64, error) {
+	r.events.mu.Lock()
+	defer r.events.mu.Unlock()
+	var count int64
+	for id, e := range r.events.rows {
+		if e.Date < before.Unix() {
+			delete(r.events.rows, id)
+			count++
+		}
+	}
+	return count, nil
+}
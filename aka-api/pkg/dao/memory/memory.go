@@ -0,0 +1,178 @@
+package memory
+
+import (
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewRepos creates a set of empty in-memory repositories.
+// Changes are published to the feed if one is given.
+func NewRepos(feed *dao.LocalFeed) *dao.Repos {
+	jumps := NewJumpRepo(feed)
+	return &dao.Repos{
+		JumpRepo:      jumps,
+		GroupRepo:     NewGroupRepo(feed, jumps),
+		UserRepo:      NewUserRepo(feed),
+		JumpEventRepo: NewJumpEventRepo(feed),
+		TokenRepo:     NewAccessTokenRepo(),
+		AuditRepo:     NewAuditRepo(),
+		WebhookRepo:   NewWebhookRepo(),
+		ProposalRepo:  NewJumpProposalRepo(feed, jumps),
+	}
+}
+
+// table is a thread-safe collection of rows
+// indexed by their primary key.
+type table[T any] struct {
+	mu     sync.RWMutex
+	rows   map[uint]*T
+	nextID uint
+	// model returns the embedded gorm.Model
+	// of a row
+	model func(row *T) *gorm.Model
+
+	name string
+	feed *dao.LocalFeed
+}
+
+func newTable[T any](name string, feed *dao.LocalFeed, model func(row *T) *gorm.Model) *table[T] {
+	return &table[T]{
+		rows:   map[uint]*T{},
+		nextID: 1,
+		model:  model,
+		name:   name,
+		feed:   feed,
+	}
+}
+
+// save stores a copy of the row, assigning it an
+// ID if it doesn't have one.
+func (t *table[T]) save(row *T) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.model(row)
+	op := "UPDATE"
+	before, ok := t.rows[m.ID]
+	if !ok {
+		op = "INSERT"
+	}
+	now := time.Now()
+	if m.ID == 0 {
+		m.ID = t.nextID
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+	}
+	if m.ID >= t.nextID {
+		t.nextID = m.ID + 1
+	}
+	m.UpdatedAt = now
+	cp := *row
+	t.rows[m.ID] = &cp
+	t.publish(op, m.ID, before, &cp)
+}
+
+// get returns a copy of the row with the given
+// ID, unless it has been deleted.
+func (t *table[T]) get(id uint) (*T, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	row, ok := t.rows[id]
+	if !ok || t.model(row).DeletedAt.Valid {
+		return nil, gorm.ErrRecordNotFound
+	}
+	cp := *row
+	return &cp, nil
+}
+
+// softDelete marks the row as deleted so
+// that it is hidden from queries.
+func (t *table[T]) softDelete(id uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row, ok := t.rows[id]
+	if !ok {
+		return
+	}
+	before := *row
+	t.model(row).DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	// soft-deletes are updates as far as
+	// the database is concerned
+	t.publish("UPDATE", id, &before, row)
+}
+
+// publish describes the change to a row. Before
+// is nil if the row has just been created.
+func (t *table[T]) publish(op string, id uint, before, after *T) {
+	if t.feed == nil {
+		return
+	}
+	var old map[string]any
+	if before != nil {
+		old = dao.RowValues(before)
+	}
+	t.feed.Publish(dao.NewRowMessage(t.name, op, id, old, dao.RowValues(after)))
+}
+
+// find returns a copy of every row that matches
+// f, sorted by ID. Deleted rows are skipped.
+func (t *table[T]) find(f func(row *T) bool) []*T {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	results := make([]*T, 0)
+	for _, row := range t.rows {
+		if t.model(row).DeletedAt.Valid || !f(row) {
+			continue
+		}
+		cp := *row
+		results = append(results, &cp)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return t.model(results[i]).ID < t.model(results[j]).ID
+	})
+	return results
+}
+
+// all matches every row
+func all[T any](*T) bool {
+	return true
+}
+
+// batches calls f with successive slices of
+// at most size items.
+func batches[T any](items []T, size int, f func([]T) error) error {
+	if size <= 0 {
+		size = len(items)
+	}
+	for i := 0; i < len(items); i += size {
+		if err := f(items[i:min(i+size, len(items))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// page converts a slice of results into a
+// model.Page using the same arithmetic as
+// the database repositories.
+func page[T model.Pageable](items []T, offset, limit int) *model.Page {
+	count := len(items)
+	start := min(max(offset, 0), count)
+	end := count
+	if limit >= 0 {
+		end = min(start+limit, count)
+	}
+	results := make([]model.Pageable, 0, end-start)
+	for _, i := range items[start:end] {
+		results = append(results, i)
+	}
+	return &model.Page{
+		Results: results,
+		Count:   count,
+		More:    count-((offset+1)*limit) > 0,
+	}
+}
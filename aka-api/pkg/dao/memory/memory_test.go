@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/datatypes"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestJumpRepo(t *testing.T) {
+	ctx := context.TODO()
+	repo := NewJumpRepo(nil)
+
+	for _, j := range []*model.Jump{
+		{Name: "public", Location: "https://example.org"},
+		{Name: "mine", Location: "https://example.org/mine", Owner: "user://john"},
+		{Name: "theirs", Location: "https://example.org/theirs", Owner: "user://jane"},
+		{Name: "team", Location: "https://example.org/team", Owner: "group://1", Alias: datatypes.JSONArray{"squad"}},
+	} {
+		_, err := repo.Save(ctx, j)
+		require.NoError(t, err)
+		assert.NotZero(t, j.ID)
+	}
+
+	page, err := repo.GetAll(ctx, "john", 0, 10, []uint{1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, page.Count)
+	assert.False(t, page.More)
+
+	page, err = repo.GetAll(ctx, "john", 0, 1, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, page.Count)
+	assert.Len(t, page.Results, 1)
+	assert.True(t, page.More)
+
+	page, err = repo.SearchForTerm(ctx, "john", "squ", 0, 10, []uint{1})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+	assert.EqualValues(t, "team", page.Results[0].(*model.Jump).Name)
+
+	// nobody can find jumps they can't see
+	page, err = repo.SearchForTerm(ctx, "john", "theirs", 0, 10, nil)
+	require.NoError(t, err)
+	assert.Empty(t, page.Results)
+
+	require.NoError(t, repo.DeleteByID(ctx, 1))
+	assert.False(t, repo.ExistsByID(ctx, 1))
+	_, err = repo.GetByID(ctx, 1)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	var seen int
+	assert.NoError(t, repo.FindInBatches(ctx, 2, func(jumps []*model.Jump) error {
+		assert.LessOrEqual(t, len(jumps), 2)
+		seen += len(jumps)
+		return nil
+	}))
+	assert.EqualValues(t, 3, seen)
+}
+
+func TestGroupRepo(t *testing.T) {
+	ctx := context.TODO()
+	repo := NewGroupRepo(nil, nil)
+
+	b, err := repo.Save(&model.Group{Name: "b"})
+	require.NoError(t, err)
+	a, err := repo.Save(&model.Group{Name: "a"})
+	require.NoError(t, err)
+	_, err = repo.Save(&model.Group{Name: "c", Public: true})
+	require.NoError(t, err)
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: b.ID, Subject: "john"}))
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: b.ID, Subject: "jane"}))
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: a.ID, Subject: "jane"}))
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: a.ID, Subject: "johnny"}))
+
+	_, err = repo.GetUserGroups(ctx, "john")
+	assert.Error(t, err)
+
+	john := context.WithValue(ctx, identity.UserContextKey, &identity.OAuthUser{Subject: "john"})
+	groups, err := repo.GetUserGroups(john, "jane")
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.EqualValues(t, "b", groups[0].Name)
+
+	_, err = repo.FindByID(john, 2)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	page, err := repo.GetGroups(ctx, "jane", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, page.Results, 3)
+	assert.EqualValues(t, "a", page.Results[0].(*model.Group).Name)
+	assert.EqualValues(t, "jane,johnny", page.Results[0].(*model.Group).Users)
+
+	require.NoError(t, repo.RemoveMember(ctx, b.ID, "jane"))
+	groups, err = repo.GetUserGroups(john, "jane")
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestUserRepo(t *testing.T) {
+	ctx := context.TODO()
+	repo := NewUserRepo(nil)
+
+	_, err := repo.Save(ctx, &dao.UserV2{Subject: "john", Groups: "a,b"})
+	require.NoError(t, err)
+	_, err = repo.Save(ctx, &dao.UserV2{Subject: "john"})
+	assert.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+
+	user, err := repo.Get(ctx, "john")
+	require.NoError(t, err)
+	assert.EqualValues(t, "a,b", user.Groups)
+
+	page, err := repo.GetUsers(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+	assert.EqualValues(t, []string{"a", "b"}, page.Results[0].(*model.User).Groups)
+
+	// deactivated users are hidden
+	user.Deactivated = true
+	_, err = repo.Save(ctx, user)
+	require.NoError(t, err)
+	page, err = repo.GetUsers(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, page.Results)
+}
+
+func TestJumpEventRepo(t *testing.T) {
+	ctx := context.TODO()
+	repo := NewJumpEventRepo(nil)
+
+	now := time.Now()
+	for _, e := range []*model.JumpEvent{
+		{UserID: "john", JumpID: 1, Date: now.Unix()},
+		{UserID: "john", JumpID: 2, Date: now.Unix()},
+		{UserID: "john", JumpID: 2, Date: now.Add(-time.Hour).Unix()},
+		{UserID: "jane", JumpID: 1, Date: now.Unix()},
+	} {
+		_, err := repo.Save(ctx, e)
+		require.NoError(t, err)
+	}
+
+	freq, err := repo.GetFrequencyByUserID(ctx, "john", 10)
+	require.NoError(t, err)
+	assert.EqualValues(t, []dao.JumpEventFrequency{{JumpID: 2, Count: 2}, {JumpID: 1, Count: 1}}, freq)
+
+	count, err := repo.DeleteBefore(ctx, now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// JumpProposalRepo is an in-memory dao.JumpProposalRepository
+type JumpProposalRepo struct {
+	mu        sync.RWMutex
+	proposals []*model.JumpProposal
+	nextID    uint
+	feed      *dao.LocalFeed
+	// jumps receives the Jumps that
+	// are created by approvals
+	jumps *JumpRepo
+}
+
+var _ dao.JumpProposalRepository = &JumpProposalRepo{}
+
+func NewJumpProposalRepo(feed *dao.LocalFeed, jumps *JumpRepo) *JumpProposalRepo {
+	return &JumpProposalRepo{
+		nextID: 1,
+		feed:   feed,
+		jumps:  jumps,
+	}
+}
+
+// Create stores a new JumpProposal
+func (r *JumpProposalRepo) Create(_ context.Context, p *model.JumpProposal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p.ID = r.nextID
+	r.nextID++
+	cp := *p
+	r.proposals = append(r.proposals, &cp)
+	r.publish("INSERT", nil, &cp)
+	return nil
+}
+
+// Save updates an existing JumpProposal
+func (r *JumpProposalRepo) Save(_ context.Context, p *model.JumpProposal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.proposals {
+		if existing.ID == p.ID {
+			cp := *p
+			r.proposals[i] = &cp
+			r.publish("UPDATE", existing, &cp)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// Approve creates the Jump that was proposed and saves
+// the JumpProposal while holding the lock, so that a
+// proposal can only be approved once.
+func (r *JumpProposalRepo) Approve(ctx context.Context, p *model.JumpProposal, jump *model.Jump) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.proposals {
+		if existing.ID != p.ID {
+			continue
+		}
+		if existing.Status != model.ProposalStatusProposed {
+			break
+		}
+		if _, err := r.jumps.Save(ctx, jump); err != nil {
+			return err
+		}
+		p.JumpID = jump.ID
+		cp := *p
+		r.proposals[i] = &cp
+		r.publish("UPDATE", existing, &cp)
+		return nil
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// Get returns a JumpProposal by its primaryKey (ID)
+func (r *JumpProposalRepo) Get(_ context.Context, id uint) (*model.JumpProposal, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.proposals {
+		if p.ID == id {
+			cp := *p
+			return &cp, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetByStatus returns the JumpProposals with
+// a given status, oldest first.
+func (r *JumpProposalRepo) GetByStatus(_ context.Context, status model.ProposalStatus, offset, limit int) ([]*model.JumpProposal, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.JumpProposal, 0)
+	for _, p := range r.proposals {
+		if p.Status == status {
+			cp := *p
+			results = append(results, &cp)
+		}
+	}
+	start := min(max(offset, 0), len(results))
+	end := len(results)
+	if limit >= 0 {
+		end = min(start+limit, end)
+	}
+	return results[start:end], nil
+}
+
+// GetBySubmitter returns the JumpProposals
+// made by a user, newest first.
+func (r *JumpProposalRepo) GetBySubmitter(_ context.Context, subject string) ([]*model.JumpProposal, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.JumpProposal, 0)
+	for i := len(r.proposals) - 1; i >= 0; i-- {
+		if r.proposals[i].Submitter == subject {
+			cp := *r.proposals[i]
+			results = append(results, &cp)
+		}
+	}
+	return results, nil
+}
+
+// publish describes the change to a JumpProposal
+func (r *JumpProposalRepo) publish(op string, before, after *model.JumpProposal) {
+	if r.feed == nil {
+		return
+	}
+	var old map[string]any
+	if before != nil {
+		old = dao.RowValues(before)
+	}
+	r.feed.Publish(dao.NewRowMessage(model.TableNameJumpProposals, op, after.ID, old, dao.RowValues(after)))
+}
@@ -0,0 +1,110 @@
+package memory
+
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// AccessTokenRepo is an in-memory dao.AccessTokenRepository
+type AccessTokenRepo struct {
+	mu     sync.RWMutex
+	tokens []*model.AccessToken
+	nextID uint
+}
+
+var _ dao.AccessTokenRepository = &AccessTokenRepo{}
+
+func NewAccessTokenRepo() *AccessTokenRepo {
+	return &AccessTokenRepo{
+		nextID: 1,
+	}
+}
+
+// Create stores a new AccessToken
+func (r *AccessTokenRepo) Create(_ context.Context, t *model.AccessToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.Hash == t.Hash {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	t.ID = r.nextID
+	r.nextID++
+	cp := *t
+	r.tokens = append(r.tokens, &cp)
+	return nil
+}
+
+// GetByHash returns the AccessToken with the given hash
+func (r *AccessTokenRepo) GetByHash(_ context.Context, hash string) (*model.AccessToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.tokens {
+		if t.Hash == hash {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetBySubject returns the AccessTokens belonging to a user, newest first
+func (r *AccessTokenRepo) GetBySubject(_ context.Context, subject string) ([]*model.AccessToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.AccessToken, 0)
+	for i := len(r.tokens) - 1; i >= 0; i-- {
+		if r.tokens[i].Subject == subject {
+			cp := *r.tokens[i]
+			results = append(results, &cp)
+		}
+	}
+	return results, nil
+}
+
+// Delete removes an AccessToken belonging to a user,
+// returning false if there wasn't one.
+func (r *AccessTokenRepo) Delete(_ context.Context, subject string, id uint) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range r.tokens {
+		if t.ID == id && t.Subject == subject {
+			r.tokens = append(r.tokens[:i], r.tokens[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteBySubject removes every AccessToken belonging to a user
+func (r *AccessTokenRepo) DeleteBySubject(_ context.Context, subject string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	tokens := make([]*model.AccessToken, 0, len(r.tokens))
+	for _, t := range r.tokens {
+		if t.Subject == subject {
+			count++
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	r.tokens = tokens
+	return count, nil
+}
+
+// SetLastUsed records when an AccessToken was last used
+func (r *AccessTokenRepo) SetLastUsed(_ context.Context, id uint, at int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tokens {
+		if t.ID == id {
+			t.LastUsedAt = at
+		}
+	}
+	return nil
+}
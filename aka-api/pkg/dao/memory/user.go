@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"sort"
+)
+
+// UserRepo is an in-memory dao.UserRepository
+type UserRepo struct {
+	users *table[dao.UserV2]
+}
+
+var _ dao.UserRepository = &UserRepo{}
+
+func NewUserRepo(feed *dao.LocalFeed) *UserRepo {
+	return &UserRepo{
+		users: newTable(model.TableNameUsersV2, feed, func(u *dao.UserV2) *gorm.Model {
+			return &u.Model
+		}),
+	}
+}
+
+// Save creates or updates a given User
+func (r *UserRepo) Save(_ context.Context, e *dao.UserV2) (*dao.UserV2, error) {
+	// subjects are unique
+	existing := r.users.find(func(u *dao.UserV2) bool {
+		return u.Subject == e.Subject && u.ID != e.ID
+	})
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("%w: subject %s", gorm.ErrDuplicatedKey, e.Subject)
+	}
+	r.users.save(e)
+	return e, nil
+}
+
+// Get returns a User by their subject
+func (r *UserRepo) Get(_ context.Context, sub string) (*dao.UserV2, error) {
+	results := r.users.find(func(u *dao.UserV2) bool {
+		return u.Subject == sub
+	})
+	if len(results) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return results[0], nil
+}
+
+// GetByID returns a User by their primaryKey (ID)
+func (r *UserRepo) GetByID(_ context.Context, id uint) (*dao.UserV2, error) {
+	return r.users.get(id)
+}
+
+// GetUsers returns a page of Users, except those that are deactivated
+func (r *UserRepo) GetUsers(_ context.Context, offset, limit int) (*model.Page, error) {
+	results := r.users.find(func(u *dao.UserV2) bool {
+		return !u.Deactivated
+	})
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Subject < results[j].Subject
+	})
+	users := make([]*model.User, len(results))
+	for i := range results {
+		users[i] = results[i].ToUser()
+	}
+	return page(users, offset, limit), nil
+}
+
+// FindInBatches iterates over every User
+func (r *UserRepo) FindInBatches(_ context.Context, size int, f func(users []*dao.UserV2) error) error {
+	return batches(r.users.find(all), size, f)
+}
@@ -0,0 +1,170 @@
+package memory
+
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gorm.io/gorm"
+	"slices"
+	"sync"
+)
+
+// WebhookRepo is an in-memory dao.WebhookRepository
+type WebhookRepo struct {
+	mu             sync.RWMutex
+	webhooks       []*model.Webhook
+	deliveries     []*model.WebhookDelivery
+	nextID         uint
+	nextDeliveryID uint
+}
+
+var _ dao.WebhookRepository = &WebhookRepo{}
+
+func NewWebhookRepo() *WebhookRepo {
+	return &WebhookRepo{
+		nextID:         1,
+		nextDeliveryID: 1,
+	}
+}
+
+// Create stores a new Webhook
+func (r *WebhookRepo) Create(_ context.Context, w *model.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w.ID = r.nextID
+	r.nextID++
+	cp := *w
+	r.webhooks = append(r.webhooks, &cp)
+	return nil
+}
+
+// Get returns a Webhook by its primaryKey (ID)
+func (r *WebhookRepo) Get(_ context.Context, id uint) (*model.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, w := range r.webhooks {
+		if w.ID == id {
+			cp := *w
+			return &cp, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetByGroup returns the Webhooks that belong
+// to any of the given Groups.
+func (r *WebhookRepo) GetByGroup(_ context.Context, groupIDs ...uint) ([]*model.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.Webhook, 0)
+	for _, w := range r.webhooks {
+		if slices.Contains(groupIDs, w.GroupID) {
+			cp := *w
+			results = append(results, &cp)
+		}
+	}
+	return results, nil
+}
+
+// GetAll returns every Webhook
+func (r *WebhookRepo) GetAll(_ context.Context) ([]*model.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.Webhook, 0, len(r.webhooks))
+	for _, w := range r.webhooks {
+		cp := *w
+		results = append(results, &cp)
+	}
+	return results, nil
+}
+
+// Delete removes a Webhook and its deliveries
+func (r *WebhookRepo) Delete(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.webhooks = slices.DeleteFunc(r.webhooks, func(w *model.Webhook) bool {
+		return w.ID == id
+	})
+	r.deliveries = slices.DeleteFunc(r.deliveries, func(d *model.WebhookDelivery) bool {
+		return d.WebhookID == id
+	})
+	return nil
+}
+
+// CreateDelivery stores a new WebhookDelivery
+func (r *WebhookRepo) CreateDelivery(_ context.Context, d *model.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d.ID = r.nextDeliveryID
+	r.nextDeliveryID++
+	cp := *d
+	r.deliveries = append(r.deliveries, &cp)
+	return nil
+}
+
+// SaveDelivery updates an existing WebhookDelivery
+func (r *WebhookRepo) SaveDelivery(_ context.Context, d *model.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.deliveries {
+		if existing.ID == d.ID {
+			cp := *d
+			r.deliveries[i] = &cp
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// GetDelivery returns a WebhookDelivery by its primaryKey (ID)
+func (r *WebhookRepo) GetDelivery(_ context.Context, id uint) (*model.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.deliveries {
+		if d.ID == id {
+			cp := *d
+			return &cp, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetDeliveries returns the deliveries made to a Webhook,
+// newest first. An empty status matches every delivery.
+func (r *WebhookRepo) GetDeliveries(_ context.Context, webhookID uint, status model.DeliveryStatus, offset, limit int) ([]*model.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.WebhookDelivery, 0)
+	for i := len(r.deliveries) - 1; i >= 0; i-- {
+		d := r.deliveries[i]
+		if d.WebhookID != webhookID || (status != "" && d.Status != status) {
+			continue
+		}
+		cp := *d
+		results = append(results, &cp)
+	}
+	start := min(max(offset, 0), len(results))
+	end := len(results)
+	if limit >= 0 {
+		end = min(start+limit, end)
+	}
+	return results[start:end], nil
+}
+
+// GetDue returns the pending deliveries that should be
+// attempted at the given unix time, oldest first.
+func (r *WebhookRepo) GetDue(_ context.Context, now int64, limit int) ([]*model.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := make([]*model.WebhookDelivery, 0)
+	for _, d := range r.deliveries {
+		if limit >= 0 && len(results) >= limit {
+			break
+		}
+		if d.Status == model.DeliveryStatusPending && d.NextAttemptAt <= now {
+			cp := *d
+			results = append(results, &cp)
+		}
+	}
+	return results, nil
+}
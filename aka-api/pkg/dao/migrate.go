@@ -0,0 +1,251 @@
+package dao
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFS embed.FS
+
+// migrationLockID is the key of the advisory lock held while
+// migrations are running, so that replicas starting at the
+// same time don't try to migrate concurrently.
+const migrationLockID = 0x616b61 // "aka"
+
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    bigint PRIMARY KEY,
+    name       text        NOT NULL,
+    applied_at timestamptz NOT NULL
+)`
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned change to the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration records that a Migration has been applied.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies and reverts migrations.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator that uses the
+// migrations embedded in the binary for the
+// database's dialect.
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFS, "migrations/"+db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// loadMigrations reads up/down scripts from a directory
+// and sorts them by version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		match := migrationName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration filename: %s", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, dir+"/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names: %s, %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) must have both an up and down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version that the database
+// is currently migrated to.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var version int
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		var err error
+		version, err = m.version(conn)
+		return err
+	})
+	return version, err
+}
+
+func (m *Migrator) version(conn *gorm.DB) (int, error) {
+	var version *int
+	if err := conn.Model(&SchemaMigration{}).Select("max(version)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	if version == nil {
+		return 0, nil
+	}
+	return *version, nil
+}
+
+// Up applies every migration that hasn't been applied.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Migrate(ctx, m.Latest())
+}
+
+// Migrate moves the database to the given version,
+// running up or down scripts as required.
+func (m *Migrator) Migrate(ctx context.Context, target int) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("Target", target)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "dao_migrator_migrate", trace.WithAttributes(attribute.Int("target", target)))
+	defer span.End()
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("unknown migration version: %d", target)
+	}
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		current, err := m.version(conn)
+		if err != nil {
+			return err
+		}
+		log.Info("migrating database", "Current", current)
+		// apply anything newer than the current
+		// version, oldest first
+		for _, mig := range m.migrations {
+			if mig.Version <= current || mig.Version > target {
+				continue
+			}
+			log.Info("applying migration", "Version", mig.Version, "Name", mig.Name)
+			if err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(mig.Up).Error; err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{
+					Version:   mig.Version,
+					Name:      mig.Name,
+					AppliedAt: time.Now(),
+				}).Error
+			}); err != nil {
+				return fmt.Errorf("applying migration %d (%s): %w", mig.Version, mig.Name, err)
+			}
+		}
+		// revert anything newer than the target,
+		// newest first
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if mig.Version > current || mig.Version <= target {
+				continue
+			}
+			log.Info("reverting migration", "Version", mig.Version, "Name", mig.Name)
+			if err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(mig.Down).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&SchemaMigration{}, mig.Version).Error
+			}); err != nil {
+				return fmt.Errorf("reverting migration %d (%s): %w", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to migrate database")
+		return err
+	}
+	return nil
+}
+
+// withLock runs f on a single connection while holding
+// the migration advisory lock.
+//
+// SQLite has no advisory locks, however it is only ever
+// used by a single replica so there is nothing to
+// coordinate with.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *gorm.DB) error) error {
+	log := logr.FromContextOrDiscard(ctx)
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		// make sure that queries don't leak
+		// conditions into each other
+		conn = conn.Session(&gorm.Session{})
+		if conn.Dialector.Name() == DialectSQLite {
+			if err := conn.Exec(schemaMigrationsTable).Error; err != nil {
+				return err
+			}
+			return f(conn)
+		}
+		log.V(1).Info("waiting for migration lock")
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID).Error; err != nil {
+			return err
+		}
+		defer func() {
+			if err := conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID).Error; err != nil {
+				log.Error(err, "failed to release migration lock")
+			}
+		}()
+		if err := conn.Exec(schemaMigrationsTable).Error; err != nil {
+			return err
+		}
+		return f(conn)
+	})
+}
@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/testconstructs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("embedded", func(t *testing.T) {
+		postgres, err := loadMigrations(migrationFS, "migrations/"+DialectPostgres)
+		require.NoError(t, err)
+		require.NotEmpty(t, postgres)
+		for i, m := range postgres {
+			assert.EqualValues(t, i+1, m.Version, "migrations must be numbered sequentially")
+		}
+		// versions should mean the same thing
+		// regardless of the database
+		sqlite, err := loadMigrations(migrationFS, "migrations/"+DialectSQLite)
+		require.NoError(t, err)
+		require.Len(t, sqlite, len(postgres))
+		for i := range sqlite {
+			assert.EqualValues(t, postgres[i].Version, sqlite[i].Version)
+			assert.EqualValues(t, postgres[i].Name, sqlite[i].Name)
+		}
+	})
+	t.Run("sorted", func(t *testing.T) {
+		migrations, err := loadMigrations(fstest.MapFS{
+			"m/0002_second.up.sql":   {Data: []byte("2 up")},
+			"m/0002_second.down.sql": {Data: []byte("2 down")},
+			"m/0001_first.up.sql":    {Data: []byte("1 up")},
+			"m/0001_first.down.sql":  {Data: []byte("1 down")},
+		}, "m")
+		require.NoError(t, err)
+		assert.EqualValues(t, []Migration{
+			{Version: 1, Name: "first", Up: "1 up", Down: "1 down"},
+			{Version: 2, Name: "second", Up: "2 up", Down: "2 down"},
+		}, migrations)
+	})
+	t.Run("missing down", func(t *testing.T) {
+		_, err := loadMigrations(fstest.MapFS{
+			"m/0001_first.up.sql": {Data: []byte("1 up")},
+		}, "m")
+		assert.Error(t, err)
+	})
+	t.Run("bad name", func(t *testing.T) {
+		_, err := loadMigrations(fstest.MapFS{
+			"m/first.sql": {Data: []byte("1 up")},
+		}, "m")
+		assert.Error(t, err)
+	})
+}
+
+func TestGroupMembersMigration(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	al, err := NewAccessLayer(ctx, testconstructs.NewSQLite(t))
+	require.NoError(t, err)
+	migrator, err := al.Migrator()
+	require.NoError(t, err)
+
+	// create a group the old way
+	require.NoError(t, migrator.Migrate(ctx, 3))
+	require.NoError(t, al.db.Exec(`INSERT INTO "groups" (name, owner, users, external) VALUES ('team', 'john', 'john,bobby,bob', false)`).Error)
+
+	require.NoError(t, migrator.Migrate(ctx, 4))
+	var members []*model.GroupMember
+	require.NoError(t, al.db.Order("subject asc").Find(&members).Error)
+	require.Len(t, members, 3)
+	assert.EqualValues(t, "bob", members[0].Subject)
+	assert.EqualValues(t, "bobby", members[1].Subject)
+	assert.EqualValues(t, "john", members[2].Subject)
+	assert.EqualValues(t, model.GroupRoleOwner, members[2].Role)
+	assert.EqualValues(t, model.GroupSourceManual, members[2].Source)
+
+	// and back again
+	require.NoError(t, migrator.Migrate(ctx, 3))
+	var users string
+	require.NoError(t, al.db.Raw(`SELECT users FROM "groups" WHERE name = 'team'`).Scan(&users).Error)
+	assert.ElementsMatch(t, []string{"john", "bobby", "bob"}, strings.Split(users, ","))
+}
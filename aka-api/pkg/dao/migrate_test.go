@@ -0,0 +1,56 @@
+package dao_test
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/testconstructs"
+	"sync"
+	"testing"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	db, err := dao.NewAccessLayer(ctx, testconstructs.NewPostgres(t))
+	require.NoError(t, err)
+
+	migrator, err := db.Migrator()
+	require.NoError(t, err)
+
+	t.Run("concurrent up", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = migrator.Up(ctx)
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+		version, err := migrator.Version(ctx)
+		assert.NoError(t, err)
+		assert.EqualValues(t, migrator.Latest(), version)
+	})
+	t.Run("down and up again", func(t *testing.T) {
+		require.NoError(t, migrator.Migrate(ctx, 0))
+		version, err := migrator.Version(ctx)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, version)
+
+		require.NoError(t, migrator.Up(ctx))
+		version, err = migrator.Version(ctx)
+		assert.NoError(t, err)
+		assert.EqualValues(t, migrator.Latest(), version)
+	})
+	t.Run("unknown version", func(t *testing.T) {
+		assert.Error(t, migrator.Migrate(ctx, migrator.Latest()+1))
+	})
+}
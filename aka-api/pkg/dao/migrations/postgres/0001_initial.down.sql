@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS groups;
+DROP TABLE IF EXISTS users_v2;
+DROP TABLE IF EXISTS jump_events;
+DROP TABLE IF EXISTS jumps;
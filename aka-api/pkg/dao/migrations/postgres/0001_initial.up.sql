@@ -0,0 +1,57 @@
+-- initial schema, matching what was previously created by gorm AutoMigrate.
+-- everything is idempotent so that existing installations can adopt
+-- versioned migrations without any manual steps.
+CREATE TABLE IF NOT EXISTS jumps
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name       text,
+    location   text,
+    title      text,
+    owner      text,
+    usage      bigint,
+    alias      jsonb
+);
+CREATE INDEX IF NOT EXISTS idx_jumps_deleted_at ON jumps (deleted_at);
+
+CREATE TABLE IF NOT EXISTS jump_events
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id    text,
+    jump_id    bigint,
+    date       bigint
+);
+CREATE INDEX IF NOT EXISTS idx_jump_events_deleted_at ON jump_events (deleted_at);
+
+CREATE TABLE IF NOT EXISTS users_v2
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    subject    text,
+    email      text,
+    groups     text,
+    username   text
+);
+CREATE INDEX IF NOT EXISTS idx_users_v2_deleted_at ON users_v2 (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_v2_subject ON users_v2 (subject);
+
+CREATE TABLE IF NOT EXISTS groups
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name       text,
+    public     boolean,
+    owner      text,
+    users      text,
+    external   boolean
+);
+CREATE INDEX IF NOT EXISTS idx_groups_deleted_at ON groups (deleted_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS jumps_location_idx;
+DROP INDEX IF EXISTS jumps_name_idx;
+DROP INDEX IF EXISTS jumps_alias_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS jumps_alias_idx ON jumps USING GIN (to_tsvector('simple', alias));
+CREATE INDEX IF NOT EXISTS jumps_name_idx ON jumps USING GIN (to_tsvector('simple', name));
+CREATE INDEX IF NOT EXISTS jumps_location_idx ON jumps USING GIN (to_tsvector('simple', location));
@@ -0,0 +1,3 @@
+-- the legacy users table cannot be restored,
+-- so there is nothing to do here.
+SELECT 1;
@@ -0,0 +1,4 @@
+-- the users table was replaced by users_v2 and
+-- is no longer read or written.
+DROP TABLE IF EXISTS users;
+DROP FUNCTION IF EXISTS notify_users_update();
@@ -0,0 +1,6 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS users text;
+UPDATE groups g
+SET users = (SELECT string_agg(m.subject, ',' ORDER BY m.added_at, m.subject)
+             FROM group_members m
+             WHERE m.group_id = g.id);
+DROP TABLE IF EXISTS group_members;
@@ -0,0 +1,26 @@
+-- group membership moves from the comma-separated groups.users
+-- column to a join table so that it can be indexed and
+-- matched exactly.
+CREATE TABLE IF NOT EXISTS group_members
+(
+    group_id bigint      NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    subject  text        NOT NULL,
+    role     text        NOT NULL DEFAULT 'member',
+    source   text        NOT NULL DEFAULT 'manual',
+    added_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (group_id, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_group_members_subject ON group_members (subject);
+
+INSERT INTO group_members (group_id, subject, role, source, added_at)
+SELECT g.id,
+       m.subject,
+       CASE WHEN m.subject = g.owner THEN 'owner' ELSE 'member' END,
+       CASE WHEN g.external THEN 'idp' ELSE 'manual' END,
+       coalesce(g.created_at, now())
+FROM groups g,
+     unnest(string_to_array(g.users, ',')) AS m(subject)
+WHERE m.subject <> ''
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE groups DROP COLUMN IF EXISTS users;
@@ -0,0 +1,17 @@
+-- the audit log records every change that users
+-- make, along with what the resource looked like
+-- before and after.
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id         bigserial PRIMARY KEY,
+    actor      text   NOT NULL,
+    action     text   NOT NULL,
+    resource   text   NOT NULL,
+    before     text   NOT NULL DEFAULT '',
+    after      text   NOT NULL DEFAULT '',
+    request_id text   NOT NULL DEFAULT '',
+    date       bigint NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor);
+CREATE INDEX IF NOT EXISTS idx_audit_events_resource ON audit_events (resource);
+CREATE INDEX IF NOT EXISTS idx_audit_events_date ON audit_events (date);
@@ -0,0 +1,30 @@
+-- webhooks send changes to jumps and groups to other
+-- services. Each event that is sent to a webhook is
+-- kept as a delivery so that it can be retried.
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id         bigserial PRIMARY KEY,
+    group_id   bigint  NOT NULL DEFAULT 0,
+    url        text    NOT NULL,
+    secret     text    NOT NULL,
+    created_by text    NOT NULL,
+    created_at bigint  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhooks_group_id ON webhooks (group_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id              bigserial PRIMARY KEY,
+    webhook_id      bigint  NOT NULL,
+    event           text    NOT NULL,
+    payload         text    NOT NULL,
+    status          text    NOT NULL,
+    attempts        integer NOT NULL DEFAULT 0,
+    response_code   integer NOT NULL DEFAULT 0,
+    last_error      text    NOT NULL DEFAULT '',
+    created_at      bigint  NOT NULL,
+    updated_at      bigint  NOT NULL,
+    next_attempt_at bigint  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
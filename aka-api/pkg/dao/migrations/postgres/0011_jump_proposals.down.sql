@@ -0,0 +1 @@
+DROP TABLE IF EXISTS jump_proposals;
@@ -0,0 +1,55 @@
+-- initial schema, equivalent to the postgres schema
+CREATE TABLE IF NOT EXISTS jumps
+(
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    name       text,
+    location   text,
+    title      text,
+    owner      text,
+    usage      integer,
+    alias      text
+);
+CREATE INDEX IF NOT EXISTS idx_jumps_deleted_at ON jumps (deleted_at);
+
+CREATE TABLE IF NOT EXISTS jump_events
+(
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    user_id    text,
+    jump_id    integer,
+    date       integer
+);
+CREATE INDEX IF NOT EXISTS idx_jump_events_deleted_at ON jump_events (deleted_at);
+
+CREATE TABLE IF NOT EXISTS users_v2
+(
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    subject    text,
+    email      text,
+    "groups"   text,
+    username   text
+);
+CREATE INDEX IF NOT EXISTS idx_users_v2_deleted_at ON users_v2 (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_v2_subject ON users_v2 (subject);
+
+CREATE TABLE IF NOT EXISTS "groups"
+(
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    name       text,
+    public     numeric,
+    owner      text,
+    users      text,
+    external   numeric
+);
+CREATE INDEX IF NOT EXISTS idx_groups_deleted_at ON "groups" (deleted_at);
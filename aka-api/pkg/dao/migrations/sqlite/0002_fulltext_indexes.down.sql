@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS jumps_fts_update;
+DROP TRIGGER IF EXISTS jumps_fts_delete;
+DROP TRIGGER IF EXISTS jumps_fts_insert;
+DROP TABLE IF EXISTS jumps_fts;
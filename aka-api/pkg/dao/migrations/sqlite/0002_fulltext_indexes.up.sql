@@ -0,0 +1,21 @@
+-- sqlite has no tsvector, so we keep an FTS5 index of
+-- the searchable columns in sync using triggers.
+CREATE VIRTUAL TABLE IF NOT EXISTS jumps_fts USING fts5(name, location, alias, content='jumps', content_rowid='id');
+
+CREATE TRIGGER IF NOT EXISTS jumps_fts_insert AFTER INSERT ON jumps
+BEGIN
+    INSERT INTO jumps_fts(rowid, name, location, alias) VALUES (new.id, new.name, new.location, new.alias);
+END;
+
+CREATE TRIGGER IF NOT EXISTS jumps_fts_delete AFTER DELETE ON jumps
+BEGIN
+    INSERT INTO jumps_fts(jumps_fts, rowid, name, location, alias) VALUES ('delete', old.id, old.name, old.location, old.alias);
+END;
+
+CREATE TRIGGER IF NOT EXISTS jumps_fts_update AFTER UPDATE ON jumps
+BEGIN
+    INSERT INTO jumps_fts(jumps_fts, rowid, name, location, alias) VALUES ('delete', old.id, old.name, old.location, old.alias);
+    INSERT INTO jumps_fts(rowid, name, location, alias) VALUES (new.id, new.name, new.location, new.alias);
+END;
+
+INSERT INTO jumps_fts(jumps_fts) VALUES ('rebuild');
@@ -0,0 +1,3 @@
+-- sqlite databases never had the legacy users table. This
+-- migration exists so that versions match across databases.
+SELECT 1;
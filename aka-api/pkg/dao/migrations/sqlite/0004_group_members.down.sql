@@ -0,0 +1,8 @@
+ALTER TABLE "groups" ADD COLUMN users text;
+UPDATE "groups"
+SET users = (SELECT group_concat(subject, ',')
+             FROM (SELECT subject
+                   FROM group_members m
+                   WHERE m.group_id = "groups".id
+                   ORDER BY m.added_at, m.subject));
+DROP TABLE IF EXISTS group_members;
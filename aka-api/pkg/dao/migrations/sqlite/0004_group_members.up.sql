@@ -0,0 +1,39 @@
+-- group membership moves from the comma-separated groups.users
+-- column to a join table so that it can be indexed and
+-- matched exactly.
+CREATE TABLE IF NOT EXISTS group_members
+(
+    group_id integer  NOT NULL REFERENCES "groups" (id) ON DELETE CASCADE,
+    subject  text     NOT NULL,
+    role     text     NOT NULL DEFAULT 'member',
+    source   text     NOT NULL DEFAULT 'manual',
+    added_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_group_members_subject ON group_members (subject);
+
+-- sqlite can't split strings, so walk
+-- through the list one user at a time
+WITH RECURSIVE split(group_id, owner, external, created_at, subject, rest) AS (
+    SELECT id, owner, external, created_at, '', coalesce(users, '') || ','
+    FROM "groups"
+    UNION ALL
+    SELECT group_id,
+           owner,
+           external,
+           created_at,
+           substr(rest, 1, instr(rest, ',') - 1),
+           substr(rest, instr(rest, ',') + 1)
+    FROM split
+    WHERE rest <> ''
+)
+INSERT OR IGNORE INTO group_members (group_id, subject, role, source, added_at)
+SELECT group_id,
+       subject,
+       CASE WHEN subject = owner THEN 'owner' ELSE 'member' END,
+       CASE WHEN external THEN 'idp' ELSE 'manual' END,
+       coalesce(created_at, CURRENT_TIMESTAMP)
+FROM split
+WHERE subject <> '';
+
+ALTER TABLE "groups" DROP COLUMN users;
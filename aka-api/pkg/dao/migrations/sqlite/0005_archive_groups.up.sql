@@ -0,0 +1,3 @@
+-- archived groups, and their jumps, are hidden without
+-- being deleted
+ALTER TABLE "groups" ADD COLUMN archived boolean NOT NULL DEFAULT false;
@@ -0,0 +1,14 @@
+-- a history of changes to group membership, including
+-- those made when synchronising with the identity provider
+CREATE TABLE IF NOT EXISTS group_member_events
+(
+    id       integer PRIMARY KEY AUTOINCREMENT,
+    group_id integer NOT NULL,
+    subject  text    NOT NULL,
+    action   text    NOT NULL,
+    role     text    NOT NULL DEFAULT '',
+    source   text    NOT NULL DEFAULT '',
+    actor    text    NOT NULL DEFAULT '',
+    date     integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_group_member_events_group_id ON group_member_events (group_id, date);
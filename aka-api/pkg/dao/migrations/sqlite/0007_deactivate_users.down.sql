@@ -0,0 +1 @@
+ALTER TABLE users_v2 DROP COLUMN deactivated;
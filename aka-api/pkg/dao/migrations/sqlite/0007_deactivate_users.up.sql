@@ -0,0 +1,3 @@
+-- deactivated users are hidden and lose their role
+-- bindings, but are kept so that they can come back
+ALTER TABLE users_v2 ADD COLUMN deactivated boolean NOT NULL DEFAULT false;
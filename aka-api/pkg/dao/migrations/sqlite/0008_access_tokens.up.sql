@@ -0,0 +1,17 @@
+-- personal access tokens let scripts call the API
+-- without going through the OIDC proxy. Only a hash
+-- of each token is kept.
+CREATE TABLE IF NOT EXISTS access_tokens
+(
+    id           integer PRIMARY KEY AUTOINCREMENT,
+    subject      text    NOT NULL,
+    name         text    NOT NULL,
+    hash         text    NOT NULL,
+    prefix       text    NOT NULL,
+    scope        text    NOT NULL,
+    created_at   integer NOT NULL,
+    expires_at   integer NOT NULL DEFAULT 0,
+    last_used_at integer NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_tokens_hash ON access_tokens (hash);
+CREATE INDEX IF NOT EXISTS idx_access_tokens_subject ON access_tokens (subject);
@@ -0,0 +1,19 @@
+-- public jumps that users have proposed, which need
+-- to be approved by an administrator before the
+-- jump is created.
+CREATE TABLE IF NOT EXISTS jump_proposals
+(
+    id          integer PRIMARY KEY AUTOINCREMENT,
+    name        text    NOT NULL,
+    location    text    NOT NULL,
+    alias       text,
+    owner       text    NOT NULL,
+    status      text    NOT NULL,
+    reason      text    NOT NULL DEFAULT '',
+    reviewed_by text    NOT NULL DEFAULT '',
+    jump_id     integer NOT NULL DEFAULT 0,
+    created_at  integer NOT NULL,
+    reviewed_at integer NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_jump_proposals_status ON jump_proposals (status);
+CREATE INDEX IF NOT EXISTS idx_jump_proposals_owner ON jump_proposals (owner);
@@ -20,31 +20,113 @@ package dao
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/lib/pq"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 )
 
+// OperationResync is the Operation of a Message that
+// doesn't describe a single row. Receivers should assume
+// that anything in the table may have changed.
+const OperationResync = "RESYNC"
+
+// notifyPayloadLimit is the size (in bytes) that a
+// NOTIFY payload must be smaller than.
+const notifyPayloadLimit = 8000
+
 type Message struct {
 	Operation string `json:"OPERATION"`
 	ID        int    `json:"ID"`
 	Table     string `json:"TABLE"`
+	// Owner and Name are copied from the row
+	// if its table has those columns.
+	Owner string `json:"OWNER,omitempty"`
+	Name  string `json:"NAME,omitempty"`
+	// Changed contains the columns modified
+	// by an UPDATE, in alphabetical order.
+	Changed []string `json:"CHANGED,omitempty"`
+	// Truncated is set when the row was too
+	// large to describe, so only the ID was sent.
+	Truncated bool `json:"TRUNCATED,omitempty"`
+}
+
+// NewRowMessage describes a change to a row. The row is given
+// as a map of column names to values, both before and after the
+// change. Before is nil for an INSERT and after is nil for
+// a DELETE.
+func NewRowMessage(table, op string, id uint, before, after map[string]any) *Message {
+	msg := &Message{
+		Operation: op,
+		ID:        int(id),
+		Table:     table,
+	}
+	row := after
+	if row == nil {
+		row = before
+	}
+	msg.Owner, _ = row["owner"].(string)
+	msg.Name, _ = row["name"].(string)
+	if op == "UPDATE" && before != nil && after != nil {
+		for k, v := range after {
+			if !reflect.DeepEqual(v, before[k]) {
+				msg.Changed = append(msg.Changed, k)
+			}
+		}
+		sort.Strings(msg.Changed)
+	}
+	return msg.limit()
 }
 
+// limit falls back to sending only the ID if the
+// message is too large to fit in a NOTIFY payload,
+// so that every feed behaves the same way.
+func (m *Message) limit() *Message {
+	data, err := json.Marshal(m)
+	if err != nil || len(data) < notifyPayloadLimit {
+		return m
+	}
+	return &Message{
+		Operation: m.Operation,
+		ID:        m.ID,
+		Table:     m.Table,
+		Truncated: true,
+	}
+}
+
+// ErrNotifierDisconnected is reported by a Notifier that has
+// lost its connection to the database and is reconnecting.
+var ErrNotifierDisconnected = errors.New("notifier is disconnected")
+
+// Notifier receives the notifications sent by the triggers
+// of a single table. The connection is re-established if it
+// is lost, after which an OperationResync message is sent
+// as notifications may have been missed in the meantime.
 type Notifier struct {
-	log         logr.Logger
-	channelName string
-	listener    *pq.Listener
-	failed      chan error
-	handler     chan *Message
+	log       logr.Logger
+	table     string
+	listener  *pq.Listener
+	handler   chan *Message
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// mu guards err
+	mu  sync.RWMutex
+	err error
 }
 
-func NewNotifier(ctx context.Context, dsn, channelName string) (*Notifier, chan *Message, error) {
+func NewNotifier(ctx context.Context, dsn, table string) (*Notifier, chan *Message, error) {
+	channelName := NotifyChannel(table)
 	log := logr.FromContextOrDiscard(ctx).WithValues("Channel", channelName)
 	n := new(Notifier)
 	n.log = log
+	n.table = table
 	log.Info("opening listener")
-	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, n.logListener)
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, n.onEvent)
 	if err := listener.Listen(channelName); err != nil {
 		log.Error(err, "failed to listen")
 		log.V(2).Info("closing listener")
@@ -54,55 +136,103 @@ func NewNotifier(ctx context.Context, dsn, channelName string) (*Notifier, chan
 		return nil, nil, err
 	}
 
-	n.channelName = channelName
 	n.listener = listener
-	n.failed = make(chan error, 2)
 	n.handler = make(chan *Message)
+	n.done = make(chan struct{})
 
 	// start the event loop in the background
-	go func() {
-		for {
-			_ = n.notify()
-		}
-	}()
+	go n.run(listener.Notify)
 
 	return n, n.handler, nil
 }
 
-func (n *Notifier) logListener(event pq.ListenerEventType, err error) {
+// onEvent keeps track of the state of the connection. It
+// is called by the listener, so it must not block.
+func (n *Notifier) onEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventConnected:
+		n.log.V(1).Info("listener connected")
+		n.setErr(nil)
+	case pq.ListenerEventReconnected:
+		n.log.Info("listener reconnected")
+		n.setErr(nil)
+	case pq.ListenerEventDisconnected:
+		n.log.Error(err, "listener disconnected")
+		n.setErr(err)
+	case pq.ListenerEventConnectionAttemptFailed:
+		n.log.Error(err, "failed to reconnect listener")
+		n.setErr(err)
+	}
+}
+
+func (n *Notifier) setErr(err error) {
 	if err != nil {
-		n.log.Error(err, "listener error")
+		err = errors.Join(ErrNotifierDisconnected, err)
 	}
-	if event == pq.ListenerEventConnectionAttemptFailed {
-		n.failed <- err
+	n.mu.Lock()
+	n.err = err
+	n.mu.Unlock()
+}
+
+// Health returns nil if the Notifier is connected
+// and receiving notifications.
+func (n *Notifier) Health() error {
+	select {
+	case <-n.done:
+		return ErrFeedClosed
+	default:
 	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.err
 }
 
-func (n *Notifier) notify() error {
+// run forwards notifications to the handler until the
+// Notifier is closed. The handler is closed when run
+// returns, as nothing else writes to it.
+func (n *Notifier) run(notify <-chan *pq.Notification) {
 	log := n.log
+	defer close(n.handler)
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
 	var fetchCounter uint64
 	for {
+		var msg *Message
 		select {
-		case e := <-n.listener.Notify:
+		case <-n.done:
+			return
+		case e, ok := <-notify:
+			if !ok {
+				log.V(1).Info("listener has been closed")
+				return
+			}
 			if e == nil {
-				continue
+				// the listener sends nil after reconnecting
+				// as notifications may have been missed
+				log.Info("requesting resync after reconnecting")
+				msg = &Message{Operation: OperationResync, Table: n.table}
+				break
 			}
 			fetchCounter++
 			log.V(2).Info("notify received", "Count", fetchCounter, "Message", e.Extra)
-			msg, err := n.parseMessage(e.Extra)
+			var err error
+			msg, err = n.parseMessage(e.Extra)
 			if err != nil {
 				continue
 			}
-			n.handler <- msg
-		case err := <-n.failed:
-			return err
-		case <-time.After(time.Minute):
+		case <-ping.C:
 			go func() {
 				log.V(4).Info("pinging listener")
 				if err := n.listener.Ping(); err != nil {
 					log.Error(err, "failed to ping listener")
 				}
 			}()
+			continue
+		}
+		select {
+		case n.handler <- msg:
+		case <-n.done:
+			return
 		}
 	}
 }
@@ -116,7 +246,78 @@ func (n *Notifier) parseMessage(data string) (*Message, error) {
 	return &message, nil
 }
 
+// Close stops the Notifier. It is safe to
+// call more than once.
 func (n *Notifier) Close() error {
-	close(n.failed)
-	return n.listener.Close()
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		if n.listener != nil {
+			err = n.listener.Close()
+		}
+	})
+	return err
+}
+
+// PostgresFeed is a ChangeFeed that receives changes
+// published by the LISTEN/NOTIFY triggers.
+type PostgresFeed struct {
+	dsn       string
+	mu        sync.Mutex
+	notifiers []*Notifier
+	closed    bool
+}
+
+var _ ChangeFeed = &PostgresFeed{}
+
+func NewPostgresFeed(dsn string) *PostgresFeed {
+	return &PostgresFeed{
+		dsn: dsn,
+	}
+}
+
+// Subscribe opens a listener on the notification
+// channel of the given table.
+func (f *PostgresFeed) Subscribe(ctx context.Context, table string) (<-chan *Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrFeedClosed
+	}
+	n, h, err := NewNotifier(ctx, f.dsn, table)
+	if err != nil {
+		return nil, err
+	}
+	f.notifiers = append(f.notifiers, n)
+	return h, nil
+}
+
+// Health returns an error if any of the
+// listeners have lost their connection.
+func (f *PostgresFeed) Health() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFeedClosed
+	}
+	var errs []error
+	for _, n := range f.notifiers {
+		if err := n.Health(); err != nil {
+			errs = append(errs, fmt.Errorf("table %s: %w", n.table, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every listener opened by the feed.
+func (f *PostgresFeed) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	var errs []error
+	for _, n := range f.notifiers {
+		errs = append(errs, n.Close())
+	}
+	f.notifiers = nil
+	return errors.Join(errs...)
 }
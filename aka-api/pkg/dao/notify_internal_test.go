@@ -0,0 +1,78 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	newNotifier := func() (*Notifier, chan *pq.Notification) {
+		notify := make(chan *pq.Notification)
+		n := &Notifier{
+			log:     logr.FromContextOrDiscard(ctx),
+			table:   "jumps",
+			handler: make(chan *Message),
+			done:    make(chan struct{}),
+		}
+		go n.run(notify)
+		return n, notify
+	}
+	receive := func(t *testing.T, n *Notifier) (*Message, bool) {
+		select {
+		case msg, ok := <-n.handler:
+			return msg, ok
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for message")
+			return nil, false
+		}
+	}
+
+	t.Run("notifications are forwarded", func(t *testing.T) {
+		n, notify := newNotifier()
+		defer n.Close()
+		notify <- &pq.Notification{Extra: `{"OPERATION":"INSERT","ID":1,"TABLE":"jumps"}`}
+		msg, ok := receive(t, n)
+		require.True(t, ok)
+		assert.EqualValues(t, &Message{Operation: "INSERT", ID: 1, Table: "jumps"}, msg)
+	})
+	t.Run("reconnecting requests a resync", func(t *testing.T) {
+		n, notify := newNotifier()
+		defer n.Close()
+
+		n.onEvent(pq.ListenerEventDisconnected, errors.New("connection reset"))
+		assert.ErrorIs(t, n.Health(), ErrNotifierDisconnected)
+
+		n.onEvent(pq.ListenerEventReconnected, nil)
+		notify <- nil
+		msg, ok := receive(t, n)
+		require.True(t, ok)
+		assert.EqualValues(t, &Message{Operation: OperationResync, Table: "jumps"}, msg)
+		assert.NoError(t, n.Health())
+	})
+	t.Run("closing the listener closes the handler", func(t *testing.T) {
+		n, notify := newNotifier()
+		defer n.Close()
+		close(notify)
+		_, ok := receive(t, n)
+		assert.False(t, ok)
+	})
+	t.Run("close", func(t *testing.T) {
+		n, _ := newNotifier()
+		assert.NoError(t, n.Close())
+		assert.NoError(t, n.Close())
+		assert.ErrorIs(t, n.Health(), ErrFeedClosed)
+		_, ok := receive(t, n)
+		assert.False(t, ok)
+		// events after closing must not panic
+		n.onEvent(pq.ListenerEventDisconnected, errors.New("connection reset"))
+	})
+}
@@ -0,0 +1,110 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type JumpProposalRepo struct {
+	Repository
+}
+
+// Create stores a new JumpProposal
+func (r *JumpProposalRepo) Create(ctx context.Context, p *model.JumpProposal) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_proposal_create", trace.WithAttributes(attribute.String("submitter", p.Submitter)))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Create(p).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to create jump proposal")
+		return err
+	}
+	return nil
+}
+
+// Save updates an existing JumpProposal
+func (r *JumpProposalRepo) Save(ctx context.Context, p *model.JumpProposal) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_proposal_save", trace.WithAttributes(attribute.Int("id", int(p.ID))))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Save(p).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to save jump proposal")
+		return err
+	}
+	return nil
+}
+
+// Approve creates the Jump that a JumpProposal asked for and
+// saves the proposal in a single transaction, so that a Jump
+// is only ever created once. It returns gorm.ErrRecordNotFound
+// if the proposal has already been reviewed.
+func (r *JumpProposalRepo) Approve(ctx context.Context, p *model.JumpProposal, jump *model.Jump) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_proposal_approve", trace.WithAttributes(attribute.Int("id", int(p.ID))))
+	defer span.End()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(jump).Error; err != nil {
+			return err
+		}
+		p.JumpID = jump.ID
+		res := tx.Model(p).Where("status = ?", model.ProposalStatusProposed).Select("*").Updates(p)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to approve jump proposal")
+		return err
+	}
+	metricJumpSave.Add(ctx, 1)
+	return nil
+}
+
+// Get returns a JumpProposal by its primaryKey (ID)
+func (r *JumpProposalRepo) Get(ctx context.Context, id uint) (*model.JumpProposal, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_proposal_get", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	var result model.JumpProposal
+	if err := r.db.WithContext(ctx).First(&result, id).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetByStatus returns the JumpProposals with
+// a given status, oldest first.
+func (r *JumpProposalRepo) GetByStatus(ctx context.Context, status model.ProposalStatus, offset, limit int) ([]*model.JumpProposal, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_proposal_getByStatus", trace.WithAttributes(attribute.String("status", string(status))))
+	defer span.End()
+	var results []*model.JumpProposal
+	if err := r.db.WithContext(ctx).Where("status = ?", status).Order("id asc").Offset(offset).Limit(limit).Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to list jump proposals")
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetBySubmitter returns the JumpProposals
+// made by a user, newest first.
+func (r *JumpProposalRepo) GetBySubmitter(ctx context.Context, subject string) ([]*model.JumpProposal, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_proposal_getBySubmitter", trace.WithAttributes(attribute.String("submitter", subject)))
+	defer span.End()
+	var results []*model.JumpProposal
+	if err := r.db.WithContext(ctx).Where("owner = ?", subject).Order("id desc").Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to list jump proposals")
+		return nil, err
+	}
+	return results, nil
+}
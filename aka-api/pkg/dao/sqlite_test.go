@@ -0,0 +1,466 @@
+package dao_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/identity"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/backup"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/datatypes"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/dao/feedtest"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/schemas"
+	"gitlab.dcas.dev/jmp/go-jmp/pkg/testconstructs"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newSQLiteDB(ctx context.Context, t *testing.T) *dao.AccessLayer {
+	db, err := dao.NewAccessLayer(ctx, testconstructs.NewSQLite(t))
+	require.NoError(t, err)
+	require.NoError(t, db.Init(ctx))
+	require.NoError(t, db.InitTriggers(ctx))
+	return db
+}
+
+func TestSQLiteMigrator(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+
+	migrator, err := db.Migrator()
+	require.NoError(t, err)
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, migrator.Latest(), version)
+
+	require.NoError(t, migrator.Migrate(ctx, 0))
+	require.NoError(t, migrator.Up(ctx))
+}
+
+func TestSQLiteJumpRepo(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+
+	repo := &dao.JumpRepo{}
+	db.NewRepo(&repo.Repository)
+
+	for _, j := range []*model.Jump{
+		{Name: "public", Location: "https://example.org"},
+		{Name: "mine", Location: "https://example.org/mine", Owner: "user://john"},
+		{Name: "theirs", Location: "https://example.org/theirs", Owner: "user://jane"},
+		{Name: "team", Location: "https://example.org/team", Owner: "group://1", Alias: datatypes.JSONArray{"squad"}},
+	} {
+		_, err := repo.Save(ctx, j)
+		require.NoError(t, err)
+	}
+
+	page, err := repo.GetAll(ctx, "john", 0, 10, []uint{1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, page.Count)
+
+	page, err = repo.SearchForTerm(ctx, "john", "squ", 0, 10, []uint{1})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+	assert.EqualValues(t, datatypes.JSONArray{"squad"}, page.Results[0].(*model.Jump).Alias)
+
+	page, err = repo.SearchForTerm(ctx, "john", "theirs", 0, 10, nil)
+	require.NoError(t, err)
+	assert.Empty(t, page.Results)
+
+	jumps, err := repo.GetByHost(ctx, "john", "EXAMPLE.org", []uint{1})
+	require.NoError(t, err)
+	assert.Len(t, jumps, 3)
+
+	// search results must follow updates
+	jump, err := repo.GetByID(ctx, 2)
+	require.NoError(t, err)
+	jump.Name = "renamed"
+	_, err = repo.Save(ctx, jump)
+	require.NoError(t, err)
+
+	page, err = repo.SearchForTerm(ctx, "john", "renam", 0, 10, nil)
+	require.NoError(t, err)
+	assert.Len(t, page.Results, 1)
+
+	require.NoError(t, repo.DeleteByID(ctx, 2))
+	page, err = repo.SearchForTerm(ctx, "john", "renam", 0, 10, nil)
+	require.NoError(t, err)
+	assert.Empty(t, page.Results)
+
+	// moving a group's jumps
+	ids, err := repo.SetOwner(ctx, "group://1", "user://john")
+	require.NoError(t, err)
+	assert.EqualValues(t, []uint{4}, ids)
+	page, err = repo.GetAll(ctx, "john", 0, 10, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, page.Count)
+
+	count, err := repo.DeleteByOwner(ctx, "user://john")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	assert.False(t, repo.ExistsByID(ctx, 4))
+}
+
+func TestSQLiteGroupRepo(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+	ctx = context.WithValue(ctx, identity.UserContextKey, &identity.OAuthUser{Subject: "john"})
+
+	repo := &dao.GroupRepo{}
+	db.NewRepo(&repo.Repository)
+
+	group, err := repo.Save(&model.Group{Name: "my-group", Owner: "john"})
+	require.NoError(t, err)
+	other, err := repo.Save(&model.Group{Name: "other", Public: true})
+	require.NoError(t, err)
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: group.ID, Subject: "john", Role: model.GroupRoleOwner}))
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: group.ID, Subject: "jane", Role: model.GroupRoleMember}))
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: other.ID, Subject: "johnny", Role: model.GroupRoleMember}))
+
+	found, err := repo.FindByID(ctx, int(group.ID))
+	require.NoError(t, err)
+	assert.EqualValues(t, "john,jane", found.Users)
+
+	// membership must be an exact match
+	_, err = repo.FindByID(ctx, int(other.ID))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	groups, err := repo.GetUserGroups(ctx, "john")
+	require.NoError(t, err)
+	assert.Len(t, groups, 1)
+
+	page, err := repo.GetGroups(ctx, "john", 0, 10)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, page.Count)
+
+	// changing a member's role doesn't add them twice
+	require.NoError(t, repo.AddMember(ctx, &model.GroupMember{GroupID: group.ID, Subject: "jane", Role: model.GroupRoleOwner}))
+	members, err := repo.GetMembers(ctx, group.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.EqualValues(t, model.GroupRoleOwner, members[1].Role)
+
+	require.NoError(t, repo.RemoveMember(ctx, group.ID, "jane"))
+	found, err = repo.GetByID(ctx, group.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, "john", found.Users)
+
+	// archived groups are hidden
+	found.Archived = true
+	_, err = repo.Save(found)
+	require.NoError(t, err)
+	groups, err = repo.GetUserGroups(ctx, "john")
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+	page, err = repo.GetGroups(ctx, "john", 0, 10)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, page.Count)
+	groups, err = repo.GetArchived(ctx, "john")
+	require.NoError(t, err)
+	assert.Len(t, groups, 1)
+
+	// memberships include archived groups
+	memberships, err := repo.GetMemberships(ctx, "john")
+	require.NoError(t, err)
+	require.Len(t, memberships, 1)
+	assert.EqualValues(t, group.ID, memberships[0].GroupID)
+
+	// history is returned newest first
+	for i, action := range []string{model.GroupMemberAdded, model.GroupMemberRemoved} {
+		require.NoError(t, repo.AddMemberEvent(ctx, &model.GroupMemberEvent{
+			GroupID: group.ID,
+			Subject: "jane",
+			Action:  action,
+			Role:    model.GroupRoleMember,
+			Source:  model.GroupSourceManual,
+			Actor:   "john",
+			Date:    int64(i),
+		}))
+	}
+	events, err := repo.GetMemberEvents(ctx, group.ID, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.EqualValues(t, model.GroupMemberRemoved, events[0].Action)
+
+	ids, err := repo.Delete(ctx, group.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	_, err = repo.GetByID(ctx, group.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestSQLiteAccessTokenRepo(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+
+	repo := &dao.AccessTokenRepo{}
+	db.NewRepo(&repo.Repository)
+
+	for _, tk := range []*model.AccessToken{
+		{Subject: "john", Name: "ci", Hash: "a", Scope: model.TokenScopeReadOnly},
+		{Subject: "john", Name: "bot", Hash: "b", Scope: model.TokenScopeJumpsWrite},
+		{Subject: "jane", Name: "ci", Hash: "c", Scope: model.TokenScopeAdmin},
+	} {
+		require.NoError(t, repo.Create(ctx, tk))
+	}
+	// hashes are unique
+	assert.Error(t, repo.Create(ctx, &model.AccessToken{Subject: "jane", Hash: "a", Scope: model.TokenScopeReadOnly}))
+
+	tokens, err := repo.GetBySubject(ctx, "john")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.EqualValues(t, "bot", tokens[0].Name)
+
+	require.NoError(t, repo.SetLastUsed(ctx, tokens[0].ID, 100))
+	token, err := repo.GetByHash(ctx, "b")
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, token.LastUsedAt)
+
+	// users can only delete their own tokens
+	ok, err := repo.Delete(ctx, "jane", token.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = repo.Delete(ctx, "john", token.ID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, err = repo.GetByHash(ctx, "b")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	count, err := repo.DeleteBySubject(ctx, "john")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+func TestSQLiteChangeFeed(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	feedtest.Run(ctx, t, func(t *testing.T) (dao.ChangeFeed, *dao.Repos) {
+		db := newSQLiteDB(ctx, t)
+		return db.ChangeFeed(), newRepos(db)
+	})
+}
+
+func TestSQLiteAuditRepo(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+
+	repo := &dao.AuditRepo{}
+	db.NewRepo(&repo.Repository)
+
+	for _, e := range []*model.AuditEvent{
+		{Actor: "john", Action: "createJump", Resource: "jump://1", After: `{"id":1}`, Date: 100},
+		{Actor: "john", Action: "patchJump", Resource: "jump://1", Before: `{"id":1}`, After: `{"id":1}`, Date: 200},
+		{Actor: "jane", Action: "deleteJump", Resource: "jump://1", Before: `{"id":1}`, Date: 300},
+	} {
+		require.NoError(t, repo.Create(ctx, e))
+	}
+
+	events, err := repo.Find(ctx, model.AuditFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.EqualValues(t, "deleteJump", events[0].Action)
+	assert.EqualValues(t, `{"id":1}`, events[0].Before)
+
+	events, err = repo.Find(ctx, model.AuditFilter{Actor: "john", Since: 150}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.EqualValues(t, "patchJump", events[0].Action)
+
+	events, err = repo.Find(ctx, model.AuditFilter{Resource: "jump://1", Until: 300}, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.EqualValues(t, "createJump", events[0].Action)
+
+	count, err := repo.DeleteBefore(ctx, time.Unix(250, 0))
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+}
+
+func TestSQLiteWebhookRepo(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+
+	repo := &dao.WebhookRepo{}
+	db.NewRepo(&repo.Repository)
+
+	global := &model.Webhook{URL: "https://example.org/global", Secret: "a", CreatedBy: "john"}
+	team := &model.Webhook{GroupID: 2, URL: "https://example.org/team", Secret: "b", CreatedBy: "john"}
+	other := &model.Webhook{GroupID: 3, URL: "https://example.org/other", Secret: "c", CreatedBy: "jane"}
+	for _, w := range []*model.Webhook{global, team, other} {
+		require.NoError(t, repo.Create(ctx, w))
+	}
+	hooks, err := repo.GetByGroup(ctx, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, hooks, 2)
+	assert.EqualValues(t, global.ID, hooks[0].ID)
+	assert.EqualValues(t, "b", hooks[1].Secret)
+
+	hooks, err = repo.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, hooks, 3)
+
+	for i, status := range []model.DeliveryStatus{model.DeliveryStatusPending, model.DeliveryStatusPending, model.DeliveryStatusDead} {
+		require.NoError(t, repo.CreateDelivery(ctx, &model.WebhookDelivery{
+			WebhookID:     team.ID,
+			Event:         "jump.created",
+			Payload:       "{}",
+			Status:        status,
+			NextAttemptAt: int64(100 * (i + 1)),
+		}))
+	}
+	due, err := repo.GetDue(ctx, 150, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+
+	due[0].Status = model.DeliveryStatusDelivered
+	due[0].Attempts = 1
+	require.NoError(t, repo.SaveDelivery(ctx, due[0]))
+	d, err := repo.GetDelivery(ctx, due[0].ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, model.DeliveryStatusDelivered, d.Status)
+
+	deliveries, err := repo.GetDeliveries(ctx, team.ID, "", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 3)
+	assert.EqualValues(t, model.DeliveryStatusDead, deliveries[0].Status)
+	deliveries, err = repo.GetDeliveries(ctx, team.ID, model.DeliveryStatusDead, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+
+	// deliveries are removed with their webhook
+	require.NoError(t, repo.Delete(ctx, team.ID))
+	_, err = repo.Get(ctx, team.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	deliveries, err = repo.GetDeliveries(ctx, team.ID, "", 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestSQLiteJumpProposalRepo(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+
+	repo := &dao.JumpProposalRepo{}
+	db.NewRepo(&repo.Repository)
+
+	first := &model.JumpProposal{Name: "docs", Location: "https://example.org/docs", Alias: []string{"d"}, Submitter: "john", Status: model.ProposalStatusProposed, CreatedAt: 1}
+	second := &model.JumpProposal{Name: "wiki", Location: "https://example.org/wiki", Submitter: "john", Status: model.ProposalStatusProposed, CreatedAt: 2}
+	other := &model.JumpProposal{Name: "blog", Location: "https://example.org/blog", Submitter: "jane", Status: model.ProposalStatusProposed, CreatedAt: 3}
+	for _, p := range []*model.JumpProposal{first, second, other} {
+		require.NoError(t, repo.Create(ctx, p))
+	}
+
+	first.Status = model.ProposalStatusRejected
+	first.Reason = "duplicate"
+	require.NoError(t, repo.Save(ctx, first))
+	p, err := repo.Get(ctx, first.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, model.ProposalStatusRejected, p.Status)
+	assert.EqualValues(t, []string{"d"}, p.Alias)
+
+	pending, err := repo.GetByStatus(ctx, model.ProposalStatusProposed, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.EqualValues(t, second.ID, pending[0].ID)
+
+	mine, err := repo.GetBySubmitter(ctx, "john")
+	require.NoError(t, err)
+	require.Len(t, mine, 2)
+	assert.EqualValues(t, second.ID, mine[0].ID)
+
+	// approving creates the jump, but only once
+	second.Status = model.ProposalStatusApproved
+	require.NoError(t, repo.Approve(ctx, second, &model.Jump{Name: second.Name, Location: second.Location}))
+	assert.NotZero(t, second.JumpID)
+	p, err = repo.Get(ctx, second.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, second.JumpID, p.JumpID)
+
+	assert.ErrorIs(t, repo.Approve(ctx, second, &model.Jump{Name: second.Name, Location: second.Location}), gorm.ErrRecordNotFound)
+	jumps := &dao.JumpRepo{}
+	db.NewRepo(&jumps.Repository)
+	created, err := jumps.GetByOwner(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, created, 1)
+}
+
+func TestSQLiteBackup(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+	db := newSQLiteDB(ctx, t)
+
+	groups := &dao.GroupRepo{}
+	db.NewRepo(&groups.Repository)
+	jumps := &dao.JumpRepo{}
+	db.NewRepo(&jumps.Repository)
+
+	group, err := groups.Save(&model.Group{Name: "team", Owner: "john"})
+	require.NoError(t, err)
+	require.NoError(t, groups.AddMember(ctx, &model.GroupMember{GroupID: group.ID, Subject: "john", Role: model.GroupRoleOwner}))
+	require.NoError(t, groups.AddMember(ctx, &model.GroupMember{GroupID: group.ID, Subject: "jane", Role: model.GroupRoleMaintainer}))
+	mine, err := jumps.Save(ctx, &model.Jump{Name: "mine", Location: "https://example.org/mine", Owner: "user://john"})
+	require.NoError(t, err)
+	_, err = jumps.Save(ctx, &model.Jump{Name: "shared", Location: "https://example.org/shared", Owner: fmt.Sprintf("group://%d", group.ID)})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := backup.NewWriter(&buf)
+	require.NoError(t, db.Export(ctx, w))
+	require.NoError(t, w.Close())
+	data := buf.Bytes()
+
+	t.Run("role bindings are exported", func(t *testing.T) {
+		r, err := backup.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		var bindings []backup.RoleBinding
+		require.NoError(t, r.Each(backup.TableRoleBindings, func(data json.RawMessage) error {
+			var b backup.RoleBinding
+			if err := json.Unmarshal(data, &b); err != nil {
+				return err
+			}
+			bindings = append(bindings, b)
+			return nil
+		}))
+		// the group's jump is covered by the group's bindings
+		assert.ElementsMatch(t, []backup.RoleBinding{
+			{Subject: "john", Resource: fmt.Sprintf("group://%d", group.ID), Action: "SUDO"},
+			{Subject: "jane", Resource: fmt.Sprintf("group://%d", group.ID), Action: "UPDATE"},
+			{Subject: "john", Resource: fmt.Sprintf("jump://%d", mine.ID), Action: "SUDO"},
+		}, bindings)
+	})
+	t.Run("groups with the same name aren't reused", func(t *testing.T) {
+		r, err := backup.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		_, err = db.Restore(ctx, r, nil)
+		assert.ErrorIs(t, err, dao.ErrGroupExists)
+
+		// nothing is restored
+		found, err := jumps.GetByOwner(ctx, "user://john")
+		require.NoError(t, err)
+		assert.Len(t, found, 1)
+	})
+	t.Run("groups are created", func(t *testing.T) {
+		other := newSQLiteDB(ctx, t)
+		otherGroups := &dao.GroupRepo{}
+		other.NewRepo(&otherGroups.Repository)
+		_, err := otherGroups.Save(&model.Group{Name: "unrelated"})
+		require.NoError(t, err)
+
+		r, err := backup.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		ids, err := other.Restore(ctx, r, nil)
+		require.NoError(t, err)
+
+		id, ok := ids.Get(schemas.ResourceGroup, group.ID)
+		require.True(t, ok)
+		restored, err := otherGroups.GetByID(ctx, id)
+		require.NoError(t, err)
+		assert.EqualValues(t, "team", restored.Name)
+		assert.EqualValues(t, "john,jane", restored.Users)
+	})
+}
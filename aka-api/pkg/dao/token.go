@@ -0,0 +1,82 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type AccessTokenRepo struct {
+	Repository
+}
+
+// Create stores a new AccessToken
+func (r *AccessTokenRepo) Create(ctx context.Context, t *model.AccessToken) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_token_create", trace.WithAttributes(attribute.String("subject", t.Subject)))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Create(t).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to create access token")
+		return err
+	}
+	return nil
+}
+
+// GetByHash returns the AccessToken with the given hash
+func (r *AccessTokenRepo) GetByHash(ctx context.Context, hash string) (*model.AccessToken, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_token_getByHash")
+	defer span.End()
+	var result model.AccessToken
+	if err := r.db.WithContext(ctx).Where("hash = ?", hash).First(&result).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetBySubject returns the AccessTokens belonging to a user, newest first
+func (r *AccessTokenRepo) GetBySubject(ctx context.Context, subject string) ([]*model.AccessToken, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_token_getBySubject", trace.WithAttributes(attribute.String("subject", subject)))
+	defer span.End()
+	var results []*model.AccessToken
+	if err := r.db.WithContext(ctx).Where("subject = ?", subject).Order("id desc").Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to list access tokens")
+		return nil, err
+	}
+	return results, nil
+}
+
+// Delete removes an AccessToken belonging to a user,
+// returning false if there wasn't one.
+func (r *AccessTokenRepo) Delete(ctx context.Context, subject string, id uint) (bool, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_token_delete", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	tx := r.db.WithContext(ctx).Where("subject = ?", subject).Delete(&model.AccessToken{}, id)
+	if tx.Error != nil {
+		span.RecordError(tx.Error)
+		return false, tx.Error
+	}
+	return tx.RowsAffected > 0, nil
+}
+
+// DeleteBySubject removes every AccessToken belonging to a user
+func (r *AccessTokenRepo) DeleteBySubject(ctx context.Context, subject string) (int64, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_token_deleteBySubject", trace.WithAttributes(attribute.String("subject", subject)))
+	defer span.End()
+	tx := r.db.WithContext(ctx).Where("subject = ?", subject).Delete(&model.AccessToken{})
+	if tx.Error != nil {
+		span.RecordError(tx.Error)
+		return 0, tx.Error
+	}
+	return tx.RowsAffected, nil
+}
+
+// SetLastUsed records when an AccessToken was last used
+func (r *AccessTokenRepo) SetLastUsed(ctx context.Context, id uint, at int64) error {
+	return r.db.WithContext(ctx).Model(&model.AccessToken{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
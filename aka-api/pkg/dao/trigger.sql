@@ -3,18 +3,48 @@ DROP TRIGGER IF EXISTS trigger_{{ .Table }}_update on {{ .Table }};
 CREATE OR REPLACE FUNCTION notify_{{ .Table }}_update() RETURNS TRIGGER AS $$
     DECLARE
     row RECORD;
+    data JSONB;
+    changed JSONB;
     output TEXT;
 
     BEGIN
     -- Checking the Operation Type
     IF (TG_OP = 'DELETE') THEN
       row = OLD;
+      data = to_jsonb(OLD);
     ELSE
       row = NEW;
+      data = to_jsonb(NEW);
     END IF;
 
-    -- Forming the Output as notification. You can choose you own notification.
-    output = '{"TABLE": "{{ .Table }}", "OPERATION": "' || TG_OP || '", "ID": ' || row.id || '}';
+    -- Collecting the columns that an update modified
+    IF (TG_OP = 'UPDATE') THEN
+      SELECT jsonb_agg(n.key ORDER BY n.key) INTO changed
+      FROM jsonb_each(data) n
+      WHERE n.value IS DISTINCT FROM (to_jsonb(OLD) -> n.key);
+    END IF;
+
+    -- Forming the Output as notification. Columns that the table
+    -- doesn't have are left out.
+    output = jsonb_strip_nulls(jsonb_build_object(
+      'TABLE', '{{ .Table }}',
+      'OPERATION', TG_OP,
+      'ID', row.id,
+      'OWNER', data ->> 'owner',
+      'NAME', data ->> 'name',
+      'CHANGED', changed
+    ))::text;
+
+    -- NOTIFY payloads must be smaller than {{ .Limit }} bytes, so
+    -- large rows fall back to sending only the ID
+    IF (octet_length(output) >= {{ .Limit }}) THEN
+      output = jsonb_build_object(
+        'TABLE', '{{ .Table }}',
+        'OPERATION', TG_OP,
+        'ID', row.id,
+        'TRUNCATED', true
+      )::text;
+    END IF;
 
     -- Calling the pg_notify for my_table_update event with output as payload
 
@@ -17,9 +17,188 @@
 
 package dao
 
+import (
+	"context"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"time"
+)
+
 type Repos struct {
-	JumpRepo      *JumpRepo
-	GroupRepo     *GroupRepo
-	UserRepo      *UserV2Repo
-	JumpEventRepo *JumpEventRepo
+	JumpRepo      JumpRepository
+	GroupRepo     GroupRepository
+	UserRepo      UserRepository
+	JumpEventRepo JumpEventRepository
+	TokenRepo     AccessTokenRepository
+	AuditRepo     AuditRepository
+	WebhookRepo   WebhookRepository
+	ProposalRepo  JumpProposalRepository
+}
+
+// JumpRepository stores Jumps
+type JumpRepository interface {
+	// GetAll returns all Jumps available to a user
+	GetAll(ctx context.Context, user string, offset, limit int, groups []uint) (*model.Page, error)
+	// ExistsByID returns whether a Jump exists by a given primaryKey (ID)
+	ExistsByID(ctx context.Context, id uint) bool
+	// GetByID returns a Jump by its primaryKey (ID)
+	GetByID(ctx context.Context, id uint) (*model.Jump, error)
+	// SearchForTerm returns the Jumps available to a user that match a term
+	SearchForTerm(ctx context.Context, user, term string, offset, limit int, groups []uint) (*model.Page, error)
+	// Save creates or updates a Jump
+	Save(ctx context.Context, j *model.Jump) (*model.Jump, error)
+	// DeleteByID soft-deletes a Jump by a given primaryKey (ID)
+	DeleteByID(ctx context.Context, id uint) error
+	// GetByHost returns the Jumps available to a user whose location mentions the host
+	GetByHost(ctx context.Context, user, host string, groups []uint) ([]*model.Jump, error)
+	// GetByOwner returns every Jump owned by a given owner
+	GetByOwner(ctx context.Context, owner string) ([]*model.Jump, error)
+	// DeleteByOwner soft-deletes every Jump owned by a given owner
+	DeleteByOwner(ctx context.Context, owner string) (int64, error)
+	// SetOwner moves every Jump owned by one owner to another, returning their IDs
+	SetOwner(ctx context.Context, from, to string) ([]uint, error)
+	// FindInBatches iterates over every Jump, regardless of who can see it
+	FindInBatches(ctx context.Context, size int, f func(jumps []*model.Jump) error) error
+}
+
+// GroupRepository stores Groups
+type GroupRepository interface {
+	// Save creates or updates a given Group
+	Save(e *model.Group) (*model.Group, error)
+	// FindByID returns a Group by its primaryKey (ID) if the current user is a member
+	FindByID(ctx context.Context, id int) (*model.Group, error)
+	// GetByID returns a Group by its primaryKey (ID), regardless of who can see it
+	GetByID(ctx context.Context, id uint) (*model.Group, error)
+	// FindByName returns a Group by a given name
+	FindByName(ctx context.Context, name string) (*model.Group, error)
+	// GetUserGroups gets all groups that contain a given user, except those that are archived
+	GetUserGroups(ctx context.Context, username string) ([]*model.Group, error)
+	// GetGroups gets all groups visible to the requesting user, except those that are archived
+	GetGroups(ctx context.Context, user string, offset, limit int) (*model.Page, error)
+	// GetArchived gets the archived groups that contain a given user
+	GetArchived(ctx context.Context, username string) ([]*model.Group, error)
+	// Delete soft-deletes a Group by a given primaryKey (ID) along with its Jumps,
+	// or gives its Jumps to reassignTo if it is set and returns their IDs
+	Delete(ctx context.Context, id uint, reassignTo string) ([]uint, error)
+	// GetMembers returns the members of a Group in the order that they were added
+	GetMembers(ctx context.Context, id uint) ([]*model.GroupMember, error)
+	// GetMember returns a single member of a Group
+	GetMember(ctx context.Context, id uint, subject string) (*model.GroupMember, error)
+	// AddMember adds a user to a Group, or updates their role if they are already a member
+	AddMember(ctx context.Context, m *model.GroupMember) error
+	// RemoveMember removes a user from a Group
+	RemoveMember(ctx context.Context, id uint, subject string) error
+	// GetMemberships returns every Group that a user is a member of, including those that are archived
+	GetMemberships(ctx context.Context, subject string) ([]*model.GroupMember, error)
+	// AddMemberEvent records a change to the members of a Group
+	AddMemberEvent(ctx context.Context, e *model.GroupMemberEvent) error
+	// GetMemberEvents returns the changes to the members of a Group, newest first
+	GetMemberEvents(ctx context.Context, id uint, offset, limit int) ([]*model.GroupMemberEvent, error)
+	// FindInBatches iterates over every Group, regardless of who can see it
+	FindInBatches(ctx context.Context, size int, f func(groups []*model.Group) error) error
+}
+
+// UserRepository stores Users
+type UserRepository interface {
+	// Save creates or updates a given User
+	Save(ctx context.Context, e *UserV2) (*UserV2, error)
+	// Get returns a User by their subject
+	Get(ctx context.Context, sub string) (*UserV2, error)
+	// GetByID returns a User by their primaryKey (ID)
+	GetByID(ctx context.Context, id uint) (*UserV2, error)
+	// GetUsers returns a page of Users, except those that are deactivated
+	GetUsers(ctx context.Context, offset, limit int) (*model.Page, error)
+	// FindInBatches iterates over every User
+	FindInBatches(ctx context.Context, size int, f func(users []*UserV2) error) error
+}
+
+// JumpEventRepository stores JumpEvents
+type JumpEventRepository interface {
+	// Save creates or updates a given JumpEvent
+	Save(ctx context.Context, e *model.JumpEvent) (*model.JumpEvent, error)
+	// GetFrequencyByUserID returns the Jumps most used by a given user
+	GetFrequencyByUserID(ctx context.Context, userID string, limit int) ([]JumpEventFrequency, error)
+	// DeleteBefore permanently removes all JumpEvents that occurred before a given time
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
 }
+
+// AccessTokenRepository stores personal AccessTokens
+type AccessTokenRepository interface {
+	// Create stores a new AccessToken
+	Create(ctx context.Context, t *model.AccessToken) error
+	// GetByHash returns the AccessToken with the given hash
+	GetByHash(ctx context.Context, hash string) (*model.AccessToken, error)
+	// GetBySubject returns the AccessTokens belonging to a user, newest first
+	GetBySubject(ctx context.Context, subject string) ([]*model.AccessToken, error)
+	// Delete removes an AccessToken belonging to a user, returning false if there wasn't one
+	Delete(ctx context.Context, subject string, id uint) (bool, error)
+	// DeleteBySubject removes every AccessToken belonging to a user
+	DeleteBySubject(ctx context.Context, subject string) (int64, error)
+	// SetLastUsed records when an AccessToken was last used
+	SetLastUsed(ctx context.Context, id uint, at int64) error
+}
+
+// AuditRepository stores the AuditEvents
+// that make up the audit log
+type AuditRepository interface {
+	// Create stores a new AuditEvent
+	Create(ctx context.Context, e *model.AuditEvent) error
+	// Find returns the AuditEvents that match a filter, newest first
+	Find(ctx context.Context, filter model.AuditFilter, offset, limit int) ([]*model.AuditEvent, error)
+	// DeleteBefore permanently removes all AuditEvents that occurred before a given time
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// WebhookRepository stores Webhooks and the
+// deliveries that have been made to them
+type WebhookRepository interface {
+	// Create stores a new Webhook
+	Create(ctx context.Context, w *model.Webhook) error
+	// Get returns a Webhook by its primaryKey (ID)
+	Get(ctx context.Context, id uint) (*model.Webhook, error)
+	// GetByGroup returns the Webhooks that belong to any of the given Groups
+	GetByGroup(ctx context.Context, groupIDs ...uint) ([]*model.Webhook, error)
+	// GetAll returns every Webhook
+	GetAll(ctx context.Context) ([]*model.Webhook, error)
+	// Delete removes a Webhook and its deliveries
+	Delete(ctx context.Context, id uint) error
+	// CreateDelivery stores a new WebhookDelivery
+	CreateDelivery(ctx context.Context, d *model.WebhookDelivery) error
+	// SaveDelivery updates an existing WebhookDelivery
+	SaveDelivery(ctx context.Context, d *model.WebhookDelivery) error
+	// GetDelivery returns a WebhookDelivery by its primaryKey (ID)
+	GetDelivery(ctx context.Context, id uint) (*model.WebhookDelivery, error)
+	// GetDeliveries returns the deliveries made to a Webhook, newest first. An empty status matches every delivery.
+	GetDeliveries(ctx context.Context, webhookID uint, status model.DeliveryStatus, offset, limit int) ([]*model.WebhookDelivery, error)
+	// GetDue returns the pending deliveries that should be attempted at the given unix time, oldest first
+	GetDue(ctx context.Context, now int64, limit int) ([]*model.WebhookDelivery, error)
+}
+
+// JumpProposalRepository stores the public Jumps
+// that are waiting to be approved
+type JumpProposalRepository interface {
+	// Create stores a new JumpProposal
+	Create(ctx context.Context, p *model.JumpProposal) error
+	// Save updates an existing JumpProposal
+	Save(ctx context.Context, p *model.JumpProposal) error
+	// Approve creates the Jump that was proposed and saves the
+	// JumpProposal at the same time. It returns gorm.ErrRecordNotFound
+	// if the proposal has already been reviewed
+	Approve(ctx context.Context, p *model.JumpProposal, jump *model.Jump) error
+	// Get returns a JumpProposal by its primaryKey (ID)
+	Get(ctx context.Context, id uint) (*model.JumpProposal, error)
+	// GetByStatus returns the JumpProposals with a given status, oldest first
+	GetByStatus(ctx context.Context, status model.ProposalStatus, offset, limit int) ([]*model.JumpProposal, error)
+	// GetBySubmitter returns the JumpProposals made by a user, newest first
+	GetBySubmitter(ctx context.Context, subject string) ([]*model.JumpProposal, error)
+}
+
+var (
+	_ JumpRepository         = &JumpRepo{}
+	_ GroupRepository        = &GroupRepo{}
+	_ UserRepository         = &UserV2Repo{}
+	_ JumpEventRepository    = &JumpEventRepo{}
+	_ AccessTokenRepository  = &AccessTokenRepo{}
+	_ AuditRepository        = &AuditRepo{}
+	_ WebhookRepository      = &WebhookRepo{}
+	_ JumpProposalRepository = &JumpProposalRepo{}
+)
@@ -36,6 +36,9 @@ type UserV2 struct {
 	Email    string `json:"email"`
 	Groups   string `json:"groups"`
 	Username string `json:"username"`
+	// Deactivated users are hidden and can't log in,
+	// but are kept so that they can be reactivated.
+	Deactivated bool `json:"deactivated"`
 }
 
 func (UserV2) TableName() string {
@@ -44,6 +47,22 @@ func (UserV2) TableName() string {
 
 func (*UserV2) IsPageable() {}
 
+// ToUser converts the database representation
+// of a User into the API representation.
+func (u *UserV2) ToUser() *model.User {
+	return &model.User{
+		ID:       strconv.Itoa(int(u.ID)),
+		Subject:  u.Subject,
+		Username: u.Username,
+		Email:    u.Email,
+		Admin:    false,
+		Groups:   strings.Split(u.Groups, ","),
+	}
+}
+
+// isActiveUser matches the Users that haven't been deactivated
+const isActiveUser = "deactivated = ?"
+
 type UserV2Repo struct {
 	Repository
 }
@@ -76,6 +95,23 @@ func (r *UserV2Repo) Get(ctx context.Context, sub string) (*UserV2, error) {
 	return &result, nil
 }
 
+// GetByID returns a User by their primaryKey (ID)
+func (r *UserV2Repo) GetByID(ctx context.Context, id uint) (*UserV2, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("ID", id)
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_userv2_getByID", trace.WithAttributes(
+		attribute.Int("id", int(id)),
+	))
+	defer span.End()
+	var result UserV2
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&result).Error; err != nil {
+		span.RecordError(err)
+		log.Error(err, "failed to fetch User")
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetUsers returns a page of Users, except those that are deactivated
 func (r *UserV2Repo) GetUsers(ctx context.Context, offset, limit int) (*model.Page, error) {
 	log := logr.FromContextOrDiscard(ctx)
 	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_userv2_getUsers", trace.WithAttributes(
@@ -85,8 +121,8 @@ func (r *UserV2Repo) GetUsers(ctx context.Context, offset, limit int) (*model.Pa
 	defer span.End()
 	var result []*UserV2
 	var count int64
-	r.db.WithContext(ctx).Model(&UserV2{}).Count(&count)
-	if err := r.db.WithContext(ctx).Limit(limit).Offset(offset).Order("subject asc").Find(&result).Error; err != nil {
+	r.db.WithContext(ctx).Model(&UserV2{}).Where(isActiveUser, false).Count(&count)
+	if err := r.db.WithContext(ctx).Where(isActiveUser, false).Limit(limit).Offset(offset).Order("subject asc").Find(&result).Error; err != nil {
 		span.RecordError(err)
 		log.Error(err, "failed to read users")
 		return nil, err
@@ -94,14 +130,7 @@ func (r *UserV2Repo) GetUsers(ctx context.Context, offset, limit int) (*model.Pa
 	// convert our results to the pageable type
 	pageable := make([]model.Pageable, len(result))
 	for i := range result {
-		pageable[i] = &model.User{
-			ID:       strconv.Itoa(int(result[i].ID)),
-			Subject:  result[i].Subject,
-			Username: result[i].Username,
-			Email:    result[i].Email,
-			Admin:    false,
-			Groups:   strings.Split(result[i].Groups, ","),
-		}
+		pageable[i] = result[i].ToUser()
 	}
 	return &model.Page{
 		Results: pageable,
@@ -109,3 +138,11 @@ func (r *UserV2Repo) GetUsers(ctx context.Context, offset, limit int) (*model.Pa
 		More:    count-int64((offset+1)*limit) > 0,
 	}, nil
 }
+
+// FindInBatches iterates over every User
+func (r *UserV2Repo) FindInBatches(ctx context.Context, size int, f func(users []*UserV2) error) error {
+	var results []*UserV2
+	return r.db.WithContext(ctx).Order("id asc").FindInBatches(&results, size, func(_ *gorm.DB, _ int) error {
+		return f(results)
+	}).Error
+}
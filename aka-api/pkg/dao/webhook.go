@@ -0,0 +1,153 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
+	"gitlab.dcas.dev/jmp/go-jmp/internal/traceopts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type WebhookRepo struct {
+	Repository
+}
+
+// Create stores a new Webhook
+func (r *WebhookRepo) Create(ctx context.Context, w *model.Webhook) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_create", trace.WithAttributes(attribute.Int("groupId", int(w.GroupID))))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Create(w).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to create webhook")
+		return err
+	}
+	return nil
+}
+
+// Get returns a Webhook by its primaryKey (ID)
+func (r *WebhookRepo) Get(ctx context.Context, id uint) (*model.Webhook, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_get", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	var result model.Webhook
+	if err := r.db.WithContext(ctx).First(&result, id).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetByGroup returns the Webhooks that belong
+// to any of the given Groups.
+func (r *WebhookRepo) GetByGroup(ctx context.Context, groupIDs ...uint) ([]*model.Webhook, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_getByGroup")
+	defer span.End()
+	var results []*model.Webhook
+	if err := r.db.WithContext(ctx).Where("group_id IN ?", groupIDs).Order("id asc").Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to list webhooks")
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetAll returns every Webhook
+func (r *WebhookRepo) GetAll(ctx context.Context) ([]*model.Webhook, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_getAll")
+	defer span.End()
+	var results []*model.Webhook
+	if err := r.db.WithContext(ctx).Order("id asc").Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to list webhooks")
+		return nil, err
+	}
+	return results, nil
+}
+
+// Delete removes a Webhook and its deliveries
+func (r *WebhookRepo) Delete(ctx context.Context, id uint) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_delete", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&model.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Webhook{}, id).Error
+	})
+	if err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to delete webhook")
+		return err
+	}
+	return nil
+}
+
+// CreateDelivery stores a new WebhookDelivery
+func (r *WebhookRepo) CreateDelivery(ctx context.Context, d *model.WebhookDelivery) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_createDelivery", trace.WithAttributes(attribute.Int("webhookId", int(d.WebhookID))))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Create(d).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to create webhook delivery")
+		return err
+	}
+	return nil
+}
+
+// SaveDelivery updates an existing WebhookDelivery
+func (r *WebhookRepo) SaveDelivery(ctx context.Context, d *model.WebhookDelivery) error {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_saveDelivery", trace.WithAttributes(attribute.Int("id", int(d.ID))))
+	defer span.End()
+	if err := r.db.WithContext(ctx).Save(d).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to save webhook delivery")
+		return err
+	}
+	return nil
+}
+
+// GetDelivery returns a WebhookDelivery by its primaryKey (ID)
+func (r *WebhookRepo) GetDelivery(ctx context.Context, id uint) (*model.WebhookDelivery, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_getDelivery", trace.WithAttributes(attribute.Int("id", int(id))))
+	defer span.End()
+	var result model.WebhookDelivery
+	if err := r.db.WithContext(ctx).First(&result, id).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDeliveries returns the deliveries made to a Webhook,
+// newest first. An empty status matches every delivery.
+func (r *WebhookRepo) GetDeliveries(ctx context.Context, webhookID uint, status model.DeliveryStatus, offset, limit int) ([]*model.WebhookDelivery, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_getDeliveries", trace.WithAttributes(attribute.Int("webhookId", int(webhookID))))
+	defer span.End()
+	tx := r.db.WithContext(ctx).Where("webhook_id = ?", webhookID)
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	var results []*model.WebhookDelivery
+	if err := tx.Order("id desc").Offset(offset).Limit(limit).Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to list webhook deliveries")
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetDue returns the pending deliveries that should be
+// attempted at the given unix time, oldest first.
+func (r *WebhookRepo) GetDue(ctx context.Context, now int64, limit int) ([]*model.WebhookDelivery, error) {
+	ctx, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "repo_webhook_getDue")
+	defer span.End()
+	var results []*model.WebhookDelivery
+	if err := r.db.WithContext(ctx).Where("status = ? AND next_attempt_at <= ?", model.DeliveryStatusPending, now).Order("id asc").Limit(limit).Find(&results).Error; err != nil {
+		span.RecordError(err)
+		logr.FromContextOrDiscard(ctx).Error(err, "failed to list due webhook deliveries")
+		return nil, err
+	}
+	return results, nil
+}
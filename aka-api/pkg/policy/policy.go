@@ -0,0 +1,357 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The names of the built-in rules, as reported
+// by a Violation.
+const (
+	RuleInvalidURL     = "invalid_url"
+	RuleMaxLength      = "max_length"
+	RuleScheme         = "scheme"
+	RuleDeniedDomain   = "denied_domain"
+	RuleAllowedDomain  = "allowed_domain"
+	RulePrivateNetwork = "private_network"
+)
+
+// ScopePublic matches the owner of public Jumps.
+const ScopePublic = "public"
+
+// Options configures which destinations Jumps
+// are allowed to have.
+type Options struct {
+	// Schemes that destinations may use.
+	Schemes []string `default:"http,https"`
+	// AllowedDomains, if set, are the only hosts that
+	// destinations may point to. Wildcards such as
+	// *.example.org are supported.
+	AllowedDomains []string `split_words:"true"`
+	// DeniedDomains are hosts that destinations may
+	// never point to, even if they are allowed.
+	DeniedDomains []string `split_words:"true"`
+	// BlockPrivateNetworks rejects destinations that are
+	// loopback, private or link-local IP addresses. Host
+	// names are not resolved.
+	BlockPrivateNetworks bool `split_words:"true"`
+	// MaxLength is the longest that a destination
+	// can be. There is no limit if 0.
+	MaxLength int `split_words:"true" default:"2048"`
+	// Rules are extra checks that only apply
+	// to Jumps with particular owners.
+	Rules Rules
+	// StripParams are query parameters that are removed
+	// from destinations when they are saved, such as
+	// tracking parameters. Wildcards are supported.
+	StripParams []string `split_words:"true" default:"utm_*"`
+}
+
+// Rule checks destinations against a regular expression.
+type Rule struct {
+	// Name identifies the rule when it is broken.
+	Name string `json:"name"`
+	// Scope is the owner that the rule applies to:
+	//
+	//	""            every Jump
+	//	"public"      public Jumps
+	//	"user://"     every user's Jumps
+	//	"group://4"   the Jumps of a single group
+	Scope string `json:"scope"`
+	// Pattern is the regular expression that
+	// destinations must match.
+	Pattern string `json:"pattern"`
+	// Deny inverts the rule, so that destinations
+	// must not match the Pattern.
+	Deny bool `json:"deny"`
+
+	re *regexp.Regexp
+}
+
+// Rules is a list of Rule that can be read
+// from the environment as JSON.
+type Rules []Rule
+
+// Decode implements envconfig.Decoder
+func (r *Rules) Decode(value string) error {
+	if value == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(value), r)
+}
+
+// Violation is returned when a destination
+// breaks one of the rules of a Policy.
+type Violation struct {
+	// Rule is the name of the rule
+	// that was broken.
+	Rule    string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("destination breaks the %s rule: %s", v.Rule, v.Message)
+}
+
+// Policy decides whether a Jump is allowed
+// to point somewhere. A nil Policy allows
+// everything.
+type Policy struct {
+	opts    Options
+	schemes map[string]struct{}
+}
+
+// New validates Options and compiles its Rules.
+func New(opts Options) (*Policy, error) {
+	p := &Policy{
+		opts:    opts,
+		schemes: make(map[string]struct{}, len(opts.Schemes)),
+	}
+	for _, s := range opts.Schemes {
+		p.schemes[strings.ToLower(strings.TrimSpace(s))] = struct{}{}
+	}
+	for _, d := range slices.Concat(opts.AllowedDomains, opts.DeniedDomains, opts.StripParams) {
+		if _, err := path.Match(d, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", d, err)
+		}
+	}
+	p.opts.Rules = make(Rules, len(opts.Rules))
+	for i, r := range opts.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d must have a name", i)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for rule %s: %w", r.Name, err)
+		}
+		r.re = re
+		p.opts.Rules[i] = r
+	}
+	return p, nil
+}
+
+// Check returns a *Violation if a Jump with the given
+// owner isn't allowed to point to the location.
+func (p *Policy) Check(owner, location string) error {
+	if p == nil {
+		return nil
+	}
+	if p.opts.MaxLength > 0 && len(location) > p.opts.MaxLength {
+		return &Violation{Rule: RuleMaxLength, Message: fmt.Sprintf("must be no longer than %d characters", p.opts.MaxLength)}
+	}
+	uri, err := url.Parse(location)
+	if err != nil {
+		return &Violation{Rule: RuleInvalidURL, Message: "must be a valid url"}
+	}
+	if len(p.schemes) > 0 {
+		if _, ok := p.schemes[strings.ToLower(uri.Scheme)]; !ok {
+			return &Violation{Rule: RuleScheme, Message: fmt.Sprintf("must use one of: %s", strings.Join(p.opts.Schemes, ", "))}
+		}
+	}
+	if host := strings.TrimSuffix(strings.ToLower(uri.Hostname()), "."); host != "" {
+		if matchDomain(p.opts.DeniedDomains, host) {
+			return &Violation{Rule: RuleDeniedDomain, Message: fmt.Sprintf("%s is not allowed", host)}
+		}
+		if len(p.opts.AllowedDomains) > 0 && !matchDomain(p.opts.AllowedDomains, host) {
+			return &Violation{Rule: RuleAllowedDomain, Message: fmt.Sprintf("%s is not an allowed domain", host)}
+		}
+		if p.opts.BlockPrivateNetworks && IsPrivate(host) {
+			return &Violation{Rule: RulePrivateNetwork, Message: fmt.Sprintf("%s is on a private network", host)}
+		}
+	}
+	for _, r := range p.opts.Rules {
+		if !inScope(r.Scope, owner) {
+			continue
+		}
+		if r.re.MatchString(location) == r.Deny {
+			msg := "must match " + r.Pattern
+			if r.Deny {
+				msg = "must not match " + r.Pattern
+			}
+			return &Violation{Rule: r.Name, Message: msg}
+		}
+	}
+	return nil
+}
+
+// Normalise rewrites a destination so that different ways
+// of writing the same URL look the same. The scheme and host
+// are lowercased, default ports are removed and any of the
+// StripParams are dropped from the query. A nil Policy
+// doesn't strip any parameters.
+//
+// Destinations that can't be parsed are returned as-is.
+func (p *Policy) Normalise(location string) string {
+	location = strings.TrimSpace(location)
+	uri, err := url.Parse(location)
+	if err != nil || uri.Scheme == "" {
+		return location
+	}
+	uri.Scheme = strings.ToLower(uri.Scheme)
+	if uri.Host != "" {
+		host, port := strings.ToLower(uri.Hostname()), uri.Port()
+		if (uri.Scheme == "http" && port == "80") || (uri.Scheme == "https" && port == "443") {
+			port = ""
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if port != "" {
+			host += ":" + port
+		}
+		uri.Host = host
+		if uri.Path == "" {
+			uri.Path = "/"
+		}
+	}
+	if p != nil && uri.RawQuery != "" {
+		uri.RawQuery = stripParams(uri.RawQuery, p.opts.StripParams)
+	}
+	return uri.String()
+}
+
+// Equivalent checks whether two destinations point to the
+// same place once they have been normalised, ignoring any
+// trailing slash and fragment.
+func (p *Policy) Equivalent(a, b string) bool {
+	return p.key(a) == p.key(b)
+}
+
+func (p *Policy) key(location string) string {
+	uri, err := url.Parse(p.Normalise(location))
+	if err != nil {
+		return location
+	}
+	uri.Fragment = ""
+	uri.RawFragment = ""
+	uri.Path = strings.TrimSuffix(uri.Path, "/")
+	uri.RawPath = strings.TrimSuffix(uri.RawPath, "/")
+	return uri.String()
+}
+
+// stripParams removes the parameters that match any of the
+// patterns from a query, leaving the rest untouched.
+func stripParams(query string, patterns []string) string {
+	params := strings.Split(query, "&")
+	kept := params[:0]
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if matchParam(patterns, strings.ToLower(key)) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
+
+func matchParam(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain checks whether a host matches
+// any of the given patterns.
+func matchDomain(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPrivate checks whether a host refers to something
+// that is only reachable from inside the network. Host
+// names other than localhost are not resolved.
+func IsPrivate(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if ip = parseIPv4(host); ip == nil {
+			return false
+		}
+	}
+	return IsPrivateIP(ip)
+}
+
+// IsPrivateIP checks whether an IP address is loopback,
+// private, link-local or unspecified.
+func IsPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// parseIPv4 parses an IPv4 address the way that browsers do
+// (i.e. inet_aton), which is far more forgiving than
+// net.ParseIP. An address can have between one and four
+// parts, each of which can be decimal, octal (0177) or
+// hex (0x7f), and the last part fills the remaining bytes,
+// so 127.1 and 2130706433 are both 127.0.0.1.
+func parseIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	var n uint64
+	for i, part := range parts {
+		v, err := parseIPv4Part(part)
+		if err != nil {
+			return nil
+		}
+		// every part but the last is a single byte
+		bits := 8
+		if i == len(parts)-1 {
+			bits = 8 * (4 - i)
+		}
+		if v >= 1<<bits {
+			return nil
+		}
+		n = n<<bits | v
+	}
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func parseIPv4Part(part string) (uint64, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x"):
+		base, part = 16, part[2:]
+		// a bare 0x is a zero
+		if part == "" {
+			return 0, nil
+		}
+	case len(part) > 1 && part[0] == '0':
+		base, part = 8, part[1:]
+	}
+	return strconv.ParseUint(part, base, 32)
+}
+
+// inScope checks whether a Rule with the
+// given scope applies to an owner.
+func inScope(scope, owner string) bool {
+	switch {
+	case scope == "":
+		return true
+	case scope == ScopePublic:
+		return owner == ""
+	case strings.HasSuffix(scope, "://"):
+		return strings.HasPrefix(owner, scope)
+	default:
+		return scope == owner
+	}
+}
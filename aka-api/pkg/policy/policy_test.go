@@ -0,0 +1,190 @@
+package policy
+
+import (
+	"errors"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	p, err := New(Options{
+		Schemes:              []string{"http", "https"},
+		AllowedDomains:       []string{"example.org", "*.example.org", "10.0.0.1"},
+		DeniedDomains:        []string{"evil.example.org"},
+		BlockPrivateNetworks: true,
+		MaxLength:            64,
+		Rules: Rules{
+			{Name: "wiki-only", Scope: "group://4", Pattern: `^https://wiki\.example\.org/`},
+			{Name: "no-http", Scope: ScopePublic, Pattern: `^http://`, Deny: true},
+		},
+	})
+	require.NoError(t, err)
+
+	var cases = []struct {
+		owner    string
+		location string
+		rule     string
+	}{
+		{"user://john", "https://example.org", ""},
+		{"user://john", "https://docs.example.org/foo", ""},
+		{"user://john", "HTTPS://Docs.Example.org./foo", ""},
+		{"user://john", "javascript:alert(1)", RuleScheme},
+		{"user://john", "ftp://example.org", RuleScheme},
+		{"user://john", "example.org", RuleScheme},
+		{"user://john", "https://evil.example.org", RuleDeniedDomain},
+		{"user://john", "https://example.org.evil.com", RuleAllowedDomain},
+		{"user://john", "https://exampIe.org", RuleAllowedDomain},
+		{"user://john", "http://10.0.0.1", RulePrivateNetwork},
+		{"user://john", "https://example.org/" + strings.Repeat("a", 64), RuleMaxLength},
+		{"user://john", "https://example.org/%zz", RuleInvalidURL},
+		{"group://4", "https://wiki.example.org/page", ""},
+		{"group://4", "https://docs.example.org/page", "wiki-only"},
+		{"group://5", "https://docs.example.org/page", ""},
+		{"", "http://example.org", "no-http"},
+		{"user://john", "http://example.org", ""},
+	}
+	for _, tt := range cases {
+		t.Run(tt.owner+" "+tt.location, func(t *testing.T) {
+			err := p.Check(tt.owner, tt.location)
+			if tt.rule == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var v *Violation
+			require.True(t, errors.As(err, &v))
+			assert.Equal(t, tt.rule, v.Rule)
+		})
+	}
+}
+
+func TestPolicy_CheckPrivate(t *testing.T) {
+	p, err := New(Options{BlockPrivateNetworks: true})
+	require.NoError(t, err)
+
+	var cases = []struct {
+		location string
+		blocked  bool
+	}{
+		{"http://localhost:8080", true},
+		{"http://app.localhost", true},
+		{"http://127.0.0.1", true},
+		{"http://[::1]/", true},
+		{"http://192.168.1.1", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://2130706433", true},
+		{"http://0x7f000001", true},
+		{"http://0.0.0.0", true},
+		{"http://127.1/", true},
+		{"http://0177.0.0.1/", true},
+		{"http://0x7f.0.0.1/", true},
+		{"http://0x7f.1/", true},
+		{"http://10.1.1/", true},
+		{"http://192.168.257/", true},
+		{"http://0251.0376.0251.0376/", true},
+		{"http://[::ffff:127.0.0.1]/", true},
+		{"http://127.0.0.1./", true},
+		{"http://1.2.3.4.5/", false},
+		{"http://0.256.0.1/", false},
+		{"http://1.256/", false},
+		{"http://12.example.org/", false},
+		{"https://8.8.8.8", false},
+		{"https://internal.example.org", false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.location, func(t *testing.T) {
+			err := p.Check("", tt.location)
+			if !tt.blocked {
+				assert.NoError(t, err)
+				return
+			}
+			var v *Violation
+			require.True(t, errors.As(err, &v))
+			assert.Equal(t, RulePrivateNetwork, v.Rule)
+		})
+	}
+}
+
+func TestPolicy_Nil(t *testing.T) {
+	var p *Policy
+	assert.NoError(t, p.Check("", "javascript:alert(1)"))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Options{Rules: Rules{{Name: "bad", Pattern: "("}}})
+	assert.Error(t, err)
+	_, err = New(Options{Rules: Rules{{Pattern: ".*"}}})
+	assert.Error(t, err)
+	_, err = New(Options{DeniedDomains: []string{"[example.org"}})
+	assert.Error(t, err)
+}
+
+func TestOptions_Env(t *testing.T) {
+	t.Setenv("TEST_ALLOWED_DOMAINS", "example.org,*.example.org")
+	t.Setenv("TEST_RULES", `[{"name": "wiki-only", "scope": "group://4", "pattern": "^https://wiki\\.example\\.org/"}]`)
+
+	var opts Options
+	require.NoError(t, envconfig.Process("test", &opts))
+	assert.EqualValues(t, []string{"http", "https"}, opts.Schemes)
+	assert.EqualValues(t, []string{"example.org", "*.example.org"}, opts.AllowedDomains)
+	assert.EqualValues(t, 2048, opts.MaxLength)
+	assert.EqualValues(t, []string{"utm_*"}, opts.StripParams)
+	require.Len(t, opts.Rules, 1)
+	assert.Equal(t, "group://4", opts.Rules[0].Scope)
+
+	_, err := New(opts)
+	assert.NoError(t, err)
+
+	// stripping can be turned off
+	t.Setenv("TEST_STRIP_PARAMS", "")
+	opts = Options{}
+	require.NoError(t, envconfig.Process("test", &opts))
+	assert.Empty(t, opts.StripParams)
+}
+
+func TestPolicy_Normalise(t *testing.T) {
+	p, err := New(Options{StripParams: []string{"utm_*", "fbclid"}})
+	require.NoError(t, err)
+
+	var cases = []struct {
+		in       string
+		expected string
+	}{
+		{"HTTPS://Example.ORG", "https://example.org/"},
+		{"https://example.org:443/Docs", "https://example.org/Docs"},
+		{"http://example.org:80/", "http://example.org/"},
+		{"http://example.org:8080/", "http://example.org:8080/"},
+		{"https://example.org:80/", "https://example.org:80/"},
+		{"http://[::1]:80/", "http://[::1]/"},
+		{"https://example.org/?utm_source=mail&q=a+b&UTM_MEDIUM=x&fbclid=1", "https://example.org/?q=a+b"},
+		{"https://example.org/?utm_source=mail", "https://example.org/"},
+		{"https://example.org/page#utm_source", "https://example.org/page#utm_source"},
+		{"  https://example.org/a  ", "https://example.org/a"},
+		{"MAILTO:someone@example.org", "mailto:someone@example.org"},
+		{"example.org", "example.org"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.expected, p.Normalise(tt.in))
+		})
+	}
+
+	t.Run("nil policy keeps parameters", func(t *testing.T) {
+		var p *Policy
+		assert.Equal(t, "https://example.org/?utm_source=mail", p.Normalise("HTTPS://EXAMPLE.org:443?utm_source=mail"))
+	})
+}
+
+func TestPolicy_Equivalent(t *testing.T) {
+	p, err := New(Options{StripParams: []string{"utm_*"}})
+	require.NoError(t, err)
+
+	assert.True(t, p.Equivalent("https://example.org", "HTTPS://EXAMPLE.ORG:443/"))
+	assert.True(t, p.Equivalent("https://example.org/docs/", "https://example.org/docs?utm_campaign=x"))
+	assert.True(t, p.Equivalent("https://example.org/docs#intro", "https://example.org/docs"))
+	assert.False(t, p.Equivalent("https://example.org/docs", "https://example.org/Docs"))
+	assert.False(t, p.Equivalent("https://example.org/docs?page=1", "https://example.org/docs?page=2"))
+	assert.False(t, p.Equivalent("http://example.org", "https://example.org"))
+}
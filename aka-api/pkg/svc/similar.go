@@ -32,6 +32,11 @@ import (
 	"strings"
 )
 
+// nearIdenticalThreshold is how similar two names need to
+// be before we think they are the same, regardless of how
+// loosely we match when searching.
+const nearIdenticalThreshold = 0.9
+
 type SimilarService struct {
 	threshold float64
 }
@@ -116,6 +121,18 @@ func (ss *SimilarService) ForSuggesting(ctx context.Context, items []*model.Jump
 	return vsm
 }
 
+// ForNaming returns the Jumps whose name is close enough
+// to the given name that one is probably a copy of the other.
+func (ss *SimilarService) ForNaming(ctx context.Context, items []*model.Jump, name string) []*model.Jump {
+	_, span := otel.Tracer(traceopts.DefaultTracerName).Start(ctx, "svc_similar_forNaming", trace.WithAttributes(attribute.String("name", name)))
+	defer span.End()
+	threshold := max(ss.threshold, nearIdenticalThreshold)
+	name = strings.ToLower(name)
+	return dao.FilterJumps(items, func(j *model.Jump) bool {
+		return textdistance.JaroWinklerDistance(name, strings.ToLower(j.Name)) >= threshold
+	})
+}
+
 // checkForDuplicates checks if any Jumps are exact matches
 func (ss *SimilarService) checkForDuplicates(items []*model.Jump, term string) []*model.Jump {
 	return dao.FilterJumps(items, func(j *model.Jump) bool {
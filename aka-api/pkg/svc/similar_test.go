@@ -18,6 +18,7 @@
 package svc
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"gitlab.dcas.dev/jmp/go-jmp/internal/ql/graph/model"
@@ -54,3 +55,20 @@ func TestSimilarService_checkForDuplicates(t *testing.T) {
 		})
 	}
 }
+
+func TestSimilarService_ForNaming(t *testing.T) {
+	ss := NewSimilarService(0.7)
+
+	items := []*model.Jump{
+		{Name: "docs"},
+		{Name: "Doc"},
+		{Name: "dogs"},
+		{Name: "wiki"},
+	}
+	results := ss.ForNaming(context.TODO(), items, "docs")
+	names := make([]string, len(results))
+	for i, j := range results {
+		names[i] = j.Name
+	}
+	assert.ElementsMatch(t, []string{"docs", "Doc"}, names)
+}
@@ -0,0 +1,12 @@
+package testconstructs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// NewSQLite returns the DSN of a SQLite database
+// that is removed when the test finishes.
+func NewSQLite(t *testing.T) string {
+	return "sqlite://" + filepath.Join(t.TempDir(), "aka.db")
+}
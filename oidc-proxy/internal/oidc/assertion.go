@@ -0,0 +1,76 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// minAssertionKeyLength is the shortest key that we'll
+// sign with, the same as the size of the HS256 digest.
+const minAssertionKeyLength = 32
+
+var assertionHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// AssertionClaims describe the user that a request
+// was made by.
+type AssertionClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	Email     string   `json:"email,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Username  string   `json:"preferred_username,omitempty"`
+}
+
+// Signer creates short-lived assertions (HS256 JWTs) that
+// upstreams use to check that the identity of the user was
+// provided by us.
+type Signer struct {
+	key      []byte
+	audience string
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+func NewSigner(key, audience string, ttl time.Duration) (*Signer, error) {
+	if len(key) < minAssertionKeyLength {
+		return nil, errors.New("assertion key must be at least 32 bytes")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("assertion ttl must be positive")
+	}
+	return &Signer{
+		key:      []byte(key),
+		audience: audience,
+		ttl:      ttl,
+		now:      time.Now,
+	}, nil
+}
+
+// Sign returns an assertion of the given user's identity.
+func (s *Signer) Sign(user User) (string, error) {
+	now := s.now()
+	claims, err := json.Marshal(AssertionClaims{
+		Issuer:    user.Iss,
+		Subject:   user.Sub,
+		Audience:  s.audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+		Email:     user.Email,
+		Groups:    user.Groups,
+		Username:  user.Username,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload := assertionHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,45 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	key := strings.Repeat("k", minAssertionKeyLength)
+
+	_, err := NewSigner("short", "aka-api", time.Minute)
+	assert.Error(t, err)
+
+	s, err := NewSigner(key, "aka-api", time.Minute)
+	require.NoError(t, err)
+	s.now = func() time.Time {
+		return time.Unix(1000, 0)
+	}
+	token, err := s.Sign(User{Sub: "john", Iss: "https://example.org", Email: "john@example.org", Groups: []string{"devs"}})
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	// the signature covers the header and claims
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	assert.EqualValues(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), parts[2])
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims AssertionClaims
+	require.NoError(t, json.Unmarshal(raw, &claims))
+	assert.EqualValues(t, "john", claims.Subject)
+	assert.EqualValues(t, "aka-api", claims.Audience)
+	assert.EqualValues(t, 1060, claims.ExpiresAt)
+	assert.EqualValues(t, []string{"devs"}, claims.Groups)
+}
@@ -20,11 +20,21 @@ func (f *Filter) Middleware(h http.Handler) http.Handler {
 		r.Header.Del(xForwardedEmail)
 		r.Header.Del(xForwardedGroups)
 		r.Header.Del(xForwardedUsername)
+		r.Header.Del(xForwardedAssertion)
 		if oidcUser.Sub != "" {
 			r.Header.Set(xForwardedUser, oidcUser.Sub)
 			r.Header.Set(xForwardedEmail, oidcUser.Email)
 			r.Header.Set(xForwardedGroups, strings.Join(oidcUser.Groups, ","))
 			r.Header.Set(xForwardedUsername, oidcUser.Username)
+			if f.signer != nil {
+				assertion, err := f.signer.Sign(oidcUser)
+				if err != nil {
+					logr.FromContextOrDiscard(r.Context()).Error(err, "failed to sign identity assertion")
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				r.Header.Set(xForwardedAssertion, assertion)
+			}
 		}
 		h.ServeHTTP(w, r)
 	})
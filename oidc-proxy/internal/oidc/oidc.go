@@ -25,6 +25,13 @@ func NewFilter(ctx context.Context, opts Options) (*Filter, error) {
 	case "none":
 		sameSite = http.SameSiteNoneMode
 	}
+	var signer *Signer
+	if opts.Assertion.Key != "" {
+		signer, err = NewSigner(opts.Assertion.Key, opts.Assertion.Audience, opts.Assertion.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("creating assertion signer: %w", err)
+		}
+	}
 	return &Filter{
 		config: oauth2.Config{
 			ClientID:     opts.Client.ID,
@@ -35,6 +42,7 @@ func NewFilter(ctx context.Context, opts Options) (*Filter, error) {
 		},
 		provider:       provider,
 		verifier:       provider.Verifier(&oidc.Config{ClientID: opts.Client.ID}),
+		signer:         signer,
 		cookieSameSite: sameSite,
 		opts:           opts,
 	}, nil
@@ -5,6 +5,7 @@ import (
 	"golang.org/x/oauth2"
 	"net/http"
 	"regexp"
+	"time"
 )
 
 type Claims map[string]any
@@ -16,6 +17,9 @@ var (
 	xForwardedGroups   = http.CanonicalHeaderKey("X-Forwarded-Groups")
 	xForwardedEmail    = http.CanonicalHeaderKey("X-Forwarded-Email")
 	xForwardedUsername = http.CanonicalHeaderKey("X-Forwarded-Preferred-Username")
+	// xForwardedAssertion contains a signed copy of
+	// the other headers
+	xForwardedAssertion = http.CanonicalHeaderKey("X-Forwarded-Assertion")
 )
 
 const (
@@ -28,6 +32,7 @@ type Filter struct {
 	config         oauth2.Config
 	provider       *oidc.Provider
 	verifier       *oidc.IDTokenVerifier
+	signer         *Signer
 	cookieSameSite http.SameSite
 	opts           Options
 }
@@ -58,4 +63,12 @@ type Options struct {
 	RedirectURI    string   `split_words:"true" required:"true"`
 	CookieSameSite string   `split_words:"true" default:"Lax"`
 	Scopes         []string `split_words:"true" required:"true"`
+	// Assertion configures the signed identity that is
+	// forwarded to upstreams. Nothing is signed if the
+	// key is empty.
+	Assertion struct {
+		Key      string        `split_words:"true"`
+		Audience string        `split_words:"true" default:"aka-api"`
+		TTL      time.Duration `envconfig:"TTL" default:"30s"`
+	}
 }